@@ -3,4 +3,5 @@ install-goic:
 
 generate-client:
 	goic generate did ../canisters/logger_canister/src/logger_canister_backend/logger_canister_backend.did client --output=internal/icp/clients/logger/client.go --packageName=icpLogger
-	goic generate did ../canisters/dex_canister/src/dex_canister_backend/dex_canister_backend.did client --output=internal/icp/clients/dex/client.go --packageName=icpDex
\ No newline at end of file
+	goic generate did ../canisters/dex_canister/src/dex_canister_backend/dex_canister_backend.did client --output=internal/icp/clients/dex/client.go --packageName=icpDex
+	goic generate did internal/icp/clients/ledger/ledger.did client --output=internal/icp/clients/ledger/client.go --packageName=icpLedger
@@ -2,7 +2,11 @@ package conf
 
 import (
 	"fmt"
+	"regexp"
+	"time"
 
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/spf13/viper"
 )
 
@@ -27,17 +31,146 @@ type MetricsConfig struct {
 type RouterConfig struct {
 	AppRevision string
 	AppVersion  string
+	// Source is the name of the source the router serves, and the last segment of its
+	// routes; empty for the canisters of ICPConfig, served at /rpc/v1 and /ws/v1. Set
+	// for each router, it is not read from the configuration file.
+	Source string `mapstructure:"-"`
+	// ChainID is the chain ID of the source; 0 asks the logger canister. Set for each
+	// router, it is not read from the configuration file.
+	ChainID            uint64 `mapstructure:"-"`
+	MaxBatchSize       int    `mapstructure:"maxBatchSize"`
+	BatchConcurrency   int    `mapstructure:"batchConcurrency"`
+	WSPollInterval     string `mapstructure:"wsPollInterval"`
+	WSMaxSubscriptions int    `mapstructure:"wsMaxSubscriptions"`
+	WSSendQueueSize    int    `mapstructure:"wsSendQueueSize"`
+	FilterTimeout      string `mapstructure:"filterTimeout"`
+	// MaxFilters is the number of filters eth_newFilter and eth_newBlockFilter may have
+	// installed at a time on the router; 0 or less falls back to the default
+	MaxFilters int `mapstructure:"maxFilters"`
+	// TokenAddresses maps DEX currencies to the address of their pseudo ERC-20 contract,
+	// currencies not listed get an address derived from their symbol
+	TokenAddresses map[string]string `mapstructure:"tokenAddresses"`
+	// TokenDecimals maps DEX currencies to the decimals returned by their token's
+	// decimals(); currencies not listed have 0 decimals
+	TokenDecimals map[string]int `mapstructure:"tokenDecimals"`
+	// NativeCurrency is the DEX currency whose balance eth_getBalance returns; empty
+	// reports a zero balance for every address
+	NativeCurrency string `mapstructure:"nativeCurrency"`
+	// VerifyBlocks checks every served block against the hash chain of the certified tip
+	VerifyBlocks bool `mapstructure:"verifyBlocks"`
+	// StorePath is the block store database file; blocks are ingested into it in the
+	// background and served from it. Empty disables the store.
+	StorePath string `mapstructure:"storePath"`
+	// IngestBatchSize is the number of blocks the ingester fetches and stores at a time
+	IngestBatchSize int `mapstructure:"ingestBatchSize"`
+	// BlockHashScanDepth is the number of blocks below the tip searched for a block hash
+	// that is not indexed yet
+	BlockHashScanDepth int `mapstructure:"blockHashScanDepth"`
+	// BlockCacheSize is the number of blocks kept in memory; finalized blocks stay until
+	// evicted as least recently used. 0 disables the block cache.
+	BlockCacheSize int `mapstructure:"blockCacheSize"`
+	// BlockCacheTTL is how long the last block of the log, which can still change until
+	// it is finalized, is served from the block cache; empty or 0 never caches it
+	BlockCacheTTL string `mapstructure:"blockCacheTTL"`
+	// TipCacheTTL is how long a certified tip is served without asking the canister
+	// again; empty or 0 asks on every request
+	TipCacheTTL string `mapstructure:"tipCacheTTL"`
+	// CertificateMaxAge is the oldest time a tip certificate may certify before it is
+	// rejected as stale; empty or 0 uses the 5 minute ingress expiry of agent-go
+	CertificateMaxAge string `mapstructure:"certificateMaxAge"`
+	// HeaderFork is the Ethereum fork whose block header shape is advertised: legacy,
+	// london, shanghai or cancun
+	HeaderFork string `mapstructure:"headerFork"`
+	// CallContracts are pseudo contracts whose eth_call methods are answered by
+	// canister queries
+	CallContracts []CallContractConfig `mapstructure:"callContracts"`
+	// DexContractAddress is the pseudo contract eth_sendRawTransaction executes DEX
+	// update calls for; empty for an address derived from a fixed namespace
+	DexContractAddress string `mapstructure:"dexContractAddress"`
+	// DexOperators are the addresses whose signed transactions may mint, burn and add
+	// currency pairs
+	DexOperators []string `mapstructure:"dexOperators"`
+	// UnsignedDexMethods serves the unauthenticated eth_mintTokens and eth_burnTokens
+	// methods, which let anyone reaching the proxy mint and burn; off by default
+	UnsignedDexMethods bool `mapstructure:"unsignedDexMethods"`
+}
+
+// CallContractConfig maps the functions of a pseudo contract to canister query methods
+type CallContractConfig struct {
+	// Address is the address eth_call requests are sent to
+	Address string `mapstructure:"address"`
+	// CanisterID is the canister queried; empty for the DEX canister, or the block
+	// source canister of sources without one
+	CanisterID string `mapstructure:"canisterId"`
+	// Methods are the functions of the contract
+	Methods []CallMethodConfig `mapstructure:"methods"`
+}
+
+// CallMethodConfig maps a Solidity function to a canister query method
+type CallMethodConfig struct {
+	// Signature is the Solidity function with its parameter and return types, e.g.
+	// "getTokenBalance(address owner, string currency) returns (uint256)"
+	Signature string `mapstructure:"signature"`
+	// Query is the canister query method the function calls
+	Query string `mapstructure:"query"`
+}
+
+// headerForks are the accepted values of RouterConfig.HeaderFork
+var headerForks = map[string]bool{
+	"legacy":   true,
+	"london":   true,
+	"shanghai": true,
+	"cancun":   true,
 }
 
 type ICPConfig struct {
 	LoggerCanisterID               string `mapstructure:"loggerCanisterId"`
 	DexCanisterID                  string `mapstructure:"dexCanisterId"`
+	LedgerCanisterID               string `mapstructure:"ledgerCanisterId"`
 	NodeURL                        string `mapstructure:"nodeUrl"`
 	Timeout                        string `mapstructure:"timeout"`
 	DisableSignedQueryVerification bool   `mapstructure:"disableSignedQueryVerification"`
 	FetchRootKey                   bool   `mapstructure:"fetchRootKey"`
+	// ChainID is the EVM chain ID of the canisters above, served at /rpc/v1; 0 asks the
+	// logger canister. It must be set when sources are configured too.
+	ChainID uint64 `mapstructure:"chainId"`
+	// Sources are further ICRC-3 canisters, each served as its own EVM chain at
+	// /rpc/v1/{name} and /ws/v1/{name}
+	Sources []SourceConfig `mapstructure:"sources"`
 }
 
+// Source types, the canister a source reads its blocks from
+const (
+	SourceTypeLogger = "logger"
+	SourceTypeDex    = "dex"
+	SourceTypeLedger = "ledger"
+)
+
+// SourceConfig is an ICRC-3 canister served as an EVM chain of its own
+type SourceConfig struct {
+	// Name is the last segment of the routes of the source
+	Name string `mapstructure:"name"`
+	// Type is the kind of canister of CanisterID: logger, dex or ledger
+	Type string `mapstructure:"type"`
+	// CanisterID is the logger, DEX or ICRC-1 ledger canister of the source
+	CanisterID string `mapstructure:"canisterId"`
+	// ChainID is the EVM chain ID of the source. Every source must set its own: logger
+	// canisters all report the same one, and a transaction signed for one chain must
+	// not be accepted by another.
+	ChainID uint64 `mapstructure:"chainId"`
+	// LoggerCanisterID is the logger canister a dex source reads its blocks from
+	LoggerCanisterID string `mapstructure:"loggerCanisterId"`
+	// DexCanisterID is the DEX canister of a logger or ledger source; empty disables
+	// the DEX methods and tokens
+	DexCanisterID string `mapstructure:"dexCanisterId"`
+	// StorePath is the block store database file of the source; every source needs
+	// its own. Empty disables the store.
+	StorePath string `mapstructure:"storePath"`
+}
+
+// sourceNamePattern matches the source names that are a single route segment
+var sourceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 func (c Config) SetDefaults() {
 	viper.SetDefault("icp.canisterId", "")
 	viper.SetDefault("icp.nodeUrl", "https://ic0.app")
@@ -46,13 +179,32 @@ func (c Config) SetDefaults() {
 	viper.SetDefault("metrics.port", "9090")
 	viper.SetDefault("icp.disableSignedQueryVerification", false)
 	viper.SetDefault("icp.fetchRootKey", false)
+	viper.SetDefault("routerConfig.maxBatchSize", 100)
+	viper.SetDefault("routerConfig.batchConcurrency", 8)
+	viper.SetDefault("routerConfig.wsPollInterval", "2s")
+	viper.SetDefault("routerConfig.wsMaxSubscriptions", 16)
+	viper.SetDefault("routerConfig.wsSendQueueSize", 256)
+	viper.SetDefault("routerConfig.filterTimeout", "5m")
+	viper.SetDefault("routerConfig.maxFilters", 1000)
+	viper.SetDefault("routerConfig.verifyBlocks", false)
+	viper.SetDefault("routerConfig.storePath", "")
+	viper.SetDefault("routerConfig.ingestBatchSize", 100)
+	viper.SetDefault("routerConfig.blockHashScanDepth", 1000)
+	viper.SetDefault("routerConfig.headerFork", "cancun")
+	viper.SetDefault("routerConfig.blockCacheSize", 10000)
+	viper.SetDefault("routerConfig.blockCacheTTL", "1s")
+	viper.SetDefault("routerConfig.tipCacheTTL", "1s")
+	viper.SetDefault("routerConfig.certificateMaxAge", "5m")
+	viper.SetDefault("routerConfig.nativeCurrency", "")
+	viper.SetDefault("routerConfig.dexContractAddress", "")
+	viper.SetDefault("routerConfig.unsignedDexMethods", false)
 }
 
 func (c Config) Validate() error {
-	if c.ICP.LoggerCanisterID == "" {
-		return fmt.Errorf("ICP LoggerCanisterID must be provided")
+	if c.ICP.LoggerCanisterID == "" && len(c.ICP.Sources) == 0 {
+		return fmt.Errorf("ICP LoggerCanisterID or sources must be provided")
 	}
-	if c.ICP.DexCanisterID == "" {
+	if c.ICP.LoggerCanisterID != "" && c.ICP.DexCanisterID == "" {
 		return fmt.Errorf("ICP DexCanisterID must be provided")
 	}
 	if c.ICP.NodeURL == "" {
@@ -62,5 +214,159 @@ func (c Config) Validate() error {
 	if c.ICP.Timeout == "" {
 		return fmt.Errorf("ICP Timeout must be provided")
 	}
+
+	if c.RouterConfig.WSPollInterval != "" {
+		if _, err := time.ParseDuration(c.RouterConfig.WSPollInterval); err != nil {
+			return fmt.Errorf("invalid routerConfig wsPollInterval '%s': %w", c.RouterConfig.WSPollInterval, err)
+		}
+	}
+
+	if c.RouterConfig.FilterTimeout != "" {
+		if _, err := time.ParseDuration(c.RouterConfig.FilterTimeout); err != nil {
+			return fmt.Errorf("invalid routerConfig filterTimeout '%s': %w", c.RouterConfig.FilterTimeout, err)
+		}
+	}
+
+	if c.RouterConfig.BlockCacheTTL != "" {
+		if _, err := time.ParseDuration(c.RouterConfig.BlockCacheTTL); err != nil {
+			return fmt.Errorf("invalid routerConfig blockCacheTTL '%s': %w", c.RouterConfig.BlockCacheTTL, err)
+		}
+	}
+
+	if c.RouterConfig.TipCacheTTL != "" {
+		if _, err := time.ParseDuration(c.RouterConfig.TipCacheTTL); err != nil {
+			return fmt.Errorf("invalid routerConfig tipCacheTTL '%s': %w", c.RouterConfig.TipCacheTTL, err)
+		}
+	}
+
+	if c.RouterConfig.CertificateMaxAge != "" {
+		if _, err := time.ParseDuration(c.RouterConfig.CertificateMaxAge); err != nil {
+			return fmt.Errorf("invalid routerConfig certificateMaxAge '%s': %w", c.RouterConfig.CertificateMaxAge, err)
+		}
+	}
+
+	if c.RouterConfig.HeaderFork != "" && !headerForks[c.RouterConfig.HeaderFork] {
+		return fmt.Errorf("invalid routerConfig headerFork '%s': must be legacy, london, shanghai or cancun", c.RouterConfig.HeaderFork)
+	}
+
+	for currency, address := range c.RouterConfig.TokenAddresses {
+		if !common.IsHexAddress(address) {
+			return fmt.Errorf("invalid routerConfig token address '%s' for currency '%s'", address, currency)
+		}
+	}
+
+	if address := c.RouterConfig.DexContractAddress; address != "" && !common.IsHexAddress(address) {
+		return fmt.Errorf("invalid routerConfig dexContractAddress '%s'", address)
+	}
+
+	for i, operator := range c.RouterConfig.DexOperators {
+		if !common.IsHexAddress(operator) {
+			return fmt.Errorf("invalid routerConfig dexOperators[%d] address '%s'", i, operator)
+		}
+	}
+	// Nonces are persisted in the block store; without one they restart from 0 and
+	// captured signed transactions could be replayed
+	if len(c.RouterConfig.DexOperators) > 0 && c.ICP.LoggerCanisterID != "" && c.RouterConfig.StorePath == "" {
+		return fmt.Errorf("routerConfig dexOperators require a storePath to persist their nonces")
+	}
+
+	for i, contract := range c.RouterConfig.CallContracts {
+		if !common.IsHexAddress(contract.Address) {
+			return fmt.Errorf("invalid routerConfig callContracts[%d] address '%s'", i, contract.Address)
+		}
+		if contract.CanisterID != "" {
+			if _, err := principal.Decode(contract.CanisterID); err != nil {
+				return fmt.Errorf("invalid routerConfig callContracts[%d] canisterId '%s': %w", i, contract.CanisterID, err)
+			}
+		}
+		for j, method := range contract.Methods {
+			if method.Signature == "" || method.Query == "" {
+				return fmt.Errorf("routerConfig callContracts[%d] method %d must have a signature and a query", i, j)
+			}
+		}
+	}
+
+	for currency, decimals := range c.RouterConfig.TokenDecimals {
+		if decimals < 0 || decimals > 255 {
+			return fmt.Errorf("invalid routerConfig token decimals %d for currency '%s': must be between 0 and 255", decimals, currency)
+		}
+	}
+
+	return c.validateSources()
+}
+
+// validateSources checks the sources of ICPConfig: unique names, chain IDs set and
+// unique, also with the canisters of ICPConfig, known types, the canisters each type
+// needs and a block store file of their own
+func (c Config) validateSources() error {
+	names := make(map[string]bool, len(c.ICP.Sources))
+	chainIDs := make(map[uint64]string, len(c.ICP.Sources))
+	if c.ICP.LoggerCanisterID != "" && len(c.ICP.Sources) > 0 {
+		if c.ICP.ChainID == 0 {
+			return fmt.Errorf("icp chainId must be provided when sources are configured")
+		}
+		chainIDs[c.ICP.ChainID] = "icp"
+	}
+	storePaths := map[string]bool{}
+	if c.ICP.LoggerCanisterID != "" && c.RouterConfig.StorePath != "" {
+		storePaths[c.RouterConfig.StorePath] = true
+	}
+
+	for i, source := range c.ICP.Sources {
+		if !sourceNamePattern.MatchString(source.Name) {
+			return fmt.Errorf("invalid icp sources[%d] name '%s': must be letters, digits, '-' or '_'", i, source.Name)
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate icp source name '%s'", source.Name)
+		}
+		names[source.Name] = true
+
+		if source.ChainID == 0 {
+			return fmt.Errorf("icp source '%s' must have a chainId", source.Name)
+		}
+		if other, found := chainIDs[source.ChainID]; found {
+			return fmt.Errorf("icp sources '%s' and '%s' have the same chainId %d", other, source.Name, source.ChainID)
+		}
+		chainIDs[source.ChainID] = source.Name
+
+		// The canisters of the source by their key, an empty optional one is skipped
+		canisters := [][2]string{{"canisterId", source.CanisterID}}
+		switch source.Type {
+		case SourceTypeLogger:
+			canisters = append(canisters, [2]string{"dexCanisterId", source.DexCanisterID})
+		case SourceTypeDex:
+			if source.LoggerCanisterID == "" {
+				return fmt.Errorf("icp source '%s' of type dex must have a loggerCanisterId", source.Name)
+			}
+			canisters = append(canisters, [2]string{"loggerCanisterId", source.LoggerCanisterID})
+		case SourceTypeLedger:
+			canisters = append(canisters, [2]string{"dexCanisterId", source.DexCanisterID})
+		default:
+			return fmt.Errorf("invalid icp source '%s' type '%s': must be logger, dex or ledger", source.Name, source.Type)
+		}
+
+		for j, canister := range canisters {
+			key, canisterID := canister[0], canister[1]
+			if canisterID == "" && j > 0 {
+				continue
+			}
+			if _, err := principal.Decode(canisterID); err != nil {
+				return fmt.Errorf("invalid icp source '%s' %s '%s': %w", source.Name, key, canisterID, err)
+			}
+		}
+
+		hasDex := source.Type == SourceTypeDex || source.DexCanisterID != ""
+		if hasDex && len(c.RouterConfig.DexOperators) > 0 && source.StorePath == "" {
+			return fmt.Errorf("icp source '%s' must have a storePath to persist the nonces of routerConfig dexOperators", source.Name)
+		}
+
+		if source.StorePath != "" {
+			if storePaths[source.StorePath] {
+				return fmt.Errorf("icp source '%s' storePath '%s' is already used by another source", source.Name, source.StorePath)
+			}
+			storePaths[source.StorePath] = true
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,191 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLoggerCanister = "ydpfi-uiaaa-aaaal-qjupa-cai"
+	testDexCanister    = "7eo5f-eqaaa-aaaam-adqoq-cai"
+	testLedgerCanister = "mxzaz-hqaaa-aaaar-qaada-cai"
+)
+
+func TestValidateSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		icp     ICPConfig
+		store   string
+		wantErr string
+	}{
+		{
+			name: "Single source deployment",
+			icp:  ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister},
+		},
+		{
+			name: "Sources only",
+			icp: ICPConfig{Sources: []SourceConfig{
+				{Name: "ckbtc", Type: SourceTypeLedger, CanisterID: testLedgerCanister, ChainID: 31337, StorePath: "ckbtc.db"},
+				{Name: "dex", Type: SourceTypeDex, CanisterID: testDexCanister, LoggerCanisterID: testLoggerCanister, ChainID: 1, StorePath: "dex.db"},
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 2},
+			}},
+		},
+		{
+			name: "Sources next to the single source",
+			icp: ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister, ChainID: 314160, Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 2},
+			}},
+		},
+		{
+			name: "Sources next to the single source without its chain ID",
+			icp: ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister, Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 2},
+			}},
+			wantErr: "icp chainId must be provided when sources are configured",
+		},
+		{
+			name: "Chain ID of the single source",
+			icp: ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister, ChainID: 314160, Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 314160},
+			}},
+			wantErr: "icp sources 'icp' and 'logs' have the same chainId 314160",
+		},
+		{
+			name:    "Neither a logger nor sources",
+			icp:     ICPConfig{},
+			wantErr: "ICP LoggerCanisterID or sources must be provided",
+		},
+		{
+			name:    "Logger without a DEX",
+			icp:     ICPConfig{LoggerCanisterID: testLoggerCanister},
+			wantErr: "ICP DexCanisterID must be provided",
+		},
+		{
+			name:    "Name that is not a route segment",
+			icp:     ICPConfig{Sources: []SourceConfig{{Name: "a/b", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 1}}},
+			wantErr: "invalid icp sources[0] name 'a/b'",
+		},
+		{
+			name: "Duplicate name",
+			icp: ICPConfig{Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 1},
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 2},
+			}},
+			wantErr: "duplicate icp source name 'logs'",
+		},
+		{
+			name: "Duplicate chain ID",
+			icp: ICPConfig{Sources: []SourceConfig{
+				{Name: "a", Type: SourceTypeLedger, CanisterID: testLedgerCanister, ChainID: 7},
+				{Name: "b", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 7},
+			}},
+			wantErr: "icp sources 'a' and 'b' have the same chainId 7",
+		},
+		{
+			name:    "Unknown type",
+			icp:     ICPConfig{Sources: []SourceConfig{{Name: "x", Type: "archive", CanisterID: testLoggerCanister, ChainID: 1}}},
+			wantErr: "invalid icp source 'x' type 'archive'",
+		},
+		{
+			name:    "Source without a chain ID",
+			icp:     ICPConfig{Sources: []SourceConfig{{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister}}},
+			wantErr: "icp source 'logs' must have a chainId",
+		},
+		{
+			name:    "DEX without a logger",
+			icp:     ICPConfig{Sources: []SourceConfig{{Name: "dex", Type: SourceTypeDex, CanisterID: testDexCanister, ChainID: 1}}},
+			wantErr: "icp source 'dex' of type dex must have a loggerCanisterId",
+		},
+		{
+			name:    "Invalid canister",
+			icp:     ICPConfig{Sources: []SourceConfig{{Name: "logs", Type: SourceTypeLogger, CanisterID: "not-a-principal", ChainID: 1}}},
+			wantErr: "invalid icp source 'logs' canisterId 'not-a-principal'",
+		},
+		{
+			name:    "Invalid DEX canister",
+			icp:     ICPConfig{Sources: []SourceConfig{{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, DexCanisterID: "nope", ChainID: 1}}},
+			wantErr: "invalid icp source 'logs' dexCanisterId 'nope'",
+		},
+		{
+			name: "Store shared with the single source",
+			icp: ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister, ChainID: 314160, Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 1, StorePath: "blocks.db"},
+			}},
+			store:   "blocks.db",
+			wantErr: "icp source 'logs' storePath 'blocks.db' is already used by another source",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			icp := tt.icp
+			icp.NodeURL, icp.Timeout = "https://ic0.app", "120s"
+			err := Config{ICP: &icp, RouterConfig: RouterConfig{StorePath: tt.store}}.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateDexOperators(t *testing.T) {
+	const operator = "0x00000000000000000000000000000000000000aa"
+
+	tests := []struct {
+		name    string
+		icp     ICPConfig
+		store   string
+		wantErr string
+	}{
+		{
+			name:  "Single source with a store",
+			icp:   ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister},
+			store: "blocks.db",
+		},
+		{
+			name:    "Single source without a store",
+			icp:     ICPConfig{LoggerCanisterID: testLoggerCanister, DexCanisterID: testDexCanister},
+			wantErr: "routerConfig dexOperators require a storePath",
+		},
+		{
+			name: "Sources without a DEX need no store",
+			icp: ICPConfig{Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, ChainID: 1},
+				{Name: "ckbtc", Type: SourceTypeLedger, CanisterID: testLedgerCanister, ChainID: 31337},
+			}},
+		},
+		{
+			name: "DEX source without a store",
+			icp: ICPConfig{Sources: []SourceConfig{
+				{Name: "dex", Type: SourceTypeDex, CanisterID: testDexCanister, LoggerCanisterID: testLoggerCanister, ChainID: 1},
+			}},
+			wantErr: "icp source 'dex' must have a storePath",
+		},
+		{
+			name: "Source with a DEX canister without a store",
+			icp: ICPConfig{Sources: []SourceConfig{
+				{Name: "logs", Type: SourceTypeLogger, CanisterID: testLoggerCanister, DexCanisterID: testDexCanister, ChainID: 1},
+			}},
+			wantErr: "icp source 'logs' must have a storePath",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			icp := tt.icp
+			icp.NodeURL, icp.Timeout = "https://ic0.app", "120s"
+			err := Config{ICP: &icp, RouterConfig: RouterConfig{StorePath: tt.store, DexOperators: []string{operator}}}.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package icp
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+)
+
+// GetBlocksMethod is the ICRC-3 method returning blocks and their archived ranges
+const GetBlocksMethod = "icrc3_get_blocks"
+
+// ArchivedRange is a range of blocks an ICRC-3 canister has moved to an archive,
+// together with the callback that returns them
+type ArchivedRange struct {
+	Start    uint64
+	Length   uint64
+	Callback idl.PrincipalMethod
+}
+
+// GetBlocks fetches the blocks [start, start+length) from an ICRC-3 canister, decoding
+// the blocks it still holds into result and returning the ranges it has archived
+//
+// Parameters:
+//   - q: The query agent to send the query with
+//   - source: The canister and method to query, icrc3_get_blocks or an archive callback
+//   - start: The first block to fetch
+//   - length: The number of blocks to fetch
+//   - args: Builds the GetBlocksArgs of the canister client for a range
+//   - result: A pointer to the GetBlocksResult of the canister client
+//
+// Returns:
+//   - []ArchivedRange: The archived ranges, in the order of the reply
+//   - error: Any error that occurred during the query or decoding
+//
+// agent-go cannot decode Candid func references, so the generated clients fail on any
+// reply listing archived blocks. The reply is therefore fetched raw, with its
+// signatures checked as agent-go does, and its archived_blocks read with decodeCandid.
+// The rest of the reply is decoded into result once archived_blocks is emptied.
+func GetBlocks(q *QueryAgent, source idl.PrincipalMethod, start, length uint64, args func(start, length uint64) any, result any) ([]ArchivedRange, error) {
+	reply, err := q.query(source.Principal, source.Method, args(start, length))
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := decodeArchivedRanges(reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s reply: %w", source.Method, err)
+	}
+
+	if len(archived) > 0 {
+		if reply, err = emptyVecField(reply, "archived_blocks"); err != nil {
+			return nil, fmt.Errorf("failed to decode %s reply: %w", source.Method, err)
+		}
+	}
+	if err := idl.Unmarshal(reply, []any{result}); err != nil {
+		return nil, fmt.Errorf("failed to decode %s reply: %w", source.Method, err)
+	}
+
+	return archived, nil
+}
+
+// decodeArchivedRanges reads the archived_blocks of a Candid encoded GetBlocksResult
+//
+// The args of an archived range are a vector of ranges for ICRC-3 ledgers and a
+// single range for the logger canister; both are accepted.
+func decodeArchivedRanges(reply []byte) ([]ArchivedRange, error) {
+	values, err := decodeCandid(reply)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("expected 1 reply value, got %d", len(values))
+	}
+
+	result, ok := values[0].(map[uint32]any)
+	if !ok {
+		return nil, fmt.Errorf("reply is not a record")
+	}
+
+	archived, _ := result[fieldID("archived_blocks")].([]any)
+	var ranges []ArchivedRange
+	for i, item := range archived {
+		entry, ok := item.(map[uint32]any)
+		if !ok {
+			return nil, fmt.Errorf("archived_blocks[%d] is not a record", i)
+		}
+
+		callback, ok := entry[fieldID("callback")].(idl.PrincipalMethod)
+		if !ok {
+			return nil, fmt.Errorf("archived_blocks[%d] has no callback", i)
+		}
+
+		var args []any
+		switch v := entry[fieldID("args")].(type) {
+		case []any:
+			args = v
+		case map[uint32]any:
+			args = []any{v}
+		default:
+			return nil, fmt.Errorf("archived_blocks[%d] has invalid args", i)
+		}
+
+		for _, arg := range args {
+			fields, ok := arg.(map[uint32]any)
+			if !ok {
+				return nil, fmt.Errorf("archived_blocks[%d] has invalid args", i)
+			}
+
+			start, okStart := fields[fieldID("start")].(*big.Int)
+			length, okLength := fields[fieldID("length")].(*big.Int)
+			if !okStart || !okLength || !start.IsUint64() || !length.IsUint64() {
+				return nil, fmt.Errorf("archived_blocks[%d] has invalid args", i)
+			}
+
+			ranges = append(ranges, ArchivedRange{
+				Start:    start.Uint64(),
+				Length:   length.Uint64(),
+				Callback: callback,
+			})
+		}
+	}
+
+	return ranges, nil
+}
+
+// fieldID returns the Candid id of a record field name
+func fieldID(name string) uint32 {
+	return uint32(idl.Hash(name).Uint64())
+}
@@ -0,0 +1,181 @@
+package icp
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/icptest"
+)
+
+// encodeGetBlocksResult encodes a GetBlocksResult whose archived ranges have the given
+// args, holding blocks whose values are text
+func encodeGetBlocksResult(t *testing.T, argsType idl.Type, archived []any, blocks ...any) []byte {
+	callbackType := idl.NewFunctionType(
+		[]idl.FunctionParameter{{Type: argsType}},
+		[]idl.FunctionParameter{{Type: new(idl.NatType)}},
+		[]string{"query"},
+	)
+	resultType := idl.NewRecordType(map[string]idl.Type{
+		"log_length": new(idl.NatType),
+		"blocks": idl.NewVectorType(idl.NewRecordType(map[string]idl.Type{
+			"id":    new(idl.NatType),
+			"block": idl.NewVariantType(map[string]idl.Type{"Text": new(idl.TextType)}),
+		})),
+		"archived_blocks": idl.NewVectorType(idl.NewRecordType(map[string]idl.Type{
+			"args":     argsType,
+			"callback": callbackType,
+		})),
+	})
+
+	reply, err := idl.Encode([]idl.Type{resultType}, []any{map[string]any{
+		"log_length":      idl.NewNat(uint64(30)),
+		"blocks":          blocks,
+		"archived_blocks": archived,
+	}})
+	require.NoError(t, err)
+
+	return reply
+}
+
+func TestDecodeArchivedRanges(t *testing.T) {
+	archive := principal.MustDecode("ryjl3-tyaaa-aaaaa-aaaba-cai")
+	callback := idl.PrincipalMethod{Principal: archive, Method: "icrc3_get_blocks"}
+	rangeType := idl.NewRecordType(map[string]idl.Type{
+		"start":  new(idl.NatType),
+		"length": new(idl.NatType),
+	})
+	blockRange := func(start, length uint64) map[string]any {
+		return map[string]any{"start": idl.NewNat(start), "length": idl.NewNat(length)}
+	}
+
+	tests := []struct {
+		name     string
+		argsType idl.Type
+		archived []any
+		want     []ArchivedRange
+	}{
+		{
+			name:     "ICRC-3 vector of ranges",
+			argsType: idl.NewVectorType(rangeType),
+			archived: []any{map[string]any{
+				"args":     []any{blockRange(0, 10), blockRange(10, 5)},
+				"callback": callback,
+			}},
+			want: []ArchivedRange{
+				{Start: 0, Length: 10, Callback: callback},
+				{Start: 10, Length: 5, Callback: callback},
+			},
+		},
+		{
+			name:     "Single range",
+			argsType: rangeType,
+			archived: []any{map[string]any{
+				"args":     blockRange(3, 7),
+				"callback": callback,
+			}},
+			want: []ArchivedRange{{Start: 3, Length: 7, Callback: callback}},
+		},
+		{
+			name:     "Nothing archived",
+			argsType: rangeType,
+			archived: []any{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeArchivedRanges(encodeGetBlocksResult(t, tt.argsType, tt.archived))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// archivingCanister answers icrc3_get_blocks with block 5 and blocks 0-4 archived
+type archivingCanister struct {
+	reply   []byte
+	queries int
+}
+
+func (c *archivingCanister) Query(icptest.Call) ([]byte, error) {
+	c.queries++
+	return c.reply, nil
+}
+
+func (c *archivingCanister) Update(call icptest.Call) ([]byte, error) {
+	return nil, icptest.UnknownMethod(call.Method)
+}
+
+func (c *archivingCanister) CertifiedData() []byte { return nil }
+
+func TestGetBlocks(t *testing.T) {
+	replica := icptest.NewReplica()
+	t.Cleanup(replica.Close)
+
+	canisterID := principal.MustDecode("ydpfi-uiaaa-aaaal-qjupa-cai")
+	callback := idl.PrincipalMethod{Principal: principal.MustDecode("ryjl3-tyaaa-aaaaa-aaaba-cai"), Method: GetBlocksMethod}
+	rangeType := idl.NewRecordType(map[string]idl.Type{
+		"start":  new(idl.NatType),
+		"length": new(idl.NatType),
+	})
+	text := "block"
+	canister := &archivingCanister{reply: encodeGetBlocksResult(t, rangeType, []any{map[string]any{
+		"args":     map[string]any{"start": idl.NewNat(uint64(0)), "length": idl.NewNat(uint64(5))},
+		"callback": callback,
+	}}, map[string]any{"id": idl.NewNat(uint64(5)), "block": idl.Variant{Name: "Text", Value: text}})}
+	replica.Install(canisterID, canister)
+
+	tests := []struct {
+		name         string
+		fetchRootKey bool
+		disable      bool
+		wantErr      string
+	}{
+		{name: "Verified", fetchRootKey: true},
+		{name: "Signed by another IC", wantErr: "failed to verify icrc3_get_blocks response"},
+		{name: "Verification disabled", disable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := agent.Config{
+				ClientConfig:                   &agent.ClientConfig{Host: mustParseURL(t, replica.URL())},
+				FetchRootKey:                   tt.fetchRootKey,
+				DisableSignedQueryVerification: tt.disable,
+			}
+			a, err := agent.New(cfg)
+			require.NoError(t, err)
+			canister.queries = 0
+
+			var result icpLogger.GetBlocksResult
+			archived, err := GetBlocks(NewQueryAgent(a, cfg), idl.PrincipalMethod{Principal: canisterID, Method: GetBlocksMethod}, 0, 6, func(start, length uint64) any {
+				return icpLogger.GetBlocksArgs{Start: idl.NewNat(start), Length: idl.NewNat(length)}
+			}, &result)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			// The live blocks are decoded from the reply listing the archived ones
+			assert.Equal(t, 1, canister.queries)
+			assert.Equal(t, []ArchivedRange{{Start: 0, Length: 5, Callback: callback}}, archived)
+			require.Len(t, result.Blocks, 1)
+			assert.Equal(t, uint64(5), result.Blocks[0].Id.BigInt().Uint64())
+			assert.Equal(t, text, *result.Blocks[0].Block.Text)
+			assert.Empty(t, result.ArchivedBlocks)
+		})
+	}
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
@@ -0,0 +1,481 @@
+package icp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+)
+
+// Candid type opcodes, see https://github.com/dfinity/candid/blob/master/spec/Candid.md
+const (
+	candidNull      = -1
+	candidBool      = -2
+	candidNat       = -3
+	candidInt       = -4
+	candidNat8      = -5
+	candidNat16     = -6
+	candidNat32     = -7
+	candidNat64     = -8
+	candidInt8      = -9
+	candidInt16     = -10
+	candidInt32     = -11
+	candidInt64     = -12
+	candidFloat32   = -13
+	candidFloat64   = -14
+	candidText      = -15
+	candidReserved  = -16
+	candidEmpty     = -17
+	candidOpt       = -18
+	candidVec       = -19
+	candidRecord    = -20
+	candidVariant   = -21
+	candidFunc      = -22
+	candidService   = -23
+	candidPrincipal = -24
+)
+
+// candidType is an entry of the type table of a Candid message; compound types refer
+// to other entries, or to primitive types, by their signed index
+type candidType struct {
+	opcode int64
+	// elem is the element type of opt and vec
+	elem int64
+	// fields are the fields of records and variants, ordered by id
+	fields []candidField
+}
+
+type candidField struct {
+	id  uint32
+	typ int64
+}
+
+// candidDecoder decodes Candid messages into generic values
+//
+// agent-go cannot decode func references: it reads the method name into the principal
+// buffer and leaves its bytes in the stream, so any message holding a func value fails
+// to decode. This decoder is only used to read such values, like the archived_blocks
+// callbacks of ICRC-3 canisters.
+//
+// Values are decoded as:
+//   - record and variant: map[uint32]any keyed by field id (variants have a single entry)
+//   - vec: []any; opt: nil or the value
+//   - nat and int: *big.Int; fixed size integers: uint64 or int64; floats: float64
+//   - text: string; bool: bool; principal: principal.Principal; func: idl.PrincipalMethod
+//   - null and reserved: nil
+type candidDecoder struct {
+	r     *bytes.Reader
+	types []candidType
+}
+
+// decodeCandid decodes all the values of a Candid message
+func decodeCandid(data []byte) ([]any, error) {
+	d, argTypes, err := newCandidDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, 0, len(argTypes))
+	for _, typ := range argTypes {
+		value, err := d.readValue(typ)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if d.r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after Candid values", d.r.Len())
+	}
+
+	return values, nil
+}
+
+// emptyVecField returns a copy of a Candid message whose only value is a record, with
+// the vector of one of its fields emptied
+//
+// The type table is kept, so the copy has the same type as the message. agent-go can
+// decode it when the emptied vector held the only func values of the message.
+func emptyVecField(data []byte, name string) ([]byte, error) {
+	d, argTypes, err := newCandidDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(argTypes) != 1 || argTypes[0] < 0 || argTypes[0] >= int64(len(d.types)) || d.types[argTypes[0]].opcode != candidRecord {
+		return nil, fmt.Errorf("expected a single record value")
+	}
+
+	id := fieldID(name)
+	for _, field := range d.types[argTypes[0]].fields {
+		start := len(data) - d.r.Len()
+		if _, err := d.readValue(field.typ); err != nil {
+			return nil, err
+		}
+		if field.id != id {
+			continue
+		}
+
+		if field.typ < 0 || d.types[field.typ].opcode != candidVec {
+			return nil, fmt.Errorf("field %s is not a vector", name)
+		}
+		end := len(data) - d.r.Len()
+		// An empty vector is encoded as its zero length
+		return append(append(append([]byte{}, data[:start]...), 0), data[end:]...), nil
+	}
+
+	return nil, fmt.Errorf("no field %s", name)
+}
+
+// newCandidDecoder reads the header of a Candid message, returning a decoder positioned
+// at its values and the types of the values
+func newCandidDecoder(data []byte) (*candidDecoder, []int64, error) {
+	d := &candidDecoder{r: bytes.NewReader(data)}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != "DIDL" {
+		return nil, nil, fmt.Errorf("invalid Candid magic number")
+	}
+
+	if err := d.readTypeTable(); err != nil {
+		return nil, nil, err
+	}
+
+	count, err := d.readCount()
+	if err != nil {
+		return nil, nil, err
+	}
+	argTypes := make([]int64, count)
+	for i := range argTypes {
+		if argTypes[i], err = d.readSLEB(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return d, argTypes, nil
+}
+
+func (d *candidDecoder) readTypeTable() error {
+	count, err := d.readCount()
+	if err != nil {
+		return err
+	}
+
+	d.types = make([]candidType, count)
+	for i := range d.types {
+		opcode, err := d.readSLEB()
+		if err != nil {
+			return err
+		}
+		typ := candidType{opcode: opcode}
+
+		switch opcode {
+		case candidOpt, candidVec:
+			if typ.elem, err = d.readSLEB(); err != nil {
+				return err
+			}
+		case candidRecord, candidVariant:
+			n, err := d.readCount()
+			if err != nil {
+				return err
+			}
+			for j := uint64(0); j < n; j++ {
+				id, err := d.readULEB()
+				if err != nil {
+					return err
+				}
+				if id > math.MaxUint32 {
+					return fmt.Errorf("invalid field id %d", id)
+				}
+				fieldType, err := d.readSLEB()
+				if err != nil {
+					return err
+				}
+				typ.fields = append(typ.fields, candidField{id: uint32(id), typ: fieldType})
+			}
+		case candidFunc:
+			// Argument types, return types and annotations are not needed to decode references
+			for k := 0; k < 2; k++ {
+				n, err := d.readCount()
+				if err != nil {
+					return err
+				}
+				for j := uint64(0); j < n; j++ {
+					if _, err := d.readSLEB(); err != nil {
+						return err
+					}
+				}
+			}
+			n, err := d.readCount()
+			if err != nil {
+				return err
+			}
+			if _, err := d.r.Seek(int64(n), io.SeekCurrent); err != nil {
+				return err
+			}
+		case candidService:
+			n, err := d.readCount()
+			if err != nil {
+				return err
+			}
+			for j := uint64(0); j < n; j++ {
+				if _, err := d.readText(); err != nil {
+					return err
+				}
+				if _, err := d.readSLEB(); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported Candid type opcode %d in type table", opcode)
+		}
+
+		d.types[i] = typ
+	}
+
+	return nil
+}
+
+func (d *candidDecoder) readValue(typ int64) (any, error) {
+	if typ >= 0 {
+		if typ >= int64(len(d.types)) {
+			return nil, fmt.Errorf("unknown Candid type index %d", typ)
+		}
+		return d.readCompound(d.types[typ])
+	}
+
+	switch typ {
+	case candidNull, candidReserved:
+		return nil, nil
+	case candidBool:
+		b, err := d.r.ReadByte()
+		return b == 1, err
+	case candidNat:
+		return d.readBigULEB()
+	case candidInt:
+		return d.readBigSLEB()
+	case candidNat8, candidNat16, candidNat32, candidNat64:
+		raw, err := d.readFixed(typ, candidNat8)
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for i := len(raw) - 1; i >= 0; i-- {
+			n = n<<8 | uint64(raw[i])
+		}
+		return n, nil
+	case candidInt8, candidInt16, candidInt32, candidInt64:
+		raw, err := d.readFixed(typ, candidInt8)
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for i := len(raw) - 1; i >= 0; i-- {
+			n = n<<8 | uint64(raw[i])
+		}
+		shift := 64 - 8*len(raw)
+		return int64(n<<shift) >> shift, nil
+	case candidFloat32:
+		raw, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(raw[0]) | uint32(raw[1])<<8 | uint32(raw[2])<<16 | uint32(raw[3])<<24)), nil
+	case candidFloat64:
+		raw, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		var bits uint64
+		for i := 7; i >= 0; i-- {
+			bits = bits<<8 | uint64(raw[i])
+		}
+		return math.Float64frombits(bits), nil
+	case candidText:
+		return d.readText()
+	case candidPrincipal:
+		return d.readPrincipal()
+	default:
+		return nil, fmt.Errorf("unsupported Candid type %d", typ)
+	}
+}
+
+func (d *candidDecoder) readCompound(typ candidType) (any, error) {
+	switch typ.opcode {
+	case candidOpt:
+		flag, err := d.r.ReadByte()
+		if err != nil || flag == 0 {
+			return nil, err
+		}
+		return d.readValue(typ.elem)
+	case candidVec:
+		n, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		items := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, err := d.readValue(typ.elem)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case candidRecord:
+		record := make(map[uint32]any, len(typ.fields))
+		for _, field := range typ.fields {
+			value, err := d.readValue(field.typ)
+			if err != nil {
+				return nil, err
+			}
+			record[field.id] = value
+		}
+		return record, nil
+	case candidVariant:
+		index, err := d.readULEB()
+		if err != nil {
+			return nil, err
+		}
+		if index >= uint64(len(typ.fields)) {
+			return nil, fmt.Errorf("invalid variant index %d", index)
+		}
+		field := typ.fields[index]
+		value, err := d.readValue(field.typ)
+		if err != nil {
+			return nil, err
+		}
+		return map[uint32]any{field.id: value}, nil
+	case candidFunc:
+		flag, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if flag != 1 {
+			return nil, fmt.Errorf("opaque func references are not supported")
+		}
+		p, err := d.readPrincipal()
+		if err != nil {
+			return nil, err
+		}
+		method, err := d.readText()
+		if err != nil {
+			return nil, err
+		}
+		return idl.PrincipalMethod{Principal: p, Method: method}, nil
+	case candidService:
+		return d.readPrincipal()
+	default:
+		return nil, fmt.Errorf("unsupported Candid type opcode %d", typ.opcode)
+	}
+}
+
+// readFixed reads a little-endian integer of a fixed size type; sizes double from the first opcode
+func (d *candidDecoder) readFixed(typ, first int64) ([]byte, error) {
+	return d.readBytes(1 << (first - typ))
+}
+
+func (d *candidDecoder) readPrincipal() (principal.Principal, error) {
+	flag, err := d.r.ReadByte()
+	if err != nil {
+		return principal.Principal{}, err
+	}
+	if flag != 1 {
+		return principal.Principal{}, fmt.Errorf("opaque principal references are not supported")
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return principal.Principal{}, err
+	}
+	raw, err := d.readBytes(n)
+	if err != nil {
+		return principal.Principal{}, err
+	}
+	return principal.Principal{Raw: raw}, nil
+}
+
+func (d *candidDecoder) readText() (string, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return "", err
+	}
+	raw, err := d.readBytes(n)
+	return string(raw), err
+}
+
+func (d *candidDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(d.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	raw := make([]byte, n)
+	_, err := io.ReadFull(d.r, raw)
+	return raw, err
+}
+
+// readCount reads a length or an element count
+func (d *candidDecoder) readCount() (uint64, error) {
+	n, err := d.readULEB()
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid Candid length %d", n)
+	}
+	return n, nil
+}
+
+func (d *candidDecoder) readULEB() (uint64, error) {
+	n, err := d.readBigULEB()
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsUint64() {
+		return 0, fmt.Errorf("LEB128 value overflows uint64")
+	}
+	return n.Uint64(), nil
+}
+
+func (d *candidDecoder) readSLEB() (int64, error) {
+	n, err := d.readBigSLEB()
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("LEB128 value overflows int64")
+	}
+	return n.Int64(), nil
+}
+
+func (d *candidDecoder) readBigULEB() (*big.Int, error) {
+	n := new(big.Int)
+	for shift := uint(0); ; shift += 7 {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n.Or(n, new(big.Int).Lsh(big.NewInt(int64(b&0x7f)), shift))
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+}
+
+func (d *candidDecoder) readBigSLEB() (*big.Int, error) {
+	n := new(big.Int)
+	for shift := uint(0); ; shift += 7 {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n.Or(n, new(big.Int).Lsh(big.NewInt(int64(b&0x7f)), shift))
+		if b&0x80 == 0 {
+			if b&0x40 != 0 {
+				n.Sub(n, new(big.Int).Lsh(big.NewInt(1), shift+7))
+			}
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package icp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCandid(t *testing.T) {
+	archive := principal.MustDecode("ryjl3-tyaaa-aaaaa-aaaba-cai")
+
+	tests := []struct {
+		name  string
+		typ   idl.Type
+		value any
+		want  any
+	}{
+		{name: "Nat", typ: new(idl.NatType), value: idl.NewBigNat(new(big.Int).Lsh(big.NewInt(1), 80)), want: new(big.Int).Lsh(big.NewInt(1), 80)},
+		{name: "Negative int", typ: new(idl.IntType), value: idl.NewInt(-129), want: big.NewInt(-129)},
+		{name: "Nat64", typ: idl.Nat64Type(), value: uint64(1) << 40, want: uint64(1) << 40},
+		{name: "Negative int32", typ: idl.Int32Type(), value: int32(-7), want: int64(-7)},
+		{name: "Float64", typ: idl.Float64Type(), value: 1.5, want: 1.5},
+		{name: "Text", typ: new(idl.TextType), value: "icrc3", want: "icrc3"},
+		{name: "Bool", typ: new(idl.BoolType), value: true, want: true},
+		{name: "Principal", typ: new(idl.PrincipalType), value: archive, want: archive},
+		{name: "Empty opt", typ: idl.NewOptionalType(new(idl.TextType)), value: nil, want: nil},
+		{name: "Opt", typ: idl.NewOptionalType(new(idl.TextType)), value: "a", want: "a"},
+		{
+			name:  "Vector",
+			typ:   idl.NewVectorType(new(idl.NatType)),
+			value: []any{idl.NewNat(uint64(1)), idl.NewNat(uint64(2))},
+			want:  []any{big.NewInt(1), big.NewInt(2)},
+		},
+		{
+			name: "Variant",
+			typ: idl.NewVariantType(map[string]idl.Type{
+				"Text": new(idl.TextType),
+				"Nat":  new(idl.NatType),
+			}),
+			value: idl.Variant{Name: "Text", Value: "memo", Type: new(idl.TextType)},
+			want:  map[uint32]any{fieldID("Text"): "memo"},
+		},
+		{
+			name:  "Func",
+			typ:   idl.NewFunctionType(nil, nil, []string{"query"}),
+			value: idl.PrincipalMethod{Principal: archive, Method: GetBlocksMethod},
+			want:  idl.PrincipalMethod{Principal: archive, Method: GetBlocksMethod},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := idl.Encode([]idl.Type{tt.typ}, []any{tt.value})
+			require.NoError(t, err)
+
+			got, err := decodeCandid(data)
+			require.NoError(t, err)
+			require.Len(t, got, 1)
+
+			if want, ok := tt.want.(*big.Int); ok {
+				require.IsType(t, want, got[0])
+				assert.Zero(t, want.Cmp(got[0].(*big.Int)))
+				return
+			}
+			if want, ok := tt.want.([]any); ok {
+				items, ok := got[0].([]any)
+				require.True(t, ok)
+				require.Len(t, items, len(want))
+				for i := range want {
+					assert.Zero(t, want[i].(*big.Int).Cmp(items[i].(*big.Int)))
+				}
+				return
+			}
+			assert.Equal(t, tt.want, got[0])
+		})
+	}
+}
+
+func TestDecodeCandidErrors(t *testing.T) {
+	valid, err := idl.Encode([]idl.Type{new(idl.TextType)}, []any{"icrc3"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Bad magic", data: []byte("DIDX\x00\x00")},
+		{name: "Truncated", data: valid[:len(valid)-1]},
+		{name: "Trailing bytes", data: append(append([]byte{}, valid...), 0)},
+		{name: "Unknown type index", data: []byte("DIDL\x00\x01\x05")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCandid(tt.data)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	icpLedger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/ledger"
 	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
 
 	"github.com/aviate-labs/agent-go"
@@ -15,10 +16,17 @@ import (
 	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
 )
 
-// ICPClients holds both logger and dex clients
+// ICPClients holds the logger, dex and ICRC-1 ledger clients of a block source
+//
+// Every source has a Logger or a Ledger client to read its blocks from; the DEX
+// client is always set for the canisters of ICPConfig, and optional for its sources.
 type Clients struct {
 	Logger *icpLogger.Agent
 	Dex    *icpDex.Agent
+	// Ledger is nil unless an ICRC-1 ledger is configured, in which case blocks are read from it
+	Ledger *icpLedger.Agent
+	// Queries sends the raw queries of GetBlocks and Query to the canisters of the source
+	Queries *QueryAgent
 }
 
 var (
@@ -31,79 +39,148 @@ var (
 //   - cfg: Configuration containing canister IDs and node URL
 //
 // Returns:
-//   - *ICPClients: A struct containing both Logger and DEX clients
+//   - *ICPClients: A struct containing the Logger, DEX and optional Ledger clients
 //   - error: Any error that occurred during initialization
 //
 // The function will:
 //  1. Parse and validate the canister IDs
 //  2. Create a new identity for the agent
-//  3. Initialize both Logger and DEX clients with the same configuration
+//  3. Initialize the Logger, DEX and, if configured, Ledger clients with the same configuration
 //  4. Return a singleton instance of ICPClients
 func NewICPClient(cfg *conf.ICPConfig) (*Clients, error) {
 	var initErr error
 
 	clientsOnce.Do(func() {
-		loggerCanisterID, err := principal.Decode(cfg.LoggerCanisterID)
-		if err != nil {
-			initErr = fmt.Errorf("invalid Logger CanisterID '%s': %w", cfg.LoggerCanisterID, err)
+		if cfg.LoggerCanisterID == "" || cfg.DexCanisterID == "" {
+			initErr = fmt.Errorf("Logger and DEX CanisterIDs are required")
 			return
 		}
 
-		dexCanisterID, err := principal.Decode(cfg.DexCanisterID)
+		agentConfig, err := NewAgentConfig(cfg)
 		if err != nil {
-			initErr = fmt.Errorf("invalid DEX CanisterID '%s': %w", cfg.DexCanisterID, err)
+			initErr = err
 			return
 		}
 
-		nodeURL, err := url.Parse(cfg.NodeURL)
-		if err != nil {
-			initErr = fmt.Errorf("invalid NodeURL '%s': %w", cfg.NodeURL, err)
-			return
-		}
+		clients, initErr = newClients(agentConfig, cfg.LoggerCanisterID, cfg.DexCanisterID, cfg.LedgerCanisterID)
+	})
+
+	if initErr != nil {
+		return nil, initErr
+	}
+
+	return clients, nil
+}
+
+// NewAgentConfig returns the agent configuration shared by the clients of every source
+//
+// Parameters:
+//   - cfg: Configuration containing the node URL, timeout and verification settings
+//
+// Returns:
+//   - agent.Config: The configuration, with a new random identity
+//   - error: If the node URL or the timeout are invalid, or the identity cannot be created
+func NewAgentConfig(cfg *conf.ICPConfig) (agent.Config, error) {
+	nodeURL, err := url.Parse(cfg.NodeURL)
+	if err != nil {
+		return agent.Config{}, fmt.Errorf("invalid NodeURL '%s': %w", cfg.NodeURL, err)
+	}
+
+	id, err := identity.NewRandomSecp256k1Identity()
+	if err != nil {
+		return agent.Config{}, fmt.Errorf("failed to create identity: %w", err)
+	}
 
-		id, err := identity.NewRandomSecp256k1Identity()
+	timeOut, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return agent.Config{}, fmt.Errorf("failed to parse timeout: %w", err)
+	}
+
+	return agent.Config{
+		ClientConfig: &agent.ClientConfig{
+			Host: nodeURL,
+		},
+		FetchRootKey:                   cfg.FetchRootKey,
+		Identity:                       id,
+		PollTimeout:                    timeOut,
+		DisableSignedQueryVerification: cfg.DisableSignedQueryVerification,
+	}, nil
+}
+
+// NewSourceClients creates the clients of a source of ICPConfig
+//
+// Unlike NewICPClient it returns new clients on every call, one set per source.
+//
+// Parameters:
+//   - agentConfig: The agent configuration, as returned by NewAgentConfig
+//   - source: The source, as checked by conf.Config.Validate
+//
+// Returns:
+//   - *Clients: The Logger client of logger and dex sources, the Ledger client of
+//     ledger sources and the DEX client of dex sources and of those configuring one
+//   - error: If a canister ID is invalid or a client cannot be created
+func NewSourceClients(agentConfig agent.Config, source conf.SourceConfig) (*Clients, error) {
+	switch source.Type {
+	case conf.SourceTypeLogger:
+		return newClients(agentConfig, source.CanisterID, source.DexCanisterID, "")
+	case conf.SourceTypeDex:
+		return newClients(agentConfig, source.LoggerCanisterID, source.CanisterID, "")
+	case conf.SourceTypeLedger:
+		return newClients(agentConfig, "", source.DexCanisterID, source.CanisterID)
+	default:
+		return nil, fmt.Errorf("invalid type '%s' of source '%s'", source.Type, source.Name)
+	}
+}
+
+// newClients creates the clients of the given canisters; an empty canister ID leaves
+// its client nil
+func newClients(agentConfig agent.Config, loggerCanisterID, dexCanisterID, ledgerCanisterID string) (*Clients, error) {
+	var result Clients
+
+	if loggerCanisterID != "" {
+		canisterID, err := principal.Decode(loggerCanisterID)
 		if err != nil {
-			initErr = fmt.Errorf("failed to create identity: %w", err)
-			return
+			return nil, fmt.Errorf("invalid Logger CanisterID '%s': %w", loggerCanisterID, err)
 		}
 
-		timeOut, err := time.ParseDuration(cfg.Timeout)
+		result.Logger, err = icpLogger.NewAgent(canisterID, agentConfig)
 		if err != nil {
-			initErr = fmt.Errorf("failed to parse timeout: %w", err)
-			return
+			return nil, fmt.Errorf("failed to create logger agent: %w", err)
 		}
+	}
 
-		agentConfig := agent.Config{
-			ClientConfig: &agent.ClientConfig{
-				Host: nodeURL,
-			},
-			FetchRootKey:                   cfg.FetchRootKey,
-			Identity:                       id,
-			PollTimeout:                    timeOut,
-			DisableSignedQueryVerification: cfg.DisableSignedQueryVerification,
+	if dexCanisterID != "" {
+		canisterID, err := principal.Decode(dexCanisterID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEX CanisterID '%s': %w", dexCanisterID, err)
 		}
 
-		loggerAgent, err := icpLogger.NewAgent(loggerCanisterID, agentConfig)
+		result.Dex, err = icpDex.NewAgent(canisterID, agentConfig)
 		if err != nil {
-			initErr = fmt.Errorf("failed to create logger agent: %w", err)
-			return
+			return nil, fmt.Errorf("failed to create dex agent: %w", err)
 		}
+	}
 
-		dexAgent, err := icpDex.NewAgent(dexCanisterID, agentConfig)
+	if ledgerCanisterID != "" {
+		canisterID, err := principal.Decode(ledgerCanisterID)
 		if err != nil {
-			initErr = fmt.Errorf("failed to create dex agent: %w", err)
-			return
+			return nil, fmt.Errorf("invalid Ledger CanisterID '%s': %w", ledgerCanisterID, err)
 		}
 
-		clients = &Clients{
-			Logger: loggerAgent,
-			Dex:    dexAgent,
+		result.Ledger, err = icpLedger.NewAgent(canisterID, agentConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ledger agent: %w", err)
 		}
-	})
+	}
 
-	if initErr != nil {
-		return nil, initErr
+	switch {
+	case result.Logger != nil:
+		result.Queries = NewQueryAgent(result.Logger.Agent, agentConfig)
+	case result.Ledger != nil:
+		result.Queries = NewQueryAgent(result.Ledger.Agent, agentConfig)
+	default:
+		return nil, fmt.Errorf("a Logger or Ledger CanisterID is required")
 	}
 
-	return clients, nil
+	return &result, nil
 }
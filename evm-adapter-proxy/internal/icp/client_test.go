@@ -0,0 +1,88 @@
+package icp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
+)
+
+func TestNewSourceClients(t *testing.T) {
+	const (
+		loggerCanister = "ydpfi-uiaaa-aaaal-qjupa-cai"
+		dexCanister    = "7eo5f-eqaaa-aaaam-adqoq-cai"
+		ledgerCanister = "mxzaz-hqaaa-aaaar-qaada-cai"
+	)
+
+	agentConfig, err := NewAgentConfig(&conf.ICPConfig{NodeURL: "http://127.0.0.1:4943", Timeout: "10s"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name                string
+		source              conf.SourceConfig
+		logger, dex, ledger string
+		wantErr             string
+	}{
+		{
+			name:   "Logger",
+			source: conf.SourceConfig{Name: "logs", Type: conf.SourceTypeLogger, CanisterID: loggerCanister},
+			logger: loggerCanister,
+		},
+		{
+			name:   "Logger with a DEX",
+			source: conf.SourceConfig{Name: "logs", Type: conf.SourceTypeLogger, CanisterID: loggerCanister, DexCanisterID: dexCanister},
+			logger: loggerCanister,
+			dex:    dexCanister,
+		},
+		{
+			name:   "DEX",
+			source: conf.SourceConfig{Name: "dex", Type: conf.SourceTypeDex, CanisterID: dexCanister, LoggerCanisterID: loggerCanister},
+			logger: loggerCanister,
+			dex:    dexCanister,
+		},
+		{
+			name:   "Ledger",
+			source: conf.SourceConfig{Name: "ckbtc", Type: conf.SourceTypeLedger, CanisterID: ledgerCanister, ChainID: 31337},
+			ledger: ledgerCanister,
+		},
+		{
+			name:    "DEX without a logger",
+			source:  conf.SourceConfig{Name: "dex", Type: conf.SourceTypeDex, CanisterID: dexCanister},
+			wantErr: "a Logger or Ledger CanisterID is required",
+		},
+		{
+			name:    "Unknown type",
+			source:  conf.SourceConfig{Name: "x", Type: "archive", CanisterID: loggerCanister},
+			wantErr: "invalid type 'archive' of source 'x'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients, err := NewSourceClients(agentConfig, tt.source)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			if tt.logger == "" {
+				assert.Nil(t, clients.Logger)
+			} else {
+				assert.Equal(t, tt.logger, clients.Logger.CanisterId.Encode())
+			}
+			if tt.dex == "" {
+				assert.Nil(t, clients.Dex)
+			} else {
+				assert.Equal(t, tt.dex, clients.Dex.CanisterId.Encode())
+			}
+			if tt.ledger == "" {
+				assert.Nil(t, clients.Ledger)
+			} else {
+				assert.Equal(t, tt.ledger, clients.Ledger.CanisterId.Encode())
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+// Package icpLedger provides a client for the "client" canister.
+// Do NOT edit this file. It was automatically generated by https://github.com/aviate-labs/agent-go.
+package icpLedger
+
+import (
+    "github.com/aviate-labs/agent-go"
+    "github.com/aviate-labs/agent-go/candid/idl"
+    "github.com/aviate-labs/agent-go/principal"
+)
+
+type Value struct {
+	Blob  *[]byte                           `ic:"Blob,variant"`
+	Text  *string                           `ic:"Text,variant"`
+	Nat   *idl.Nat                          `ic:"Nat,variant"`
+	Int   *idl.Int                          `ic:"Int,variant"`
+	Array *[]Value                          `ic:"Array,variant"`
+	Map   *[]struct {
+	Field0 string `ic:"0" json:"0"`
+	Field1 Value  `ic:"1" json:"1"`
+} `ic:"Map,variant"`
+}
+
+type Account struct {
+	Owner      principal.Principal `ic:"owner" json:"owner"`
+	Subaccount *[]byte             `ic:"subaccount,omitempty" json:"subaccount,omitempty"`
+}
+
+type MetadataValue struct {
+	Nat  *idl.Nat `ic:"Nat,variant"`
+	Int  *idl.Int `ic:"Int,variant"`
+	Text *string  `ic:"Text,variant"`
+	Blob *[]byte  `ic:"Blob,variant"`
+}
+
+type AllowanceArgs struct {
+	Account Account `ic:"account" json:"account"`
+	Spender Account `ic:"spender" json:"spender"`
+}
+
+type Allowance struct {
+	Allowance idl.Nat `ic:"allowance" json:"allowance"`
+	ExpiresAt *uint64 `ic:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+type GetArchivesArgs struct {
+	From *principal.Principal `ic:"from,omitempty" json:"from,omitempty"`
+}
+
+type GetArchivesResult = []struct {
+	CanisterId principal.Principal `ic:"canister_id" json:"canister_id"`
+	Start      idl.Nat             `ic:"start" json:"start"`
+	End        idl.Nat             `ic:"end" json:"end"`
+}
+
+type GetBlocksArgs = []struct {
+	Start  idl.Nat `ic:"start" json:"start"`
+	Length idl.Nat `ic:"length" json:"length"`
+}
+
+type GetBlocksResult struct {
+	LogLength      idl.Nat                                  `ic:"log_length" json:"log_length"`
+	Blocks         []struct {
+	Id    idl.Nat `ic:"id" json:"id"`
+	Block Value   `ic:"block" json:"block"`
+} `ic:"blocks" json:"blocks"`
+	ArchivedBlocks []ArchivedBlocks                         `ic:"archived_blocks" json:"archived_blocks"`
+}
+
+type ArchivedBlocks struct {
+	Args     GetBlocksArgs                  `ic:"args" json:"args"`
+	Callback struct { /* NOT SUPPORTED */ } `ic:"callback" json:"callback"`
+}
+
+type DataCertificate struct {
+	Certificate []byte `ic:"certificate" json:"certificate"`
+	HashTree    []byte `ic:"hash_tree" json:"hash_tree"`
+}
+
+// Agent is a client for the "client" canister.
+type Agent struct {
+    *agent.Agent
+    CanisterId principal.Principal
+}
+
+// NewAgent creates a new agent for the "client" canister.
+func NewAgent(canisterId principal.Principal, config agent.Config) (*Agent, error) {
+    a, err := agent.New(config)
+    if err != nil {
+        return nil, err
+    }
+    return &Agent{
+        Agent:      a,
+        CanisterId: canisterId,
+    }, nil
+}
+
+// Icrc1Name calls the "icrc1_name" method on the "client" canister.
+func (a Agent) Icrc1Name() (*string, error) {
+    var r0 string
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_name",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1Symbol calls the "icrc1_symbol" method on the "client" canister.
+func (a Agent) Icrc1Symbol() (*string, error) {
+    var r0 string
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_symbol",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1Decimals calls the "icrc1_decimals" method on the "client" canister.
+func (a Agent) Icrc1Decimals() (*uint8, error) {
+    var r0 uint8
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_decimals",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1Fee calls the "icrc1_fee" method on the "client" canister.
+func (a Agent) Icrc1Fee() (*idl.Nat, error) {
+    var r0 idl.Nat
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_fee",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1Metadata calls the "icrc1_metadata" method on the "client" canister.
+func (a Agent) Icrc1Metadata() (*[]struct {
+	Field0 string        `ic:"0" json:"0"`
+	Field1 MetadataValue `ic:"1" json:"1"`
+}, error) {
+    var r0 []struct {
+	Field0 string        `ic:"0" json:"0"`
+	Field1 MetadataValue `ic:"1" json:"1"`
+}
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_metadata",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1TotalSupply calls the "icrc1_total_supply" method on the "client" canister.
+func (a Agent) Icrc1TotalSupply() (*idl.Nat, error) {
+    var r0 idl.Nat
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_total_supply",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1BalanceOf calls the "icrc1_balance_of" method on the "client" canister.
+func (a Agent) Icrc1BalanceOf(arg0 Account) (*idl.Nat, error) {
+    var r0 idl.Nat
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_balance_of",
+        []any{arg0},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc1SupportedStandards calls the "icrc1_supported_standards" method on the "client" canister.
+func (a Agent) Icrc1SupportedStandards() (*[]struct {
+	Name string `ic:"name" json:"name"`
+	Url  string `ic:"url" json:"url"`
+}, error) {
+    var r0 []struct {
+	Name string `ic:"name" json:"name"`
+	Url  string `ic:"url" json:"url"`
+}
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc1_supported_standards",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc2Allowance calls the "icrc2_allowance" method on the "client" canister.
+func (a Agent) Icrc2Allowance(arg0 AllowanceArgs) (*Allowance, error) {
+    var r0 Allowance
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc2_allowance",
+        []any{arg0},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc3GetArchives calls the "icrc3_get_archives" method on the "client" canister.
+func (a Agent) Icrc3GetArchives(arg0 GetArchivesArgs) (*GetArchivesResult, error) {
+    var r0 GetArchivesResult
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc3_get_archives",
+        []any{arg0},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc3GetTipCertificate calls the "icrc3_get_tip_certificate" method on the "client" canister.
+func (a Agent) Icrc3GetTipCertificate() (**DataCertificate, error) {
+    var r0 *DataCertificate
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc3_get_tip_certificate",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc3GetBlocks calls the "icrc3_get_blocks" method on the "client" canister.
+func (a Agent) Icrc3GetBlocks(arg0 GetBlocksArgs) (*GetBlocksResult, error) {
+    var r0 GetBlocksResult
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc3_get_blocks",
+        []any{arg0},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
+
+// Icrc3SupportedBlockTypes calls the "icrc3_supported_block_types" method on the "client" canister.
+func (a Agent) Icrc3SupportedBlockTypes() (*[]struct {
+	BlockType string `ic:"block_type" json:"block_type"`
+	Url       string `ic:"url" json:"url"`
+}, error) {
+    var r0 []struct {
+	BlockType string `ic:"block_type" json:"block_type"`
+	Url       string `ic:"url" json:"url"`
+}
+    if err := a.Agent.Query(
+        a.CanisterId,
+        "icrc3_supported_block_types",
+        []any{},
+        []any{&r0},
+    ); err != nil {
+        return nil, err
+    }
+    return &r0, nil
+}
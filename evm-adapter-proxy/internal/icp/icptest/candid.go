@@ -0,0 +1,207 @@
+package icptest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/leb128"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// Candid type codes used by the hand-written type definitions
+const (
+	candidText    = -15
+	candidVector  = -19
+	candidRecord  = -20
+	candidVariant = -21
+)
+
+// valueTypeName names the Value type in type definition tables
+const valueTypeName = "Value"
+
+// valueAlternatives are the alternatives of Value, in the order of their hashes
+var valueAlternatives = func() []string {
+	alternatives := []string{"Blob", "Text", "Nat", "Int", "Array", "Map"}
+	sort.Slice(alternatives, func(i, j int) bool {
+		return idl.Hash(alternatives[i]).Cmp(idl.Hash(alternatives[j])) < 0
+	})
+	return alternatives
+}()
+
+// valueType is the Candid type of the recursive ICRC-3 Value
+//
+// idl.Marshal derives types from Go values and cannot encode a recursive type, so
+// replies holding values are encoded with idl.Encode and the types of this file. The
+// type only encodes: canisters decode their arguments with idl.Unmarshal.
+type valueType struct{}
+
+// AddTypeDefinition implements idl.Type, defining Value, its map entries and the
+// vectors of both
+func (valueType) AddTypeDefinition(tdt *idl.TypeDefinitionTable) error {
+	if _, ok := tdt.Indexes[valueTypeName]; ok {
+		return nil
+	}
+
+	// Value is defined first so the types it holds can refer to it
+	index := len(tdt.Types)
+	tdt.Indexes[valueTypeName] = index
+	tdt.Types = append(tdt.Types, nil)
+
+	valueRef := typeRef(int64(index))
+	blob := addDefinition(tdt, "vec nat8", concat(typeRef(candidVector), mustEncodeType(idl.Nat8Type(), tdt)))
+	array := addDefinition(tdt, "vec Value", concat(typeRef(candidVector), valueRef))
+	entry := addDefinition(tdt, "record {0:text; 1:Value}", concat(typeRef(candidRecord), encodeLEB128(2),
+		encodeLEB128(0), typeRef(candidText), encodeLEB128(1), valueRef))
+	entries := addDefinition(tdt, "vec record {0:text; 1:Value}", concat(typeRef(candidVector), typeRef(entry)))
+
+	alternativeTypes := map[string][]byte{
+		"Blob":  typeRef(blob),
+		"Text":  typeRef(candidText),
+		"Nat":   mustEncodeType(idl.NatType{}, tdt),
+		"Int":   mustEncodeType(idl.IntType{}, tdt),
+		"Array": typeRef(array),
+		"Map":   typeRef(entries),
+	}
+	definition := concat(typeRef(candidVariant), encodeLEB128(uint64(len(valueAlternatives))))
+	for _, alternative := range valueAlternatives {
+		definition = concat(definition, encodeLEB128(idl.Hash(alternative).Uint64()), alternativeTypes[alternative])
+	}
+	tdt.Types[index] = definition
+
+	return nil
+}
+
+// Decode implements idl.Type
+func (valueType) Decode(*bytes.Reader) (any, error) {
+	return nil, errors.New("icptest: Value is encode only")
+}
+
+// EncodeType implements idl.Type
+func (valueType) EncodeType(tdt *idl.TypeDefinitionTable) ([]byte, error) {
+	index, ok := tdt.Indexes[valueTypeName]
+	if !ok {
+		return nil, fmt.Errorf("missing type index for: %s", valueTypeName)
+	}
+	return typeRef(int64(index)), nil
+}
+
+// EncodeValue implements idl.Type
+func (t valueType) EncodeValue(v any) ([]byte, error) {
+	value, ok := v.(icpLogger.Value)
+	if !ok {
+		return nil, fmt.Errorf("invalid value: %v", v)
+	}
+
+	tag := func(alternative string) []byte {
+		return encodeLEB128(uint64(slices.Index(valueAlternatives, alternative)))
+	}
+
+	switch {
+	case value.Blob != nil:
+		return concat(tag("Blob"), encodeLEB128(uint64(len(*value.Blob))), *value.Blob), nil
+	case value.Text != nil:
+		return concat(tag("Text"), encodeLEB128(uint64(len(*value.Text))), []byte(*value.Text)), nil
+	case value.Nat != nil:
+		nat, err := idl.NatType{}.EncodeValue(*value.Nat)
+		return concat(tag("Nat"), nat), err
+	case value.Int != nil:
+		integer, err := idl.IntType{}.EncodeValue(*value.Int)
+		return concat(tag("Int"), integer), err
+	case value.Array != nil:
+		encoded := concat(tag("Array"), encodeLEB128(uint64(len(*value.Array))))
+		for _, item := range *value.Array {
+			item, err := t.EncodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			encoded = concat(encoded, item)
+		}
+		return encoded, nil
+	case value.Map != nil:
+		encoded := concat(tag("Map"), encodeLEB128(uint64(len(*value.Map))))
+		for _, entry := range *value.Map {
+			item, err := t.EncodeValue(entry.Field1)
+			if err != nil {
+				return nil, err
+			}
+			encoded = concat(encoded, encodeLEB128(uint64(len(entry.Field0))), []byte(entry.Field0), item)
+		}
+		return encoded, nil
+	}
+
+	return nil, errors.New("empty value")
+}
+
+// UnmarshalGo implements idl.Type
+func (valueType) UnmarshalGo(any, any) error {
+	return errors.New("icptest: Value is encode only")
+}
+
+// String implements idl.Type
+func (valueType) String() string {
+	return valueTypeName
+}
+
+// Types of the replies of the logger canister holding values
+var (
+	logEntriesType = idl.NewVectorType(idl.NewRecordType(map[string]idl.Type{
+		"timestamp": idl.Nat64Type(),
+		"operation": new(idl.TextType),
+		"details":   valueType{},
+		"caller":    new(idl.TextType),
+	}))
+	getBlocksResultType = idl.NewRecordType(map[string]idl.Type{
+		"log_length": new(idl.NatType),
+		"blocks": idl.NewVectorType(idl.NewRecordType(map[string]idl.Type{
+			"id":    new(idl.NatType),
+			"block": valueType{},
+		})),
+		"archived_blocks": idl.NewVectorType(idl.NewRecordType(map[string]idl.Type{
+			"args": idl.NewRecordType(map[string]idl.Type{
+				"start":  new(idl.NatType),
+				"length": new(idl.NatType),
+			}),
+			"callback": idl.NewRecordType(map[string]idl.Type{}),
+		})),
+	})
+)
+
+// addDefinition adds a type definition to a table, reusing an identical one, and
+// returns its index
+func addDefinition(tdt *idl.TypeDefinitionTable, name string, definition []byte) int64 {
+	index := slices.IndexFunc(tdt.Types, func(t []byte) bool { return bytes.Equal(t, definition) })
+	if index == -1 {
+		index = len(tdt.Types)
+		tdt.Types = append(tdt.Types, definition)
+	}
+	tdt.Indexes[name] = index
+
+	return int64(index)
+}
+
+// mustEncodeType returns the reference to a primitive type
+func mustEncodeType(t idl.Type, tdt *idl.TypeDefinitionTable) []byte {
+	encoded, err := t.EncodeType(tdt)
+	if err != nil {
+		panic(fmt.Sprintf("icptest: failed to encode type %s: %v", t, err))
+	}
+	return encoded
+}
+
+// typeRef encodes a type reference: a type code, or the index of a type definition
+func typeRef(t int64) []byte {
+	encoded, err := leb128.EncodeSigned(big.NewInt(t))
+	if err != nil {
+		panic(fmt.Sprintf("icptest: failed to encode type reference %d: %v", t, err))
+	}
+	return encoded
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
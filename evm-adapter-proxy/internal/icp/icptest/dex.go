@@ -0,0 +1,187 @@
+package icptest
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+)
+
+// Operations the DEX canister logs
+const (
+	OperationAddCurrencyPair = "add_currency_pair"
+	OperationMintTokens      = "mint_tokens"
+	OperationBurnTokens      = "burn_tokens"
+)
+
+// dexResult is the result of the mint_tokens and burn_tokens methods
+type dexResult struct {
+	Ok  *idl.Null `ic:"Ok,variant"`
+	Err *string   `ic:"Err,variant"`
+}
+
+// Dex is an in-memory DEX canister logging its operations to a logger canister
+//
+// Operations are logged the way the DEX canister logs them: for the caller of the
+// DEX, with the Rust Debug formatting of the operation as details.
+type Dex struct {
+	mu       sync.Mutex
+	logger   *Logger
+	pairs    []icpDex.CurrencyPair
+	balances map[string]map[string]*big.Int
+}
+
+// NewDex returns a DEX canister listing the given currency pairs, logging to logger
+func NewDex(logger *Logger, pairs ...icpDex.CurrencyPair) *Dex {
+	return &Dex{logger: logger, pairs: pairs, balances: make(map[string]map[string]*big.Int)}
+}
+
+// SetBalance sets the balance of an owner in a currency, without logging it
+func (d *Dex) SetBalance(owner principal.Principal, currency string, amount *big.Int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.balance(owner, currency).Set(amount)
+}
+
+// Balance returns the balance of an owner in a currency
+func (d *Dex) Balance(owner principal.Principal, currency string) *big.Int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.balanceOf(owner, currency)
+}
+
+// CertifiedData implements Canister; the DEX certifies no data
+func (d *Dex) CertifiedData() []byte {
+	return nil
+}
+
+// Query implements Canister
+func (d *Dex) Query(call Call) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch call.Method {
+	case "get_currency_pairs":
+		return idl.Marshal([]any{append([]icpDex.CurrencyPair{}, d.pairs...)})
+	case "get_token_balance":
+		var owner principal.Principal
+		var currency string
+		if err := idl.Unmarshal(call.Arg, []any{&owner, &currency}); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return idl.Marshal([]any{idl.NewBigNat(d.balanceOf(owner, currency))})
+	}
+
+	return nil, UnknownMethod(call.Method)
+}
+
+// Update implements Canister
+func (d *Dex) Update(call Call) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch call.Method {
+	case "add_currency_pair":
+		var pair icpDex.CurrencyPair
+		if err := idl.Unmarshal(call.Arg, []any{&pair}); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		d.pairs = append(d.pairs, pair)
+		d.log(call.Sender, OperationAddCurrencyPair, fmt.Sprintf(`CurrencyPair { base_currency: %q, quote_currency: %q }`,
+			pair.BaseCurrency, pair.QuoteCurrency))
+		return idl.Marshal([]any{})
+	case "mint_tokens":
+		var operation icpDex.MintOperation
+		if err := idl.Unmarshal(call.Arg, []any{&operation}); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return idl.Marshal([]any{d.mint(call.Sender, operation)})
+	case "burn_tokens":
+		var operation icpDex.BurnOperation
+		if err := idl.Unmarshal(call.Arg, []any{&operation}); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return idl.Marshal([]any{d.burn(call.Sender, operation)})
+	}
+
+	return nil, UnknownMethod(call.Method)
+}
+
+// mint credits the recipient of a mint operation; currencies of no listed pair are
+// rejected
+func (d *Dex) mint(caller principal.Principal, operation icpDex.MintOperation) dexResult {
+	if !d.listed(operation.Currency) {
+		return errResult(fmt.Sprintf("Currency %s is not listed", operation.Currency))
+	}
+
+	balance := d.balance(operation.Recipient, operation.Currency)
+	balance.Add(balance, operation.Amount.BigInt())
+	d.log(caller, OperationMintTokens, fmt.Sprintf(`MintOperation { currency: %q, amount: Nat(%s), recipient: Principal(%s) }`,
+		operation.Currency, operation.Amount.BigInt(), operation.Recipient.Encode()))
+
+	return dexResult{Ok: new(idl.Null)}
+}
+
+// burn debits the owner of a burn operation, which must hold the burnt amount
+func (d *Dex) burn(caller principal.Principal, operation icpDex.BurnOperation) dexResult {
+	balance := d.balances[operation.Owner.Encode()][operation.Currency]
+	if balance == nil {
+		return errResult("No balance for this user and currency")
+	}
+	if balance.Cmp(operation.Amount.BigInt()) < 0 {
+		return errResult("Insufficient balance")
+	}
+	balance.Sub(balance, operation.Amount.BigInt())
+	d.log(caller, OperationBurnTokens, fmt.Sprintf(`BurnOperation { currency: %q, amount: Nat(%s), owner: Principal(%s) }`,
+		operation.Currency, operation.Amount.BigInt(), operation.Owner.Encode()))
+
+	return dexResult{Ok: new(idl.Null)}
+}
+
+// listed reports whether a currency belongs to a listed pair
+func (d *Dex) listed(currency string) bool {
+	for _, pair := range d.pairs {
+		if pair.BaseCurrency == currency || pair.QuoteCurrency == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// balanceOf returns a copy of the balance of an owner, zero when it has none
+func (d *Dex) balanceOf(owner principal.Principal, currency string) *big.Int {
+	if balance := d.balances[owner.Encode()][currency]; balance != nil {
+		return new(big.Int).Set(balance)
+	}
+	return new(big.Int)
+}
+
+// balance returns the balance of an owner, creating it when needed
+func (d *Dex) balance(owner principal.Principal, currency string) *big.Int {
+	balances, ok := d.balances[owner.Encode()]
+	if !ok {
+		balances = make(map[string]*big.Int)
+		d.balances[owner.Encode()] = balances
+	}
+	if balances[currency] == nil {
+		balances[currency] = new(big.Int)
+	}
+	return balances[currency]
+}
+
+// log logs an operation to the logger; details are formatted with Debug once by the
+// DEX and once more by log_operation, hence the quoting
+func (d *Dex) log(caller principal.Principal, operation, debug string) {
+	details := mapValue(mapEntry{Field0: "value", Field1: textValue(strconv.Quote(debug))})
+	d.logger.AddEntry(operation, details, caller)
+}
+
+func errResult(message string) dexResult {
+	return dexResult{Err: &message}
+}
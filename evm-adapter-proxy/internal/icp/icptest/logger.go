@@ -0,0 +1,331 @@
+package icptest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// logEntryBlockType is the block type of the logger canister
+const logEntryBlockType = "log_entry"
+
+// Logger is an in-memory logger canister: an ICRC-3 log with one block per entry
+//
+// Blocks have the shape the logger canister serves (id, hash, phash, btype, ts,
+// finalized and entries), and the last one is the tip the canister certifies, with the
+// last_block_index and last_block_hash labels of the ICRC-3 tip certificate.
+type Logger struct {
+	mu      sync.Mutex
+	chainID string
+	blocks  []icpLogger.Block
+	// certified is the number of blocks of the tip last read by the replica
+	certified int
+}
+
+// NewLogger returns an empty logger canister answering chain_id and net_version with
+// the given chain ID
+func NewLogger(chainID string) *Logger {
+	return &Logger{chainID: chainID}
+}
+
+// AddEntry appends a block holding a single entry and returns its index
+//
+// Parameters:
+//   - operation: The operation of the entry
+//   - details: The details of the entry
+//   - caller: The principal the entry is logged for
+//
+// Returns:
+//   - uint64: The index of the new block
+func (l *Logger) AddEntry(operation string, details icpLogger.Value, caller principal.Principal) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	timestamp := uint64(time.Now().UnixNano())
+	phash := make([]byte, sha256.Size)
+	if len(l.blocks) > 0 {
+		l.blocks[len(l.blocks)-1].Finalized = true
+		phash = l.blocks[len(l.blocks)-1].Hash
+	}
+
+	index := uint64(len(l.blocks))
+	block := icpLogger.Block{
+		Id:    idl.NewNat(index),
+		Phash: phash,
+		Btype: logEntryBlockType,
+		Ts:    timestamp,
+	}
+	entry := icpLogger.LogEntry{
+		Timestamp: timestamp,
+		Operation: operation,
+		Details:   details,
+		Caller:    caller.Encode(),
+	}
+	block.Entries = []icpLogger.Value{entryValue(entry)}
+	block.Hash = blockHash(block, entry)
+	l.blocks = append(l.blocks, block)
+
+	return index
+}
+
+// Len returns the number of blocks of the log
+func (l *Logger) Len() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return uint64(len(l.blocks))
+}
+
+// BlockHash returns the hash of a block, the hash the adapter serves for it
+func (l *Logger) BlockHash(index uint64) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.blocks[index].Hash
+}
+
+// CertifiedData implements Canister, certifying the tip of the log
+func (l *Logger) CertifiedData() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.certified = len(l.blocks)
+	if l.certified == 0 {
+		return nil
+	}
+
+	digest := l.tipTree().Reconstruct()
+	return digest[:]
+}
+
+// Query implements Canister
+func (l *Logger) Query(call Call) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch call.Method {
+	case "chain_id", "net_version":
+		return idl.Marshal([]any{l.chainID})
+	case "get_logs":
+		logs := make([]icpLogger.LogEntry, 0, len(l.blocks))
+		for _, block := range l.blocks {
+			logs = append(logs, blockEntry(block))
+		}
+		return idl.Encode([]idl.Type{logEntriesType}, []any{logs})
+	case "icrc3_get_blocks":
+		var args icpLogger.GetBlocksArgs
+		if err := idl.Unmarshal(call.Arg, []any{&args}); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return idl.Encode([]idl.Type{getBlocksResultType}, []any{l.getBlocks(args.Start.BigInt().Uint64(), args.Length.BigInt().Uint64())})
+	case "icrc3_get_tip_certificate":
+		return l.tipCertificate(call.Certificate)
+	case "icrc3_get_archives":
+		return idl.Marshal([]any{icpLogger.GetArchivesResult{}})
+	case "icrc3_supported_block_types":
+		return idl.Marshal([]any{[]icpLogger.BlockTypeInfo{{
+			BlockType: logEntryBlockType,
+			Url:       "https://github.com/dfinity/ICRC-1/blob/main/standards/ICRC-3/README.md",
+		}}})
+	}
+
+	return nil, UnknownMethod(call.Method)
+}
+
+// Update implements Canister
+func (l *Logger) Update(call Call) ([]byte, error) {
+	if call.Method != "add_entry" {
+		return nil, UnknownMethod(call.Method)
+	}
+
+	var operation string
+	var details icpLogger.Value
+	var caller principal.Principal
+	if err := idl.Unmarshal(call.Arg, []any{&operation, &details, &caller}); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if operation == "" {
+		return nil, errors.New("Operation cannot be empty")
+	}
+
+	l.AddEntry(operation, details, caller)
+	return idl.Marshal([]any{})
+}
+
+// getBlocks returns the blocks of a range, as served by icrc3_get_blocks
+func (l *Logger) getBlocks(start, length uint64) icpLogger.GetBlocksResult {
+	result := icpLogger.GetBlocksResult{
+		LogLength:      idl.NewNat(uint64(len(l.blocks))),
+		ArchivedBlocks: []icpLogger.ArchivedBlock{},
+	}
+	for index := start; index < start+length && index < uint64(len(l.blocks)); index++ {
+		result.Blocks = append(result.Blocks, struct {
+			Id    idl.Nat         `ic:"id" json:"id"`
+			Block icpLogger.Value `ic:"block" json:"block"`
+		}{Id: idl.NewNat(index), Block: blockValue(l.blocks[index])})
+	}
+
+	return result
+}
+
+// tipCertificate returns the tip certificate of the log, None when it is empty
+func (l *Logger) tipCertificate(certificate []byte) ([]byte, error) {
+	if l.certified == 0 {
+		return idl.Marshal([]any{(*icpLogger.DataCertificate)(nil)})
+	}
+
+	hashTree, err := hashtree.Serialize(l.tipTree())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tip hash tree: %w", err)
+	}
+
+	return idl.Marshal([]any{&icpLogger.DataCertificate{Certificate: certificate, HashTree: hashTree}})
+}
+
+// tipTree returns the ICRC-3 tip hash tree of the last certified block
+func (l *Logger) tipTree() hashtree.Node {
+	tip := l.blocks[l.certified-1]
+	return hashtree.Fork{
+		LeftTree:  hashtree.Labeled{Label: hashtree.Label("last_block_hash"), Tree: hashtree.Leaf(tip.Hash)},
+		RightTree: hashtree.Labeled{Label: hashtree.Label("last_block_index"), Tree: hashtree.Leaf(encodeLEB128(uint64(l.certified - 1)))},
+	}
+}
+
+// blockHash hashes the content of a block as the logger canister does, which is not
+// the ICRC-3 representation-independent hash
+func blockHash(block icpLogger.Block, entries ...icpLogger.LogEntry) []byte {
+	hasher := sha256.New()
+	hasher.Write(littleEndian(block.Id.BigInt()))
+	hasher.Write(block.Phash)
+	hasher.Write([]byte(block.Btype))
+	hasher.Write(binary.BigEndian.AppendUint64(nil, block.Ts))
+	for _, entry := range entries {
+		hasher.Write(binary.BigEndian.AppendUint64(nil, entry.Timestamp))
+		hasher.Write([]byte(entry.Operation))
+		hasher.Write(valueHash(entry.Details))
+		hasher.Write([]byte(entry.Caller))
+	}
+
+	return hasher.Sum(nil)
+}
+
+// valueHash hashes a value as the logger canister does; map entries are hashed in the
+// order of their key and value hashes
+func valueHash(value icpLogger.Value) []byte {
+	hasher := sha256.New()
+	switch {
+	case value.Nat != nil:
+		hasher.Write(littleEndian(value.Nat.BigInt()))
+	case value.Int != nil:
+		// The canister holds integers as i128
+		twos := new(big.Int).And(value.Int.BigInt(), new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1)))
+		encoded := make([]byte, 16)
+		twos.FillBytes(encoded)
+		slices.Reverse(encoded)
+		hasher.Write(encoded)
+	case value.Text != nil:
+		hasher.Write([]byte(*value.Text))
+	case value.Blob != nil:
+		hasher.Write(*value.Blob)
+	case value.Array != nil:
+		for _, item := range *value.Array {
+			hasher.Write(valueHash(item))
+		}
+	case value.Map != nil:
+		hashes := make([][]byte, 0, len(*value.Map))
+		for _, entry := range *value.Map {
+			key := sha256.Sum256([]byte(entry.Field0))
+			hashes = append(hashes, append(key[:], valueHash(entry.Field1)...))
+		}
+		slices.SortFunc(hashes, bytes.Compare)
+		for _, hash := range hashes {
+			hasher.Write(hash)
+		}
+	}
+
+	return hasher.Sum(nil)
+}
+
+// littleEndian encodes a natural number in little-endian bytes, zero as one byte
+func littleEndian(n *big.Int) []byte {
+	encoded := n.Bytes()
+	if len(encoded) == 0 {
+		return []byte{0}
+	}
+	slices.Reverse(encoded)
+	return encoded
+}
+
+// blockValue returns the ICRC-3 value of a block
+func blockValue(block icpLogger.Block) icpLogger.Value {
+	return mapValue(
+		mapEntry{Field0: "id", Field1: icpLogger.Value{Nat: &block.Id}},
+		mapEntry{Field0: "hash", Field1: blobValue(block.Hash)},
+		mapEntry{Field0: "phash", Field1: blobValue(block.Phash)},
+		mapEntry{Field0: "btype", Field1: textValue(block.Btype)},
+		mapEntry{Field0: "ts", Field1: natValue(block.Ts)},
+		mapEntry{Field0: "finalized", Field1: textValue(fmt.Sprint(block.Finalized))},
+		mapEntry{Field0: "entries", Field1: icpLogger.Value{Array: &block.Entries}},
+	)
+}
+
+// entryValue returns the ICRC-3 value of a log entry
+func entryValue(entry icpLogger.LogEntry) icpLogger.Value {
+	return mapValue(
+		mapEntry{Field0: "timestamp", Field1: natValue(entry.Timestamp)},
+		mapEntry{Field0: "operation", Field1: textValue(entry.Operation)},
+		mapEntry{Field0: "details", Field1: entry.Details},
+		mapEntry{Field0: "caller", Field1: textValue(entry.Caller)},
+	)
+}
+
+// blockEntry returns the log entry of a block, as served by get_logs
+func blockEntry(block icpLogger.Block) icpLogger.LogEntry {
+	entry := icpLogger.LogEntry{Timestamp: block.Ts}
+	for _, field := range *block.Entries[0].Map {
+		switch field.Field0 {
+		case "operation":
+			entry.Operation = *field.Field1.Text
+		case "details":
+			entry.Details = field.Field1
+		case "caller":
+			entry.Caller = *field.Field1.Text
+		}
+	}
+
+	return entry
+}
+
+// mapEntry is an entry of a Map value
+type mapEntry = struct {
+	Field0 string          `ic:"0" json:"0"`
+	Field1 icpLogger.Value `ic:"1" json:"1"`
+}
+
+func mapValue(entries ...mapEntry) icpLogger.Value {
+	return icpLogger.Value{Map: &entries}
+}
+
+func textValue(text string) icpLogger.Value {
+	return icpLogger.Value{Text: &text}
+}
+
+func blobValue(blob []byte) icpLogger.Value {
+	return icpLogger.Value{Blob: &blob}
+}
+
+func natValue(n uint64) icpLogger.Value {
+	nat := idl.NewNat(n)
+	return icpLogger.Value{Nat: &nat}
+}
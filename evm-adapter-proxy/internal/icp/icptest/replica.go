@@ -0,0 +1,456 @@
+// Package icptest provides a local Internet Computer replica for end-to-end tests
+//
+// The replica serves the IC HTTP interface agent-go talks to (status, query, call and
+// read_state, CBOR encoded) from in-memory canisters, so the ICP clients and the
+// adapter can run against it without dfx or network access. Its certificates are
+// signed with a root key of its own, which agents fetch with FetchRootKey, and its
+// query responses are signed by a node of the root subnet, so agents can keep
+// certificate and signed query verification enabled.
+package icptest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/bls"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// apiVersion is the version of the IC HTTP interface reported by the status endpoint
+const apiVersion = "0.18.0"
+
+// Reject codes of the IC interface specification
+const (
+	RejectCodeSysFatal           = 1
+	RejectCodeSysTransient       = 2
+	RejectCodeDestinationInvalid = 3
+	RejectCodeCanisterReject     = 4
+	RejectCodeCanisterError      = 5
+)
+
+// Canister is a canister installed on a Replica
+//
+// The replica executes one call at a time, like a single subnet: the certified data
+// read for a query is the one the query executes against. Implementations still guard
+// their state when tests script it directly, outside of the replica.
+type Canister interface {
+	// Query answers a query call with its Candid encoded reply
+	Query(call Call) ([]byte, error)
+	// Update executes an update call and returns its Candid encoded reply
+	Update(call Call) ([]byte, error)
+	// CertifiedData returns the data the canister certifies, or nil for none
+	CertifiedData() []byte
+}
+
+// Call is a call to a method of a canister
+type Call struct {
+	// Sender is the principal that signed the request
+	Sender principal.Principal
+	// Method is the name of the called method
+	Method string
+	// Arg is the Candid encoded argument
+	Arg []byte
+	// Certificate certifies the certified data of the canister, as returned by
+	// ic0.data_certificate; only set for queries to canisters certifying data
+	Certificate []byte
+}
+
+// Reject is an error rejecting a call with a reject code of the IC interface
+//
+// Other errors returned by canisters are reported as canister errors, the way the
+// replica reports a trapping canister.
+type Reject struct {
+	Code      uint64
+	Message   string
+	ErrorCode string
+}
+
+// Error implements error
+func (r Reject) Error() string {
+	return fmt.Sprintf("(%d) %s: %s", r.Code, r.ErrorCode, r.Message)
+}
+
+// UnknownMethod returns the rejection of a call to a method a canister does not export
+func UnknownMethod(method string) error {
+	return Reject{
+		Code:      RejectCodeDestinationInvalid,
+		Message:   fmt.Sprintf("Canister has no method '%s'", method),
+		ErrorCode: "IC0536",
+	}
+}
+
+// Replica is a local replica serving the IC HTTP interface from installed canisters
+type Replica struct {
+	server     *httptest.Server
+	rootKey    *bls.SecretKey
+	rootKeyDER []byte
+	nodeKey    ed25519.PrivateKey
+	nodeKeyDER []byte
+	nodeID     principal.Principal
+
+	// execution serializes the calls executed by canisters
+	execution sync.Mutex
+
+	mu        sync.Mutex
+	canisters map[string]Canister
+	requests  map[agent.RequestID]requestStatus
+}
+
+// requestStatus is the outcome of an update call, as read back with read_state
+type requestStatus struct {
+	reply  []byte
+	reject *Reject
+}
+
+// NewReplica starts a replica without canisters; Close stops it
+func NewReplica() *Replica {
+	rootKey := bls.NewSecretKeyByCSPRNG()
+	if rootKey == nil {
+		panic("icptest: failed to generate the root key")
+	}
+	rootKeyDER, err := certification.PublicBLSKeyToDER(blsPublicKeyBytes(rootKey))
+	if err != nil {
+		panic(fmt.Sprintf("icptest: failed to encode the root key: %v", err))
+	}
+
+	nodePublicKey, nodeKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("icptest: failed to generate the node key: %v", err))
+	}
+	nodeKeyDER, err := x509.MarshalPKIXPublicKey(nodePublicKey)
+	if err != nil {
+		panic(fmt.Sprintf("icptest: failed to encode the node key: %v", err))
+	}
+
+	r := &Replica{
+		rootKey:    rootKey,
+		rootKeyDER: rootKeyDER,
+		nodeKey:    nodeKey,
+		nodeKeyDER: nodeKeyDER,
+		nodeID:     principal.NewSelfAuthenticating(nodeKeyDER),
+		canisters:  make(map[string]Canister),
+		requests:   make(map[agent.RequestID]requestStatus),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/status", r.handleStatus)
+	mux.HandleFunc("POST /api/v2/canister/{canisterID}/query", r.handleQuery)
+	mux.HandleFunc("POST /api/v2/canister/{canisterID}/call", r.handleCall)
+	mux.HandleFunc("POST /api/v2/canister/{canisterID}/read_state", r.handleReadState)
+	r.server = httptest.NewServer(mux)
+
+	return r
+}
+
+// URL returns the base URL of the replica, the NodeURL of the agents using it
+func (r *Replica) URL() string {
+	return r.server.URL
+}
+
+// RootKey returns the DER encoded root key the replica signs its certificates with
+func (r *Replica) RootKey() []byte {
+	return r.rootKeyDER
+}
+
+// Close stops the replica
+func (r *Replica) Close() {
+	r.server.Close()
+}
+
+// Install installs a canister under an ID, replacing any canister installed there
+func (r *Replica) Install(canisterID principal.Principal, canister Canister) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.canisters[string(canisterID.Raw)] = canister
+}
+
+// canister returns the canister installed under an ID
+func (r *Replica) canister(canisterID []byte) (Canister, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	canister, ok := r.canisters[string(canisterID)]
+	return canister, ok
+}
+
+// handleStatus serves the status of the replica, holding its root key
+func (r *Replica) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	writeCBOR(w, http.StatusOK, map[string]any{
+		"ic_api_version": apiVersion,
+		"impl_source":    "icptest",
+		"root_key":       r.rootKeyDER,
+	})
+}
+
+// handleQuery answers a query call with a response signed by the replica node
+func (r *Replica) handleQuery(w http.ResponseWriter, req *http.Request) {
+	content, ok := readRequest(w, req, agent.RequestTypeQuery)
+	if !ok {
+		return
+	}
+
+	var reply []byte
+	canister, err := r.installed(content.CanisterID)
+	if err == nil {
+		r.execution.Lock()
+		call := content.call()
+		if certifiedData := canister.CertifiedData(); certifiedData != nil {
+			call.Certificate, err = r.certificate(r.stateTree(content.CanisterID, certifiedData))
+		}
+		if err == nil {
+			reply, err = canister.Query(call)
+		}
+		r.execution.Unlock()
+	}
+
+	response, err := r.signedResponse(content.requestID(), reply, toReject(content.CanisterID, err))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeCBOR(w, http.StatusOK, response)
+}
+
+// handleCall executes an update call and records its outcome for read_state
+//
+// Calls are executed before the reply, so the first poll of the agent finds them done.
+func (r *Replica) handleCall(w http.ResponseWriter, req *http.Request) {
+	content, ok := readRequest(w, req, agent.RequestTypeCall)
+	if !ok {
+		return
+	}
+
+	var reply []byte
+	canister, err := r.installed(content.CanisterID)
+	if err == nil {
+		r.execution.Lock()
+		reply, err = canister.Update(content.call())
+		r.execution.Unlock()
+	}
+
+	r.mu.Lock()
+	r.requests[content.requestID()] = requestStatus{reply: reply, reject: toReject(content.CanisterID, err)}
+	r.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleReadState returns a certificate of the replica state tree
+//
+// The tree holds the whole state rather than the requested paths only: the time, the
+// node keys of the subnet, the status of every update call and the certified data of
+// the canister.
+func (r *Replica) handleReadState(w http.ResponseWriter, req *http.Request) {
+	if _, ok := readRequest(w, req, agent.RequestTypeReadState); !ok {
+		return
+	}
+
+	canisterID, err := principal.Decode(req.PathValue("canisterID"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid canister ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var certifiedData []byte
+	if canister, ok := r.canister(canisterID.Raw); ok {
+		certifiedData = canister.CertifiedData()
+	}
+
+	certificate, err := r.certificate(r.stateTree(canisterID.Raw, certifiedData))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeCBOR(w, http.StatusOK, map[string][]byte{"certificate": certificate})
+}
+
+// installed returns the canister a request is sent to, or the rejection of a request
+// to a canister that does not exist
+func (r *Replica) installed(canisterID []byte) (Canister, error) {
+	canister, ok := r.canister(canisterID)
+	if !ok {
+		return nil, Reject{
+			Code:      RejectCodeDestinationInvalid,
+			Message:   fmt.Sprintf("Canister %s not found", principal.Principal{Raw: canisterID}.Encode()),
+			ErrorCode: "IC0301",
+		}
+	}
+
+	return canister, nil
+}
+
+// toReject converts the error of a canister call into its rejection
+func toReject(canisterID []byte, err error) *Reject {
+	if err == nil {
+		return nil
+	}
+
+	var reject Reject
+	if errors.As(err, &reject) {
+		return &reject
+	}
+
+	return &Reject{
+		Code:      RejectCodeCanisterError,
+		Message:   fmt.Sprintf("Canister %s trapped explicitly: %v", principal.Principal{Raw: canisterID}.Encode(), err),
+		ErrorCode: "IC0503",
+	}
+}
+
+// queryResponse is the body of a query response
+type queryResponse struct {
+	Status        string           `cbor:"status"`
+	Reply         cbor.RawMessage  `cbor:"reply,omitempty"`
+	RejectCode    uint64           `cbor:"reject_code,omitempty"`
+	RejectMessage string           `cbor:"reject_message,omitempty"`
+	ErrorCode     string           `cbor:"error_code,omitempty"`
+	Signatures    []querySignature `cbor:"signatures"`
+}
+
+// querySignature is the signature of a query response by a replica node
+type querySignature struct {
+	Timestamp uint64 `cbor:"timestamp"`
+	Signature []byte `cbor:"signature"`
+	Identity  []byte `cbor:"identity"`
+}
+
+// signedResponse builds the response to a query, signed by the replica node over the
+// representation-independent hash of its fields and the request ID
+func (r *Replica) signedResponse(requestID agent.RequestID, reply []byte, reject *Reject) (queryResponse, error) {
+	timestamp := time.Now().UnixNano()
+	response := queryResponse{Status: "replied"}
+	var fields []certification.KeyValuePair
+
+	if reject != nil {
+		response.Status = "rejected"
+		response.RejectCode = reject.Code
+		response.RejectMessage = reject.Message
+		response.ErrorCode = reject.ErrorCode
+		fields = []certification.KeyValuePair{
+			{Key: "status", Value: response.Status},
+			{Key: "reject_code", Value: encodeLEB128(reject.Code)},
+			{Key: "reject_message", Value: reject.Message},
+			{Key: "error_code", Value: reject.ErrorCode},
+		}
+	} else {
+		encoded, err := cbor.Marshal(map[string][]byte{"arg": reply})
+		if err != nil {
+			return queryResponse{}, fmt.Errorf("failed to encode reply: %w", err)
+		}
+		response.Reply = encoded
+		fields = []certification.KeyValuePair{
+			{Key: "status", Value: response.Status},
+			{Key: "reply", Value: response.Reply},
+		}
+	}
+
+	hash, err := certification.RepresentationIndependentHash(append(fields,
+		certification.KeyValuePair{Key: "timestamp", Value: timestamp},
+		certification.KeyValuePair{Key: "request_id", Value: requestID[:]},
+	))
+	if err != nil {
+		return queryResponse{}, fmt.Errorf("failed to hash response: %w", err)
+	}
+
+	response.Signatures = []querySignature{{
+		Timestamp: uint64(timestamp),
+		Signature: ed25519.Sign(r.nodeKey, append(hashtree.DomainSeparator("ic-response"), hash[:]...)),
+		Identity:  r.nodeID.Raw,
+	}}
+
+	return response, nil
+}
+
+// request is the content of a request envelope
+type request struct {
+	Type          string     `cbor:"request_type"`
+	Sender        []byte     `cbor:"sender"`
+	Nonce         []byte     `cbor:"nonce"`
+	IngressExpiry uint64     `cbor:"ingress_expiry"`
+	CanisterID    []byte     `cbor:"canister_id"`
+	MethodName    string     `cbor:"method_name"`
+	Arg           []byte     `cbor:"arg"`
+	Paths         [][][]byte `cbor:"paths"`
+}
+
+// readRequest decodes the envelope of a request of the given type, replying with an
+// error when it cannot
+//
+// Sender signatures are not checked: the replica trusts its clients.
+func readRequest(w http.ResponseWriter, req *http.Request, requestType string) (request, bool) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return request{}, false
+	}
+
+	var envelope struct {
+		Content request `cbor:"content"`
+	}
+	if err := cbor.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request envelope: %v", err), http.StatusBadRequest)
+		return request{}, false
+	}
+	if envelope.Content.Type != requestType {
+		http.Error(w, fmt.Sprintf("invalid request type %q", envelope.Content.Type), http.StatusBadRequest)
+		return request{}, false
+	}
+
+	return envelope.Content, true
+}
+
+// call returns the canister call of a query or update request
+func (c request) call() Call {
+	return Call{Sender: principal.Principal{Raw: c.Sender}, Method: c.MethodName, Arg: c.Arg}
+}
+
+// requestID returns the ID of a request, as computed by the agent that sent it
+func (c request) requestID() agent.RequestID {
+	var paths [][]hashtree.Label
+	for _, path := range c.Paths {
+		labels := make([]hashtree.Label, len(path))
+		for i, label := range path {
+			labels[i] = label
+		}
+		paths = append(paths, labels)
+	}
+
+	return agent.NewRequestID(agent.Request{
+		Type:          c.Type,
+		Sender:        principal.Principal{Raw: c.Sender},
+		Nonce:         c.Nonce,
+		IngressExpiry: c.IngressExpiry,
+		CanisterID:    principal.Principal{Raw: c.CanisterID},
+		MethodName:    c.MethodName,
+		Arguments:     c.Arg,
+		Paths:         paths,
+	})
+}
+
+// writeCBOR writes a CBOR encoded response
+func writeCBOR(w http.ResponseWriter, status int, body any) {
+	encoded, err := cbor.Marshal(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/cbor")
+	w.WriteHeader(status)
+	_, _ = w.Write(encoded)
+}
@@ -0,0 +1,246 @@
+package icptest
+
+import (
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/identity"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+var (
+	testLoggerCanister = principal.MustDecode("ydpfi-uiaaa-aaaal-qjupa-cai")
+	testDexCanister    = principal.MustDecode("7eo5f-eqaaa-aaaam-adqoq-cai")
+	testRecipient      = principal.MustDecode("rrkah-fqaaa-aaaaa-aaaaq-cai")
+)
+
+// newTestReplica starts a replica with a logger and a DEX canister listing USD/EUR,
+// and returns agents talking to them with every verification enabled
+func newTestReplica(t *testing.T) (*Replica, *Logger, *Dex, *icpLogger.Agent, *icpDex.Agent) {
+	return newTestReplicaWith(t, func(*agent.Config) {})
+}
+
+// newTestReplicaWith is newTestReplica with an agent configuration of the test
+func newTestReplicaWith(t *testing.T, configure func(*agent.Config)) (*Replica, *Logger, *Dex, *icpLogger.Agent, *icpDex.Agent) {
+	replica := NewReplica()
+	t.Cleanup(replica.Close)
+
+	logger := NewLogger("1337")
+	dex := NewDex(logger, icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"})
+	replica.Install(testLoggerCanister, logger)
+	replica.Install(testDexCanister, dex)
+
+	host, err := url.Parse(replica.URL())
+	require.NoError(t, err)
+	id, err := identity.NewRandomSecp256k1Identity()
+	require.NoError(t, err)
+	config := agent.Config{
+		ClientConfig: &agent.ClientConfig{Host: host},
+		FetchRootKey: true,
+		Identity:     id,
+		PollDelay:    10 * time.Millisecond,
+		PollTimeout:  5 * time.Second,
+	}
+	configure(&config)
+
+	loggerAgent, err := icpLogger.NewAgent(testLoggerCanister, config)
+	require.NoError(t, err)
+	dexAgent, err := icpDex.NewAgent(testDexCanister, config)
+	require.NoError(t, err)
+
+	return replica, logger, dex, loggerAgent, dexAgent
+}
+
+func TestReplicaQueries(t *testing.T) {
+	replica, logger, _, loggerAgent, _ := newTestReplica(t)
+	assert.Equal(t, replica.RootKey(), loggerAgent.GetRootKey())
+
+	chainID, err := loggerAgent.ChainId()
+	require.NoError(t, err)
+	assert.Equal(t, "1337", *chainID)
+
+	blocks, err := loggerAgent.Icrc3GetBlocks(icpLogger.GetBlocksArgs{Start: idl.NewNat(uint64(0)), Length: idl.NewNat(uint64(10))})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), blocks.LogLength.BigInt().Uint64())
+	assert.Empty(t, blocks.Blocks)
+
+	details := mapValue(mapEntry{Field0: "key", Field1: textValue("value")})
+	logger.AddEntry("first", details, testRecipient)
+	logger.AddEntry("second", details, testRecipient)
+
+	blocks, err = loggerAgent.Icrc3GetBlocks(icpLogger.GetBlocksArgs{Start: idl.NewNat(uint64(1)), Length: idl.NewNat(uint64(10))})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), blocks.LogLength.BigInt().Uint64())
+	require.Len(t, blocks.Blocks, 1)
+	assert.Equal(t, uint64(1), blocks.Blocks[0].Id.BigInt().Uint64())
+	assert.Equal(t, logger.BlockHash(1), *field(t, blocks.Blocks[0].Block, "hash").Blob)
+	assert.Equal(t, logger.BlockHash(0), *field(t, blocks.Blocks[0].Block, "phash").Blob)
+	assert.Equal(t, "false", *field(t, blocks.Blocks[0].Block, "finalized").Text)
+
+	logs, err := loggerAgent.GetLogs()
+	require.NoError(t, err)
+	require.Len(t, *logs, 2)
+	assert.Equal(t, "second", (*logs)[1].Operation)
+	assert.Equal(t, testRecipient.Encode(), (*logs)[1].Caller)
+}
+
+func TestReplicaTipCertificate(t *testing.T) {
+	replica, logger, _, loggerAgent, _ := newTestReplica(t)
+
+	tip, err := loggerAgent.Icrc3GetTipCertificate()
+	require.NoError(t, err)
+	assert.Nil(t, *tip)
+
+	logger.AddEntry("first", textValue("details"), testRecipient)
+	index := logger.AddEntry("second", textValue("details"), testRecipient)
+
+	tip, err = loggerAgent.Icrc3GetTipCertificate()
+	require.NoError(t, err)
+	require.NotNil(t, *tip)
+
+	var certificate certification.Certificate
+	require.NoError(t, cbor.Unmarshal((*tip).Certificate, &certificate))
+	var tree hashtree.HashTree
+	require.NoError(t, cbor.Unmarshal((*tip).HashTree, &tree))
+	digest := tree.Digest()
+	require.NoError(t, certification.VerifyCertifiedData(certificate, testLoggerCanister, replica.RootKey(), digest[:]))
+
+	hash, err := tree.Lookup(hashtree.Label("last_block_hash"))
+	require.NoError(t, err)
+	assert.Equal(t, logger.BlockHash(index), hash)
+	lastIndex, err := tree.Lookup(hashtree.Label("last_block_index"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{byte(index)}, lastIndex)
+
+	// A certificate of another replica does not verify
+	require.Error(t, certification.VerifyCertifiedData(certificate, testLoggerCanister, NewReplica().RootKey(), digest[:]))
+}
+
+func TestReplicaUpdates(t *testing.T) {
+	_, logger, dex, loggerAgent, dexAgent := newTestReplica(t)
+
+	result, err := dexAgent.MintTokens(icpDex.MintOperation{Currency: "USD", Amount: idl.NewNat(uint64(100)), Recipient: testRecipient})
+	require.NoError(t, err)
+	assert.NotNil(t, result.Ok)
+	assert.Equal(t, big.NewInt(100), dex.Balance(testRecipient, "USD"))
+
+	balance, err := dexAgent.GetTokenBalance(testRecipient, "USD")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), balance.BigInt().Uint64())
+
+	result, err = dexAgent.BurnTokens(icpDex.BurnOperation{Currency: "USD", Amount: idl.NewNat(uint64(150)), Owner: testRecipient})
+	require.NoError(t, err)
+	require.NotNil(t, result.Err)
+	assert.Equal(t, "Insufficient balance", *result.Err)
+
+	result, err = dexAgent.MintTokens(icpDex.MintOperation{Currency: "JPY", Amount: idl.NewNat(uint64(1)), Recipient: testRecipient})
+	require.NoError(t, err)
+	require.NotNil(t, result.Err)
+	assert.Equal(t, "Currency JPY is not listed", *result.Err)
+
+	logs, err := loggerAgent.GetLogs()
+	require.NoError(t, err)
+	require.Len(t, *logs, 1)
+	assert.Equal(t, OperationMintTokens, (*logs)[0].Operation)
+	assert.Equal(t, dexAgent.Sender().Encode(), (*logs)[0].Caller)
+	assert.Equal(t, `"MintOperation { currency: \"USD\", amount: Nat(100), recipient: Principal(`+testRecipient.Encode()+`) }"`,
+		*(*(*logs)[0].Details.Map)[0].Field1.Text)
+	assert.Equal(t, uint64(1), logger.Len())
+}
+
+func TestLoggerAddEntry(t *testing.T) {
+	_, logger, _, loggerAgent, _ := newTestReplica(t)
+
+	// The generated client cannot encode Value arguments, so add_entry is called with
+	// the arguments a canister would send
+	details := mapValue(
+		mapEntry{Field0: "value", Field1: textValue("details")},
+		mapEntry{Field0: "items", Field1: icpLogger.Value{Array: &[]icpLogger.Value{natValue(1), blobValue([]byte{2})}}},
+	)
+	_, err := logger.Update(Call{Sender: testDexCanister, Method: "add_entry", Arg: mustEncode(t, "operation", details, testRecipient)})
+	require.NoError(t, err)
+
+	logs, err := loggerAgent.GetLogs()
+	require.NoError(t, err)
+	require.Len(t, *logs, 1)
+	assert.Equal(t, "operation", (*logs)[0].Operation)
+	assert.Equal(t, testRecipient.Encode(), (*logs)[0].Caller)
+	assert.Equal(t, details, (*logs)[0].Details)
+
+	_, err = logger.Update(Call{Method: "add_entry", Arg: mustEncode(t, "", details, testRecipient)})
+	assert.EqualError(t, err, "Operation cannot be empty")
+}
+
+func TestReplicaRejects(t *testing.T) {
+	// agent-go fails to hash the reject code of rejected queries when verifying their
+	// signature, so rejects are read unverified, as from any replica
+	_, _, _, loggerAgent, _ := newTestReplicaWith(t, func(config *agent.Config) {
+		config.DisableSignedQueryVerification = true
+	})
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr string
+	}{
+		{
+			name: "Trapping update",
+			call: func() error {
+				return loggerAgent.Call(testLoggerCanister, "add_entry", []any{"operation"}, []any{})
+			},
+			wantErr: "(5) Canister " + testLoggerCanister.Encode() + " trapped explicitly: invalid arguments",
+		},
+		{
+			name: "Unknown query method",
+			call: func() error {
+				return loggerAgent.Query(testLoggerCanister, "unknown", []any{}, []any{})
+			},
+			wantErr: "Canister has no method 'unknown'",
+		},
+		{
+			name: "Unknown canister",
+			call: func() error {
+				return loggerAgent.Query(testRecipient, "chain_id", []any{}, []any{})
+			},
+			wantErr: "Canister " + testRecipient.Encode() + " not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// mustEncode encodes the arguments of add_entry
+func mustEncode(t *testing.T, operation string, details icpLogger.Value, caller principal.Principal) []byte {
+	arg, err := idl.Encode([]idl.Type{new(idl.TextType), valueType{}, new(idl.PrincipalType)}, []any{operation, details, caller})
+	require.NoError(t, err)
+	return arg
+}
+
+// field returns a field of a Map value
+func field(t *testing.T, value icpLogger.Value, name string) icpLogger.Value {
+	require.NotNil(t, value.Map)
+	for _, entry := range *value.Map {
+		if entry.Field0 == name {
+			return entry.Field1
+		}
+	}
+	t.Fatalf("no field %s", name)
+	return icpLogger.Value{}
+}
@@ -0,0 +1,150 @@
+package icptest
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/bls"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	"github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// stateNode is a labeled node of the state tree; its values are either []byte leaves
+// or nested stateNodes
+type stateNode map[string]any
+
+// stateTree returns the state tree of the replica, holding the certified data of a
+// canister when it has any
+func (r *Replica) stateTree(canisterID []byte, certifiedData []byte) hashtree.Node {
+	r.mu.Lock()
+	requests := make(stateNode, len(r.requests))
+	for requestID, status := range r.requests {
+		requests[string(requestID[:])] = status.node()
+	}
+	r.mu.Unlock()
+
+	state := stateNode{
+		"time":           encodeLEB128(uint64(time.Now().UnixNano())),
+		"request_status": requests,
+		"subnet": stateNode{
+			string(principal.MustDecode(certification.RootSubnetID).Raw): stateNode{
+				"node": stateNode{
+					string(r.nodeID.Raw): stateNode{"public_key": r.nodeKeyDER},
+				},
+			},
+		},
+	}
+	if certifiedData != nil {
+		state["canister"] = stateNode{
+			string(canisterID): stateNode{"certified_data": certifiedData},
+		}
+	}
+
+	return state.build()
+}
+
+// node returns the request_status subtree of an update call
+func (s requestStatus) node() stateNode {
+	if s.reject != nil {
+		return stateNode{
+			"status":         []byte("rejected"),
+			"reject_code":    encodeLEB128(s.reject.Code),
+			"reject_message": []byte(s.reject.Message),
+			"error_code":     []byte(s.reject.ErrorCode),
+		}
+	}
+
+	return stateNode{"status": []byte("replied"), "reply": s.reply}
+}
+
+// build converts a node into a hash tree, with its labels sorted as the IC sorts them
+func (n stateNode) build() hashtree.Node {
+	labels := make([]string, 0, len(n))
+	for label := range n {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	nodes := make([]hashtree.Node, len(labels))
+	for i, label := range labels {
+		var tree hashtree.Node
+		switch value := n[label].(type) {
+		case []byte:
+			tree = hashtree.Leaf(value)
+		case stateNode:
+			tree = value.build()
+		default:
+			panic(fmt.Sprintf("icptest: invalid state tree value %T", value))
+		}
+		nodes[i] = hashtree.Labeled{Label: hashtree.Label(label), Tree: tree}
+	}
+
+	return fork(nodes)
+}
+
+// fork joins nodes into a balanced tree of forks
+func fork(nodes []hashtree.Node) hashtree.Node {
+	switch len(nodes) {
+	case 0:
+		return hashtree.Empty{}
+	case 1:
+		return nodes[0]
+	}
+
+	middle := len(nodes) / 2
+	return hashtree.Fork{LeftTree: fork(nodes[:middle]), RightTree: fork(nodes[middle:])}
+}
+
+// certificate returns a CBOR certificate of a tree, signed with the root key
+func (r *Replica) certificate(root hashtree.Node) ([]byte, error) {
+	tree := hashtree.NewHashTree(root)
+	digest := tree.Digest()
+	signature, err := r.rootKey.Sign(append(hashtree.DomainSeparator("ic-state-root"), digest[:]...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign state tree: %w", err)
+	}
+	signatureBytes := (*bls12381.G1Affine)(signature).Bytes()
+
+	treeBytes, err := tree.MarshalCBOR()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state tree: %w", err)
+	}
+
+	return cbor.Marshal(struct {
+		Tree      cbor.RawMessage `cbor:"tree"`
+		Signature []byte          `cbor:"signature"`
+	}{Tree: treeBytes, Signature: signatureBytes[:]})
+}
+
+// blsPublicKeyBytes returns the compressed public key of a BLS secret key
+//
+// bls.SecretKey.PublicKey multiplies the generator of its package in place, which
+// breaks the keys derived after the first one, so the key is derived here.
+func blsPublicKeyBytes(sk *bls.SecretKey) []byte {
+	_, _, _, generator := bls12381.Generators()
+	element := fr.Element(*sk)
+
+	var publicKey bls12381.G2Affine
+	publicKey.ScalarMultiplication(&generator, element.BigInt(new(big.Int)))
+	encoded := publicKey.Bytes()
+	return encoded[:]
+}
+
+// encodeLEB128 encodes a number in unsigned LEB128, as the IC encodes numbers in trees
+func encodeLEB128(n uint64) []byte {
+	var encoded []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(encoded, b)
+		}
+		encoded = append(encoded, b|0x80)
+	}
+}
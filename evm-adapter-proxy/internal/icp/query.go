@@ -0,0 +1,261 @@
+package icp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/aviate-labs/leb128"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// rawQueryExpiry bounds the ingress expiry and the HTTP timeout of raw queries, and the
+// age of the certificates their signatures are checked against
+const rawQueryExpiry = 5 * time.Minute
+
+// QueryAgent sends raw queries, for the replies agent-go cannot decode
+//
+// Responses are checked as agent-go checks its own queries: every signature must be
+// from a node of the subnet of the canister, whose key is read from a certificate of
+// the IC the agent trusts. Checks are skipped only when the agent configuration
+// disables signed query verification.
+type QueryAgent struct {
+	agent            *agent.Agent
+	verifySignatures bool
+}
+
+// NewQueryAgent returns a QueryAgent sending queries with an agent
+//
+// Parameters:
+//   - a: The agent, for its HTTP client and root key
+//   - cfg: The configuration the agent was created with
+//
+// Returns:
+//   - *QueryAgent: The query agent, verifying signatures unless cfg disables it
+func NewQueryAgent(a *agent.Agent, cfg agent.Config) *QueryAgent {
+	return &QueryAgent{agent: a, verifySignatures: !cfg.DisableSignedQueryVerification}
+}
+
+// Query calls a query method of any canister, without a generated client
+//
+// Parameters:
+//   - q: The query agent to send the query with, checking its signatures
+//   - canisterID: The canister to query
+//   - method: The query method
+//   - argTypes: The Candid types of the arguments
+//   - args: The arguments, as accepted by the EncodeValue method of their type
+//
+// Returns:
+//   - []any: The values of the reply, decoded as described on candidDecoder
+//   - error: Any error encoding the arguments, sending the query or decoding the reply
+func Query(q *QueryAgent, canisterID principal.Principal, method string, argTypes []idl.Type, args []any) ([]any, error) {
+	arguments, err := idl.Encode(argTypes, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s arguments: %w", method, err)
+	}
+
+	reply, err := q.send(canisterID, method, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := decodeCandid(reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s reply: %w", method, err)
+	}
+
+	return values, nil
+}
+
+// query sends a query and returns its Candid encoded reply
+//
+// Rejections are reported in the same "(<code>) <error code>: <message>" format as
+// agent-go so they are classified like any other canister error.
+func (q *QueryAgent) query(canisterID principal.Principal, method string, args ...any) ([]byte, error) {
+	arguments, err := idl.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s arguments: %w", method, err)
+	}
+
+	return q.send(canisterID, method, arguments)
+}
+
+// send sends an anonymous query with Candid encoded arguments, checks the signatures of
+// its response and returns its Candid encoded reply
+func (q *QueryAgent) send(canisterID principal.Principal, method string, arguments []byte) ([]byte, error) {
+	nonce := make([]byte, 10)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce: %w", err)
+	}
+
+	request := agent.Request{
+		Type:          agent.RequestTypeQuery,
+		Sender:        principal.AnonymousID,
+		Nonce:         nonce,
+		IngressExpiry: uint64(time.Now().Add(rawQueryExpiry).UnixNano()),
+		CanisterID:    canisterID,
+		MethodName:    method,
+		Arguments:     arguments,
+	}
+	envelope, err := cbor.Marshal(agent.Envelope{Content: request})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rawQueryExpiry)
+	defer cancel()
+
+	raw, err := q.agent.Client().Query(ctx, canisterID, envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	var response agent.Response
+	if err := cbor.Unmarshal(raw, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+
+	if q.verifySignatures {
+		if err := q.verifyResponse(canisterID, agent.NewRequestID(request), response); err != nil {
+			return nil, fmt.Errorf("failed to verify %s response: %w", method, err)
+		}
+	}
+
+	switch response.Status {
+	case "replied":
+		var reply struct {
+			Arg []byte `cbor:"arg"`
+		}
+		if err := cbor.Unmarshal(response.Reply, &reply); err != nil {
+			return nil, fmt.Errorf("failed to decode %s reply: %w", method, err)
+		}
+		return reply.Arg, nil
+	case "rejected":
+		return nil, fmt.Errorf("(%d) %s: %s", response.RejectCode, response.ErrorCode, response.RejectMsg)
+	default:
+		return nil, fmt.Errorf("unexpected %s response status %q", method, response.Status)
+	}
+}
+
+// verifyResponse checks that every signature of a query response is from a node of the
+// subnet of the canister, over the response and the ID of the request
+func (q *QueryAgent) verifyResponse(canisterID principal.Principal, requestID agent.RequestID, response agent.Response) error {
+	if len(response.Signatures) == 0 {
+		return fmt.Errorf("no signatures")
+	}
+
+	fields, err := responseFields(response)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := q.subnetCertificate(canisterID)
+	if err != nil {
+		return err
+	}
+	subnetID := principal.MustDecode(certification.RootSubnetID)
+	if certificate.Delegation != nil {
+		subnetID = certificate.Delegation.SubnetId
+	}
+
+	for _, signature := range response.Signatures {
+		der, err := certificate.Tree.Lookup(hashtree.Label("subnet"), subnetID.Raw, hashtree.Label("node"), signature.Identity.Raw, hashtree.Label("public_key"))
+		if err != nil {
+			return fmt.Errorf("unknown node %s: %w", signature.Identity, err)
+		}
+		publicKey, err := certification.PublicED25519KeyFromDER(der)
+		if err != nil {
+			return err
+		}
+
+		hash, err := certification.RepresentationIndependentHash(append(fields,
+			certification.KeyValuePair{Key: "timestamp", Value: signature.Timestamp},
+			certification.KeyValuePair{Key: "request_id", Value: requestID[:]},
+		))
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(*publicKey, append(hashtree.DomainSeparator("ic-response"), hash[:]...), signature.Signature) {
+			return fmt.Errorf("invalid %s signature", response.Status)
+		}
+	}
+
+	return nil
+}
+
+// subnetCertificate reads the node keys of the subnet of a canister, in a certificate
+// checked against the root key of the agent
+func (q *QueryAgent) subnetCertificate(canisterID principal.Principal) (*certification.Certificate, error) {
+	envelope, err := cbor.Marshal(agent.Envelope{Content: agent.Request{
+		Type:          agent.RequestTypeReadState,
+		Sender:        principal.AnonymousID,
+		Paths:         [][]hashtree.Label{{hashtree.Label("subnet")}},
+		IngressExpiry: uint64(time.Now().Add(rawQueryExpiry).UnixNano()),
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode read_state request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rawQueryExpiry)
+	defer cancel()
+
+	raw, err := q.agent.Client().ReadState(ctx, canisterID, envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	var state map[string][]byte
+	if err := cbor.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode read_state response: %w", err)
+	}
+	var certificate certification.Certificate
+	if err := cbor.Unmarshal(state["certificate"], &certificate); err != nil {
+		return nil, fmt.Errorf("failed to decode subnet certificate: %w", err)
+	}
+	if err := certificate.VerifyTime(rawQueryExpiry); err != nil {
+		return nil, err
+	}
+	if err := certification.VerifyCertificate(certificate, canisterID, q.agent.GetRootKey()); err != nil {
+		return nil, err
+	}
+
+	return &certificate, nil
+}
+
+// responseFields returns the fields of a query response its signatures cover, besides
+// the timestamp and the request ID
+func responseFields(response agent.Response) ([]certification.KeyValuePair, error) {
+	switch response.Status {
+	case "replied":
+		// Decoded here since certification.HashAny panics on invalid CBOR
+		var reply any
+		if err := cbor.Unmarshal(response.Reply, &reply); err != nil {
+			return nil, fmt.Errorf("failed to decode reply: %w", err)
+		}
+		return []certification.KeyValuePair{
+			{Key: "status", Value: response.Status},
+			{Key: "reply", Value: reply},
+		}, nil
+	case "rejected":
+		code, err := leb128.EncodeUnsigned(new(big.Int).SetUint64(response.RejectCode))
+		if err != nil {
+			return nil, err
+		}
+		return []certification.KeyValuePair{
+			{Key: "status", Value: response.Status},
+			{Key: "reject_code", Value: []byte(code)},
+			{Key: "reject_message", Value: response.RejectMsg},
+			{Key: "error_code", Value: response.ErrorCode},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unexpected response status %q", response.Status)
+	}
+}
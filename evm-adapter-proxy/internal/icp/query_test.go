@@ -0,0 +1,53 @@
+package icp
+
+import (
+	"testing"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/icptest"
+)
+
+func TestQuery(t *testing.T) {
+	replica := icptest.NewReplica()
+	t.Cleanup(replica.Close)
+
+	canisterID := principal.MustDecode("ydpfi-uiaaa-aaaal-qjupa-cai")
+	replica.Install(canisterID, icptest.NewLogger("1337"))
+
+	tests := []struct {
+		name         string
+		fetchRootKey bool
+		disable      bool
+		method       string
+		want         []any
+		wantErr      string
+	}{
+		{name: "Verified reply", fetchRootKey: true, method: "chain_id", want: []any{"1337"}},
+		{name: "Verified rejection", fetchRootKey: true, method: "unknown", wantErr: "(3) IC0536"},
+		{name: "Signed by another IC", method: "chain_id", wantErr: "failed to verify chain_id response"},
+		{name: "Verification disabled", disable: true, method: "chain_id", want: []any{"1337"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := agent.Config{
+				ClientConfig:                   &agent.ClientConfig{Host: mustParseURL(t, replica.URL())},
+				FetchRootKey:                   tt.fetchRootKey,
+				DisableSignedQueryVerification: tt.disable,
+			}
+			a, err := agent.New(cfg)
+			require.NoError(t, err)
+
+			got, err := Query(NewQueryAgent(a, cfg), canisterID, tt.method, nil, nil)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,29 +1,224 @@
 package evm
 
 import (
+	"encoding/binary"
 	"fmt"
-	"strings"
+	"hash/crc32"
+	"sync"
 
 	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+	"go.uber.org/zap"
 )
 
-// ConvertEthAddressToICPPrincipal converts an Ethereum address to an ICP principal
-// For PoC purposes, we expect the Ethereum address to be in format "0x<full_icp_principal>"
-// Example: if ICP principal is "2vxsx-fae", the Ethereum address should be "0x2vxsx-fae"
+// maxEmbeddedPrincipalLength is the length of the longest principal whose bytes fit in
+// its address next to their CRC-32 checksum; canister ids and the anonymous principal
+// are shorter
+const maxEmbeddedPrincipalLength = common.AddressLength - crc32.Size
+
+// principalAddressNamespace seeds the derivation of the addresses of longer principals
+const principalAddressNamespace = "icp-icrc3-evm-adapter:principal:"
+
+// principalToEthAddress returns the address of a principal
+//
+// Principals of up to 16 bytes are embedded in their address: the CRC-32 checksum of
+// the principal followed by its bytes, left padded with zeros. This is the byte string
+// behind the textual form of the principal. Longer principals, such as
+// self-authenticating ones, get the last 20 bytes of the Keccak-256 hash of
+// principalAddressNamespace and their bytes; principalBook.address also records them so
+// the address maps back to them.
+func principalToEthAddress(p principal.Principal) common.Address {
+	if len(p.Raw) <= maxEmbeddedPrincipalLength {
+		encoded := binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(p.Raw))
+		return common.BytesToAddress(append(encoded, p.Raw...))
+	}
+
+	return derivedPrincipalAddress(p.Raw)
+}
+
+// derivedPrincipalAddress returns the address derived from the bytes of a long principal
+func derivedPrincipalAddress(raw []byte) common.Address {
+	return common.BytesToAddress(crypto.Keccak256([]byte(principalAddressNamespace), raw))
+}
+
+// convertICPToEthAddress returns the EIP-55 checksummed address of a principal given
+// in its textual form, without recording derived addresses; no principal, as in blocks
+// without a caller, is the zero address
+func convertICPToEthAddress(icpAddress string) (string, error) {
+	return (*principalBook)(nil).convertICPToEthAddress(icpAddress)
+}
+
+// embeddedPrincipal recovers a principal embedded in its address by principalToEthAddress
+//
+// The padding is stripped one zero at a time, from none to all of it, until the leading
+// four bytes are the checksum of the rest.
+func embeddedPrincipal(address common.Address) (principal.Principal, bool) {
+	encoded := address.Bytes()
+	for start := 0; start < len(encoded)-crc32.Size; start++ {
+		if start > 0 && encoded[start-1] != 0 {
+			break
+		}
+
+		checksum, raw := encoded[start:start+crc32.Size], encoded[start+crc32.Size:]
+		if binary.BigEndian.Uint32(checksum) == crc32.ChecksumIEEE(raw) {
+			return principal.Principal{Raw: append([]byte{}, raw...)}, true
+		}
+	}
+
+	return principal.Principal{}, false
+}
+
+// principalBook maps the derived addresses of long principals back to them
+//
+// Every router has its own book, and every derived address it computes is added to it,
+// so any address that appeared in a log, a transaction or a call result maps back to
+// its principal. A book with a block store keeps the entries across restarts until
+// detach is called, before the store is closed. A nil book records nothing and only
+// maps embedded principals back.
+type principalBook struct {
+	mu         sync.RWMutex
+	principals map[common.Address]principal.Principal
+	store      *store.Store
+}
+
+// newPrincipalBook creates a book persisting its entries in blockStore, which may be nil
+func newPrincipalBook(blockStore *store.Store) *principalBook {
+	return &principalBook{principals: make(map[common.Address]principal.Principal), store: blockStore}
+}
+
+// detach stops reading from and writing to the block store of the book, before it is closed
+func (b *principalBook) detach() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.store = nil
+}
+
+// address returns the address of a principal, as principalToEthAddress, and records
+// the principal of derived addresses
+func (b *principalBook) address(p principal.Principal) common.Address {
+	address := principalToEthAddress(p)
+	if b != nil && len(p.Raw) > maxEmbeddedPrincipalLength {
+		b.add(address, p)
+	}
+
+	return address
+}
+
+// convertICPToEthAddress returns the EIP-55 checksummed address of a principal given
+// in its textual form, and records the principal of derived addresses; no principal,
+// as in blocks without a caller, is the zero address
+func (b *principalBook) convertICPToEthAddress(icpAddress string) (string, error) {
+	if icpAddress == "" {
+		return common.Address{}.Hex(), nil
+	}
+
+	p, err := principal.Decode(icpAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ICP address: %w", err)
+	}
+
+	return b.address(p).Hex(), nil
+}
+
+// convertEthAddressToICPPrincipal returns the principal of an Ethereum address, the
+// reverse of the mapping used for log, transaction and token addresses
 //
 // Parameters:
-//   - ethAddr: Ethereum address (with 0x prefix)
+//   - ethAddr: The 0x-prefixed hex address, in any case
 //
 // Returns:
-//   - principal.Principal: The converted ICP principal
-//   - error: Any error that occurred during conversion
-func ConvertEthAddressToICPPrincipal(ethAddr string) (principal.Principal, error) {
-	principalStr := strings.TrimPrefix(ethAddr, "0x")
+//   - principal.Principal: The principal the address was derived from
+//   - error: If the address is not a hex address or does not map to a known principal
+func (b *principalBook) convertEthAddressToICPPrincipal(ethAddr string) (principal.Principal, error) {
+	if !common.IsHexAddress(ethAddr) {
+		return principal.Principal{}, fmt.Errorf("invalid address '%s'", ethAddr)
+	}
 
-	principalID, err := principal.Decode(principalStr)
+	p, ok, err := b.principalFromEthAddress(common.HexToAddress(ethAddr))
 	if err != nil {
-		return principal.Principal{}, fmt.Errorf("failed to decode principal: %w", err)
+		return principal.Principal{}, err
+	}
+	if !ok {
+		return principal.Principal{}, fmt.Errorf("address %s does not map to a known principal", ethAddr)
+	}
+
+	return p, nil
+}
+
+// principalFromEthAddress recovers the principal whose address is derived by
+// principalToEthAddress
+//
+// Embedded principals are recovered from the address itself, derived addresses are
+// looked up in the book and then in its block store.
+//
+// Parameters:
+//   - address: The address of the principal
+//
+// Returns:
+//   - principal.Principal: The principal
+//   - bool: False if no known principal maps to the address; the zero address stands
+//     for no account rather than the empty management canister principal
+//   - error: Any error reading the principals persisted in the block store
+func (b *principalBook) principalFromEthAddress(address common.Address) (principal.Principal, bool, error) {
+	if p, ok := embeddedPrincipal(address); ok {
+		return p, true, nil
+	}
+	if b == nil {
+		return principal.Principal{}, false, nil
+	}
+
+	return b.lookup(address)
+}
+
+// add records the principal of a derived address, and persists it when it is new
+func (b *principalBook) add(address common.Address, p principal.Principal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, known := b.principals[address]; known {
+		return
+	}
+	b.principals[address] = p
+
+	if b.store != nil {
+		if err := b.store.PutPrincipal(address.Hex(), p.Raw); err != nil {
+			zap.S().Warnf("principal of address %s not persisted: %v", address.Hex(), err)
+		}
+	}
+}
+
+// lookup returns the principal of a derived address, if it is known
+func (b *principalBook) lookup(address common.Address) (principal.Principal, bool, error) {
+	b.mu.RLock()
+	p, found := b.principals[address]
+	b.mu.RUnlock()
+	if found {
+		return p, true, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.store == nil {
+		return principal.Principal{}, false, nil
+	}
+
+	raw, found, err := b.store.Principal(address.Hex())
+	if err != nil {
+		return principal.Principal{}, false, fmt.Errorf("failed to read principal of %s: %w", address.Hex(), err)
+	}
+	if !found {
+		return principal.Principal{}, false, nil
+	}
+
+	p = principal.Principal{Raw: raw}
+	if len(raw) <= maxEmbeddedPrincipalLength || derivedPrincipalAddress(raw) != address {
+		return principal.Principal{}, false, fmt.Errorf("stored principal %s does not map to %s", p.Encode(), address.Hex())
 	}
 
-	return principalID, nil
+	b.principals[address] = p
+	return p, true, nil
 }
@@ -1,52 +1,130 @@
 package evm
 
 import (
+	"path/filepath"
 	"testing"
 
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// testSelfAuthenticating is a self-authenticating principal, too long to be embedded
+// in an address
+var testSelfAuthenticating = principal.Principal{Raw: append(common.FromHex("0x0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c"), 2)}
+
+func TestConvertICPToEthAddress(t *testing.T) {
+	t.Run("Embedded principals", func(t *testing.T) {
+		// The address of a canister id is the byte string behind its textual form
+		address, err := convertICPToEthAddress("rrkah-fqaaa-aaaaa-aaaaq-cai")
+		require.NoError(t, err)
+		assert.Equal(t, "0x0000000000008C54039600000000000000010101", address)
+		assert.Equal(t, common.HexToAddress(address).Hex(), address, "EIP-55 checksummed")
+	})
+
+	t.Run("Invalid principal", func(t *testing.T) {
+		_, err := convertICPToEthAddress("not-a-principal")
+		assert.Error(t, err)
+	})
+}
+
+func TestPrincipalFromEthAddress(t *testing.T) {
+	book := newPrincipalBook(nil)
+	for _, text := range []string{testCallerA, testCallerB, "7eo5f-eqaaa-aaaam-adqoq-cai", "2vxsx-fae", testSelfAuthenticating.Encode()} {
+		t.Run(text, func(t *testing.T) {
+			address, err := book.convertICPToEthAddress(text)
+			require.NoError(t, err)
+
+			got, ok, err := book.principalFromEthAddress(common.HexToAddress(address))
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, text, got.Encode())
+		})
+	}
+
+	t.Run("Unknown addresses", func(t *testing.T) {
+		for _, address := range []common.Address{
+			{},
+			common.HexToAddress("0x00000000000000000000000000000000000000aa"),
+			(*tokenRegistry)(nil).address("USD"),
+			derivedPrincipalAddress([]byte("never mapped")),
+		} {
+			_, ok, err := book.principalFromEthAddress(address)
+			require.NoError(t, err)
+			assert.False(t, ok, address.Hex())
+		}
+	})
+
+	t.Run("Derived addresses persisted in the block store", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocks.db")
+		blockStore := openTestStore(t, path)
+		book := newPrincipalBook(blockStore)
+
+		address := book.address(testSelfAuthenticating)
+		book.detach()
+		require.NoError(t, blockStore.Close())
+
+		// Detached books no longer touch the closed store
+		other := principal.Principal{Raw: append([]byte{}, testSelfAuthenticating.Raw...)}
+		other.Raw[0]++
+		book.address(other)
+		_, found, err := book.principalFromEthAddress(derivedPrincipalAddress([]byte("never mapped")))
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		// Another router, as after a restart, maps the address back from the store
+		_, found, err = newPrincipalBook(nil).principalFromEthAddress(address)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		got, found, err := newPrincipalBook(openTestStore(t, path)).principalFromEthAddress(address)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, testSelfAuthenticating, got)
+	})
+}
+
+func TestTransferRecipientsMapBack(t *testing.T) {
+	book := newPrincipalBook(nil)
+	registry := newDefaultMapperRegistry(nil, book)
+	block := newDexBlock(0, OperationMintTokens, `MintOperation { currency: "USD", amount: 5, recipient: Principal(`+testSelfAuthenticating.Encode()+`) }`)
+
+	logs, err := registry.mapLogs(block, logFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+
+	recipient, err := book.convertEthAddressToICPPrincipal(common.HexToAddress(logs[0].Topics[2]).Hex())
+	require.NoError(t, err)
+	assert.Equal(t, testSelfAuthenticating, recipient, "the minted address is the one eth_mintTokens takes")
+}
+
 func TestConvertEthAddressToICPPrincipal(t *testing.T) {
+	canister, err := convertICPToEthAddress(testCallerA)
+	require.NoError(t, err)
+
 	tests := []struct {
 		name        string
 		ethAddr     string
-		wantErr     bool
+		want        string
 		errContains string
 	}{
-		{
-			name:    "Valid ICP principal with 0x prefix",
-			ethAddr: "0x2vxsx-fae",
-			wantErr: false,
-		},
-		{
-			name:    "Valid ICP principal without 0x prefix",
-			ethAddr: "2vxsx-fae",
-			wantErr: false,
-		},
-		{
-			name:        "Invalid ICP principal",
-			ethAddr:     "0xinvalid-principal",
-			wantErr:     true,
-			errContains: "failed to decode principal",
-		},
-		{
-			name:        "Empty address",
-			ethAddr:     "",
-			wantErr:     true,
-			errContains: "failed to decode principal",
-		},
+		{name: "Checksummed address", ethAddr: canister, want: testCallerA},
+		{name: "Lowercase address", ethAddr: common.HexToAddress(canister).String(), want: testCallerA},
+		{name: "Principal text", ethAddr: "0x2vxsx-fae", errContains: "invalid address"},
+		{name: "Empty address", ethAddr: "", errContains: "invalid address"},
+		{name: "Unknown address", ethAddr: "0x00000000000000000000000000000000000000aa", errContains: "does not map to a known principal"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			principal, err := ConvertEthAddressToICPPrincipal(tt.ethAddr)
-			if tt.wantErr {
-				assert.Error(t, err)
-				assert.Contains(t, err.Error(), tt.errContains)
-			} else {
-				assert.NoError(t, err)
-				assert.NotNil(t, principal)
+			got, err := (*principalBook)(nil).convertEthAddressToICPPrincipal(tt.ethAddr)
+			if tt.errContains != "" {
+				assert.ErrorContains(t, err, tt.errContains)
+				return
 			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Encode())
 		})
 	}
 }
@@ -0,0 +1,147 @@
+package evm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp"
+	icpLedger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/ledger"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// archiveFetcher fetches up to length blocks from start out of an archive callback,
+// returning any range the archive itself reports as archived elsewhere
+type archiveFetcher func(callback idl.PrincipalMethod, start, length uint64) ([]ICRC3Block, []icp.ArchivedRange, error)
+
+// maxArchiveDepth bounds how many archives can be followed from one another
+const maxArchiveDepth = 3
+
+// stitchArchived adds the blocks of the archived ranges to a page of live blocks
+//
+// Parameters:
+//   - page: The blocks still held by the canister
+//   - archived: The ranges the canister reported as archived
+//   - fetch: Reads blocks from an archive callback
+//
+// Returns:
+//   - blocksPage: The page with the archived blocks, ordered by block ID
+//   - error: Any error returned by an archive
+//
+// Archives may return fewer blocks than a range holds, so each range is read until
+// it is complete or the archive returns no more blocks.
+func stitchArchived(page blocksPage, archived []icp.ArchivedRange, fetch archiveFetcher) (blocksPage, error) {
+	if len(archived) == 0 {
+		return page, nil
+	}
+
+	blocks := make(map[uint64]ICRC3Block, len(page.Blocks))
+	for _, block := range page.Blocks {
+		blocks[block.ID] = block
+	}
+
+	if err := fetchArchivedRanges(archived, fetch, blocks, 0); err != nil {
+		return blocksPage{}, err
+	}
+
+	stitched := blocksPage{LogLength: page.LogLength, Blocks: make([]ICRC3Block, 0, len(blocks))}
+	for _, block := range blocks {
+		stitched.Blocks = append(stitched.Blocks, block)
+	}
+	sort.Slice(stitched.Blocks, func(i, j int) bool {
+		return stitched.Blocks[i].ID < stitched.Blocks[j].ID
+	})
+
+	return stitched, nil
+}
+
+func fetchArchivedRanges(archived []icp.ArchivedRange, fetch archiveFetcher, blocks map[uint64]ICRC3Block, depth int) error {
+	if depth >= maxArchiveDepth {
+		return newCanisterError(fmt.Errorf("more than %d nested archives", maxArchiveDepth), "failed to get archived blocks")
+	}
+
+	for _, r := range archived {
+		start, end := r.Start, r.Start+r.Length
+		for start < end {
+			fetched, nested, err := fetch(r.Callback, start, end-start)
+			if err != nil {
+				return newCanisterError(err, "failed to get archived blocks from %s", r.Callback.Principal)
+			}
+			if err := fetchArchivedRanges(nested, fetch, blocks, depth+1); err != nil {
+				return err
+			}
+
+			next := start
+			for _, block := range fetched {
+				if block.ID < start || block.ID >= end {
+					continue
+				}
+				blocks[block.ID] = block
+				next = max(next, block.ID+1)
+			}
+			for _, n := range nested {
+				next = max(next, min(n.Start+n.Length, end))
+			}
+
+			if next == start {
+				break
+			}
+			start = next
+		}
+	}
+
+	return nil
+}
+
+// fetchLedgerArchive reads blocks from an ICRC-1 ledger archive
+func fetchLedgerArchive(q *icp.QueryAgent) archiveFetcher {
+	return func(callback idl.PrincipalMethod, start, length uint64) ([]ICRC3Block, []icp.ArchivedRange, error) {
+		var result icpLedger.GetBlocksResult
+		archived, err := icp.GetBlocks(q, callback, start, length, ledgerBlocksArgs, &result)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return ledgerBlocks(result), archived, nil
+	}
+}
+
+// fetchLoggerArchive reads blocks from a logger canister archive
+func fetchLoggerArchive(q *icp.QueryAgent) archiveFetcher {
+	return func(callback idl.PrincipalMethod, start, length uint64) ([]ICRC3Block, []icp.ArchivedRange, error) {
+		var result icpLogger.GetBlocksResult
+		archived, err := icp.GetBlocks(q, callback, start, length, loggerBlocksArgs, &result)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return loggerBlocks(result), archived, nil
+	}
+}
+
+func ledgerBlocksArgs(start, length uint64) any {
+	return icpLedger.GetBlocksArgs{{Start: idl.NewNat(start), Length: idl.NewNat(length)}}
+}
+
+func loggerBlocksArgs(start, length uint64) any {
+	return icpLogger.GetBlocksArgs{Start: idl.NewNat(start), Length: idl.NewNat(length)}
+}
+
+func ledgerBlocks(result icpLedger.GetBlocksResult) []ICRC3Block {
+	blocks := make([]ICRC3Block, 0, len(result.Blocks))
+	for _, blockInfo := range result.Blocks {
+		blocks = append(blocks, ICRC3Block{
+			ID:    blockInfo.Id.BigInt().Uint64(),
+			Value: fromLedgerValue(blockInfo.Block),
+		})
+	}
+	return blocks
+}
+
+func loggerBlocks(result icpLogger.GetBlocksResult) []ICRC3Block {
+	blocks := make([]ICRC3Block, 0, len(result.Blocks))
+	for _, blockInfo := range result.Blocks {
+		blocks = append(blocks, ICRC3Block{ID: blockInfo.Id.BigInt().Uint64(), Value: blockInfo.Block})
+	}
+	return blocks
+}
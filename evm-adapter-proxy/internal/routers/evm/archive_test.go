@@ -0,0 +1,115 @@
+package evm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp"
+)
+
+// testArchive serves the blocks [start, end) in pages of at most pageSize blocks
+type testArchive struct {
+	callback idl.PrincipalMethod
+	start    uint64
+	end      uint64
+	pageSize uint64
+	calls    int
+}
+
+func (a *testArchive) blocks(start, length uint64) []ICRC3Block {
+	a.calls++
+	var blocks []ICRC3Block
+	for id := max(start, a.start); id < start+length && id < a.end && uint64(len(blocks)) < a.pageSize; id++ {
+		blocks = append(blocks, ICRC3Block{ID: id, Value: mapValue(testMapEntry{Field0: "ts", Field1: natValue(id)})})
+	}
+	return blocks
+}
+
+func archiveFetchers(archives ...*testArchive) archiveFetcher {
+	return func(callback idl.PrincipalMethod, start, length uint64) ([]ICRC3Block, []icp.ArchivedRange, error) {
+		for _, archive := range archives {
+			if archive.callback.Principal.Equal(callback.Principal) {
+				return archive.blocks(start, length), nil, nil
+			}
+		}
+		return nil, nil, errors.New("unknown archive")
+	}
+}
+
+func blockIDs(blocks []ICRC3Block) []uint64 {
+	ids := make([]uint64, 0, len(blocks))
+	for _, block := range blocks {
+		ids = append(ids, block.ID)
+	}
+	return ids
+}
+
+func TestStitchArchived(t *testing.T) {
+	first := &testArchive{
+		callback: idl.PrincipalMethod{Principal: principal.MustDecode("ryjl3-tyaaa-aaaaa-aaaba-cai"), Method: icp.GetBlocksMethod},
+		start:    0,
+		end:      5,
+		pageSize: 2,
+	}
+	second := &testArchive{
+		callback: idl.PrincipalMethod{Principal: principal.MustDecode("r7inp-6aaaa-aaaaa-aaabq-cai"), Method: icp.GetBlocksMethod},
+		start:    5,
+		end:      8,
+		pageSize: 10,
+	}
+
+	live := blocksPage{LogLength: 10, Blocks: []ICRC3Block{
+		{ID: 8, Value: mapValue(testMapEntry{Field0: "ts", Field1: natValue(8)})},
+		{ID: 9, Value: mapValue(testMapEntry{Field0: "ts", Field1: natValue(9)})},
+	}}
+	archived := []icp.ArchivedRange{
+		{Start: 5, Length: 3, Callback: second.callback},
+		{Start: 1, Length: 4, Callback: first.callback},
+	}
+
+	page, err := stitchArchived(live, archived, archiveFetchers(first, second))
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(10), page.LogLength)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9}, blockIDs(page.Blocks))
+	assert.Equal(t, 2, first.calls, "short archive pages are followed until the range is complete")
+	assert.Equal(t, 1, second.calls)
+}
+
+func TestStitchArchivedErrors(t *testing.T) {
+	live := blocksPage{LogLength: 3}
+
+	t.Run("Archive error", func(t *testing.T) {
+		archived := []icp.ArchivedRange{{Start: 0, Length: 3, Callback: idl.PrincipalMethod{Principal: principal.AnonymousID}}}
+		_, err := stitchArchived(live, archived, archiveFetchers())
+
+		var rpcErr *RPCError
+		require.True(t, errors.As(err, &rpcErr))
+		assert.ErrorContains(t, err, "unknown archive")
+	})
+
+	t.Run("Archive returns no blocks", func(t *testing.T) {
+		empty := &testArchive{callback: idl.PrincipalMethod{Principal: principal.AnonymousID}, pageSize: 10}
+		archived := []icp.ArchivedRange{{Start: 0, Length: 3, Callback: empty.callback}}
+
+		page, err := stitchArchived(live, archived, archiveFetchers(empty))
+		require.NoError(t, err)
+		assert.Empty(t, page.Blocks)
+		assert.Equal(t, 1, empty.calls)
+	})
+
+	t.Run("Archives nested too deep", func(t *testing.T) {
+		callback := idl.PrincipalMethod{Principal: principal.AnonymousID}
+		archived := []icp.ArchivedRange{{Start: 0, Length: 3, Callback: callback}}
+		loop := func(idl.PrincipalMethod, uint64, uint64) ([]ICRC3Block, []icp.ArchivedRange, error) {
+			return nil, archived, nil
+		}
+
+		_, err := stitchArchived(live, archived, loop)
+		assert.ErrorContains(t, err, "nested archives")
+	})
+}
@@ -0,0 +1,208 @@
+package evm
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBlockHashScanDepth = 1000
+	blockHashScanPageSize     = 100
+	// maxIndexedHashes is the number of block hashes, and of transaction hashes, kept in memory
+	maxIndexedHashes = 100000
+)
+
+// blockHashIndex maps block and transaction hashes to block numbers
+//
+// Every block read from the canister is added to the in-memory maps, which keep the
+// last maxIndexedHashes hashes of each kind. When a block store is configured, the
+// hashes of ingested blocks are persisted in it and looked up there as well, so the
+// index survives restarts. Forgotten hashes are found by the scan of findBlock.
+type blockHashIndex struct {
+	mu        sync.RWMutex
+	numbers   *hashNumbers
+	txNumbers *hashNumbers
+	store     *store.Store
+}
+
+func newBlockHashIndex(blockStore *store.Store) *blockHashIndex {
+	return &blockHashIndex{
+		numbers:   newHashNumbers(maxIndexedHashes),
+		txNumbers: newHashNumbers(maxIndexedHashes),
+		store:     blockStore,
+	}
+}
+
+// hashNumbers maps hashes to block numbers, forgetting the oldest hashes beyond its size
+type hashNumbers struct {
+	numbers map[string]*list.Element
+	order   *list.List
+	size    int
+}
+
+// hashNumber is an entry of hashNumbers
+type hashNumber struct {
+	hash   string
+	number uint64
+}
+
+func newHashNumbers(size int) *hashNumbers {
+	return &hashNumbers{numbers: make(map[string]*list.Element), order: list.New(), size: size}
+}
+
+// put records the block number of a hash
+func (h *hashNumbers) put(hash string, number uint64) {
+	if element, ok := h.numbers[hash]; ok {
+		element.Value = hashNumber{hash: hash, number: number}
+		return
+	}
+
+	h.numbers[hash] = h.order.PushBack(hashNumber{hash: hash, number: number})
+	for h.order.Len() > h.size {
+		oldest := h.order.Front()
+		h.order.Remove(oldest)
+		delete(h.numbers, oldest.Value.(hashNumber).hash)
+	}
+}
+
+// get returns the block number of a hash, if it is remembered
+func (h *hashNumbers) get(hash string) (uint64, bool) {
+	element, ok := h.numbers[hash]
+	if !ok {
+		return 0, false
+	}
+	return element.Value.(hashNumber).number, true
+}
+
+// add records the block and transaction hashes of every block that has them
+func (i *blockHashIndex) add(blocks []ICRC3Block) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, block := range blocks {
+		hash, err := icrc3BlockHash(block.Value)
+		if err != nil {
+			zap.S().Debugf("block %d not indexed by hash: %v", block.ID, err)
+			continue
+		}
+		i.numbers.put(strings.ToLower(hash), block.ID)
+
+		txHashes, err := entryTransactionHashes(block.Value)
+		if err != nil {
+			zap.S().Debugf("transactions of block %d not indexed: %v", block.ID, err)
+			continue
+		}
+		for _, txHash := range txHashes {
+			i.txNumbers.put(txHash, block.ID)
+		}
+	}
+}
+
+// lookup returns the number of the block with the given hash, if it is indexed
+func (i *blockHashIndex) lookup(hash string) (uint64, bool, error) {
+	i.mu.RLock()
+	number, found := i.numbers.get(strings.ToLower(hash))
+	i.mu.RUnlock()
+	if found || i.store == nil {
+		return number, found, nil
+	}
+
+	return i.store.BlockNumberByHash(hash)
+}
+
+// lookupTransaction returns the number of the block of the transaction with the given
+// hash, if it is indexed
+func (i *blockHashIndex) lookupTransaction(hash string) (uint64, bool, error) {
+	i.mu.RLock()
+	number, found := i.txNumbers.get(strings.ToLower(hash))
+	i.mu.RUnlock()
+	if found || i.store == nil {
+		return number, found, nil
+	}
+
+	return i.store.BlockNumberByTxHash(hash)
+}
+
+// findBlockByHash returns the number of the block with the given hash
+//
+// Indexed hashes are found in constant time. Other hashes are searched among the
+// blockHashScanDepth blocks below the tip, which indexes them for later lookups.
+//
+// Returns:
+//   - uint64: The block number
+//   - bool: Whether the block was found
+//   - error: Any error reading the index or the canister
+func (r *evmRouter) findBlockByHash(hash string) (uint64, bool, error) {
+	return r.findBlock(hash, r.hashIndex.lookup, func(block ICRC3Block) bool {
+		blockHash, err := icrc3BlockHash(block.Value)
+		return err == nil && strings.EqualFold(blockHash, hash)
+	})
+}
+
+// findBlockByTxHash returns the number of the block holding the transaction with the
+// given hash, searching it like findBlockByHash
+func (r *evmRouter) findBlockByTxHash(hash string) (uint64, bool, error) {
+	return r.findBlock(hash, r.hashIndex.lookupTransaction, func(block ICRC3Block) bool {
+		txHashes, err := entryTransactionHashes(block.Value)
+		if err != nil {
+			return false
+		}
+		for _, txHash := range txHashes {
+			if strings.EqualFold(txHash, hash) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// findBlock looks up a hash in the index, then scans the blocks below the tip for
+// one that matches; no block matches while the log is empty
+func (r *evmRouter) findBlock(hash string, lookup func(hash string) (uint64, bool, error), match func(block ICRC3Block) bool) (uint64, bool, error) {
+	number, found, err := lookup(hash)
+	if err != nil {
+		return 0, false, newInternalError("failed to read block hash index: %w", err)
+	}
+	if found {
+		return number, true, nil
+	}
+
+	tip, err := r.getLatestBlockNumber()
+	if isNotFoundError(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return scanBlocks(tip, uint64(r.blockHashScanDepth), r.getBlocks, match)
+}
+
+// scanBlocks searches the depth blocks at and below tip for a block that matches, newest first
+func scanBlocks(tip, depth uint64, getBlocks func(start, length uint64) (blocksPage, error), match func(block ICRC3Block) bool) (uint64, bool, error) {
+	scanned := uint64(0)
+	for end := tip + 1; end > 0 && scanned < depth; {
+		length := min(blockHashScanPageSize, end, depth-scanned)
+		start := end - length
+
+		page, err := getBlocks(start, length)
+		if err != nil {
+			return 0, false, newCanisterError(err, "failed to get blocks %d to %d", start, end-1)
+		}
+
+		for _, block := range page.Blocks {
+			if match(block) {
+				return block.ID, true, nil
+			}
+		}
+
+		scanned += length
+		end = start
+	}
+
+	return 0, false, nil
+}
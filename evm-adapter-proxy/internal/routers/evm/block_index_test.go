@@ -0,0 +1,121 @@
+package evm
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+)
+
+func testBlockHash(t *testing.T, block ICRC3Block) string {
+	hash, err := icrc3BlockHash(block.Value)
+	require.NoError(t, err)
+	return hash
+}
+
+func TestBlockHashIndex(t *testing.T) {
+	log := newTestLog(6)
+
+	t.Run("In memory", func(t *testing.T) {
+		index := newBlockHashIndex(nil)
+		index.add(log.blocks[:3])
+
+		number, found, err := index.lookup(strings.ToUpper(testBlockHash(t, log.blocks[2])))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, uint64(2), number)
+
+		_, found, err = index.lookup(testBlockHash(t, log.blocks[4]))
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Oldest hashes forgotten", func(t *testing.T) {
+		index := newBlockHashIndex(nil)
+		index.numbers = newHashNumbers(2)
+		index.add(log.blocks[:3])
+
+		_, found, err := index.lookup(testBlockHash(t, log.blocks[0]))
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		number, found, err := index.lookup(testBlockHash(t, log.blocks[2]))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, uint64(2), number)
+		assert.Equal(t, 2, index.numbers.order.Len())
+	})
+
+	t.Run("Persisted in the block store", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocks.db")
+		blockStore, err := store.Open(path)
+		require.NoError(t, err)
+		router := newStoreRouter(blockStore)
+		require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 10).Start())
+		require.NoError(t, blockStore.Close())
+
+		// A new process finds the hashes ingested by the previous one
+		index := newBlockHashIndex(openTestStore(t, path))
+		number, found, err := index.lookup(testBlockHash(t, log.blocks[4]))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, uint64(4), number)
+	})
+}
+
+func TestScanBlocks(t *testing.T) {
+	log := newTestLog(250)
+
+	tests := []struct {
+		name        string
+		target      uint64
+		depth       uint64
+		wantFound   bool
+		wantFetches []uint64
+	}{
+		{name: "Near the tip", target: 240, depth: 1000, wantFound: true, wantFetches: []uint64{150}},
+		{name: "Deeper pages", target: 20, depth: 1000, wantFound: true, wantFetches: []uint64{150, 50, 0}},
+		{name: "Below the scan depth", target: 100, depth: 120, wantFetches: []uint64{150, 130}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log.fetches = nil
+			hash := testBlockHash(t, log.blocks[tt.target])
+			number, found, err := scanBlocks(249, tt.depth, log.fetch, func(block ICRC3Block) bool {
+				return testBlockHash(t, block) == hash
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFound, found)
+			if tt.wantFound {
+				assert.Equal(t, tt.target, number)
+			}
+			assert.Equal(t, tt.wantFetches, log.fetches)
+		})
+	}
+
+	t.Run("Canister error", func(t *testing.T) {
+		failing := func(uint64, uint64) (blocksPage, error) { return blocksPage{}, errors.New("boom") }
+		_, _, err := scanBlocks(10, 100, failing, func(ICRC3Block) bool { return true })
+
+		var rpcErr *RPCError
+		assert.True(t, errors.As(err, &rpcErr))
+	})
+}
+
+func TestEthGetBlockByHashUnknown(t *testing.T) {
+	log := newTestLog(3)
+	blockStore := openTestStore(t, filepath.Join(t.TempDir(), "blocks.db"))
+	router := newStoreRouter(blockStore)
+	require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 10).Start())
+
+	got, err := router.EthGetBlockByHash(JSONRPCRequest{Params: []interface{}{"0xzz"}})
+	assert.Nil(t, got)
+	var rpcErr *RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, ErrCodeInvalidParams, rpcErr.Code)
+}
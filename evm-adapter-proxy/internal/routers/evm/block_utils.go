@@ -6,6 +6,8 @@ import (
 
 	"reflect"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
 )
 
@@ -32,14 +34,15 @@ func mapBlockToEVMBlock(blockValue icpLogger.Value) (Block, error) {
 		Hash:         fmt.Sprintf("0x%x", icrcBlock.Hash),
 		ParentHash:   fmt.Sprintf("0x%x", icrcBlock.Phash),
 		Timestamp:    fmt.Sprintf("0x%x", icrcBlock.Ts/1e9), // Convert nanoseconds to seconds to prevent overflow
-		Transactions: []string{},                            // we don't have transactions in this PoC
-		// Fill other fields with placeholder values
-		TransactionsRoot: "0x0000000000000000000000000000000000000000000000000000000000000000",
-		ReceiptsRoot:     "0x0000000000000000000000000000000000000000000000000000000000000000",
-		StateRoot:        "0x0000000000000000000000000000000000000000000000000000000000000000",
+		Transactions: []string{},
+		// Roots and bloom of a block without transactions; the mapper registry sets them
+		// from the transactions and logs of the block
+		TransactionsRoot: types.EmptyTxsHash.Hex(),
+		ReceiptsRoot:     types.EmptyReceiptsHash.Hex(),
+		StateRoot:        types.EmptyRootHash.Hex(),
 		Nonce:            "0x0000000000000000",
-		Sha3Uncles:       "",
-		LogsBloom:        "0x00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		Sha3Uncles:       types.EmptyUncleHash.Hex(),
+		LogsBloom:        hexutil.Encode(types.Bloom{}.Bytes()),
 		Miner:            "0x0000000000000000000000000000000000000000",
 		Difficulty:       "0x0",
 		TotalDifficulty:  "0x0",
@@ -48,6 +51,7 @@ func mapBlockToEVMBlock(blockValue icpLogger.Value) (Block, error) {
 		GasLimit:         "0x0",
 		GasUsed:          "0x0",
 		Uncles:           []string{},
+		MixHash:          "0x0000000000000000000000000000000000000000000000000000000000000000",
 	}, nil
 }
 
@@ -118,7 +122,7 @@ func decodeValue(value icpLogger.Value, target interface{}) error {
 // decodeCertificateData decodes a ULEB128-encoded block number from certificate data
 //
 // Parameters:
-//   - data: The raw certificate data containing the encoded block number
+//   - data: The last_block_index leaf of an ICRC-3 tip hash tree
 //
 // Returns:
 //   - uint64: The decoded block number
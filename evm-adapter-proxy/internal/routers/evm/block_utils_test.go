@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stretchr/testify/assert"
 	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
 )
@@ -61,9 +62,10 @@ func TestMapBlockToEVMBlock(t *testing.T) {
 				ParentHash:       "0x05060708",
 				Timestamp:        "0x1",
 				Transactions:     []string{},
-				TransactionsRoot: "0x0000000000000000000000000000000000000000000000000000000000000000",
-				ReceiptsRoot:     "0x0000000000000000000000000000000000000000000000000000000000000000",
-				StateRoot:        "0x0000000000000000000000000000000000000000000000000000000000000000",
+				TransactionsRoot: types.EmptyTxsHash.Hex(),
+				ReceiptsRoot:     types.EmptyReceiptsHash.Hex(),
+				StateRoot:        types.EmptyRootHash.Hex(),
+				Sha3Uncles:       types.EmptyUncleHash.Hex(),
 			},
 			wantErr: false,
 		},
@@ -97,6 +99,7 @@ func TestMapBlockToEVMBlock(t *testing.T) {
 			assert.Equal(t, tt.want.TransactionsRoot, got.TransactionsRoot)
 			assert.Equal(t, tt.want.ReceiptsRoot, got.ReceiptsRoot)
 			assert.Equal(t, tt.want.StateRoot, got.StateRoot)
+			assert.Equal(t, tt.want.Sha3Uncles, got.Sha3Uncles)
 		})
 	}
 }
@@ -0,0 +1,292 @@
+package evm
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zondax/golem/pkg/metrics"
+	"github.com/zondax/golem/pkg/metrics/collectors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// Metrics of the block and tip caches, labeled by the source of the router and the
+// cache that was looked up
+const (
+	CacheHitsMetric   = "cache_hits"
+	CacheMissesMetric = "cache_misses"
+)
+
+// Caches reported in the cache label of CacheHitsMetric and CacheMissesMetric
+const (
+	cacheLabelBlock = "block"
+	cacheLabelTip   = "tip"
+)
+
+// logCache serves the blocks and the tip of a log from memory, reading what it does
+// not hold from the block and tip sources it wraps
+//
+// Blocks below the last one of the log are final: logger canisters finalize a block
+// when they open the next one, and ICRC-3 blocks never change once finalized. Final
+// blocks are kept until evicted as the least recently used of size blocks. The last
+// block is kept for blockTTL only, unless it is marked finalized, and the tip for
+// tipTTL. Concurrent fetches of the same page, or of the tip, are coalesced into a
+// single canister query.
+type logCache struct {
+	blocks   blockSource
+	tips     tipSource
+	size     int
+	blockTTL time.Duration
+	tipTTL   time.Duration
+	source   string
+	metrics  metrics.TaskMetrics
+	group    singleflight.Group
+
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	lru     *list.List
+	// logLength is the longest length of the log seen
+	logLength  uint64
+	tip        *certifiedTip
+	tipExpires time.Time
+	// generation is bumped by invalidate; fetches started before are not cached
+	generation uint64
+}
+
+// cachedBlock is an entry of the block cache
+type cachedBlock struct {
+	block ICRC3Block
+	// expires is zero for final blocks
+	expires time.Time
+}
+
+// newLogCache creates a cache of the given sources
+//
+// Parameters:
+//   - blocks: The block source of the router
+//   - tips: The tip source of the router
+//   - size: Number of blocks kept; 0 or less caches no block
+//   - blockTTL: How long the last block is kept while not finalized; 0 or less never keeps it
+//   - tipTTL: How long the tip is kept; 0 or less fetches it every time
+//   - source: The source of the router, reported in the source label of the metrics
+//   - metricsServer: Receives CacheHitsMetric and CacheMissesMetric, may be nil
+func newLogCache(blocks blockSource, tips tipSource, size int, blockTTL, tipTTL time.Duration, source string, metricsServer metrics.TaskMetrics) *logCache {
+	if metricsServer != nil {
+		registerCounter(metricsServer, CacheHitsMetric, "Blocks and tips served from the cache.", "source", "cache")
+		registerCounter(metricsServer, CacheMissesMetric, "Blocks and tips fetched from the canister.", "source", "cache")
+	}
+
+	return &logCache{
+		blocks:   blocks,
+		tips:     tips,
+		size:     size,
+		blockTTL: blockTTL,
+		tipTTL:   tipTTL,
+		source:   source,
+		metrics:  metricsServer,
+		entries:  make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// fetchBlocksPage implements blockSource, fetching the blocks after the longest cached
+// run from start
+func (c *logCache) fetchBlocksPage(start, length uint64) (blocksPage, error) {
+	blocks, logLength, generation := c.cachedBlocks(start, length)
+	hits := uint64(len(blocks))
+	c.record(cacheLabelBlock, hits, length-hits)
+	if hits == length {
+		return blocksPage{LogLength: logLength, Blocks: blocks}, nil
+	}
+
+	key := fmt.Sprintf("blocks:%d:%d:%d", generation, start+hits, length-hits)
+	result, err, _ := c.group.Do(key, func() (interface{}, error) {
+		page, err := c.blocks.fetchBlocksPage(start+hits, length-hits)
+		if err != nil {
+			return nil, err
+		}
+		c.add(page, generation)
+		return page, nil
+	})
+	if err != nil {
+		return blocksPage{}, err
+	}
+
+	page := result.(blocksPage)
+	page.Blocks = append(blocks, page.Blocks...)
+	return page, nil
+}
+
+// fetchCertifiedTip implements tipSource, fetching the tip once the cached one expires
+func (c *logCache) fetchCertifiedTip() (certifiedTip, error) {
+	c.mu.Lock()
+	if c.tip != nil && time.Now().Before(c.tipExpires) {
+		tip := *c.tip
+		c.mu.Unlock()
+		c.record(cacheLabelTip, 1, 0)
+		return tip, nil
+	}
+	c.mu.Unlock()
+
+	c.record(cacheLabelTip, 0, 1)
+	return c.fetchFreshTip()
+}
+
+// fetchFreshTip fetches the tip from the canister, for callers that cannot be served
+// an older tip
+func (c *logCache) fetchFreshTip() (certifiedTip, error) {
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	result, err, _ := c.group.Do(fmt.Sprintf("tip:%d", generation), func() (interface{}, error) {
+		tip, err := c.tips.fetchCertifiedTip()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.logLength = max(c.logLength, tip.Index+1)
+		if c.tipTTL > 0 && generation == c.generation {
+			c.tip = &tip
+			c.tipExpires = time.Now().Add(c.tipTTL)
+		}
+		return tip, nil
+	})
+	if err != nil {
+		return certifiedTip{}, err
+	}
+
+	return result.(certifiedTip), nil
+}
+
+// invalidate drops the tip and the blocks that are not final, after the router wrote
+// to the log
+func (c *logCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.tip = nil
+	for id, element := range c.entries {
+		if !element.Value.(cachedBlock).expires.IsZero() {
+			c.lru.Remove(element)
+			delete(c.entries, id)
+		}
+	}
+}
+
+// cachedBlocks returns the cached blocks of the longest run from start, with the
+// length of the log and the generation of the cache
+func (c *logCache) cachedBlocks(start, length uint64) ([]ICRC3Block, uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var blocks []ICRC3Block
+	now := time.Now()
+	for id := start; id < start+length; id++ {
+		element, ok := c.entries[id]
+		if !ok {
+			break
+		}
+
+		entry := element.Value.(cachedBlock)
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			c.lru.Remove(element)
+			delete(c.entries, id)
+			break
+		}
+
+		c.lru.MoveToFront(element)
+		blocks = append(blocks, entry.block)
+	}
+
+	return blocks, c.logLength, c.generation
+}
+
+// add caches the blocks of a page fetched in a generation, unless the cache was
+// invalidated since
+func (c *logCache) add(page blocksPage, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logLength = max(c.logLength, page.LogLength)
+	if c.size <= 0 || generation != c.generation {
+		return
+	}
+
+	now := time.Now()
+	for _, block := range page.Blocks {
+		entry := cachedBlock{block: block}
+		if !blockFinal(block, page.LogLength) {
+			if c.blockTTL <= 0 {
+				continue
+			}
+			entry.expires = now.Add(c.blockTTL)
+		}
+
+		if element, ok := c.entries[block.ID]; ok {
+			element.Value = entry
+			c.lru.MoveToFront(element)
+			continue
+		}
+		c.entries[block.ID] = c.lru.PushFront(entry)
+	}
+
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(cachedBlock).block.ID)
+	}
+}
+
+// record counts the hits and misses of a cache
+func (c *logCache) record(cache string, hits, misses uint64) {
+	if c.metrics == nil {
+		return
+	}
+
+	for name, count := range map[string]uint64{CacheHitsMetric: hits, CacheMissesMetric: misses} {
+		if count == 0 {
+			continue
+		}
+		if err := c.metrics.UpdateMetric(name, float64(count), c.source, cache); err != nil {
+			zap.S().Warnf("failed to update %s metric: %v", name, err)
+		}
+	}
+}
+
+// blockFinal reports whether a block can no longer change: blocks below the last one
+// of the log, and blocks marked finalized or, as ICRC-3 blocks, with no such mark
+func blockFinal(block ICRC3Block, logLength uint64) bool {
+	if block.ID+1 < logLength {
+		return true
+	}
+
+	finalized := lookupField(block.Value, "finalized")
+	return finalized == nil || (finalized.Text != nil && *finalized.Text == "true")
+}
+
+// registerCounter registers a counter with labels on the metrics server; the routers
+// of every source share their counters, the first one registers them, and tell their
+// counts apart by a source label, empty for the unnamed source
+func registerCounter(metricsServer metrics.TaskMetrics, name, help string, labels ...string) {
+	err := metricsServer.RegisterMetric(name, help, labels, &collectors.Counter{})
+	var registered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &registered) {
+		zap.S().Warnf("failed to register %s metric: %v", name, err)
+	}
+}
+
+// invalidateCache drops what the router cached of the log after a DEX update call,
+// which logs to it; routers built without a cache have nothing to drop
+func (r *evmRouter) invalidateCache() {
+	if r.cache != nil {
+		r.cache.invalidate()
+	}
+}
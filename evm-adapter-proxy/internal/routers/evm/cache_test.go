@@ -0,0 +1,267 @@
+package evm
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/golem/pkg/metrics"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// countingLog is a log whose last block is not finalized, counting the queries it answers
+type countingLog struct {
+	mu      sync.Mutex
+	blocks  []ICRC3Block
+	fetches atomic.Int32
+	tips    atomic.Int32
+	// release, when set, holds every query until it is closed
+	release chan struct{}
+}
+
+func newCountingLog(n int) *countingLog {
+	l := &countingLog{}
+	l.append(n)
+	return l
+}
+
+// append adds n blocks, finalizing the previous last block as the logger canister does
+func (l *countingLog) append(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		id := uint64(len(l.blocks))
+		if id > 0 {
+			l.blocks[id-1].Value = withFinalized(l.blocks[id-1].Value, "true")
+		}
+		l.blocks = append(l.blocks, ICRC3Block{ID: id, Value: withFinalized(newTestBlock(id, testCallerA), "false")})
+	}
+}
+
+func (l *countingLog) fetchBlocksPage(start, length uint64) (blocksPage, error) {
+	l.fetches.Add(1)
+	if l.release != nil {
+		<-l.release
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	page := blocksPage{LogLength: uint64(len(l.blocks))}
+	for id := start; id < start+length && id < uint64(len(l.blocks)); id++ {
+		page.Blocks = append(page.Blocks, l.blocks[id])
+	}
+	return page, nil
+}
+
+func (l *countingLog) fetchCertifiedTip() (certifiedTip, error) {
+	l.tips.Add(1)
+	if l.release != nil {
+		<-l.release
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.blocks) == 0 {
+		return certifiedTip{}, newNotFoundError("no blocks found")
+	}
+	return certifiedTip{Index: uint64(len(l.blocks) - 1)}, nil
+}
+
+// withFinalized returns a block value with its finalized field set
+func withFinalized(value icpLogger.Value, finalized string) icpLogger.Value {
+	fields := []testMapEntry{}
+	for _, field := range *value.Map {
+		if field.Field0 != "finalized" {
+			fields = append(fields, field)
+		}
+	}
+	return mapValue(append(fields, testMapEntry{Field0: "finalized", Field1: textValue(finalized)})...)
+}
+
+func TestLogCacheBlocks(t *testing.T) {
+	log := newCountingLog(5)
+	cache := newLogCache(log, log, 100, time.Hour, 0, "", nil)
+
+	page, err := cache.fetchBlocksPage(0, 5)
+	require.NoError(t, err)
+	require.Len(t, page.Blocks, 5)
+	assert.Equal(t, int32(1), log.fetches.Load())
+
+	// Final and unexpired blocks are served from memory
+	page, err = cache.fetchBlocksPage(1, 4)
+	require.NoError(t, err)
+	require.Len(t, page.Blocks, 4)
+	assert.Equal(t, uint64(5), page.LogLength)
+	assert.Equal(t, int32(1), log.fetches.Load())
+
+	// Only the blocks after the cached ones are fetched
+	log.append(2)
+	page, err = cache.fetchBlocksPage(3, 4)
+	require.NoError(t, err)
+	require.Len(t, page.Blocks, 4)
+	assert.Equal(t, []uint64{3, 4, 5, 6}, blockIDs(page.Blocks))
+	assert.Equal(t, int32(2), log.fetches.Load())
+
+	// The old last block was cached before it was finalized, invalidation drops it
+	cache.invalidate()
+	page, err = cache.fetchBlocksPage(3, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, blockIDs(page.Blocks))
+	assert.Equal(t, int32(3), log.fetches.Load())
+	assert.Equal(t, "true", *lookupField(page.Blocks[1].Value, "finalized").Text)
+}
+
+func TestLogCacheFinality(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockTTL  time.Duration
+		wantFetch int32
+	}{
+		{name: "Unfinalized block kept for its TTL", blockTTL: time.Hour, wantFetch: 1},
+		{name: "Unfinalized block not kept", blockTTL: 0, wantFetch: 2},
+		{name: "Unfinalized block expired", blockTTL: time.Nanosecond, wantFetch: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := newCountingLog(3)
+			cache := newLogCache(log, log, 100, tt.blockTTL, 0, "", nil)
+
+			_, err := cache.fetchBlocksPage(0, 3)
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond)
+
+			// The final blocks are always served from memory
+			_, err = cache.fetchBlocksPage(0, 2)
+			require.NoError(t, err)
+			assert.Equal(t, int32(1), log.fetches.Load())
+
+			_, err = cache.fetchBlocksPage(2, 1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFetch, log.fetches.Load())
+		})
+	}
+}
+
+func TestLogCacheEviction(t *testing.T) {
+	log := newCountingLog(4)
+	cache := newLogCache(log, log, 2, time.Hour, 0, "", nil)
+
+	_, err := cache.fetchBlocksPage(0, 2)
+	require.NoError(t, err)
+	_, err = cache.fetchBlocksPage(2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), log.fetches.Load())
+
+	// Blocks 0 and 1 were evicted as the least recently used
+	_, err = cache.fetchBlocksPage(2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), log.fetches.Load())
+	_, err = cache.fetchBlocksPage(0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), log.fetches.Load())
+
+	// No block is kept without a size
+	cache = newLogCache(log, log, 0, time.Hour, 0, "", nil)
+	for i := 0; i < 2; i++ {
+		_, err = cache.fetchBlocksPage(0, 1)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(5), log.fetches.Load())
+}
+
+func TestLogCacheTip(t *testing.T) {
+	log := newCountingLog(0)
+	cache := newLogCache(log, log, 100, time.Hour, time.Hour, "", nil)
+
+	// Errors, such as an empty log, are not cached
+	_, err := cache.fetchCertifiedTip()
+	require.Error(t, err)
+	log.append(2)
+
+	for i := 0; i < 3; i++ {
+		tip, err := cache.fetchCertifiedTip()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), tip.Index)
+	}
+	assert.Equal(t, int32(2), log.tips.Load())
+
+	log.append(1)
+	tip, err := cache.fetchFreshTip()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), tip.Index)
+
+	log.append(1)
+	cache.invalidate()
+	tip, err = cache.fetchCertifiedTip()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), tip.Index)
+	assert.Equal(t, int32(4), log.tips.Load())
+}
+
+func TestLogCacheCoalescing(t *testing.T) {
+	log := newCountingLog(3)
+	log.release = make(chan struct{})
+	cache := newLogCache(log, log, 100, time.Hour, time.Hour, "", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			page, err := cache.fetchBlocksPage(0, 3)
+			assert.NoError(t, err)
+			assert.Len(t, page.Blocks, 3)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := cache.fetchCertifiedTip()
+			assert.NoError(t, err)
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		return log.fetches.Load() == 1 && log.tips.Load() == 1
+	}, time.Second, time.Millisecond)
+	// Give the other requests time to join the queries in flight
+	time.Sleep(50 * time.Millisecond)
+	close(log.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), log.fetches.Load())
+	assert.Equal(t, int32(1), log.tips.Load())
+}
+
+func TestLogCacheMetrics(t *testing.T) {
+	metricsServer := &metrics.MockTaskMetrics{}
+	metricsServer.On("RegisterMetric", mock.Anything, mock.Anything, []string{"source", "cache"}, mock.Anything).Return(nil)
+	metricsServer.On("UpdateMetric", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	log := newCountingLog(3)
+	cache := newLogCache(log, log, 100, time.Hour, time.Hour, "ledger", metricsServer)
+	metricsServer.AssertCalled(t, "RegisterMetric", CacheHitsMetric, mock.Anything, []string{"source", "cache"}, mock.Anything)
+	metricsServer.AssertCalled(t, "RegisterMetric", CacheMissesMetric, mock.Anything, []string{"source", "cache"}, mock.Anything)
+
+	_, err := cache.fetchBlocksPage(0, 2)
+	require.NoError(t, err)
+	metricsServer.AssertCalled(t, "UpdateMetric", CacheMissesMetric, float64(2), "ledger", cacheLabelBlock)
+
+	_, err = cache.fetchBlocksPage(0, 3)
+	require.NoError(t, err)
+	metricsServer.AssertCalled(t, "UpdateMetric", CacheHitsMetric, float64(2), "ledger", cacheLabelBlock)
+	metricsServer.AssertCalled(t, "UpdateMetric", CacheMissesMetric, float64(1), "ledger", cacheLabelBlock)
+
+	for i := 0; i < 2; i++ {
+		_, err = cache.fetchCertifiedTip()
+		require.NoError(t, err)
+	}
+	metricsServer.AssertCalled(t, "UpdateMetric", CacheMissesMetric, float64(1), "ledger", cacheLabelTip)
+	metricsServer.AssertCalled(t, "UpdateMetric", CacheHitsMetric, float64(1), "ledger", cacheLabelTip)
+}
@@ -0,0 +1,117 @@
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Labels of the ICRC-3 tip hash tree
+const (
+	lastBlockIndexLabel = "last_block_index"
+	lastBlockHashLabel  = "last_block_hash"
+)
+
+// defaultCertificateMaxAge is the oldest time a tip certificate may certify by default,
+// the ingress expiry agent-go checks the certificates of its own calls against
+const defaultCertificateMaxAge = 5 * time.Minute
+
+// errEmptyLog is returned when a tip certificate proves the log has no blocks
+var errEmptyLog = errors.New("log has no blocks")
+
+// certifiedTip is the last block of an ICRC-3 log, as certified by the IC
+type certifiedTip struct {
+	Index uint64
+	Hash  []byte
+}
+
+// verifyTipCertificate validates an icrc3_get_tip_certificate response and returns the tip it certifies
+//
+// Parameters:
+//   - certificate: The CBOR-encoded IC certificate
+//   - hashTree: The CBOR-encoded hash tree holding the tip labels
+//   - canisterID: The canister that issued the certificate
+//   - rootKey: The DER-encoded IC root key
+//   - maxAge: How long ago the certificate may have been issued
+//
+// Returns:
+//   - certifiedTip: The index and hash of the last block
+//   - error: errEmptyLog when the certified tree has no tip, or any verification error
+//
+// The function will:
+//  1. Verify the certificate BLS signature, following the subnet delegation if present
+//  2. Check that the certified data of the canister is the digest of the hash tree
+//  3. Reject the certificate if its time is older than maxAge, so a stale tip cannot be replayed
+//  4. Read last_block_index (LEB128) and last_block_hash from the hash tree
+func verifyTipCertificate(certificate, hashTree []byte, canisterID principal.Principal, rootKey []byte, maxAge time.Duration) (certifiedTip, error) {
+	var cert certification.Certificate
+	if err := cbor.Unmarshal(certificate, &cert); err != nil {
+		return certifiedTip{}, fmt.Errorf("failed to decode certificate: %w", err)
+	}
+
+	var tree hashtree.HashTree
+	if err := cbor.Unmarshal(hashTree, &tree); err != nil {
+		return certifiedTip{}, fmt.Errorf("failed to decode hash tree: %w", err)
+	}
+
+	digest := tree.Digest()
+	if err := certification.VerifyCertifiedData(cert, canisterID, rootKey, digest[:]); err != nil {
+		return certifiedTip{}, fmt.Errorf("invalid certificate: %w", err)
+	}
+	if err := cert.VerifyTime(maxAge); err != nil {
+		return certifiedTip{}, fmt.Errorf("invalid certificate time: %w", err)
+	}
+
+	rawIndex, err := tree.Lookup(hashtree.Label(lastBlockIndexLabel))
+	if err != nil {
+		var lookupErr hashtree.LookupError
+		if errors.As(err, &lookupErr) && lookupErr.Type == hashtree.LookupResultAbsent {
+			return certifiedTip{}, errEmptyLog
+		}
+		return certifiedTip{}, fmt.Errorf("failed to read %s: %w", lastBlockIndexLabel, err)
+	}
+
+	index, err := decodeCertificateData(rawIndex)
+	if err != nil {
+		return certifiedTip{}, fmt.Errorf("invalid %s: %w", lastBlockIndexLabel, err)
+	}
+
+	hash, err := tree.Lookup(hashtree.Label(lastBlockHashLabel))
+	if err != nil {
+		return certifiedTip{}, fmt.Errorf("failed to read %s: %w", lastBlockHashLabel, err)
+	}
+	if len(hash) != 32 {
+		return certifiedTip{}, fmt.Errorf("invalid %s length: %d", lastBlockHashLabel, len(hash))
+	}
+
+	return certifiedTip{Index: index, Hash: bytes.Clone(hash)}, nil
+}
+
+// getCertifiedTip returns the verified tip of the block source
+func (r *evmRouter) getCertifiedTip() (certifiedTip, error) {
+	return r.tips.fetchCertifiedTip()
+}
+
+// checkTipCertificate verifies an icrc3_get_tip_certificate response with
+// verifyTipCertificate, and reports its failures as RPC errors
+func checkTipCertificate(certificate, hashTree []byte, canisterID principal.Principal, rootKey []byte, maxAge time.Duration) (certifiedTip, error) {
+	if certificate == nil {
+		return certifiedTip{}, newInternalError("certificate data is nil")
+	}
+
+	tip, err := verifyTipCertificate(certificate, hashTree, canisterID, rootKey, maxAge)
+	if errors.Is(err, errEmptyLog) {
+		return certifiedTip{}, newNotFoundError("no blocks found")
+	}
+	if err != nil {
+		return certifiedTip{}, newInternalError("failed to verify tip certificate: %w", err)
+	}
+
+	return tip, nil
+}
@@ -0,0 +1,172 @@
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/bls"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/aviate-labs/leb128"
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCanisterID = principal.MustDecode("ydpfi-uiaaa-aaaal-qjupa-cai")
+
+// tipTree builds the ICRC-3 tip hash tree; a nil hash builds the tree of an empty log
+func tipTree(index []byte, hash []byte) hashtree.Node {
+	if hash == nil {
+		return hashtree.Empty{}
+	}
+	return hashtree.Fork{
+		LeftTree:  hashtree.Labeled{Label: hashtree.Label(lastBlockHashLabel), Tree: hashtree.Leaf(hash)},
+		RightTree: hashtree.Labeled{Label: hashtree.Label(lastBlockIndexLabel), Tree: hashtree.Leaf(index)},
+	}
+}
+
+// signedCertificate returns a CBOR certificate of the certified data of a canister,
+// issued at the given time and signed by the secret key
+func signedCertificate(t *testing.T, sk *bls.SecretKey, canisterID principal.Principal, certifiedData []byte, issued time.Time) []byte {
+	issuedAt, err := leb128.EncodeUnsigned(big.NewInt(issued.UnixNano()))
+	require.NoError(t, err)
+
+	tree := hashtree.NewHashTree(hashtree.Fork{
+		LeftTree: hashtree.Labeled{
+			Label: hashtree.Label("canister"),
+			Tree: hashtree.Labeled{
+				Label: hashtree.Label(canisterID.Raw),
+				Tree: hashtree.Labeled{
+					Label: hashtree.Label("certified_data"),
+					Tree:  hashtree.Leaf(certifiedData),
+				},
+			},
+		},
+		RightTree: hashtree.Labeled{
+			Label: hashtree.Label("time"),
+			Tree:  hashtree.Leaf(issuedAt),
+		},
+	})
+
+	rootHash := tree.Digest()
+	signature, err := sk.Sign(append(hashtree.DomainSeparator("ic-state-root"), rootHash[:]...))
+	require.NoError(t, err)
+	signatureBytes := (*bls12381.G1Affine)(signature).Bytes()
+
+	treeBytes, err := tree.MarshalCBOR()
+	require.NoError(t, err)
+
+	certificate, err := cbor.Marshal(struct {
+		Tree      cbor.RawMessage `cbor:"tree"`
+		Signature []byte          `cbor:"signature"`
+	}{Tree: treeBytes, Signature: signatureBytes[:]})
+	require.NoError(t, err)
+
+	return certificate
+}
+
+func rootKeyDER(t *testing.T, sk *bls.SecretKey) []byte {
+	publicKey := (*bls12381.G2Affine)(sk.PublicKey()).Bytes()
+	der, err := certification.PublicBLSKeyToDER(publicKey[:])
+	require.NoError(t, err)
+	return der
+}
+
+func TestVerifyTipCertificate(t *testing.T) {
+	sk := bls.NewSecretKeyByCSPRNG()
+	rootKey := rootKeyDER(t, sk)
+	otherRootKey := rootKeyDER(t, bls.NewSecretKeyByCSPRNG())
+	tipHash := bytes.Repeat([]byte{0xab}, 32)
+
+	tree := tipTree([]byte{0xE5, 0x8E, 0x26}, tipHash)
+	treeBytes, err := hashtree.Serialize(tree)
+	require.NoError(t, err)
+	digest := tree.Reconstruct()
+
+	emptyTree := tipTree(nil, nil)
+	emptyTreeBytes, err := hashtree.Serialize(emptyTree)
+	require.NoError(t, err)
+	emptyDigest := emptyTree.Reconstruct()
+
+	otherCanister := principal.MustDecode("7eo5f-eqaaa-aaaam-adqoq-cai")
+
+	tests := []struct {
+		name        string
+		certificate []byte
+		hashTree    []byte
+		rootKey     []byte
+		want        certifiedTip
+		wantErr     error
+		errContains string
+	}{
+		{
+			name:        "Valid certificate",
+			certificate: signedCertificate(t, sk, testCanisterID, digest[:], time.Now()),
+			hashTree:    treeBytes,
+			rootKey:     rootKey,
+			want:        certifiedTip{Index: 624485, Hash: tipHash},
+		},
+		{
+			name:        "Empty log",
+			certificate: signedCertificate(t, sk, testCanisterID, emptyDigest[:], time.Now()),
+			hashTree:    emptyTreeBytes,
+			rootKey:     rootKey,
+			wantErr:     errEmptyLog,
+		},
+		{
+			name:        "Signed by another key",
+			certificate: signedCertificate(t, sk, testCanisterID, digest[:], time.Now()),
+			hashTree:    treeBytes,
+			rootKey:     otherRootKey,
+			errContains: "signature verification failed",
+		},
+		{
+			name:        "Hash tree does not match the certified data",
+			certificate: signedCertificate(t, sk, testCanisterID, emptyDigest[:], time.Now()),
+			hashTree:    treeBytes,
+			rootKey:     rootKey,
+			errContains: "certified data does not match",
+		},
+		{
+			name:        "Certificate of another canister",
+			certificate: signedCertificate(t, sk, otherCanister, digest[:], time.Now()),
+			hashTree:    treeBytes,
+			rootKey:     rootKey,
+			errContains: "invalid certificate",
+		},
+		{
+			name:        "Stale certificate",
+			certificate: signedCertificate(t, sk, testCanisterID, digest[:], time.Now().Add(-defaultCertificateMaxAge-time.Minute)),
+			hashTree:    treeBytes,
+			rootKey:     rootKey,
+			errContains: "certificate outdated",
+		},
+		{
+			name:        "Malformed certificate",
+			certificate: []byte{0x01},
+			hashTree:    treeBytes,
+			rootKey:     rootKey,
+			errContains: "failed to decode certificate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyTipCertificate(tt.certificate, tt.hashTree, testCanisterID, tt.rootKey, defaultCertificateMaxAge)
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.errContains != "":
+				assert.ErrorContains(t, err, tt.errContains)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
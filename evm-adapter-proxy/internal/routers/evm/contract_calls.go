@@ -0,0 +1,631 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
+)
+
+// canisterQuery calls a query method of a canister with Candid arguments of the given
+// types and returns the decoded values of its reply, see icp.Query
+type canisterQuery func(canisterID principal.Principal, method string, argTypes []idl.Type, args []any) ([]any, error)
+
+// callDispatcher answers eth_call requests to the configured pseudo contracts by
+// calling canister query methods
+//
+// The arguments of a call are ABI-decoded and encoded to Candid, and the Candid reply
+// is ABI-encoded back, with each ABI type mapped to a single Candid type:
+//
+//   - uint8 to uint64 and int8 to int64: nat8 to nat64 and int8 to int64
+//   - any other uintN and intN: nat and int
+//   - address: principal, through the address derivation of principalToEthAddress
+//   - bool, string: bool, text
+//   - bytes and bytesN: blob
+//   - T[] and T[N]: vec T
+//   - tuples: records, whose fields are named after the tuple components
+//
+// Replies are further unwrapped: a null opt becomes the zero value of its ABI type,
+// and a single variant { Ok; Err } reply returns Ok or reverts with Err.
+type callDispatcher struct {
+	contracts  map[common.Address]callContract
+	query      canisterQuery
+	principals *principalBook
+}
+
+// callContract is a pseudo contract, with its functions indexed by selector
+type callContract struct {
+	canisterID principal.Principal
+	methods    map[[4]byte]callMethod
+}
+
+// callMethod is a function of a pseudo contract and the query that answers it
+type callMethod struct {
+	method   abi.Method
+	query    string
+	argTypes []idl.Type
+}
+
+// newCallDispatcher creates a dispatcher for the configured pseudo contracts
+//
+// Parameters:
+//   - configs: The pseudo contracts and their functions
+//   - defaultCanister: The canister queried by contracts without a canister ID
+//   - query: Calls the canister query methods
+//   - principals: Maps address arguments to principals and records the principals of
+//     address results, may be nil
+//
+// Returns:
+//   - *callDispatcher: The dispatcher
+//   - error: If a contract address, canister ID or function signature is invalid, or a
+//     function uses an ABI type without a Candid equivalent
+func newCallDispatcher(configs []conf.CallContractConfig, defaultCanister principal.Principal, query canisterQuery, principals *principalBook) (*callDispatcher, error) {
+	dispatcher := &callDispatcher{contracts: make(map[common.Address]callContract, len(configs)), query: query, principals: principals}
+	for _, config := range configs {
+		if !common.IsHexAddress(config.Address) {
+			return nil, fmt.Errorf("invalid call contract address '%s'", config.Address)
+		}
+
+		contract := callContract{canisterID: defaultCanister, methods: make(map[[4]byte]callMethod, len(config.Methods))}
+		if config.CanisterID != "" {
+			canisterID, err := principal.Decode(config.CanisterID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid canister ID '%s' of call contract %s: %w", config.CanisterID, config.Address, err)
+			}
+			contract.canisterID = canisterID
+		}
+
+		for _, methodConfig := range config.Methods {
+			method, err := parseFunctionSignature(methodConfig.Signature)
+			if err != nil {
+				return nil, fmt.Errorf("invalid signature '%s' of call contract %s: %w", methodConfig.Signature, config.Address, err)
+			}
+
+			argTypes, err := candidTypes(method.Inputs)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported parameter type in '%s' of call contract %s: %w", methodConfig.Signature, config.Address, err)
+			}
+			if _, err := candidTypes(method.Outputs); err != nil {
+				return nil, fmt.Errorf("unsupported return type in '%s' of call contract %s: %w", methodConfig.Signature, config.Address, err)
+			}
+
+			contract.methods[[4]byte(method.ID)] = callMethod{method: method, query: methodConfig.Query, argTypes: argTypes}
+		}
+
+		dispatcher.contracts[common.HexToAddress(config.Address)] = contract
+	}
+
+	return dispatcher, nil
+}
+
+// call answers a call to a pseudo contract
+//
+// Parameters:
+//   - address: The called address
+//   - data: The ABI-encoded call, a 4-byte selector followed by the arguments
+//
+// Returns:
+//   - []byte: The ABI-encoded return values
+//   - bool: False if the address is not a pseudo contract
+//   - error: A revert error for unknown selectors, malformed arguments and Err replies,
+//     or the error of the canister query
+func (d *callDispatcher) call(address common.Address, data []byte) ([]byte, bool, error) {
+	if d == nil {
+		return nil, false, nil
+	}
+
+	contract, ok := d.contracts[address]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if len(data) < 4 {
+		return nil, true, newRevertError("missing function selector")
+	}
+
+	method, ok := contract.methods[[4]byte(data[:4])]
+	if !ok {
+		return nil, true, newRevertError("unsupported function selector %s", hexutil.Encode(data[:4]))
+	}
+
+	inputs, err := method.method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, true, newRevertError("invalid arguments for %s: %w", method.method.Sig, err)
+	}
+
+	args := make([]any, 0, len(inputs))
+	for i, input := range inputs {
+		arg, err := d.toCandidValue(method.method.Inputs[i].Type, reflect.ValueOf(input))
+		if err != nil {
+			return nil, true, newRevertError("invalid argument %d for %s: %w", i, method.method.Sig, err)
+		}
+		args = append(args, arg)
+	}
+
+	reply, err := d.query(contract.canisterID, method.query, method.argTypes, args)
+	if err != nil {
+		return nil, true, newCanisterError(err, "failed to query %s", method.query)
+	}
+
+	outputs := method.method.Outputs
+	if len(outputs) == 1 && len(reply) == 1 {
+		if reply[0], err = unwrapResult(reply[0]); err != nil {
+			return nil, true, err
+		}
+	}
+	if len(reply) < len(outputs) {
+		return nil, true, newInternalError("%s replied %d values, %s returns %d", method.query, len(reply), method.method.Sig, len(outputs))
+	}
+
+	values := make([]any, 0, len(outputs))
+	for i, output := range outputs {
+		value, err := d.fromCandidValue(output.Type, reply[i])
+		if err != nil {
+			return nil, true, newInternalError("failed to convert %s reply value %d: %w", method.query, i, err)
+		}
+		values = append(values, value.Interface())
+	}
+
+	result, err := outputs.Pack(values...)
+	if err != nil {
+		return nil, true, newInternalError("failed to encode %s result: %w", method.method.Sig, err)
+	}
+
+	return result, true, nil
+}
+
+// unwrapResult returns the Ok value of a variant { Ok; Err } reply, or reverts with its
+// Err value; any other value is returned as is
+func unwrapResult(value any) (any, error) {
+	variant, ok := value.(map[uint32]any)
+	if !ok || len(variant) != 1 {
+		return value, nil
+	}
+
+	if ok, found := variant[candidFieldID("Ok")]; found {
+		return ok, nil
+	}
+	if reason, found := variant[candidFieldID("Err")]; found {
+		return nil, newRevertError("%v", reason)
+	}
+
+	return value, nil
+}
+
+// candidFieldID returns the Candid id of a record or variant field name
+func candidFieldID(name string) uint32 {
+	return uint32(idl.Hash(name).Uint64())
+}
+
+// candidTypes returns the Candid types of a list of ABI arguments
+func candidTypes(args abi.Arguments) ([]idl.Type, error) {
+	types := make([]idl.Type, 0, len(args))
+	for _, arg := range args {
+		typ, err := candidType(arg.Type)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+
+	return types, nil
+}
+
+// candidType returns the Candid type an ABI type is mapped to
+func candidType(t abi.Type) (idl.Type, error) {
+	switch t.T {
+	case abi.UintTy:
+		switch t.Size {
+		case 8:
+			return idl.Nat8Type(), nil
+		case 16:
+			return idl.Nat16Type(), nil
+		case 32:
+			return idl.Nat32Type(), nil
+		case 64:
+			return idl.Nat64Type(), nil
+		}
+		return new(idl.NatType), nil
+	case abi.IntTy:
+		switch t.Size {
+		case 8:
+			return idl.Int8Type(), nil
+		case 16:
+			return idl.Int16Type(), nil
+		case 32:
+			return idl.Int32Type(), nil
+		case 64:
+			return idl.Int64Type(), nil
+		}
+		return new(idl.IntType), nil
+	case abi.BoolTy:
+		return new(idl.BoolType), nil
+	case abi.StringTy:
+		return new(idl.TextType), nil
+	case abi.AddressTy:
+		return new(idl.PrincipalType), nil
+	case abi.BytesTy, abi.FixedBytesTy:
+		return idl.NewVectorType(idl.Nat8Type()), nil
+	case abi.SliceTy, abi.ArrayTy:
+		elem, err := candidType(*t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return idl.NewVectorType(elem), nil
+	case abi.TupleTy:
+		fields := make(map[string]idl.Type, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			field, err := candidType(*elem)
+			if err != nil {
+				return nil, err
+			}
+			fields[t.TupleRawNames[i]] = field
+		}
+		return idl.NewRecordType(fields), nil
+	default:
+		return nil, fmt.Errorf("no Candid type for %s", t.String())
+	}
+}
+
+// toCandidValue converts an ABI-decoded argument to the value its Candid type encodes
+func (d *callDispatcher) toCandidValue(t abi.Type, value reflect.Value) (any, error) {
+	switch t.T {
+	case abi.UintTy:
+		if n, ok := value.Interface().(*big.Int); ok {
+			return idl.NewBigNat(n), nil
+		}
+		return value.Interface(), nil
+	case abi.IntTy:
+		if n, ok := value.Interface().(*big.Int); ok {
+			return idl.NewBigInt(n), nil
+		}
+		return value.Interface(), nil
+	case abi.AddressTy:
+		address := value.Interface().(common.Address)
+		owner, ok, err := d.principals.principalFromEthAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("address %s does not map to a principal", address.Hex())
+		}
+		return owner, nil
+	case abi.FixedBytesTy:
+		return value.Slice(0, value.Len()).Bytes(), nil
+	case abi.SliceTy, abi.ArrayTy:
+		items := make([]any, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			item, err := d.toCandidValue(*t.Elem, value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case abi.TupleTy:
+		fields := make(map[string]any, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			field, err := d.toCandidValue(*elem, value.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			fields[t.TupleRawNames[i]] = field
+		}
+		return fields, nil
+	default:
+		return value.Interface(), nil
+	}
+}
+
+// fromCandidValue converts a decoded Candid reply value to the Go value the ABI type
+// packs; a null opt becomes the zero value
+func (d *callDispatcher) fromCandidValue(t abi.Type, value any) (reflect.Value, error) {
+	result := zeroABIValue(t)
+	if value == nil {
+		return result, nil
+	}
+
+	switch t.T {
+	case abi.UintTy, abi.IntTy:
+		n, err := candidInteger(value)
+		if err != nil {
+			return result, err
+		}
+		if !fitsABIInteger(t, n) {
+			return result, fmt.Errorf("%s does not fit in %s", n, t.String())
+		}
+		switch result.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			result.SetUint(n.Uint64())
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			result.SetInt(n.Int64())
+		default:
+			result.Set(reflect.ValueOf(n))
+		}
+	case abi.BoolTy, abi.StringTy:
+		if reflect.TypeOf(value) != result.Type() {
+			return result, fmt.Errorf("expected %s, got %T", t.String(), value)
+		}
+		result.Set(reflect.ValueOf(value))
+	case abi.AddressTy:
+		owner, ok := value.(principal.Principal)
+		if !ok {
+			return result, fmt.Errorf("expected a principal, got %T", value)
+		}
+		result.Set(reflect.ValueOf(d.principals.address(owner)))
+	case abi.BytesTy, abi.FixedBytesTy:
+		blob, err := candidBlob(value)
+		if err != nil {
+			return result, err
+		}
+		if t.T == abi.BytesTy {
+			result.SetBytes(blob)
+		} else if len(blob) != t.Size {
+			return result, fmt.Errorf("expected %d bytes, got %d", t.Size, len(blob))
+		} else {
+			reflect.Copy(result, reflect.ValueOf(blob))
+		}
+	case abi.SliceTy, abi.ArrayTy:
+		items, ok := value.([]any)
+		if !ok {
+			return result, fmt.Errorf("expected a vec, got %T", value)
+		}
+		if t.T == abi.SliceTy {
+			result.Set(reflect.MakeSlice(result.Type(), len(items), len(items)))
+		} else if len(items) != t.Size {
+			return result, fmt.Errorf("expected %d items, got %d", t.Size, len(items))
+		}
+		for i, item := range items {
+			elem, err := d.fromCandidValue(*t.Elem, item)
+			if err != nil {
+				return result, err
+			}
+			result.Index(i).Set(elem)
+		}
+	case abi.TupleTy:
+		record, ok := value.(map[uint32]any)
+		if !ok {
+			return result, fmt.Errorf("expected a record, got %T", value)
+		}
+		for i, elem := range t.TupleElems {
+			field, ok := record[candidFieldID(t.TupleRawNames[i])]
+			if !ok {
+				return result, fmt.Errorf("record has no %s field", t.TupleRawNames[i])
+			}
+			converted, err := d.fromCandidValue(*elem, field)
+			if err != nil {
+				return result, fmt.Errorf("%s: %w", t.TupleRawNames[i], err)
+			}
+			result.Field(i).Set(converted)
+		}
+	default:
+		return result, fmt.Errorf("no Candid type for %s", t.String())
+	}
+
+	return result, nil
+}
+
+// zeroABIValue returns the zero value of an ABI type, with zero rather than nil big.Ints
+// so it can be packed
+func zeroABIValue(t abi.Type) reflect.Value {
+	result := reflect.New(t.GetType()).Elem()
+	switch t.T {
+	case abi.UintTy, abi.IntTy:
+		if result.Type() == reflect.TypeOf(new(big.Int)) {
+			result.Set(reflect.ValueOf(new(big.Int)))
+		}
+	case abi.ArrayTy:
+		for i := 0; i < t.Size; i++ {
+			result.Index(i).Set(zeroABIValue(*t.Elem))
+		}
+	case abi.TupleTy:
+		for i, elem := range t.TupleElems {
+			result.Field(i).Set(zeroABIValue(*elem))
+		}
+	}
+
+	return result
+}
+
+// fitsABIInteger reports whether an integer is in the range of an ABI integer type
+func fitsABIInteger(t abi.Type, n *big.Int) bool {
+	if t.T == abi.UintTy {
+		return n.Sign() >= 0 && n.BitLen() <= t.Size
+	}
+
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+	return n.Cmp(new(big.Int).Neg(limit)) >= 0 && n.Cmp(limit) < 0
+}
+
+// candidInteger returns a decoded Candid nat, int or fixed size integer as a big.Int
+func candidInteger(value any) (*big.Int, error) {
+	switch n := value.(type) {
+	case *big.Int:
+		return n, nil
+	case uint64:
+		return new(big.Int).SetUint64(n), nil
+	case int64:
+		return big.NewInt(n), nil
+	default:
+		return nil, fmt.Errorf("expected an integer, got %T", value)
+	}
+}
+
+// candidBlob returns a decoded Candid blob, a vec of nat8
+func candidBlob(value any) ([]byte, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a blob, got %T", value)
+	}
+
+	blob := make([]byte, 0, len(items))
+	for _, item := range items {
+		b, ok := item.(uint64)
+		if !ok || b > 0xff {
+			return nil, fmt.Errorf("expected a blob, got a vec of %T", item)
+		}
+		blob = append(blob, byte(b))
+	}
+
+	return blob, nil
+}
+
+// parseFunctionSignature parses a Solidity function signature with its parameter and
+// return types, such as "balance(address owner, string currency) returns (uint256)"
+//
+// Parameter names are optional, except for the components of tuples, which name the
+// fields of the Candid record they map to.
+func parseFunctionSignature(signature string) (abi.Method, error) {
+	signature = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(signature), "function "))
+
+	open := strings.Index(signature, "(")
+	if open <= 0 {
+		return abi.Method{}, fmt.Errorf("missing function name or parameters")
+	}
+	name := strings.TrimSpace(signature[:open])
+
+	params, rest, err := splitParenthesized(signature[open:])
+	if err != nil {
+		return abi.Method{}, err
+	}
+
+	var returns string
+	if rest = strings.TrimSpace(rest); rest != "" {
+		if !strings.HasPrefix(rest, "returns") {
+			return abi.Method{}, fmt.Errorf("unexpected '%s' after parameters", rest)
+		}
+		var trailing string
+		if returns, trailing, err = splitParenthesized(strings.TrimSpace(strings.TrimPrefix(rest, "returns"))); err != nil {
+			return abi.Method{}, err
+		}
+		if strings.TrimSpace(trailing) != "" {
+			return abi.Method{}, fmt.Errorf("unexpected '%s' after return types", trailing)
+		}
+	}
+
+	inputs, err := parseArguments(params)
+	if err != nil {
+		return abi.Method{}, err
+	}
+	outputs, err := parseArguments(returns)
+	if err != nil {
+		return abi.Method{}, err
+	}
+
+	return abi.NewMethod(name, name, abi.Function, "view", false, false, inputs, outputs), nil
+}
+
+// parseArguments parses a comma separated list of typed, optionally named, parameters
+func parseArguments(list string) (abi.Arguments, error) {
+	params, err := parseParameters(list)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make(abi.Arguments, 0, len(params))
+	for _, param := range params {
+		typ, err := abi.NewType(param.Type, "", param.Components)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, abi.Argument{Name: param.Name, Type: typ})
+	}
+
+	return args, nil
+}
+
+// parseParameters parses a parameter list into the JSON ABI form of its parameters,
+// where tuples have the "tuple" type and their components
+func parseParameters(list string) ([]abi.ArgumentMarshaling, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	var params []abi.ArgumentMarshaling
+	for _, item := range splitTopLevel(list) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("empty parameter")
+		}
+
+		var param abi.ArgumentMarshaling
+		if strings.HasPrefix(item, "(") {
+			inner, rest, err := splitParenthesized(item)
+			if err != nil {
+				return nil, err
+			}
+			if param.Components, err = parseParameters(inner); err != nil {
+				return nil, err
+			}
+			for _, component := range param.Components {
+				if component.Name == "" {
+					return nil, fmt.Errorf("tuple component %s must be named after its Candid record field", component.Type)
+				}
+			}
+			item = "tuple" + rest
+		}
+
+		fields := strings.Fields(item)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid parameter '%s'", item)
+		}
+		param.Type = fields[0]
+		if len(fields) == 2 {
+			param.Name = fields[1]
+		}
+		params = append(params, param)
+	}
+
+	return params, nil
+}
+
+// splitParenthesized splits a string starting with "(" into the contents of that
+// parenthesis and what follows its matching ")"
+func splitParenthesized(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return "", "", fmt.Errorf("expected '(' in '%s'", s)
+	}
+
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s[1:i], s[i+1:], nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("unbalanced parentheses in '%s'", s)
+}
+
+// splitTopLevel splits a parameter list on the commas outside of tuples
+func splitTopLevel(list string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(items, list[start:])
+}
@@ -0,0 +1,241 @@
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
+)
+
+func TestParseFunctionSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		wantSig   string
+		wantOut   []string
+		wantErr   bool
+	}{
+		{
+			name:      "Named parameters",
+			signature: "getTokenBalance(address owner, string currency) returns (uint256)",
+			wantSig:   "getTokenBalance(address,string)",
+			wantOut:   []string{"uint256"},
+		},
+		{
+			name:      "Tuple array result",
+			signature: "function getCurrencyPairs() returns ((string base_currency, string quote_currency)[])",
+			wantSig:   "getCurrencyPairs()",
+			wantOut:   []string{"(string,string)[]"},
+		},
+		{
+			name:      "Nested tuples and no result",
+			signature: "ping((uint64 id, (bool ok, bytes32 hash) inner) args, int8[2])",
+			wantSig:   "ping((uint64,(bool,bytes32)),int8[2])",
+		},
+		{name: "Missing parameters", signature: "getCurrencyPairs", wantErr: true},
+		{name: "Unbalanced parentheses", signature: "f(uint256", wantErr: true},
+		{name: "Missing return types", signature: "f() returns", wantErr: true},
+		{name: "Trailing modifier", signature: "f() view", wantErr: true},
+		{name: "Unnamed tuple component", signature: "f((uint256 amount, string))", wantErr: true},
+		{name: "Unknown type", signature: "f(foo)", wantErr: true},
+		{name: "Empty parameter", signature: "f(uint256,)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := parseFunctionSignature(tt.signature)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSig, method.Sig)
+			assert.Equal(t, crypto.Keccak256([]byte(tt.wantSig))[:4], method.ID)
+
+			var outputs []string
+			for _, output := range method.Outputs {
+				outputs = append(outputs, output.Type.String())
+			}
+			assert.Equal(t, tt.wantOut, outputs)
+		})
+	}
+}
+
+// fakeCanister records the queries of a callDispatcher and replies with canned values
+type fakeCanister struct {
+	t        *testing.T
+	replies  map[string][]any
+	err      error
+	canister principal.Principal
+	method   string
+	args     []any
+}
+
+func (f *fakeCanister) query(canisterID principal.Principal, method string, argTypes []idl.Type, args []any) ([]any, error) {
+	_, err := idl.Encode(argTypes, args)
+	require.NoError(f.t, err, "arguments must be Candid encodable")
+
+	f.canister, f.method, f.args = canisterID, method, args
+	return f.replies[method], f.err
+}
+
+func TestCallDispatcher(t *testing.T) {
+	dex, err := principal.Decode("7eo5f-eqaaa-aaaam-adqoq-cai")
+	require.NoError(t, err)
+	owner, err := principal.Decode(testCallerA)
+	require.NoError(t, err)
+	ownerAddress, err := convertICPToEthAddress(testCallerA)
+	require.NoError(t, err)
+
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000c0")
+	ledger := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	fake := &fakeCanister{t: t, replies: map[string][]any{
+		"get_currency_pairs": {[]any{
+			map[uint32]any{candidFieldID("base_currency"): "USD", candidFieldID("quote_currency"): "EUR"},
+		}},
+		"get_token_balance": {big.NewInt(42)},
+		"get_owner":         {owner},
+		"get_optional":      {nil},
+		"get_result":        {map[uint32]any{candidFieldID("Ok"): uint64(7)}},
+		"get_error":         {map[uint32]any{candidFieldID("Err"): "insufficient funds"}},
+		"get_negative":      {big.NewInt(-1)},
+	}}
+
+	dispatcher, err := newCallDispatcher([]conf.CallContractConfig{
+		{
+			Address: contract.Hex(),
+			Methods: []conf.CallMethodConfig{
+				{Signature: "getCurrencyPairs() returns ((string base_currency, string quote_currency)[])", Query: "get_currency_pairs"},
+				{Signature: "getTokenBalance(address owner, string currency) returns (uint256)", Query: "get_token_balance"},
+				{Signature: "owner() returns (address)", Query: "get_owner"},
+				{Signature: "optional() returns (uint256)", Query: "get_optional"},
+				{Signature: "result() returns (uint64)", Query: "get_result"},
+				{Signature: "failing() returns (uint64)", Query: "get_error"},
+				{Signature: "negative() returns (uint256)", Query: "get_negative"},
+			},
+		},
+		{
+			Address:    ledger.Hex(),
+			CanisterID: testCallerB,
+			Methods:    []conf.CallMethodConfig{{Signature: "owner() returns (address)", Query: "get_owner"}},
+		},
+	}, dex, fake.query, newPrincipalBook(nil))
+	require.NoError(t, err)
+
+	call := func(t *testing.T, address common.Address, signature string, args ...interface{}) []interface{} {
+		method, err := parseFunctionSignature(signature)
+		require.NoError(t, err)
+		data, err := method.Inputs.Pack(args...)
+		require.NoError(t, err)
+
+		result, handled, err := dispatcher.call(address, append(method.ID, data...))
+		require.NoError(t, err)
+		require.True(t, handled)
+
+		values, err := method.Outputs.Unpack(result)
+		require.NoError(t, err)
+		return values
+	}
+
+	t.Run("Record vector reply", func(t *testing.T) {
+		values := call(t, contract, "getCurrencyPairs() returns ((string base_currency, string quote_currency)[])")
+		pairs := reflect.ValueOf(values[0])
+		require.Equal(t, 1, pairs.Len())
+		assert.Equal(t, "USD", pairs.Index(0).FieldByName("BaseCurrency").String())
+		assert.Equal(t, "EUR", pairs.Index(0).FieldByName("QuoteCurrency").String())
+		assert.Equal(t, dex, fake.canister)
+		assert.Equal(t, "get_currency_pairs", fake.method)
+		assert.Empty(t, fake.args)
+	})
+
+	t.Run("Address and string arguments", func(t *testing.T) {
+		values := call(t, contract, "getTokenBalance(address,string) returns (uint256)", common.HexToAddress(ownerAddress), "USD")
+		assert.Equal(t, []interface{}{big.NewInt(42)}, values)
+		assert.Equal(t, []any{owner, "USD"}, fake.args)
+	})
+
+	t.Run("Principal reply and configured canister", func(t *testing.T) {
+		assert.Equal(t, []interface{}{common.HexToAddress(ownerAddress)}, call(t, contract, "owner() returns (address)"))
+
+		call(t, ledger, "owner() returns (address)")
+		assert.Equal(t, testCallerB, fake.canister.Encode())
+	})
+
+	t.Run("Unwrapped replies", func(t *testing.T) {
+		assert.Zero(t, call(t, contract, "optional() returns (uint256)")[0].(*big.Int).Sign(), "null is zero")
+		assert.Equal(t, []interface{}{uint64(7)}, call(t, contract, "result() returns (uint64)"))
+	})
+
+	t.Run("Other addresses are not handled", func(t *testing.T) {
+		_, handled, err := dispatcher.call(common.HexToAddress("0xaa"), nil)
+		require.NoError(t, err)
+		assert.False(t, handled)
+
+		_, handled, err = (*callDispatcher)(nil).call(contract, nil)
+		require.NoError(t, err)
+		assert.False(t, handled)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		failing, err := parseFunctionSignature("failing()")
+		require.NoError(t, err)
+		negative, err := parseFunctionSignature("negative()")
+		require.NoError(t, err)
+		balance, err := parseFunctionSignature("getTokenBalance(address,string)")
+		require.NoError(t, err)
+		unknownOwner, err := balance.Inputs.Pack(common.HexToAddress("0xaa"), "USD")
+		require.NoError(t, err)
+
+		tests := []struct {
+			name string
+			data []byte
+			code int
+		}{
+			{name: "Missing selector", data: []byte{0x01}, code: ErrCodeExecutionReverted},
+			{name: "Unknown selector", data: crypto.Keccak256([]byte("transfer(address,uint256)"))[:4], code: ErrCodeExecutionReverted},
+			{name: "Missing arguments", data: balance.ID, code: ErrCodeExecutionReverted},
+			{name: "Address without principal", data: append(balance.ID, unknownOwner...), code: ErrCodeExecutionReverted},
+			{name: "Err reply", data: failing.ID, code: ErrCodeExecutionReverted},
+			{name: "Reply out of range", data: negative.ID, code: ErrCodeInternal},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, handled, err := dispatcher.call(contract, tt.data)
+				assert.True(t, handled)
+				var rpcErr *RPCError
+				require.True(t, errors.As(err, &rpcErr))
+				assert.Equal(t, tt.code, rpcErr.Code)
+			})
+		}
+
+		fake.err = errors.New("(2) IC0515: canister is stopping")
+		_, _, err = dispatcher.call(contract, failing.ID)
+		var rpcErr *RPCError
+		require.True(t, errors.As(err, &rpcErr))
+		assert.Equal(t, ErrCodeResourceUnavailable, rpcErr.Code)
+	})
+}
+
+func TestNewCallDispatcherErrors(t *testing.T) {
+	for name, config := range map[string]conf.CallContractConfig{
+		"Invalid address":   {Address: "0x1234"},
+		"Invalid canister":  {Address: testLedgerToken.Hex(), CanisterID: "not a principal"},
+		"Invalid signature": {Address: testLedgerToken.Hex(), Methods: []conf.CallMethodConfig{{Signature: "f(", Query: "f"}}},
+		"No Candid type":    {Address: testLedgerToken.Hex(), Methods: []conf.CallMethodConfig{{Signature: "f(function)", Query: "f"}}},
+		"No Candid result":  {Address: testLedgerToken.Hex(), Methods: []conf.CallMethodConfig{{Signature: "f() returns (function)", Query: "f"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newCallDispatcher([]conf.CallContractConfig{config}, principal.Principal{}, nil, nil)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -2,7 +2,6 @@ package evm
 
 import (
 	"encoding/json"
-	"fmt"
 
 	"github.com/aviate-labs/agent-go/candid/idl"
 	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
@@ -11,9 +10,9 @@ import (
 // GetCurrencyPairs handles the eth_getCurrencyPairs RPC method
 // Returns all available currency pairs from the DEX canister
 func (r *evmRouter) GetCurrencyPairs(_ JSONRPCRequest) (interface{}, error) {
-	pairs, err := r.icpClients.Dex.GetCurrencyPairs()
+	pairs, err := r.dex.GetCurrencyPairs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get currency pairs: %w", err)
+		return nil, newCanisterError(err, "failed to get currency pairs")
 	}
 	return pairs, nil
 }
@@ -29,26 +28,26 @@ func (r *evmRouter) MintTokens(request JSONRPCRequest) (interface{}, error) {
 	var mintReq MintRequest
 	params, ok := request.Params.([]interface{})
 	if !ok || len(params) == 0 {
-		return nil, fmt.Errorf("invalid params for eth_mintTokens")
+		return nil, newInvalidParamsError("invalid params for eth_mintTokens")
 	}
 
 	paramBytes, err := json.Marshal(params[0])
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal mint params: %w", err)
+		return nil, newInvalidParamsError("failed to marshal mint params: %w", err)
 	}
 
 	if err := json.Unmarshal(paramBytes, &mintReq); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal mint request: %w", err)
+		return nil, newInvalidParamsError("failed to unmarshal mint request: %w", err)
 	}
 
-	principalRecipient, err := ConvertEthAddressToICPPrincipal(mintReq.Recipient)
+	principalRecipient, err := r.principals.convertEthAddressToICPPrincipal(mintReq.Recipient)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert eth address to principal: %w", err)
+		return nil, newInvalidParamsError("failed to convert eth address to principal: %w", err)
 	}
 
 	amountInt, err := ConvertHexAmountToBigInt(mintReq.Amount)
 	if err != nil {
-		return nil, err
+		return nil, newInvalidParamsError("%w", err)
 	}
 
 	operation := icpDex.MintOperation{
@@ -57,13 +56,14 @@ func (r *evmRouter) MintTokens(request JSONRPCRequest) (interface{}, error) {
 		Recipient: principalRecipient,
 	}
 
-	result, err := r.icpClients.Dex.MintTokens(operation)
+	result, err := r.dex.MintTokens(operation)
+	r.invalidateCache()
 	if err != nil {
-		return nil, fmt.Errorf("failed to mint tokens: %w", err)
+		return nil, newCanisterError(err, "failed to mint tokens")
 	}
 
 	if result.Err != nil {
-		return nil, fmt.Errorf("failed to mint tokens: %s", *result.Err)
+		return nil, newRPCError(ErrCodeTransactionRejected, "failed to mint tokens: %s", *result.Err)
 	}
 
 	return true, nil
@@ -80,26 +80,26 @@ func (r *evmRouter) BurnTokens(request JSONRPCRequest) (interface{}, error) {
 	var burnReq BurnRequest
 	params, ok := request.Params.([]interface{})
 	if !ok || len(params) == 0 {
-		return nil, fmt.Errorf("invalid params for eth_burnTokens")
+		return nil, newInvalidParamsError("invalid params for eth_burnTokens")
 	}
 
 	paramBytes, err := json.Marshal(params[0])
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal burn params: %w", err)
+		return nil, newInvalidParamsError("failed to marshal burn params: %w", err)
 	}
 
 	if err := json.Unmarshal(paramBytes, &burnReq); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal burn request: %w", err)
+		return nil, newInvalidParamsError("failed to unmarshal burn request: %w", err)
 	}
 
-	principalOwner, err := ConvertEthAddressToICPPrincipal(burnReq.Owner)
+	principalOwner, err := r.principals.convertEthAddressToICPPrincipal(burnReq.Owner)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert eth address to principal: %w", err)
+		return nil, newInvalidParamsError("failed to convert eth address to principal: %w", err)
 	}
 
 	amountInt, err := ConvertHexAmountToBigInt(burnReq.Amount)
 	if err != nil {
-		return nil, err
+		return nil, newInvalidParamsError("%w", err)
 	}
 
 	operation := icpDex.BurnOperation{
@@ -108,13 +108,14 @@ func (r *evmRouter) BurnTokens(request JSONRPCRequest) (interface{}, error) {
 		Owner:    principalOwner,
 	}
 
-	result, err := r.icpClients.Dex.BurnTokens(operation)
+	result, err := r.dex.BurnTokens(operation)
+	r.invalidateCache()
 	if err != nil {
-		return nil, fmt.Errorf("failed to burn tokens: %w", err)
+		return nil, newCanisterError(err, "failed to burn tokens")
 	}
 
 	if result.Err != nil {
-		return nil, fmt.Errorf("failed to burn tokens: %s", *result.Err)
+		return nil, newRPCError(ErrCodeTransactionRejected, "failed to burn tokens: %s", *result.Err)
 	}
 
 	return true, nil
@@ -0,0 +1,277 @@
+package evm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// DEX canister operations logged to the ICRC-3 logger, see canisters/dex_canister constants.rs
+const (
+	OperationAddCurrencyPair = "add_currency_pair"
+	OperationMintTokens      = "mint_tokens"
+	OperationBurnTokens      = "burn_tokens"
+)
+
+// ERC-20 events emitted for DEX and ICRC-1/ICRC-2 ledger activity
+const (
+	TransferEventSignature = "Transfer(address,address,uint256)"
+	ApprovalEventSignature = "Approval(address,address,uint256)"
+)
+
+// tokenAddressNamespace seeds the derivation of the pseudo token contract addresses
+const tokenAddressNamespace = "icp-icrc3-evm-adapter:token:"
+
+var (
+	// TransferEventTopic is topic0 of every Transfer log
+	TransferEventTopic = crypto.Keccak256Hash([]byte(TransferEventSignature))
+	// ApprovalEventTopic is topic0 of every Approval log
+	ApprovalEventTopic = crypto.Keccak256Hash([]byte(ApprovalEventSignature))
+
+	// The DEX canister logs operations with their Rust Debug representation, e.g.
+	// MintOperation { currency: "USD", amount: Nat(100), recipient: Principal { ... } }
+	dexCurrencyPattern  = regexp.MustCompile(`currency: "([^"]*)"`)
+	dexAmountPattern    = regexp.MustCompile(`amount: (?:Nat\()?([0-9_]+)`)
+	dexPrincipalPattern = regexp.MustCompile(`(?:recipient|owner): (?:Principal\()?([a-z0-9]+(?:-[a-z0-9]+)+)`)
+	dexPrincipalBytes   = regexp.MustCompile(`(?:recipient|owner): Principal \{ len: \d+, bytes: \[([0-9, ]*)\] \}`)
+)
+
+// dexOperation is a DEX mint or burn decoded from its logger entry
+type dexOperation struct {
+	Operation string
+	Currency  string
+	Amount    *big.Int
+	Account   string
+}
+
+// tokenRegistry maps DEX currencies to their pseudo ERC-20 contract addresses and
+// decimals
+//
+// Configured addresses take precedence; any other currency gets an address derived
+// from its symbol, so it is stable across restarts and proxy instances.
+type tokenRegistry struct {
+	addresses map[string]common.Address
+	decimals  map[string]uint8
+}
+
+// newTokenRegistry creates a registry from the configured currency to address and
+// currency to decimals mappings
+//
+// Returns:
+//   - *tokenRegistry: The registry
+//   - error: If any configured address is not a valid hex address, or any decimals
+//     value does not fit in a uint8
+func newTokenRegistry(configured map[string]string, decimals map[string]int) (*tokenRegistry, error) {
+	registry := &tokenRegistry{
+		addresses: make(map[string]common.Address, len(configured)),
+		decimals:  make(map[string]uint8, len(decimals)),
+	}
+	for currency, address := range configured {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid token address '%s' for currency '%s'", address, currency)
+		}
+		registry.addresses[strings.ToUpper(currency)] = common.HexToAddress(address)
+	}
+	for currency, value := range decimals {
+		if value < 0 || value > math.MaxUint8 {
+			return nil, fmt.Errorf("invalid token decimals %d for currency '%s'", value, currency)
+		}
+		registry.decimals[strings.ToUpper(currency)] = uint8(value)
+	}
+
+	return registry, nil
+}
+
+// address returns the token contract address of a currency
+func (t *tokenRegistry) address(currency string) common.Address {
+	currency = strings.ToUpper(currency)
+	if t != nil {
+		if address, ok := t.addresses[currency]; ok {
+			return address
+		}
+	}
+
+	return common.BytesToAddress(crypto.Keccak256([]byte(tokenAddressNamespace + currency)))
+}
+
+// tokenDecimals returns the configured decimals of a currency, zero when not configured
+// as DEX amounts are plain integers
+func (t *tokenRegistry) tokenDecimals(currency string) uint8 {
+	if t == nil {
+		return 0
+	}
+
+	return t.decimals[strings.ToUpper(currency)]
+}
+
+// currency returns the currency whose token contract has the given address
+//
+// Parameters:
+//   - address: The token contract address
+//   - known: The currencies listed by the DEX, checked before the configured ones so
+//     the DEX spelling of the currency is returned
+//
+// Returns:
+//   - string: The currency
+//   - bool: False if no known or configured currency has that address
+func (t *tokenRegistry) currency(address common.Address, known []string) (string, bool) {
+	candidates := append([]string{}, known...)
+	if t != nil {
+		for currency := range t.addresses {
+			candidates = append(candidates, currency)
+		}
+	}
+
+	for _, currency := range candidates {
+		if t.address(currency) == address {
+			return currency, true
+		}
+	}
+
+	return "", false
+}
+
+// parseDexOperation decodes a DEX mint or burn logger entry
+//
+// Returns:
+//   - dexOperation: The decoded operation
+//   - bool: False if the entry is not a mint or burn, or its details cannot be decoded
+func parseDexOperation(operation string, details icpLogger.Value) (dexOperation, bool) {
+	if operation != OperationMintTokens && operation != OperationBurnTokens {
+		return dexOperation{}, false
+	}
+
+	text, ok := detailText(details)
+	if !ok {
+		return dexOperation{}, false
+	}
+
+	currency := dexCurrencyPattern.FindStringSubmatch(text)
+	amount := dexAmountPattern.FindStringSubmatch(text)
+	if currency == nil || amount == nil {
+		return dexOperation{}, false
+	}
+
+	value, ok := new(big.Int).SetString(strings.ReplaceAll(amount[1], "_", ""), 10)
+	if !ok {
+		return dexOperation{}, false
+	}
+
+	account, ok := parseDexPrincipal(text)
+	if !ok {
+		return dexOperation{}, false
+	}
+
+	return dexOperation{
+		Operation: operation,
+		Currency:  currency[1],
+		Amount:    value,
+		Account:   account,
+	}, true
+}
+
+// detailText returns the Debug text the DEX canister stores under the "value" detail
+func detailText(details icpLogger.Value) (string, bool) {
+	if details.Map == nil {
+		return "", false
+	}
+
+	for _, entry := range *details.Map {
+		if entry.Field0 != "value" || entry.Field1.Text == nil {
+			continue
+		}
+
+		text := *entry.Field1.Text
+		// The operation is formatted twice, so the outer Debug adds quotes and escapes
+		if unquoted, err := strconv.Unquote(text); err == nil {
+			text = unquoted
+		}
+		return text, true
+	}
+
+	return "", false
+}
+
+// parseDexPrincipal extracts the recipient or owner principal, printed either as
+// its textual form or as its raw bytes depending on the candid version
+func parseDexPrincipal(text string) (string, bool) {
+	if match := dexPrincipalPattern.FindStringSubmatch(text); match != nil {
+		return match[1], true
+	}
+
+	match := dexPrincipalBytes.FindStringSubmatch(text)
+	if match == nil {
+		return "", false
+	}
+
+	var raw []byte
+	for _, item := range strings.Split(match[1], ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		b, err := strconv.ParseUint(item, 10, 8)
+		if err != nil {
+			return "", false
+		}
+		raw = append(raw, byte(b))
+	}
+
+	return principal.Principal{Raw: raw}.Encode(), true
+}
+
+// transferEvent builds the Transfer event of a DEX operation; mints come from and
+// burns go to the zero address; long accounts are recorded in principals
+func transferEvent(op dexOperation, principals *principalBook) (Log, error) {
+	account, err := principals.convertICPToEthAddress(op.Account)
+	if err != nil {
+		return Log{}, fmt.Errorf("failed to convert ICP address to ETH address: %w", err)
+	}
+
+	from, to := common.Address{}, common.HexToAddress(account)
+	if op.Operation == OperationBurnTokens {
+		from, to = to, common.Address{}
+	}
+
+	return erc20Event(TransferEventTopic, from, to, op.Amount), nil
+}
+
+// erc20Event builds the topics and data of a Transfer or Approval event
+func erc20Event(topic common.Hash, from, to common.Address, amount *big.Int) Log {
+	return Log{
+		Topics: []string{
+			topic.Hex(),
+			common.BytesToHash(from.Bytes()).Hex(),
+			common.BytesToHash(to.Bytes()).Hex(),
+		},
+		Data: "0x" + common.Bytes2Hex(common.BigToHash(amount).Bytes()),
+	}
+}
+
+// dexTransferMapper maps DEX mint and burn entries to Transfer logs emitted by the
+// token address of their currency
+func dexTransferMapper(tokens *tokenRegistry, principals *principalBook) operationMapper {
+	return func(entry loggerEntry) (Log, bool, error) {
+		op, ok := parseDexOperation(entry.Operation, entry.Details)
+		if !ok {
+			return Log{}, false, nil
+		}
+
+		log, err := transferEvent(op, principals)
+		if err != nil {
+			return Log{}, false, err
+		}
+
+		log.Address = tokens.address(op.Currency).Hex()
+		return log, true, nil
+	}
+}
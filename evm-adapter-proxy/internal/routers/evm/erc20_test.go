@@ -0,0 +1,181 @@
+package evm
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+const testRecipient = "rrkah-fqaaa-aaaaa-aaaaq-cai"
+
+// dexDetails wraps an operation the way the DEX canister logs it: formatted with
+// Debug once by the caller and once more by log_operation
+func dexDetails(debug string) icpLogger.Value {
+	return mapValue(testMapEntry{Field0: "value", Field1: textValue(strconv.Quote(debug))})
+}
+
+// newDexBlock builds a logger canister block with a single DEX entry
+func newDexBlock(id uint64, operation, debug string) ICRC3Block {
+	entries := []icpLogger.Value{mapValue(
+		testMapEntry{Field0: "timestamp", Field1: natValue(id * 1e9)},
+		testMapEntry{Field0: "operation", Field1: textValue(operation)},
+		testMapEntry{Field0: "caller", Field1: textValue("7eo5f-eqaaa-aaaam-adqoq-cai")},
+		testMapEntry{Field0: "details", Field1: dexDetails(debug)},
+	)}
+
+	return ICRC3Block{ID: id, Value: mapValue(
+		testMapEntry{Field0: "id", Field1: natValue(id)},
+		testMapEntry{Field0: "hash", Field1: blobValue([]byte{byte(id), 1})},
+		testMapEntry{Field0: "btype", Field1: textValue(BlockTypeLogEntry)},
+		testMapEntry{Field0: "entries", Field1: icpLogger.Value{Array: &entries}},
+	)}
+}
+
+func TestParseDexOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		debug     string
+		want      dexOperation
+		wantOK    bool
+	}{
+		{
+			name:      "Mint with textual principal",
+			operation: OperationMintTokens,
+			debug:     `MintOperation { currency: "USD", amount: Nat(1_000), recipient: Principal(` + testRecipient + `) }`,
+			want:      dexOperation{Operation: OperationMintTokens, Currency: "USD", Amount: big.NewInt(1000), Account: testRecipient},
+			wantOK:    true,
+		},
+		{
+			name:      "Burn with raw principal bytes",
+			operation: OperationBurnTokens,
+			debug:     `BurnOperation { currency: "EUR", amount: 42, owner: Principal { len: 1, bytes: [4] } }`,
+			want:      dexOperation{Operation: OperationBurnTokens, Currency: "EUR", Amount: big.NewInt(42), Account: "2vxsx-fae"},
+			wantOK:    true,
+		},
+		{
+			name:      "Other operation",
+			operation: "add_currency_pair",
+			debug:     `CurrencyPair { base_currency: "USD", quote_currency: "EUR" }`,
+		},
+		{
+			name:      "Missing amount",
+			operation: OperationMintTokens,
+			debug:     `MintOperation { currency: "USD", recipient: Principal(` + testRecipient + `) }`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseDexOperation(tt.operation, dexDetails(tt.debug))
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTokenRegistry(t *testing.T) {
+	configured := "0x00000000000000000000000000000000000000aa"
+	registry, err := newTokenRegistry(map[string]string{"usd": configured}, map[string]int{"usd": 6})
+	require.NoError(t, err)
+
+	assert.Equal(t, common.HexToAddress(configured), registry.address("USD"))
+
+	derived := registry.address("EUR")
+	assert.NotEqual(t, common.Address{}, derived)
+	assert.Equal(t, derived, registry.address("eur"), "derivation ignores the symbol case")
+	assert.Equal(t, derived, (*tokenRegistry)(nil).address("EUR"), "derivation does not depend on the configuration")
+
+	assert.Equal(t, uint8(6), registry.tokenDecimals("USD"))
+	assert.Equal(t, uint8(0), registry.tokenDecimals("EUR"))
+
+	currency, ok := registry.currency(derived, []string{"usd", "eur"})
+	assert.True(t, ok)
+	assert.Equal(t, "eur", currency, "the DEX spelling of the currency is kept")
+	currency, ok = registry.currency(common.HexToAddress(configured), nil)
+	assert.True(t, ok)
+	assert.Equal(t, "USD", currency)
+	_, ok = registry.currency(derived, nil)
+	assert.False(t, ok, "derived addresses are only known for listed currencies")
+
+	_, err = newTokenRegistry(map[string]string{"USD": "0x1234"}, nil)
+	assert.Error(t, err)
+	_, err = newTokenRegistry(nil, map[string]int{"USD": 256})
+	assert.Error(t, err)
+}
+
+func TestExtractTransferLogs(t *testing.T) {
+	erc20, err := abi.JSON(strings.NewReader(`[{"anonymous":false,"inputs":[
+		{"indexed":true,"name":"from","type":"address"},
+		{"indexed":true,"name":"to","type":"address"},
+		{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}]`))
+	require.NoError(t, err)
+
+	recipient, err := convertICPToEthAddress(testRecipient)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		operation string
+		debug     string
+		from      common.Address
+		to        common.Address
+	}{
+		{
+			name:      "Mint",
+			operation: OperationMintTokens,
+			debug:     `MintOperation { currency: "USD", amount: Nat(1_000), recipient: Principal(` + testRecipient + `) }`,
+			to:        common.HexToAddress(recipient),
+		},
+		{
+			name:      "Burn",
+			operation: OperationBurnTokens,
+			debug:     `BurnOperation { currency: "USD", amount: Nat(1_000), owner: Principal(` + testRecipient + `) }`,
+			from:      common.HexToAddress(recipient),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := newDefaultMapperRegistry(nil, nil).mapLogs(newDexBlock(1, tt.operation, tt.debug), logFilter{})
+			require.NoError(t, err)
+			require.Len(t, logs, 1)
+
+			log := logs[0]
+			assert.Equal(t, (*tokenRegistry)(nil).address("USD").Hex(), log.Address)
+			require.Len(t, log.Topics, 3)
+			assert.Equal(t, erc20.Events["Transfer"].ID.Hex(), log.Topics[0])
+			assert.Equal(t, common.BytesToHash(tt.from.Bytes()).Hex(), log.Topics[1])
+			assert.Equal(t, common.BytesToHash(tt.to.Bytes()).Hex(), log.Topics[2])
+
+			values, err := erc20.Unpack("Transfer", common.FromHex(log.Data))
+			require.NoError(t, err)
+			require.Len(t, values, 1)
+			assert.Equal(t, big.NewInt(1000), values[0])
+		})
+	}
+}
+
+func TestExtractTransferLogsTopicFilter(t *testing.T) {
+	block := newDexBlock(1, OperationMintTokens, `MintOperation { currency: "USD", amount: 5, recipient: Principal(`+testRecipient+`) }`)
+	zero := common.Hash{}.Hex()
+
+	registry := newDefaultMapperRegistry(nil, nil)
+
+	logs, err := registry.mapLogs(block, logFilter{Topics: [][]string{{TransferEventTopic.Hex()}, {zero}}})
+	require.NoError(t, err)
+	assert.Len(t, logs, 1, "mints are transfers from the zero address")
+
+	logs, err = registry.mapLogs(block, logFilter{Topics: [][]string{nil, nil, {zero}}})
+	require.NoError(t, err)
+	assert.Empty(t, logs, "mints are not transfers to the zero address")
+}
@@ -0,0 +1,222 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC 2.0 reserved error codes
+const (
+	ErrCodeParseError     = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+)
+
+// EIP-1474 server error codes
+const (
+	ErrCodeInvalidInput        = -32000
+	ErrCodeResourceNotFound    = -32001
+	ErrCodeResourceUnavailable = -32002
+	ErrCodeTransactionRejected = -32003
+	ErrCodeMethodNotSupported  = -32004
+	ErrCodeLimitExceeded       = -32005
+	ErrCodeVersionNotSupported = -32006
+)
+
+// Adapter server error codes, in the implementation-defined -32000 to -32099 range
+const (
+	// ErrCodeBlockVerificationFailed reports blocks that do not belong to the certified ICRC-3 chain
+	ErrCodeBlockVerificationFailed = -32010
+)
+
+// Ethereum execution error codes, as returned by go-ethereum
+const (
+	// ErrCodeExecutionReverted reports an eth_call the called contract does not answer
+	ErrCodeExecutionReverted = 3
+)
+
+// IC reject codes as defined by the Internet Computer interface specification
+const (
+	RejectCodeSysFatal           = 1
+	RejectCodeSysTransient       = 2
+	RejectCodeDestinationInvalid = 3
+	RejectCodeCanisterReject     = 4
+	RejectCodeCanisterError      = 5
+)
+
+var rejectCodeNames = map[uint64]string{
+	RejectCodeSysFatal:           "SYS_FATAL",
+	RejectCodeSysTransient:       "SYS_TRANSIENT",
+	RejectCodeDestinationInvalid: "DESTINATION_INVALID",
+	RejectCodeCanisterReject:     "CANISTER_REJECT",
+	RejectCodeCanisterError:      "CANISTER_ERROR",
+}
+
+// agent-go reports rejects and HTTP failures as "(<code>) <details>"
+var agentErrorPattern = regexp.MustCompile(`^\((\d+)\) (.*)$`)
+
+// icErrorCodePattern matches IC error codes such as IC0503
+var icErrorCodePattern = regexp.MustCompile(`\bIC\d{4}\b`)
+
+// RPCError is an error carrying a JSON-RPC error code and optional structured data.
+// Handlers return it (possibly wrapped) so the dispatcher can build a spec-compliant
+// error object; any other error is reported as an internal error.
+type RPCError struct {
+	Code int
+	Data interface{}
+	Err  error
+}
+
+func (e *RPCError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("rpc error %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+// CanisterErrorData is attached as the data of errors caused by a failed canister call
+type CanisterErrorData struct {
+	RejectCode     uint64 `json:"rejectCode,omitempty"`
+	RejectCodeName string `json:"rejectCodeName,omitempty"`
+	ErrorCode      string `json:"errorCode,omitempty"`
+	HTTPStatus     int    `json:"httpStatus,omitempty"`
+	Message        string `json:"message"`
+}
+
+// newRPCError creates an RPCError with the given code and formatted message
+func newRPCError(code int, format string, args ...interface{}) *RPCError {
+	return &RPCError{
+		Code: code,
+		Err:  fmt.Errorf(format, args...),
+	}
+}
+
+// newInvalidParamsError creates a -32602 error for malformed or missing method parameters
+func newInvalidParamsError(format string, args ...interface{}) *RPCError {
+	return newRPCError(ErrCodeInvalidParams, format, args...)
+}
+
+// newInternalError creates a -32603 error for failures inside the adapter itself
+func newInternalError(format string, args ...interface{}) *RPCError {
+	return newRPCError(ErrCodeInternal, format, args...)
+}
+
+// newNotFoundError creates a -32001 error for resources that do not exist
+func newNotFoundError(format string, args ...interface{}) *RPCError {
+	return newRPCError(ErrCodeResourceNotFound, format, args...)
+}
+
+// isNotFoundError reports whether an error is a -32001 resource not found error
+func isNotFoundError(err error) bool {
+	var rpcErr *RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == ErrCodeResourceNotFound
+}
+
+// newVerificationError creates a -32010 error for blocks that fail hash chain verification
+func newVerificationError(format string, args ...interface{}) *RPCError {
+	return newRPCError(ErrCodeBlockVerificationFailed, format, args...)
+}
+
+// newRevertError creates a 3 error for eth_call requests that revert
+func newRevertError(format string, args ...interface{}) *RPCError {
+	return newRPCError(ErrCodeExecutionReverted, "execution reverted: "+format, args...)
+}
+
+// newCanisterError wraps an error returned by an ICP canister call
+//
+// The agent-go error is parsed to recover the IC reject code (or the HTTP status
+// of the replica response) which is exposed in the error data. Transient failures
+// (SYS_TRANSIENT rejects, 5xx replies, network errors, timeouts) are reported as
+// -32002 resource unavailable so clients can retry them; every other failure is
+// reported as -32603 internal error. Errors that already carry an RPC code, such
+// as block verification failures, keep it.
+func newCanisterError(err error, format string, args ...interface{}) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return &RPCError{
+			Code: rpcErr.Code,
+			Data: rpcErr.Data,
+			Err:  fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err),
+		}
+	}
+
+	data := parseCanisterError(err)
+	code := ErrCodeInternal
+	if isTransientCanisterError(err, data) {
+		code = ErrCodeResourceUnavailable
+	}
+
+	return &RPCError{
+		Code: code,
+		Data: data,
+		Err:  fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err),
+	}
+}
+
+// parseCanisterError extracts the reject details from an agent-go error message
+func parseCanisterError(err error) CanisterErrorData {
+	message := err.Error()
+	data := CanisterErrorData{Message: message}
+
+	matches := agentErrorPattern.FindStringSubmatch(message)
+	if matches == nil {
+		return data
+	}
+
+	code, parseErr := strconv.ParseUint(matches[1], 10, 64)
+	if parseErr != nil {
+		return data
+	}
+
+	data.Message = matches[2]
+	if name, ok := rejectCodeNames[code]; ok {
+		data.RejectCode = code
+		data.RejectCodeName = name
+		data.ErrorCode = icErrorCodePattern.FindString(matches[2])
+		return data
+	}
+
+	data.HTTPStatus = int(code)
+	return data
+}
+
+// isTransientCanisterError reports whether a canister failure is worth retrying
+func isTransientCanisterError(err error, data CanisterErrorData) bool {
+	if data.RejectCode == RejectCodeSysTransient || data.HTTPStatus >= 500 || data.HTTPStatus == 429 {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return strings.HasPrefix(err.Error(), "out of time")
+}
+
+// toJSONRPCError converts any handler error into a JSON-RPC error object
+func toJSONRPCError(err error) *JSONRPCError {
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		return &JSONRPCError{
+			Code:    ErrCodeInternal,
+			Message: err.Error(),
+		}
+	}
+
+	return &JSONRPCError{
+		Code:    rpcErr.Code,
+		Message: err.Error(),
+		Data:    rpcErr.Data,
+	}
+}
@@ -0,0 +1,104 @@
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCanisterError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantData CanisterErrorData
+	}{
+		{
+			name:     "Canister reject from a query",
+			err:      errors.New("(4) IC0406: Canister rejected the message"),
+			wantCode: ErrCodeInternal,
+			wantData: CanisterErrorData{
+				RejectCode:     RejectCodeCanisterReject,
+				RejectCodeName: "CANISTER_REJECT",
+				ErrorCode:      "IC0406",
+				Message:        "IC0406: Canister rejected the message",
+			},
+		},
+		{
+			name:     "Transient reject",
+			err:      errors.New("(2) IC0207: Canister is out of cycles"),
+			wantCode: ErrCodeResourceUnavailable,
+			wantData: CanisterErrorData{
+				RejectCode:     RejectCodeSysTransient,
+				RejectCodeName: "SYS_TRANSIENT",
+				ErrorCode:      "IC0207",
+				Message:        "IC0207: Canister is out of cycles",
+			},
+		},
+		{
+			name:     "Replica HTTP failure",
+			err:      errors.New("(503) 503 Service Unavailable: overloaded"),
+			wantCode: ErrCodeResourceUnavailable,
+			wantData: CanisterErrorData{
+				HTTPStatus: 503,
+				Message:    "503 Service Unavailable: overloaded",
+			},
+		},
+		{
+			name:     "Unstructured error",
+			err:      errors.New("candid: unexpected type"),
+			wantCode: ErrCodeInternal,
+			wantData: CanisterErrorData{
+				Message: "candid: unexpected type",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newCanisterError(tt.err, "failed to get blocks")
+			assert.Equal(t, tt.wantCode, got.Code)
+			assert.Equal(t, tt.wantData, got.Data)
+			assert.ErrorIs(t, got, tt.err)
+			assert.Contains(t, got.Error(), "failed to get blocks")
+		})
+	}
+}
+
+func TestToJSONRPCError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantMsg  string
+	}{
+		{
+			name:     "Plain error",
+			err:      errors.New("boom"),
+			wantCode: ErrCodeInternal,
+			wantMsg:  "boom",
+		},
+		{
+			name:     "Typed error",
+			err:      newInvalidParamsError("invalid block number"),
+			wantCode: ErrCodeInvalidParams,
+			wantMsg:  "invalid block number",
+		},
+		{
+			name:     "Wrapped typed error keeps code and full message",
+			err:      fmt.Errorf("failed to get latest block number: %w", newNotFoundError("no tip certificate found")),
+			wantCode: ErrCodeResourceNotFound,
+			wantMsg:  "failed to get latest block number: no tip certificate found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toJSONRPCError(tt.err)
+			assert.Equal(t, tt.wantCode, got.Code)
+			assert.Equal(t, tt.wantMsg, got.Message)
+		})
+	}
+}
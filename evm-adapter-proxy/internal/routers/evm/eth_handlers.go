@@ -1,16 +1,13 @@
 package evm
 
 import (
-	"encoding/base32"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 
 	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
 
-	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 // Block tag constants for EVM block references
@@ -18,50 +15,61 @@ const (
 	BlockTagLatest    = "latest"
 	BlockTagSafe      = "safe"
 	BlockTagFinalized = "finalized"
+	BlockTagPending   = "pending"
+	BlockTagEarliest  = "earliest"
 )
 
 // EthChainID implements the eth_chainId RPC method
 // Returns the current chain ID in hexadecimal format
 func (r *evmRouter) EthChainID(_ JSONRPCRequest) (interface{}, error) {
-	chainID, err := r.icpClients.Logger.ChainId()
+	chainID, err := r.chainID()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get chain ID: %w", err)
+		return nil, err
+	}
+
+	evmChainID := fmt.Sprintf("0x%x", chainID)
+	return evmChainID, nil
+}
+
+// chainID returns the chain ID configured for the source, or the one reported by the
+// logger canister
+func (r *evmRouter) chainID() (uint64, error) {
+	if r.configuredChainID != 0 {
+		return r.configuredChainID, nil
+	}
+
+	if r.chain == nil {
+		return 0, newInternalError("no chain ID configured")
+	}
+
+	chainID, err := r.chain.ChainId()
+	if err != nil {
+		return 0, newCanisterError(err, "failed to get chain ID")
 	}
 	if chainID == nil {
-		return nil, fmt.Errorf("chain ID is nil")
+		return 0, newInternalError("chain ID is nil")
 	}
 
 	chainIDInt, err := strconv.ParseUint(*chainID, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid chain ID format: %w", err)
+		return 0, newInternalError("invalid chain ID format: %w", err)
 	}
 
-	evmChainID := fmt.Sprintf("0x%x", chainIDInt)
-	return evmChainID, nil
+	return chainIDInt, nil
 }
 
 // EthBlockNumber implements the eth_blockNumber RPC method
 // Returns the latest block number in hexadecimal format
+//
+// The number is the last_block_index of the ICRC-3 tip certificate, which is
+// verified against the IC root key before it is trusted.
 func (r *evmRouter) EthBlockNumber(_ JSONRPCRequest) (interface{}, error) {
-	tipCert, err := r.icpClients.Logger.Icrc3GetTipCertificate()
+	tip, err := r.getCertifiedTip()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tip certificate: %w", err)
-	}
-	if tipCert == nil || *tipCert == nil {
-		return nil, fmt.Errorf("no tip certificate found")
-	}
-
-	certificate := (*tipCert).Certificate
-	if certificate == nil {
-		return nil, fmt.Errorf("certificate data is nil")
-	}
-
-	blockNumber, err := decodeCertificateData(certificate)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode tip certificate: %w", err)
+		return nil, err
 	}
 
-	ethBlockNumber := fmt.Sprintf("0x%x", blockNumber)
+	ethBlockNumber := fmt.Sprintf("0x%x", tip.Index)
 	return ethBlockNumber, nil
 }
 
@@ -71,138 +79,174 @@ func (r *evmRouter) EthAccounts(_ JSONRPCRequest) (interface{}, error) {
 }
 
 // EthNetVersion implements the net_version RPC method
-// Returns the current network ID
+// Returns the current network ID, the decimal chain ID for sources configuring one
 func (r *evmRouter) EthNetVersion(_ JSONRPCRequest) (interface{}, error) {
-	netVersion, err := r.icpClients.Logger.NetVersion()
+	if r.configuredChainID != 0 {
+		netVersion := strconv.FormatUint(r.configuredChainID, 10)
+		return &netVersion, nil
+	}
+
+	if r.chain == nil {
+		return nil, newInternalError("no chain ID configured")
+	}
+
+	netVersion, err := r.chain.NetVersion()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get net version: %w", err)
+		return nil, newCanisterError(err, "failed to get net version")
 	}
 	return netVersion, nil
 }
 
 // EthGetBlockByNumber implements the eth_getBlockByNumber RPC method
-// Retrieves a block by its number
+// Retrieves a block by its number, or null when the log has no such block
 //
 // Parameters:
-// - blockNumberHex: Block number in hex or "latest"
+// - blockNumberHex: Block number in hex or a block tag
+// - fullTransactions: Optional, whether to return transaction objects instead of hashes
 // Note: This is a PoC implementation and should be enhanced for production use
 func (r *evmRouter) EthGetBlockByNumber(request JSONRPCRequest) (interface{}, error) {
 	params, ok := request.Params.([]interface{})
 	if !ok || len(params) < 1 {
-		return nil, fmt.Errorf("invalid params for eth_getBlockByNumber")
+		return nil, newInvalidParamsError("invalid params for eth_getBlockByNumber")
 	}
 
 	blockNumberHex, ok := params[0].(string)
 	if !ok {
-		return nil, fmt.Errorf("invalid block number")
-	}
-
-	var blockNumber string
-	var err error
-	switch blockNumberHex {
-	case BlockTagLatest, BlockTagSafe, BlockTagFinalized:
-		latestBlockHex, err := r.EthBlockNumber(JSONRPCRequest{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to get latest block number: %w", err)
-		}
-		blockNumberHex = latestBlockHex.(string)
+		return nil, newInvalidParamsError("invalid block number")
 	}
 
-	blockNumber, err = hexToDecimal(blockNumberHex)
+	fullTransactions, err := fullTransactionsParam(params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse block number: %w", err)
+		return nil, err
 	}
 
-	blocksArgs := icpLogger.GetBlocksArgs{
-		Start:  idl.NewNatFromString(blockNumber),
-		Length: idl.NewNatFromString("1"),
+	start, err := r.resolveBlockNumber(blockNumberHex)
+	if isNotFoundError(err) {
+		// An empty log has no latest block
+		return nil, nil
 	}
-	result, err := r.icpClients.Logger.Icrc3GetBlocks(blocksArgs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get block by number: %w", err)
+		return nil, err
 	}
 
-	if result.LogLength.BigInt().Uint64() == 0 {
-		return nil, fmt.Errorf("block not found")
+	result, err := r.getBlocks(start, 1)
+	if err != nil {
+		return nil, newCanisterError(err, "failed to get block by number")
 	}
 
+	// Blocks past the tip, or missing from the log, are not found
 	if len(result.Blocks) == 0 {
-		return nil, fmt.Errorf("block not found")
-	}
-
-	firstBlock := result.Blocks[0]
-	evmBlock, err := mapBlockToEVMBlock(firstBlock.Block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to map ICRC3 block to EVM block: %w", err)
+		return nil, nil
 	}
 
-	return evmBlock, nil
+	return r.blockResult(result.Blocks[0], fullTransactions)
 }
 
 // EthGetBlockByHash implements the eth_getBlockByHash RPC method
-// Retrieves a block by its hash
+// Retrieves a block by its hash, or null when no block has that hash
 //
 // Parameters:
 // - blockHash: The hash of the block to retrieve
+// - fullTransactions: Optional, whether to return transaction objects instead of hashes
 func (r *evmRouter) EthGetBlockByHash(request JSONRPCRequest) (interface{}, error) {
 	params, ok := request.Params.([]interface{})
 	if !ok || len(params) < 1 {
-		return nil, fmt.Errorf("invalid params for eth_getBlockByHash")
+		return nil, newInvalidParamsError("invalid params for eth_getBlockByHash")
 	}
 
 	requestedBlockHash, ok := params[0].(string)
 	if !ok {
-		return nil, fmt.Errorf("invalid block hash")
+		return nil, newInvalidParamsError("invalid block hash")
+	}
+	if _, err := hexutil.Decode(requestedBlockHash); err != nil {
+		return nil, newInvalidParamsError("invalid block hash: %w", err)
 	}
 
-	blocksArgs := icpLogger.GetBlocksArgs{
-		Start:  idl.NewNatFromString("0"), // Initialize as needed
-		Length: idl.NewNatFromString("1"),
+	fullTransactions, err := fullTransactionsParam(params)
+	if err != nil {
+		return nil, err
 	}
-	latestBlockResult, err := r.icpClients.Logger.Icrc3GetBlocks(blocksArgs)
+
+	number, found, err := r.findBlockByHash(requestedBlockHash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get latest block: %w", err)
+		return nil, err
+	}
+	if !found {
+		return nil, nil
 	}
 
-	if len(latestBlockResult.Blocks) == 0 {
-		return nil, fmt.Errorf("no blocks found")
+	result, err := r.getBlocks(number, 1)
+	if err != nil {
+		return nil, newCanisterError(err, "failed to get block %d", number)
+	}
+	if len(result.Blocks) == 0 {
+		return nil, nil
 	}
 
-	currentBlockNumber := latestBlockResult.Blocks[0].Id.BigInt().Uint64()
+	return r.blockResult(result.Blocks[0], fullTransactions)
+}
 
-	for {
-		blocksArgs := icpLogger.GetBlocksArgs{
-			Start:  idl.NewNatFromString(fmt.Sprintf("%d", currentBlockNumber)),
-			Length: idl.NewNatFromString("1"),
-		}
-		blocksResult, err := r.icpClients.Logger.Icrc3GetBlocks(blocksArgs)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block %d: %w", currentBlockNumber, err)
-		}
+// blockResult maps a block for eth_getBlockByNumber and eth_getBlockByHash
+//
+// Returns:
+//   - interface{}: A Block with transaction hashes, or a BlockWithTransactions when
+//     fullTransactions is set
+//   - error: Any error mapping the block
+func (r *evmRouter) blockResult(block ICRC3Block, fullTransactions bool) (interface{}, error) {
+	evmBlock, err := r.mappers.mapBlock(block)
+	if err != nil {
+		return nil, newInternalError("failed to map ICRC3 block to EVM block: %w", err)
+	}
 
-		if len(blocksResult.Blocks) == 0 {
-			return nil, fmt.Errorf("block %d not found", currentBlockNumber)
-		}
+	if !fullTransactions {
+		return evmBlock, nil
+	}
 
-		blockResult := blocksResult.Blocks[0]
+	transactions, err := r.mappers.mapTransactions(block)
+	if err != nil {
+		return nil, newInternalError("failed to map transactions of block %d: %w", block.ID, err)
+	}
 
-		blockHash, err := getBlockHash(blockResult.Block)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block hash: %w", err)
-		}
+	return BlockWithTransactions{Block: evmBlock, Transactions: transactions}, nil
+}
 
-		if blockHash == requestedBlockHash {
-			return mapBlockToEVMBlock(blockResult.Block)
-		}
+// fullTransactionsParam returns the optional fullTransactions flag, the second parameter
+// of the block methods
+func fullTransactionsParam(params []interface{}) (bool, error) {
+	if len(params) < 2 || params[1] == nil {
+		return false, nil
+	}
 
-		if currentBlockNumber <= 0 {
-			break
-		}
+	fullTransactions, ok := params[1].(bool)
+	if !ok {
+		return false, newInvalidParamsError("invalid fullTransactions flag")
+	}
 
-		currentBlockNumber--
+	return fullTransactions, nil
+}
+
+// resolveBlockNumber returns the number of a block parameter: a hex number or a block
+// tag, as parseBlockParam resolves them; earliest is block 0 and the others all
+// resolve to the certified tip
+func (r *evmRouter) resolveBlockNumber(blockNumberHex string) (uint64, error) {
+	switch blockNumberHex {
+	case BlockTagEarliest:
+		return 0, nil
+	case BlockTagLatest, BlockTagSafe, BlockTagFinalized, BlockTagPending:
+		return r.getLatestBlockNumber()
 	}
 
-	return nil, fmt.Errorf("block with hash %s not found", requestedBlockHash)
+	blockNumber, err := hexToDecimal(blockNumberHex)
+	if err != nil {
+		return 0, newInvalidParamsError("failed to parse block number: %w", err)
+	}
+
+	number, err := strconv.ParseUint(blockNumber, 10, 64)
+	if err != nil {
+		return 0, newInvalidParamsError("failed to parse block number: %w", err)
+	}
+
+	return number, nil
 }
 
 // EthGetLogs implements the eth_getLogs RPC method
@@ -211,79 +255,90 @@ func (r *evmRouter) EthGetBlockByHash(request JSONRPCRequest) (interface{}, erro
 // The filter can include:
 // - fromBlock: Start block number
 // - toBlock: End block number
-// - address: Contract address to filter
-// - blockHash: Specific block to get logs from
+// - address: Contract address, or array of addresses, to filter
+// - topics: Positional topic filters, each null (any), a topic or an array of topics (any of them)
+// - blockHash: Specific block to get logs from, exclusive with fromBlock/toBlock
 func (r *evmRouter) EthGetLogs(request JSONRPCRequest) (interface{}, error) {
-	filter, err := extractFilterFromParams(request.Params)
+	filter, err := extractFilterFromParams("eth_getLogs", request.Params)
 	if err != nil {
 		return nil, err
 	}
 
-	fromBlock, toBlock, err := extractBlockRange(filter)
+	criteria, err := parseLogFilter(filter, r.latestBlockOrGenesis)
 	if err != nil {
 		return nil, err
 	}
 
-	address, err := extractAddress(filter)
-	if err != nil {
-		return nil, err
-	}
-
-	filterBlockHash := extractBlockHash(filter)
-
-	return r.getLogsByFilter(fromBlock, toBlock, address, filterBlockHash)
+	return r.getLogsByFilter(criteria)
 }
 
 // Helper functions
 
 // extractFilterFromParams extracts and validates the filter parameters from the RPC request
-func extractFilterFromParams(params interface{}) (map[string]interface{}, error) {
+func extractFilterFromParams(method string, params interface{}) (map[string]interface{}, error) {
 	paramSlice, ok := params.([]interface{})
 	if !ok || len(paramSlice) < 1 {
-		return nil, fmt.Errorf("invalid params for eth_getLogs")
+		return nil, newInvalidParamsError("invalid params for %s", method)
 	}
 
 	filter, ok := paramSlice[0].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("invalid filter parameters")
+		return nil, newInvalidParamsError("invalid filter parameters")
 	}
 
 	return filter, nil
 }
 
-// extractBlockHash extracts the blockHash parameter from the filter
-func extractBlockHash(filter map[string]interface{}) string {
-	if blockHash, ok := filter["blockHash"].(string); ok {
-		return blockHash
-	}
-	return ""
-}
-
 // extractBlockRange extracts and validates the block range from the filter
-func extractBlockRange(filter map[string]interface{}) (uint64, uint64, error) {
-	fromBlock, err := parseBlockParam(filter["fromBlock"], 0)
+//
+// Parameters:
+// - filter: The filter object, whose fromBlock and toBlock are numbers or block tags
+// - latest: Returns the number of the latest block, which the tags of fromBlock resolve to
+//
+// A missing toBlock, or one tagged latest, safe, finalized or pending, leaves the range
+// open: it ends at the tip when the logs are read, which is also what keeps filters
+// following new blocks.
+func extractBlockRange(filter map[string]interface{}, latest func() (uint64, error)) (uint64, uint64, error) {
+	fromBlock, err := parseBlockParam(filter["fromBlock"], 0, latest)
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid fromBlock: %w", err)
+		return 0, 0, blockParamError("fromBlock", err)
 	}
 
-	toBlock, err := parseBlockParam(filter["toBlock"], ^uint64(0)) // Max uint64 value
+	openEnded := func() (uint64, error) { return ^uint64(0), nil } // Max uint64 value
+	toBlock, err := parseBlockParam(filter["toBlock"], ^uint64(0), openEnded)
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid toBlock: %w", err)
+		return 0, 0, blockParamError("toBlock", err)
 	}
 
 	return fromBlock, toBlock, nil
 }
 
+// blockParamError reports an invalid block parameter as invalid params, and a failure
+// to read the latest block for its tag as is
+func blockParamError(name string, err error) error {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return err
+	}
+	return newInvalidParamsError("invalid %s: %w", name, err)
+}
+
 // parseBlockParam parses a block parameter which can be a number or block tag
-func parseBlockParam(blockParam interface{}, defaultValue uint64) (uint64, error) {
+//
+// earliest is block 0; latest, safe, finalized and pending resolve to the number
+// returned by latest, as every block of the log is final.
+func parseBlockParam(blockParam interface{}, defaultValue uint64, latest func() (uint64, error)) (uint64, error) {
 	if blockParam == nil {
 		return defaultValue, nil
 	}
 
 	switch v := blockParam.(type) {
 	case string:
-		if v == BlockTagLatest || v == BlockTagSafe || v == BlockTagFinalized {
-			return defaultValue, nil
+		switch v {
+		case BlockTagEarliest:
+			return 0, nil
+		case BlockTagLatest, BlockTagSafe, BlockTagFinalized, BlockTagPending:
+			return latest()
 		}
 		return strconv.ParseUint(v, 0, 64)
 	case float64:
@@ -296,59 +351,195 @@ func parseBlockParam(blockParam interface{}, defaultValue uint64) (uint64, error
 // getLogsByFilter retrieves logs matching the specified filter criteria
 //
 // Parameters:
-// - fromBlock: Start of block range
-// - toBlock: End of block range
-// - address: Optional address to filter logs
-// - filterBlockHash: Optional specific block hash to get logs from
-func (r *evmRouter) getLogsByFilter(fromBlock, toBlock uint64, address, filterBlockhash string) ([]Log, error) {
-	var logs []Log
-	// TODO: Just for PoC
-	batchSize := uint64(10)
-
+// - criteria: Block range, optional addresses, topics and block hash to get logs from
+func (r *evmRouter) getLogsByFilter(criteria logFilter) ([]Log, error) {
 	latestBlock, err := r.getLatestBlockNumber()
+	if isNotFoundError(err) {
+		// An empty log has no logs
+		return []Log{}, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get latest block number: %w", err)
 	}
+
+	toBlock := criteria.ToBlock
 	if toBlock > latestBlock {
 		toBlock = latestBlock
 	}
 
-	if fromBlock > toBlock {
-		return nil, fmt.Errorf("fromBlock (%d) is greater than toBlock (%d)", fromBlock, toBlock)
+	if criteria.FromBlock > toBlock {
+		return nil, newInvalidParamsError("fromBlock (%d) is greater than toBlock (%d)", criteria.FromBlock, toBlock)
 	}
 
-	for start := fromBlock; start <= toBlock; start += batchSize {
-		end := start + batchSize - 1
-		if end > toBlock {
-			end = toBlock
+	if criteria.BlockHash != "" {
+		number, found, err := r.findBlockByHash(criteria.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return []Log{}, nil
 		}
+		return r.getLogsInRange(criteria, number, number)
+	}
 
-		blocksArgs := icpLogger.GetBlocksArgs{
-			Start:  idl.NewNatFromString(fmt.Sprintf("%d", start)),
-			Length: idl.NewNatFromString(fmt.Sprintf("%d", end-start+1)),
+	return r.getLogsInRange(criteria, criteria.FromBlock, toBlock)
+}
+
+// getLogsInRange returns the logs matching the filter addresses, topics and block hash
+// within the inclusive range [fromBlock, toBlock], ignoring the filter own block range
+//
+// Ingested blocks are looked up in the block store indexes when the filter has an
+// address, topic or block hash; the blocks not ingested yet are read from the canister.
+func (r *evmRouter) getLogsInRange(criteria logFilter, fromBlock, toBlock uint64) ([]Log, error) {
+	var logs []Log
+	appendLogs := func(block ICRC3Block) error {
+		blockLogs, err := r.mappers.mapLogs(block, criteria)
+		if err != nil {
+			return newInternalError("failed to extract logs from block: %w", err)
 		}
 
-		result, err := r.icpClients.Logger.Icrc3GetBlocks(blocksArgs)
+		logs = append(logs, blockLogs...)
+		return nil
+	}
+
+	if r.store != nil {
+		height, err := r.store.Height()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get blocks: %w", err)
+			return nil, newInternalError("failed to read block store height: %w", err)
 		}
 
-		for _, blockInfo := range result.Blocks {
-			blockLogs, err := extractLogsFromBlock(blockInfo.Block, address, filterBlockhash)
+		if fromBlock < height {
+			storedTo := min(toBlock, height-1)
+			blocks, indexed, err := r.indexedLogBlocks(criteria, fromBlock, storedTo)
 			if err != nil {
-				return nil, fmt.Errorf("failed to extract logs from block: %w", err)
+				return nil, newInternalError("failed to read block store indexes: %w", err)
 			}
 
-			logs = append(logs, blockLogs...)
+			if indexed {
+				for _, block := range blocks {
+					if err := appendLogs(block); err != nil {
+						return nil, err
+					}
+				}
+				if storedTo == toBlock {
+					return logs, nil
+				}
+				fromBlock = storedTo + 1
+			}
+		}
+	}
+
+	if err := r.forEachBlock(fromBlock, toBlock, appendLogs); err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// forEachBlock fetches the blocks in the inclusive range [fromBlock, toBlock] from the
+// logger canister in batches and calls fn for each of them, in order
+//
+// Iteration stops early when the canister returns a short batch that reaches the end
+// of the log, or when fn returns an error. Blocks missing from a batch that ends within
+// the log are skipped.
+func (r *evmRouter) forEachBlock(fromBlock, toBlock uint64, fn func(block ICRC3Block) error) error {
+	// TODO: Just for PoC
+	batchSize := uint64(10)
+
+	for start := fromBlock; start <= toBlock; start += batchSize {
+		end := start + batchSize - 1
+		if end > toBlock {
+			end = toBlock
+		}
+
+		result, err := r.getBlocks(start, end-start+1)
+		if err != nil {
+			return newCanisterError(err, "failed to get blocks")
+		}
+
+		for _, block := range result.Blocks {
+			if err := fn(block); err != nil {
+				return err
+			}
 		}
 
-		if uint64(len(result.Blocks)) < batchSize {
+		if uint64(len(result.Blocks)) < batchSize && end+1 >= result.LogLength {
 			break
 		}
 	}
 
-	return logs, nil
+	return nil
+}
+
+// blocksPage is the part of an icrc3_get_blocks response used by the router
+type blocksPage struct {
+	LogLength uint64
+	Blocks    []ICRC3Block
+}
+
+// getBlocks returns the blocks of [start, start+length): ingested blocks from the block
+// store, and the rest from getCanisterBlocks
+func (r *evmRouter) getBlocks(start, length uint64) (blocksPage, error) {
+	if r.store == nil {
+		return r.getCanisterBlocks(start, length)
+	}
+
+	stored, height, err := r.storedBlocks(start, length)
+	if err != nil {
+		return blocksPage{}, newInternalError("failed to read stored blocks: %w", err)
+	}
+	if uint64(len(stored)) == length {
+		return blocksPage{LogLength: height, Blocks: stored}, nil
+	}
+
+	page, err := r.getCanisterBlocks(start+uint64(len(stored)), length-uint64(len(stored)))
+	if err != nil {
+		return blocksPage{}, err
+	}
+	page.Blocks = append(stored, page.Blocks...)
+
+	return page, nil
 }
+
+// getCanisterBlocks returns the blocks of fetchBlocksPage, checked against the certified
+// chain when block verification is enabled
+func (r *evmRouter) getCanisterBlocks(start, length uint64) (blocksPage, error) {
+	page, err := r.fetchBlocksPage(start, length)
+	if err != nil {
+		return blocksPage{}, err
+	}
+
+	if r.verifier != nil {
+		if err := r.verifier.verify(page); err != nil {
+			return blocksPage{}, err
+		}
+	}
+
+	r.hashIndex.add(page.Blocks)
+
+	return page, nil
+}
+
+// fetchBlocksPage reads blocks from the block source: the ICRC-1 ledger when one is
+// configured, the logger canister otherwise
+func (r *evmRouter) fetchBlocksPage(start, length uint64) (blocksPage, error) {
+	return r.blocks.fetchBlocksPage(start, length)
+}
+
+// fetchBlocks returns the blocks in the inclusive range [fromBlock, toBlock]
+func (r *evmRouter) fetchBlocks(fromBlock, toBlock uint64) ([]ICRC3Block, error) {
+	var blocks []ICRC3Block
+	err := r.forEachBlock(fromBlock, toBlock, func(block ICRC3Block) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
 func (r *evmRouter) getLatestBlockNumber() (uint64, error) {
 	latestBlockHex, err := r.EthBlockNumber(JSONRPCRequest{})
 	if err != nil {
@@ -368,115 +559,60 @@ func (r *evmRouter) getLatestBlockNumber() (uint64, error) {
 	return latestBlock, nil
 }
 
+// latestBlockOrGenesis returns the latest block number, or 0 while the log is empty
+func (r *evmRouter) latestBlockOrGenesis() (uint64, error) {
+	latestBlock, err := r.getLatestBlockNumber()
+	if isNotFoundError(err) {
+		return 0, nil
+	}
+	return latestBlock, err
+}
+
 // extractLogsFromBlock extracts EVM-compatible logs from an ICRC-3 block
 //
 // Parameters:
 // - block: The ICRC-3 block to extract logs from
-// - address: Optional address filter
-// - filterBlockHash: Optional block hash filter
-func extractLogsFromBlock(block icpLogger.Value, address, filterBlockHash string) ([]Log, error) {
-	var logs []Log
-
-	blockMap := block.Map
-	if blockMap == nil {
-		return nil, fmt.Errorf("invalid block format: Map is nil")
+// - operations: Mappers of the entry operations with a specific log encoding; any other
+// entry is emitted by its caller with the JSON-encoded entry as data
+// - principals: Records the addresses of long callers, may be nil
+func extractLogsFromBlock(block ICRC3Block, operations map[string]operationMapper, principals *principalBook) ([]Log, error) {
+	entries, err := blockEntries(block.Value)
+	if err != nil {
+		return nil, err
 	}
 
-	var id *idl.Nat
-	var entries []icpLogger.Value
-	for _, entry := range *blockMap {
-		switch entry.Field0 {
-		case "id":
-			if nat := entry.Field1.Nat; nat != nil {
-				id = nat
-			}
-		case "entries":
-			if entriesArray := entry.Field1.Array; entriesArray != nil {
-				entries = *entriesArray
-			}
-		}
+	blockHash, err := icrc3BlockHash(block.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block hash: %w", err)
 	}
 
-	for i, entryValue := range entries {
-		entryMap := entryValue.Map
-		if entryMap == nil {
-			continue
-		}
-
-		var logEntry struct {
-			Timestamp uint64
-			Operation string
-			Details   icpLogger.Value
-			Caller    string
-		}
-
-		for _, field := range *entryMap {
-			switch field.Field0 {
-			case "timestamp":
-				if nat := field.Field1.Nat; nat != nil {
-					logEntry.Timestamp = nat.BigInt().Uint64()
-				}
-			case "operation":
-				if text := field.Field1.Text; text != nil {
-					logEntry.Operation = *text
-				}
-			case "details":
-				logEntry.Details = field.Field1
-			case "caller":
-				if text := field.Field1.Text; text != nil {
-					logEntry.Caller = *text
-				}
+	logs := make([]Log, 0, len(entries))
+	for i, entry := range entries {
+		var log Log
+		mapped := false
+		if mapper, ok := operations[entry.Operation]; ok {
+			log, mapped, err = mapper(entry)
+			if err != nil {
+				return nil, err
 			}
 		}
 
-		ethAddress, err := convertICPToEthAddress(logEntry.Caller) //nolint
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert ICP address to ETH address: %w", err)
-		}
-
-		if address != "" && ethAddress != address {
-			continue
-		}
-
-		blockHash, err := getBlockHash(block)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block hash: %w", err)
-		}
-
-		blockNumber := "0x0"
-		if id != nil {
-			if bigInt := id.BigInt(); bigInt != nil {
-				blockNumber = fmt.Sprintf("0x%x", bigInt.Uint64())
+		if !mapped {
+			log, err = genericEntryLog(entry, principals)
+			if err != nil {
+				return nil, err
 			}
 		}
 
-		logData := LogData{
-			Operation: logEntry.Operation,
-			Detail: LogDataDetails{
-				Map: extractDetailMap(logEntry.Details),
-			},
-		}
-
-		logDataJSON, err := json.Marshal(logData)
+		log.BlockNumber = fmt.Sprintf("0x%x", block.ID)
+		log.BlockHash = blockHash
+		log.TxHash, err = transactionHash(blockHash, i, entry.Value)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal log data: %w", err)
-		}
-
-		log := Log{
-			Address:     ethAddress,
-			Topics:      []string{"0x0000000000000000000000000000000000000000000000000000000000000000"},
-			Data:        fmt.Sprintf("0x%x", logDataJSON),
-			BlockNumber: blockNumber,
-			BlockHash:   blockHash,
-			TxHash:      calculateTransactionHash(block),
-			TxIndex:     fmt.Sprintf("0x%x", i),
-			LogIndex:    fmt.Sprintf("0x%x", i),
-			Removed:     false,
-		}
-
-		if filterBlockHash != "" && blockHash != filterBlockHash {
-			continue
+			return nil, err
 		}
+		log.TxIndex = fmt.Sprintf("0x%x", i)
+		log.LogIndex = fmt.Sprintf("0x%x", i)
+		log.Removed = false
 
 		logs = append(logs, log)
 	}
@@ -510,35 +646,3 @@ func extractDetailMap(details icpLogger.Value) []LogDataField {
 
 	return fields
 }
-
-// extractAddress extracts the address filter from the filter parameters
-func extractAddress(filter map[string]interface{}) (string, error) {
-	if addr, ok := filter["address"]; ok {
-		if addrStr, ok := addr.(string); ok {
-			return addrStr, nil
-		}
-		return "", fmt.Errorf("invalid address format")
-	}
-	return "", nil // No address filter
-}
-
-// Only for POC purposes
-func convertICPToEthAddress(icpAddress string) (string, error) {
-	icpAddress = strings.TrimSuffix(icpAddress, "-fae")
-
-	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(icpAddress))
-	if err != nil {
-		return "", fmt.Errorf("failed to decode ICP address: %w", err)
-	}
-
-	if len(decoded) < 20 {
-		padding := make([]byte, 20-len(decoded))
-		decoded = append(padding, decoded...) //nolint
-	} else if len(decoded) > 20 {
-		decoded = decoded[len(decoded)-20:]
-	}
-
-	ethAddress := "0x" + hex.EncodeToString(decoded)
-
-	return ethAddress, nil
-}
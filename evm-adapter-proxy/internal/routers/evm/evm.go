@@ -1,25 +1,174 @@
 package evm
 
 import (
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/zondax/golem/pkg/metrics"
+	"github.com/zondax/golem/pkg/runner"
 	"github.com/zondax/golem/pkg/zrouter"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
 	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+)
+
+const (
+	defaultMaxBatchSize     = 100
+	defaultBatchConcurrency = 8
 )
 
 // NewEVMRouter creates and initializes a new EVM-compatible router
 //
 // Parameters:
 //   - zr: The base router to add EVM routes to
-//   - icpClients: The ICP clients (Logger and DEX) to use for operations
+//   - icpClients: The ICP clients of the source: a Logger or Ledger client to read blocks
+//     from, and an optional DEX client for the DEX methods and tokens
+//   - cfg: Router settings such as the source name and chain ID, batch limits, WebSocket
+//     subscription limits, filter timeout,
+//     DEX token addresses and decimals, the native currency, the eth_call contracts, the
+//     DEX contract and operators of eth_sendRawTransaction, block verification, the block
+//     store, the block and tip caches and the header fork
+//   - metricsServer: Server the router metrics are registered with
+//   - tr: Runner the block ingester is added to when a block store is configured
+//
+// Returns:
+//   - error: Any error opening the block store or parsing the eth_call contracts
 //
 // The router will:
-//  1. Initialize an evmRouter instance with the provided clients
-//  2. Set up all supported RPC method handlers
-//  3. Add the main RPC endpoint (/rpc/v1)
-func NewEVMRouter(zr zrouter.ZRouter, icpClients *icp.Clients) {
+//  1. Open the block store, if configured
+//  2. Initialize an evmRouter instance reading from the provided clients, with all
+//     supported RPC method handlers, with newEVMRouter
+//  3. Start the ingester of the block store
+//  4. Add the main RPC endpoint (/rpc/v1, or /rpc/v1/{source} for a named source)
+//  5. Add the WebSocket endpoint (/ws/v1 or /ws/v1/{source}), which also serves eth_subscribe
+//
+// Every source gets its own router, with its own caches, filters, subscriptions and
+// block store, so NewEVMRouter is called once per source on the same base router.
+func NewEVMRouter(zr zrouter.ZRouter, icpClients *icp.Clients, cfg conf.RouterConfig, metricsServer metrics.TaskMetrics, tr *runner.TaskRunner) error {
+	var blockStore *store.Store
+	if cfg.StorePath != "" {
+		var err error
+		if blockStore, err = store.Open(cfg.StorePath); err != nil {
+			return err
+		}
+	}
+
+	// Checked by conf.Validate, empty falls back to the default
+	certificateMaxAge, _ := time.ParseDuration(cfg.CertificateMaxAge)
+	r, err := newEVMRouter(newRouterSources(icpClients, certificateMaxAge), cfg, blockStore, metricsServer)
+	if err != nil {
+		return err
+	}
+	if blockStore != nil {
+		ingester := newBlockIngester(blockStore, r.getCanisterBlocks, r.indexBlock, cfg.IngestBatchSize)
+		ingester.release = r.release
+		tr.AddTask(ingester)
+	}
+
+	rpcPath, wsPath := sourcePaths(cfg.Source)
+	zr.POST(rpcPath, r.HandleRPCRequest)
+	zr.GET(wsPath, zrouter.ToHandlerFunc(http.HandlerFunc(r.ServeWebSocket)))
+
+	return nil
+}
+
+// newEVMRouter creates a router reading from and calling the given sources, with every
+// RPC method handler set up
+//
+// Parameters:
+//   - sources: The canisters of the source, or fakes of them
+//   - cfg: Router settings, as for NewEVMRouter; StorePath is not used
+//   - blockStore: The opened block store, or nil; its ingester is not started
+//   - metricsServer: Server the router metrics are registered with, may be nil
+//
+// Returns:
+//   - *evmRouter: The router
+//   - error: Any error parsing the eth_call contracts
+func newEVMRouter(sources routerSources, cfg conf.RouterConfig, blockStore *store.Store, metricsServer metrics.TaskMetrics) (*evmRouter, error) {
 	r := &evmRouter{
-		icpClients: icpClients,
+		blocks:             sources.blocks,
+		tips:               sources.tips,
+		chain:              sources.chain,
+		dex:                sources.dex,
+		ledger:             sources.ledger,
+		configuredChainID:  cfg.ChainID,
+		maxBatchSize:       cfg.MaxBatchSize,
+		batchConcurrency:   cfg.BatchConcurrency,
+		wsMaxSubscriptions: cfg.WSMaxSubscriptions,
+		wsSendQueueSize:    cfg.WSSendQueueSize,
+		nativeCurrency:     cfg.NativeCurrency,
+		dexContract:        dexContractAddress(cfg.DexContractAddress),
+		dexOperators:       make(map[common.Address]bool, len(cfg.DexOperators)),
+		unsignedDexMethods: cfg.UnsignedDexMethods,
+		store:              blockStore,
+		gate:               &requestGate{},
 	}
+	for _, operator := range cfg.DexOperators {
+		r.dexOperators[common.HexToAddress(operator)] = true
+	}
+	if r.maxBatchSize <= 0 {
+		r.maxBatchSize = defaultMaxBatchSize
+	}
+	if r.batchConcurrency <= 0 {
+		r.batchConcurrency = defaultBatchConcurrency
+	}
+	if r.wsMaxSubscriptions <= 0 {
+		r.wsMaxSubscriptions = defaultWSMaxSubscriptions
+	}
+	if r.wsSendQueueSize <= 0 {
+		r.wsSendQueueSize = defaultWSSendQueueSize
+	}
+
+	// Durations, token addresses and decimals are checked by conf.Validate, empty values fall back to the defaults
+	r.tokens, _ = newTokenRegistry(cfg.TokenAddresses, cfg.TokenDecimals)
+	r.principals = newPrincipalBook(r.store)
+	r.mappers = newDefaultMapperRegistry(r.tokens, r.principals)
+	r.mappers.SetHeaderFork(HeaderFork(cfg.HeaderFork))
+	if r.ledger != nil {
+		registerLedgerMappers(r.mappers, r.ledgerTokenAddress, r.ledgerBlock, r.principals)
+	}
+	calls, err := newCallDispatcher(cfg.CallContracts, sources.callCanister, sources.query, r.principals)
+	if err != nil {
+		return nil, err
+	}
+	r.calls = calls
+	blockCacheTTL, _ := time.ParseDuration(cfg.BlockCacheTTL)
+	tipCacheTTL, _ := time.ParseDuration(cfg.TipCacheTTL)
+	r.cache = newLogCache(sources.blocks, sources.tips, cfg.BlockCacheSize, blockCacheTTL, tipCacheTTL, cfg.Source, metricsServer)
+	r.blocks, r.tips = r.cache, r.cache
+	if cfg.VerifyBlocks {
+		// A cached tip may be older than the blocks being verified
+		r.verifier = newBlockVerifier(r.fetchBlocksPage, r.cache.fetchFreshTip, cfg.Source, metricsServer)
+	}
+	r.hashIndex = newBlockHashIndex(r.store)
+	r.submissions = newSubmissionRegistry(r.store)
+	r.blockHashScanDepth = cfg.BlockHashScanDepth
+	if r.blockHashScanDepth <= 0 {
+		r.blockHashScanDepth = defaultBlockHashScanDepth
+	}
+	pollInterval, _ := time.ParseDuration(cfg.WSPollInterval)
+	r.subscriptions = newSubscriptionHub(r, pollInterval)
+	filterTimeout, _ := time.ParseDuration(cfg.FilterTimeout)
+	r.filters = newFilterManager(filterTimeout, cfg.MaxFilters)
 	r.initMethodHandlers()
 
-	zr.POST("/rpc/v1", r.HandleRPCRequest)
+	return r, nil
+}
+
+// release stops the router from reading the block store, once the requests and
+// subscription polls in flight are done, before the ingester closes it
+func (r *evmRouter) release() {
+	r.gate.close()
+	r.principals.detach()
+}
+
+// sourcePaths returns the JSON-RPC and WebSocket routes of a source; the unnamed
+// source is served at the routes of a single source deployment
+func sourcePaths(source string) (string, string) {
+	if source == "" {
+		return "/rpc/v1", "/ws/v1"
+	}
+
+	return "/rpc/v1/" + source, "/ws/v1/" + source
 }
@@ -0,0 +1,46 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourcePaths(t *testing.T) {
+	rpcPath, wsPath := sourcePaths("")
+	assert.Equal(t, "/rpc/v1", rpcPath)
+	assert.Equal(t, "/ws/v1", wsPath)
+
+	rpcPath, wsPath = sourcePaths("ckbtc")
+	assert.Equal(t, "/rpc/v1/ckbtc", rpcPath)
+	assert.Equal(t, "/ws/v1/ckbtc", wsPath)
+}
+
+func TestSourceWithoutDex(t *testing.T) {
+	// A ledger source: its chain ID is configured and it has no DEX canister
+	router := &evmRouter{configuredChainID: 31337, unsignedDexMethods: true}
+	router.initMethodHandlers()
+
+	chainID, err := router.EthChainID(JSONRPCRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "0x7a69", chainID)
+
+	netVersion, err := router.EthNetVersion(JSONRPCRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "31337", *netVersion.(*string))
+
+	for _, method := range []string{"eth_sendRawTransaction", "eth_getCurrencyPairs", "eth_mintTokens", "eth_burnTokens"} {
+		assert.NotContains(t, router.methodHandlers, method)
+	}
+	assert.Contains(t, router.methodHandlers, "eth_getTransactionCount")
+
+	currency, found, err := router.tokenCurrency(router.dexContract)
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Empty(t, currency)
+
+	balance, err := router.dexBalance(principalToEthAddress(testSelfAuthenticating), "USD")
+	require.NoError(t, err)
+	assert.Zero(t, balance.Sign())
+}
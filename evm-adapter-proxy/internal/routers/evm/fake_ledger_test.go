@@ -0,0 +1,285 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// testDexCanister is the caller of the entries the fake DEX logs
+const testDexCanister = "7eo5f-eqaaa-aaaam-adqoq-cai"
+
+// fakeLedger is an in-memory ICRC-3 log that implements the block, tip and chain
+// sources of a logger canister and the ICRC-1 metadata of a ledger
+//
+// Blocks are chained by their phash and carry no hash field, so their hash is the
+// representation-independent one, as for a real ledger. The blocks listed in gaps
+// are left out of the pages, as if the canister had lost them, and err is returned
+// by every call when set.
+type fakeLedger struct {
+	mu       sync.Mutex
+	blocks   []icpLogger.Value
+	gaps     map[uint64]bool
+	err      error
+	chainID  string
+	name     string
+	symbol   string
+	decimals uint8
+}
+
+func newFakeLedger() *fakeLedger {
+	return &fakeLedger{gaps: make(map[uint64]bool), chainID: "1337", name: "Test Token", symbol: "TST", decimals: 8}
+}
+
+// appendBlock appends a block of the given type and fields, linked to the previous
+// block, and returns its index
+func (l *fakeLedger) appendBlock(btype string, fields ...testMapEntry) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := uint64(len(l.blocks))
+	block := []testMapEntry{
+		{Field0: "btype", Field1: textValue(btype)},
+		{Field0: "ts", Field1: natValue((id + 1) * 1e9)},
+	}
+	if id > 0 {
+		phash, err := hashValue(l.blocks[id-1])
+		if err != nil {
+			panic(err)
+		}
+		block = append(block, testMapEntry{Field0: "phash", Field1: blobValue(phash)})
+	}
+
+	l.blocks = append(l.blocks, mapValue(append(block, fields...)...))
+	return id
+}
+
+// appendEntries appends a logger canister block holding the given entries
+func (l *fakeLedger) appendEntries(entries ...icpLogger.Value) uint64 {
+	return l.appendBlock(BlockTypeLogEntry, testMapEntry{Field0: "entries", Field1: icpLogger.Value{Array: &entries}})
+}
+
+// testEntry returns a generic logger entry of a caller
+func testEntry(caller string, ts uint64) icpLogger.Value {
+	return mapValue(
+		testMapEntry{Field0: "timestamp", Field1: natValue(ts)},
+		testMapEntry{Field0: "operation", Field1: textValue("test")},
+		testMapEntry{Field0: "caller", Field1: textValue(caller)},
+		testMapEntry{Field0: "details", Field1: mapValue(testMapEntry{Field0: "key", Field1: textValue("value")})},
+	)
+}
+
+// appendTransfer appends an ICRC-1 transfer block; an empty from is a mint and an
+// empty to a burn
+func (l *fakeLedger) appendTransfer(from, to string, amount uint64) uint64 {
+	tx := []testMapEntry{{Field0: "amt", Field1: natValue(amount)}}
+	btype := BlockTypeICRC1Transfer
+	if from == "" {
+		btype = BlockTypeICRC1Mint
+	} else {
+		tx = append(tx, testMapEntry{Field0: "from", Field1: fakeAccount(from)})
+	}
+	if to == "" {
+		btype = BlockTypeICRC1Burn
+	} else {
+		tx = append(tx, testMapEntry{Field0: "to", Field1: fakeAccount(to)})
+	}
+
+	return l.appendBlock(btype, testMapEntry{Field0: "tx", Field1: mapValue(tx...)})
+}
+
+// fakeAccount encodes the default account of a principal the way ledgers store it
+func fakeAccount(owner string) icpLogger.Value {
+	return icpLogger.Value{Array: &[]icpLogger.Value{blobValue(principal.MustDecode(owner).Raw)}}
+}
+
+// hash returns the hash of a block, as served in EVM blocks
+func (l *fakeLedger) hash(id uint64) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hash, err := icrc3BlockHash(l.blocks[id])
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// fetchBlocksPage implements blockSource
+func (l *fakeLedger) fetchBlocksPage(start, length uint64) (blocksPage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return blocksPage{}, l.err
+	}
+
+	page := blocksPage{LogLength: uint64(len(l.blocks))}
+	for id := start; id < start+length && id < uint64(len(l.blocks)); id++ {
+		if !l.gaps[id] {
+			page.Blocks = append(page.Blocks, ICRC3Block{ID: id, Value: l.blocks[id]})
+		}
+	}
+
+	return page, nil
+}
+
+// fetchCertifiedTip implements tipSource, as an empty log is certified by a real one
+func (l *fakeLedger) fetchCertifiedTip() (certifiedTip, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return certifiedTip{}, newCanisterError(l.err, "failed to get tip certificate")
+	}
+	if len(l.blocks) == 0 {
+		return certifiedTip{}, newNotFoundError("no blocks found")
+	}
+
+	last := uint64(len(l.blocks) - 1)
+	hash, err := hashValue(l.blocks[last])
+	if err != nil {
+		return certifiedTip{}, err
+	}
+
+	return certifiedTip{Index: last, Hash: hash}, nil
+}
+
+// ChainId implements chainSource
+func (l *fakeLedger) ChainId() (*string, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &l.chainID, nil
+}
+
+// NetVersion implements chainSource
+func (l *fakeLedger) NetVersion() (*string, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &l.chainID, nil
+}
+
+// Icrc1Name implements ledgerInfo
+func (l *fakeLedger) Icrc1Name() (*string, error) {
+	return &l.name, l.err
+}
+
+// Icrc1Symbol implements ledgerInfo
+func (l *fakeLedger) Icrc1Symbol() (*string, error) {
+	return &l.symbol, l.err
+}
+
+// Icrc1Decimals implements ledgerInfo
+func (l *fakeLedger) Icrc1Decimals() (*uint8, error) {
+	return &l.decimals, l.err
+}
+
+// fakeDex implements dexOperations over in-memory balances, logging every executed
+// operation to its logger the way the DEX canister does
+type fakeDex struct {
+	mu         sync.Mutex
+	log        *fakeLedger
+	operations uint64
+	pairs      []icpDex.CurrencyPair
+	balances   map[string]map[string]*big.Int
+	// err is returned by mints once executed, as by an update call that timed out
+	err error
+}
+
+func newFakeDex(log *fakeLedger, pairs ...icpDex.CurrencyPair) *fakeDex {
+	return &fakeDex{log: log, pairs: pairs, balances: make(map[string]map[string]*big.Int)}
+}
+
+// logOperation appends the logger entry of a DEX operation
+func (d *fakeDex) logOperation(operation, debug string) {
+	d.operations++
+	d.log.appendEntries(mapValue(
+		testMapEntry{Field0: "timestamp", Field1: natValue(d.operations * 1e9)},
+		testMapEntry{Field0: "operation", Field1: textValue(operation)},
+		testMapEntry{Field0: "caller", Field1: textValue(testDexCanister)},
+		testMapEntry{Field0: "details", Field1: dexDetails(debug)},
+	))
+}
+
+// balance returns the balance of an owner, creating it when needed
+func (d *fakeDex) balance(owner principal.Principal, currency string) *big.Int {
+	balances, ok := d.balances[owner.Encode()]
+	if !ok {
+		balances = make(map[string]*big.Int)
+		d.balances[owner.Encode()] = balances
+	}
+	if balances[currency] == nil {
+		balances[currency] = new(big.Int)
+	}
+	return balances[currency]
+}
+
+// GetCurrencyPairs implements dexOperations
+func (d *fakeDex) GetCurrencyPairs() (*[]icpDex.CurrencyPair, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pairs := append([]icpDex.CurrencyPair{}, d.pairs...)
+	return &pairs, nil
+}
+
+// GetTokenBalance implements dexOperations
+func (d *fakeDex) GetTokenBalance(owner principal.Principal, currency string) (*idl.Nat, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	balance := idl.NewBigNat(new(big.Int).Set(d.balance(owner, currency)))
+	return &balance, nil
+}
+
+// MintTokens implements dexOperations
+func (d *fakeDex) MintTokens(operation icpDex.MintOperation) (*dexResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	balance := d.balance(operation.Recipient, operation.Currency)
+	balance.Add(balance, operation.Amount.BigInt())
+	d.logOperation(OperationMintTokens, fmt.Sprintf(`MintOperation { currency: %q, amount: Nat(%s), recipient: Principal(%s) }`,
+		operation.Currency, operation.Amount.BigInt(), operation.Recipient.Encode()))
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return &dexResult{Ok: new(idl.Null)}, nil
+}
+
+// BurnTokens implements dexOperations; burning more than the balance is rejected
+func (d *fakeDex) BurnTokens(operation icpDex.BurnOperation) (*dexResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	balance := d.balance(operation.Owner, operation.Currency)
+	if balance.Cmp(operation.Amount.BigInt()) < 0 {
+		rejection := "Insufficient balance"
+		return &dexResult{Err: &rejection}, nil
+	}
+	balance.Sub(balance, operation.Amount.BigInt())
+	d.logOperation(OperationBurnTokens, fmt.Sprintf(`BurnOperation { currency: %q, amount: Nat(%s), owner: Principal(%s) }`,
+		operation.Currency, operation.Amount.BigInt(), operation.Owner.Encode()))
+
+	return &dexResult{Ok: new(idl.Null)}, nil
+}
+
+// AddCurrencyPair implements dexOperations
+func (d *fakeDex) AddCurrencyPair(pair icpDex.CurrencyPair) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pairs = append(d.pairs, pair)
+	d.logOperation(OperationAddCurrencyPair, fmt.Sprintf(`CurrencyPair { base_currency: %q, quote_currency: %q }`,
+		pair.BaseCurrency, pair.QuoteCurrency))
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package evm
+
+// EthNewFilter implements the eth_newFilter RPC method
+// Creates a log filter whose changes are the logs of blocks produced after its creation
+//
+// The filter object accepts the same criteria as eth_getLogs.
+func (r *evmRouter) EthNewFilter(request JSONRPCRequest) (interface{}, error) {
+	filter, err := extractFilterFromParams("eth_newFilter", request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	criteria, err := parseLogFilter(filter, r.latestBlockOrGenesis)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.installFilter(logsFilter, criteria)
+}
+
+// EthNewBlockFilter implements the eth_newBlockFilter RPC method
+// Creates a filter whose changes are the hashes of blocks produced after its creation
+func (r *evmRouter) EthNewBlockFilter(_ JSONRPCRequest) (interface{}, error) {
+	return r.installFilter(blocksFilter, logFilter{ToBlock: ^uint64(0)})
+}
+
+// EthGetFilterChanges implements the eth_getFilterChanges RPC method
+// Returns what happened since the previous poll of the filter
+//
+// Returns:
+//   - []Log: For log filters, the new matching logs
+//   - []string: For block filters, the hashes of the new blocks
+//   - error: Any error that occurred, including unknown or expired filters
+func (r *evmRouter) EthGetFilterChanges(request JSONRPCRequest) (interface{}, error) {
+	id, err := extractFilterID("eth_getFilterChanges", request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, ok := r.filters.get(id)
+	if !ok {
+		return nil, newRPCError(ErrCodeInvalidInput, "filter not found")
+	}
+
+	latestBlock, err := r.getLatestBlockNumber()
+	if isNotFoundError(err) {
+		return emptyFilterChanges(filter.kind), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Filters installed on an empty log start at block 0, as lastBlock wraps around
+	fromBlock := filter.lastBlock + 1
+	if fromBlock < filter.criteria.FromBlock {
+		fromBlock = filter.criteria.FromBlock
+	}
+
+	toBlock := latestBlock
+	if toBlock > filter.criteria.ToBlock {
+		toBlock = filter.criteria.ToBlock
+	}
+	if toBlock >= fromBlock && toBlock-fromBlock+1 > maxBlocksPerPoll {
+		toBlock = fromBlock + maxBlocksPerPoll - 1
+	}
+
+	if toBlock < fromBlock || !r.filters.advance(id, filter.lastBlock, toBlock) {
+		return emptyFilterChanges(filter.kind), nil
+	}
+
+	var changes interface{}
+	switch filter.kind {
+	case blocksFilter:
+		changes, err = r.getBlockHashesInRange(fromBlock, toBlock)
+	default:
+		changes, err = r.getLogsInRange(filter.criteria, fromBlock, toBlock)
+	}
+	if err != nil {
+		// Give the range back so the next poll retries it
+		r.filters.advance(id, toBlock, filter.lastBlock)
+		return nil, err
+	}
+
+	if logs, ok := changes.([]Log); ok && logs == nil {
+		return []Log{}, nil
+	}
+
+	return changes, nil
+}
+
+// EthGetFilterLogs implements the eth_getFilterLogs RPC method
+// Returns every log matching a log filter, as eth_getLogs would with the same criteria
+func (r *evmRouter) EthGetFilterLogs(request JSONRPCRequest) (interface{}, error) {
+	id, err := extractFilterID("eth_getFilterLogs", request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, ok := r.filters.get(id)
+	if !ok || filter.kind != logsFilter {
+		return nil, newRPCError(ErrCodeInvalidInput, "filter not found")
+	}
+
+	logs, err := r.getLogsByFilter(filter.criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	if logs == nil {
+		return []Log{}, nil
+	}
+
+	return logs, nil
+}
+
+// EthUninstallFilter implements the eth_uninstallFilter RPC method
+// Returns whether the filter existed and was removed
+func (r *evmRouter) EthUninstallFilter(request JSONRPCRequest) (interface{}, error) {
+	id, err := extractFilterID("eth_uninstallFilter", request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.filters.uninstall(id), nil
+}
+
+// installFilter registers a filter starting at the current tip, or at the first block
+// when the log is still empty
+func (r *evmRouter) installFilter(kind filterKind, criteria logFilter) (interface{}, error) {
+	latestBlock, err := r.getLatestBlockNumber()
+	if isNotFoundError(err) {
+		latestBlock, err = ^uint64(0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.filters.install(kind, criteria, latestBlock)
+}
+
+// getBlockHashesInRange returns the hashes of the blocks in [fromBlock, toBlock]
+func (r *evmRouter) getBlockHashesInRange(fromBlock, toBlock uint64) ([]string, error) {
+	hashes := []string{}
+	err := r.forEachBlock(fromBlock, toBlock, func(block ICRC3Block) error {
+		hash, err := icrc3BlockHash(block.Value)
+		if err != nil {
+			return newInternalError("failed to get block hash: %w", err)
+		}
+
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// extractFilterID extracts the filter ID from the RPC request parameters
+func extractFilterID(method string, params interface{}) (string, error) {
+	paramSlice, ok := params.([]interface{})
+	if !ok || len(paramSlice) < 1 {
+		return "", newInvalidParamsError("invalid params for %s", method)
+	}
+
+	id, ok := paramSlice[0].(string)
+	if !ok {
+		return "", newInvalidParamsError("invalid filter ID")
+	}
+
+	return id, nil
+}
+
+// emptyFilterChanges returns the empty result matching the filter kind
+func emptyFilterChanges(kind filterKind) interface{} {
+	if kind == blocksFilter {
+		return []string{}
+	}
+	return []Log{}
+}
@@ -0,0 +1,147 @@
+package evm
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultFilterTimeout = 5 * time.Minute
+	defaultMaxFilters    = 1000
+)
+
+type filterKind int
+
+const (
+	logsFilter filterKind = iota
+	blocksFilter
+)
+
+// installedFilter is a filter created by eth_newFilter or eth_newBlockFilter
+type installedFilter struct {
+	kind      filterKind
+	criteria  logFilter
+	lastBlock uint64
+	lastPoll  time.Time
+}
+
+// filterManager keeps the state of polling filters
+//
+// Each filter remembers the last block delivered to the client so that
+// eth_getFilterChanges only returns what is new. Filters that are not polled
+// within the timeout are dropped, as go-ethereum does. At most maxFilters are
+// installed at a time, so clients cannot grow the filters without bound.
+type filterManager struct {
+	mu         sync.Mutex
+	filters    map[string]*installedFilter
+	timeout    time.Duration
+	maxFilters int
+	now        func() time.Time
+}
+
+// newFilterManager creates a filter manager; a timeout or maxFilters of 0 or less
+// falls back to the default
+func newFilterManager(timeout time.Duration, maxFilters int) *filterManager {
+	if timeout <= 0 {
+		timeout = defaultFilterTimeout
+	}
+	if maxFilters <= 0 {
+		maxFilters = defaultMaxFilters
+	}
+
+	return &filterManager{
+		filters:    make(map[string]*installedFilter),
+		timeout:    timeout,
+		maxFilters: maxFilters,
+		now:        time.Now,
+	}
+}
+
+// install stores a new filter whose changes start after lastBlock
+//
+// Returns:
+//   - string: The filter ID
+//   - error: A limit exceeded error when maxFilters are installed, or an internal
+//     error creating the ID
+func (m *filterManager) install(kind filterKind, criteria logFilter, lastBlock uint64) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", newInternalError("failed to create filter ID: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if len(m.filters) >= m.maxFilters {
+		return "", newRPCError(ErrCodeLimitExceeded, "filter limit of %d reached", m.maxFilters)
+	}
+	m.filters[id] = &installedFilter{
+		kind:      kind,
+		criteria:  criteria,
+		lastBlock: lastBlock,
+		lastPoll:  m.now(),
+	}
+
+	return id, nil
+}
+
+// get returns a copy of a live filter and refreshes its expiration
+func (m *filterManager) get(id string) (installedFilter, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	filter, ok := m.filters[id]
+	if !ok {
+		return installedFilter{}, false
+	}
+
+	filter.lastPoll = m.now()
+	return *filter, true
+}
+
+// advance moves the delivery cursor of a filter from fromBlock to toBlock
+//
+// Returns:
+//   - bool: False if the filter is gone or another poll already moved the cursor,
+//     in which case the caller must not deliver the range
+func (m *filterManager) advance(id string, fromBlock, toBlock uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filter, ok := m.filters[id]
+	if !ok || filter.lastBlock != fromBlock {
+		return false
+	}
+
+	filter.lastBlock = toBlock
+	return true
+}
+
+// uninstall removes a filter
+//
+// Returns:
+//   - bool: Whether the filter existed
+func (m *filterManager) uninstall(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if _, ok := m.filters[id]; !ok {
+		return false
+	}
+
+	delete(m.filters, id)
+	return true
+}
+
+// expire drops the filters not polled within the timeout; m.mu must be held
+func (m *filterManager) expire() {
+	deadline := m.now().Add(-m.timeout)
+	for id, filter := range m.filters {
+		if filter.lastPoll.Before(deadline) {
+			delete(m.filters, id)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package evm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterManagerLifecycle(t *testing.T) {
+	now := time.Unix(0, 0)
+	manager := newFilterManager(time.Minute, 0)
+	manager.now = func() time.Time { return now }
+
+	id, err := manager.install(logsFilter, logFilter{Addresses: []string{"0x01"}}, 10)
+	require.NoError(t, err)
+
+	filter, ok := manager.get(id)
+	require.True(t, ok)
+	assert.Equal(t, logsFilter, filter.kind)
+	assert.Equal(t, []string{"0x01"}, filter.criteria.Addresses)
+	assert.Equal(t, uint64(10), filter.lastBlock)
+
+	// Only the poll that observed the current cursor may advance it
+	assert.True(t, manager.advance(id, 10, 15))
+	assert.False(t, manager.advance(id, 10, 20))
+
+	filter, ok = manager.get(id)
+	require.True(t, ok)
+	assert.Equal(t, uint64(15), filter.lastBlock)
+
+	assert.True(t, manager.uninstall(id))
+	assert.False(t, manager.uninstall(id))
+	_, ok = manager.get(id)
+	assert.False(t, ok)
+}
+
+func TestFilterManagerExpiration(t *testing.T) {
+	now := time.Unix(0, 0)
+	manager := newFilterManager(time.Minute, 0)
+	manager.now = func() time.Time { return now }
+
+	polled, err := manager.install(blocksFilter, logFilter{}, 0)
+	require.NoError(t, err)
+	idle, err := manager.install(blocksFilter, logFilter{}, 0)
+	require.NoError(t, err)
+
+	now = now.Add(45 * time.Second)
+	_, ok := manager.get(polled)
+	require.True(t, ok)
+
+	now = now.Add(45 * time.Second)
+	_, ok = manager.get(polled)
+	assert.True(t, ok, "a polled filter is kept alive")
+	_, ok = manager.get(idle)
+	assert.False(t, ok, "an idle filter expires after the timeout")
+}
+
+func TestFilterManagerLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	manager := newFilterManager(time.Minute, 2)
+	manager.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := manager.install(blocksFilter, logFilter{}, 0)
+		require.NoError(t, err)
+	}
+	_, err := manager.install(blocksFilter, logFilter{}, 0)
+	requireRPCCode(t, err, ErrCodeLimitExceeded)
+
+	// Expired filters make room for new ones
+	now = now.Add(2 * time.Minute)
+	_, err = manager.install(logsFilter, logFilter{}, 0)
+	require.NoError(t, err)
+}
+
+func TestNewFilterManagerDefaults(t *testing.T) {
+	manager := newFilterManager(0, 0)
+	assert.Equal(t, defaultFilterTimeout, manager.timeout)
+	assert.Equal(t, defaultMaxFilters, manager.maxFilters)
+}
+
+func TestExtractFilterID(t *testing.T) {
+	id, err := extractFilterID("eth_getFilterChanges", []interface{}{"0xabc"})
+	require.NoError(t, err)
+	assert.Equal(t, "0xabc", id)
+
+	_, err = extractFilterID("eth_getFilterChanges", []interface{}{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid params for eth_getFilterChanges")
+
+	_, err = extractFilterID("eth_getFilterChanges", []interface{}{1})
+	assert.Error(t, err)
+}
@@ -1,15 +1,19 @@
 package evm
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/zondax/golem/pkg/logger"
 	"github.com/zondax/golem/pkg/zrouter"
 	"github.com/zondax/golem/pkg/zrouter/domain"
-	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
 )
 
 // Note: This pkg contains implementations for Proof of Concept (POC) purposes.
@@ -19,47 +23,97 @@ type methodHandler func(JSONRPCRequest) (interface{}, error)
 
 type EVMRouter interface {
 	HandleRPCRequest(ctx zrouter.Context) (domain.ServiceResponse, error)
+	ServeWebSocket(w http.ResponseWriter, req *http.Request)
 }
 
 type evmRouter struct {
-	methodHandlers       map[string]methodHandler
-	icpClients           *icp.Clients
-	arrayResponseMethods map[string]bool
+	methodHandlers     map[string]methodHandler
+	blocks             blockSource
+	tips               tipSource
+	chain              chainSource
+	dex                dexOperations
+	ledger             ledgerInfo
+	configuredChainID  uint64
+	maxBatchSize       int
+	batchConcurrency   int
+	subscriptions      *subscriptionHub
+	filters            *filterManager
+	mappers            *MapperRegistry
+	tokens             *tokenRegistry
+	principals         *principalBook
+	nativeCurrency     string
+	calls              *callDispatcher
+	dexContract        common.Address
+	dexOperators       map[common.Address]bool
+	unsignedDexMethods bool
+	submissions        *submissionRegistry
+	ledgerMetadata     ledgerMetadataCache
+	verifier           *blockVerifier
+	cache              *logCache
+	store              *store.Store
+	hashIndex          *blockHashIndex
+	blockHashScanDepth int
+	wsMaxSubscriptions int
+	wsSendQueueSize    int
+	// gate drains the requests reading the store before the ingester closes it
+	gate *requestGate
 }
 
 func (r *evmRouter) initMethodHandlers() {
 	r.methodHandlers = map[string]methodHandler{
 		// Standard Ethereum JSON-RPC methods
-		"eth_chainId":          r.EthChainID,
-		"net_version":          r.EthNetVersion,
-		"eth_getBlockByNumber": r.EthGetBlockByNumber,
-		"eth_getBlockByHash":   r.EthGetBlockByHash,
-		"eth_getLogs":          r.EthGetLogs,
-		"eth_blockNumber":      r.EthBlockNumber,
-		"eth_accounts":         r.EthAccounts,
-		"web3_clientVersion":   r.Web3ClientVersion,
-		"web3_sha3":            r.Web3Sha3,
-		"net_listening":        r.NetListening,
-		"net_peerCount":        r.NetPeerCount,
-
-		// Custom DEX methods
-		"eth_getCurrencyPairs": r.GetCurrencyPairs,
-		"eth_mintTokens":       r.MintTokens,
-		"eth_burnTokens":       r.BurnTokens,
-	}
-
-	r.arrayResponseMethods = map[string]bool{
-		"net_version": true,
+		"eth_chainId":               r.EthChainID,
+		"net_version":               r.EthNetVersion,
+		"eth_getBlockByNumber":      r.EthGetBlockByNumber,
+		"eth_getBlockByHash":        r.EthGetBlockByHash,
+		"eth_getTransactionByHash":  r.EthGetTransactionByHash,
+		"eth_getTransactionReceipt": r.EthGetTransactionReceipt,
+		"eth_getBlockReceipts":      r.EthGetBlockReceipts,
+		"eth_getLogs":               r.EthGetLogs,
+		"eth_getBalance":            r.EthGetBalance,
+		"eth_call":                  r.EthCall,
+		"eth_getTransactionCount":   r.EthGetTransactionCount,
+		"eth_gasPrice":              r.EthGasPrice,
+		"eth_maxPriorityFeePerGas":  r.EthMaxPriorityFeePerGas,
+		"eth_estimateGas":           r.EthEstimateGas,
+		"eth_newFilter":             r.EthNewFilter,
+		"eth_newBlockFilter":        r.EthNewBlockFilter,
+		"eth_getFilterChanges":      r.EthGetFilterChanges,
+		"eth_getFilterLogs":         r.EthGetFilterLogs,
+		"eth_uninstallFilter":       r.EthUninstallFilter,
+		"eth_blockNumber":           r.EthBlockNumber,
+		"eth_accounts":              r.EthAccounts,
+		"web3_clientVersion":        r.Web3ClientVersion,
+		"web3_sha3":                 r.Web3Sha3,
+		"net_listening":             r.NetListening,
+		"net_peerCount":             r.NetPeerCount,
+
+		// Custom ICRC-1 ledger methods
+		"eth_getLedgerMetadata": r.GetLedgerMetadata,
+	}
+
+	// Sources without a DEX canister have no DEX methods, and no transactions to send
+	if r.dex != nil {
+		r.methodHandlers["eth_sendRawTransaction"] = r.EthSendRawTransaction
+		r.methodHandlers["eth_getCurrencyPairs"] = r.GetCurrencyPairs
+
+		// The unsigned DEX methods let anyone reaching the proxy mint and burn
+		if r.unsignedDexMethods {
+			r.methodHandlers["eth_mintTokens"] = r.MintTokens
+			r.methodHandlers["eth_burnTokens"] = r.BurnTokens
+		}
 	}
 }
 
 // HandleRPCRequest processes incoming JSON-RPC requests and returns appropriate responses
 //
 // The function:
-// 1. Reads and parses the request body
-// 2. Validates the request format
-// 3. Routes to appropriate handler
-// 4. Formats and returns the response
+// 1. Reads the request body
+// 2. Hands it to processMessage for parsing, validation and routing
+// 3. Formats and returns the response
+//
+// Protocol level failures (malformed JSON, invalid requests, unknown methods) and
+// handler failures are all reported as JSON-RPC error objects with an HTTP 200 status.
 //
 // Returns:
 //   - domain.ServiceResponse: The formatted response
@@ -70,47 +124,188 @@ func (r *evmRouter) HandleRPCRequest(ctx zrouter.Context) (domain.ServiceRespons
 		return nil, fmt.Errorf("failed to read request body: %w", err)
 	}
 
+	headers := http.Header{}
+	headers.Set("Content-Type", "text/plain; charset=utf-8")
+
+	payload := r.processMessage(ctx.Context(), r.methodHandlers, body)
+	if payload == nil {
+		// Notifications, and batches made only of notifications, get no response at all
+		return domain.NewServiceResponseWithHeader(http.StatusNoContent, nil, headers), nil
+	}
+
+	return domain.NewServiceResponseWithHeader(http.StatusOK, payload, headers), nil
+}
+
+// processMessage parses a raw JSON-RPC message (single request or batch) and runs it
+// against the given handlers. It is shared by the HTTP and WebSocket transports.
+// Messages arriving once the router is shutting down are refused.
+//
+// Returns:
+//   - interface{}: A JSONRPCResponse, a []JSONRPCResponse for batches, or nil when
+//     nothing must be sent back
+func (r *evmRouter) processMessage(ctx context.Context, handlers map[string]methodHandler, body []byte) interface{} {
+	if !r.gate.enter() {
+		return newErrorResponse(nil, ErrCodeResourceUnavailable, "the proxy is shutting down")
+	}
+	defer r.gate.leave()
+
+	if isBatch(body) {
+		responses := r.handleBatch(ctx, handlers, body)
+		if len(responses) == 0 {
+			return nil
+		}
+		return responses
+	}
+
+	if !json.Valid(body) {
+		return newErrorResponse(nil, ErrCodeParseError, "parse error: invalid JSON")
+	}
+
 	var request JSONRPCRequest
-	err = json.Unmarshal(body, &request)
-	if err != nil {
-		var requests []JSONRPCRequest
-		err = json.Unmarshal(body, &requests)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode request: %w", err)
+	if err := json.Unmarshal(body, &request); err != nil {
+		return newErrorResponse(nil, ErrCodeInvalidRequest, "invalid request")
+	}
+
+	if errResponse, ok := validateRequest(handlers, request); !ok {
+		if errResponse == nil {
+			return nil
+		}
+		return *errResponse
+	}
+
+	response := dispatch(ctx, handlers, request)
+	if request.IsNotification() {
+		return nil
+	}
+
+	return response
+}
+
+// handleBatch processes a JSON-RPC 2.0 batch request
+//
+// Every element of the batch is validated and dispatched independently, using at most
+// batchConcurrency goroutines at a time. Responses keep the order of the requests that
+// produced them, and notifications (requests without an ID) produce no response.
+//
+// Returns:
+//   - []JSONRPCResponse: One response per non-notification element, or a single error
+//     response if the batch itself is malformed, empty or too large
+func (r *evmRouter) handleBatch(ctx context.Context, handlers map[string]methodHandler, body []byte) []JSONRPCResponse {
+	var rawRequests []json.RawMessage
+	if err := json.Unmarshal(body, &rawRequests); err != nil {
+		return []JSONRPCResponse{newErrorResponse(nil, ErrCodeParseError, fmt.Sprintf("parse error: %v", err))}
+	}
+
+	if len(rawRequests) == 0 {
+		return []JSONRPCResponse{newErrorResponse(nil, ErrCodeInvalidRequest, "invalid request: empty batch")}
+	}
+
+	if len(rawRequests) > r.maxBatchSize {
+		msg := fmt.Sprintf("batch of %d requests exceeds limit of %d", len(rawRequests), r.maxBatchSize)
+		return []JSONRPCResponse{newErrorResponse(nil, ErrCodeLimitExceeded, msg)}
+	}
+
+	responses := make([]*JSONRPCResponse, len(rawRequests))
+	concurrency := r.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, raw := range rawRequests {
+		var request JSONRPCRequest
+		if err := json.Unmarshal(raw, &request); err != nil {
+			response := newErrorResponse(nil, ErrCodeInvalidRequest, "invalid request")
+			responses[i] = &response
+			continue
 		}
-		if len(requests) == 0 {
-			return nil, fmt.Errorf("empty request array")
+
+		if errResponse, ok := validateRequest(handlers, request); !ok {
+			responses[i] = errResponse
+			continue
 		}
-		request = requests[0]
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, request JSONRPCRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			response := dispatch(ctx, handlers, request)
+			if !request.IsNotification() {
+				responses[i] = &response
+			}
+		}(i, request)
 	}
+	wg.Wait()
 
-	handler, ok := r.methodHandlers[request.Method]
-	if !ok {
-		return nil, fmt.Errorf("unsupported method: %s", request.Method)
+	result := make([]JSONRPCResponse, 0, len(responses))
+	for _, response := range responses {
+		if response != nil {
+			result = append(result, *response)
+		}
 	}
 
+	return result
+}
+
+// dispatch runs a request through its registered method handler and wraps the outcome
+// into a JSON-RPC response. The method must have been checked to exist beforehand.
+func dispatch(ctx context.Context, handlers map[string]methodHandler, request JSONRPCRequest) JSONRPCResponse {
 	response := JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
 	}
 
-	headers := http.Header{}
-	headers.Set("Content-Type", "text/plain; charset=utf-8")
-
-	result, err := handler(request)
+	result, err := handlers[request.Method](request)
 	if err != nil {
-		logger.GetLoggerFromContext(ctx.Context()).Errorf("error with method %s and details %v", request.Method, err)
-		response.Error = &JSONRPCError{
-			Code:    1,
-			Message: err.Error(),
-		}
-		return domain.NewServiceResponseWithHeader(http.StatusOK, response, headers), nil
+		logger.GetLoggerFromContext(ctx).Errorf("error with method %s and details %v", request.Method, err)
+		response.Error = toJSONRPCError(err)
+		return response
 	}
 
 	response.Result = result
-	if r.arrayResponseMethods[request.Method] {
-		return domain.NewServiceResponseWithHeader(http.StatusOK, []JSONRPCResponse{response}, headers), nil
+	return response
+}
+
+// validateRequest checks a decoded request before dispatching it
+//
+// Returns:
+//   - *JSONRPCResponse: The error response to send back, nil for notifications of unknown methods
+//   - bool: Whether the request can be dispatched
+func validateRequest(handlers map[string]methodHandler, request JSONRPCRequest) (*JSONRPCResponse, bool) {
+	if request.Method == "" {
+		// An invalid request is answered even without an ID, as it cannot be told apart from a notification
+		response := newErrorResponse(request.ID, ErrCodeInvalidRequest, "invalid request: missing method")
+		return &response, false
+	}
+
+	if _, ok := handlers[request.Method]; !ok {
+		if request.IsNotification() {
+			return nil, false
+		}
+		response := newErrorResponse(request.ID, ErrCodeMethodNotFound, fmt.Sprintf("the method %s does not exist/is not available", request.Method))
+		return &response, false
 	}
 
-	return domain.NewServiceResponseWithHeader(http.StatusOK, response, headers), nil
+	return nil, true
+}
+
+// isBatch reports whether the body holds a JSON array, i.e. a JSON-RPC batch
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// newErrorResponse builds a JSON-RPC error response for the given request ID
+func newErrorResponse(id json.RawMessage, code int, message string) JSONRPCResponse {
+	return JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
 }
@@ -0,0 +1,231 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/golem/pkg/zrouter"
+)
+
+func newTestRouter(handlers map[string]methodHandler) *evmRouter {
+	return &evmRouter{
+		methodHandlers:   handlers,
+		maxBatchSize:     defaultMaxBatchSize,
+		batchConcurrency: defaultBatchConcurrency,
+	}
+}
+
+func newTestContext(body string) *zrouter.MockContext {
+	ctx := &zrouter.MockContext{}
+	ctx.On("Request").Return(httptest.NewRequest(http.MethodPost, "/rpc/v1", strings.NewReader(body)))
+	ctx.On("Context").Return(context.Background())
+	return ctx
+}
+
+func echoHandler(request JSONRPCRequest) (interface{}, error) {
+	return request.Method, nil
+}
+
+func TestHandleRPCRequestBatch(t *testing.T) {
+	router := newTestRouter(map[string]methodHandler{
+		"test_echo": echoHandler,
+		"test_fail": func(_ JSONRPCRequest) (interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		},
+	})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "Every element gets its own response",
+			body:       `[{"jsonrpc":"2.0","method":"test_echo","id":1},{"jsonrpc":"2.0","method":"test_fail","id":"two"}]`,
+			wantStatus: http.StatusOK,
+			want:       `[{"jsonrpc":"2.0","result":"test_echo","id":1},{"jsonrpc":"2.0","error":{"code":-32603,"message":"boom"},"id":"two"}]`,
+		},
+		{
+			name:       "Notifications get no response",
+			body:       `[{"jsonrpc":"2.0","method":"test_echo"},{"jsonrpc":"2.0","method":"test_echo","id":3}]`,
+			wantStatus: http.StatusOK,
+			want:       `[{"jsonrpc":"2.0","result":"test_echo","id":3}]`,
+		},
+		{
+			name:       "Batch of only notifications",
+			body:       `[{"jsonrpc":"2.0","method":"test_echo"},{"jsonrpc":"2.0","method":"test_fail"}]`,
+			wantStatus: http.StatusNoContent,
+			want:       ``,
+		},
+		{
+			name:       "Mixed valid and invalid entries",
+			body:       `[1,{"jsonrpc":"2.0","method":"test_echo","id":1},{"jsonrpc":"2.0","id":2},{"jsonrpc":"2.0","method":"test_unknown","id":3}]`,
+			wantStatus: http.StatusOK,
+			want: `[{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null},` +
+				`{"jsonrpc":"2.0","result":"test_echo","id":1},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: missing method"},"id":2},` +
+				`{"jsonrpc":"2.0","error":{"code":-32601,"message":"the method test_unknown does not exist/is not available"},"id":3}]`,
+		},
+		{
+			name:       "Empty batch",
+			body:       `[]`,
+			wantStatus: http.StatusOK,
+			want:       `[{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: empty batch"},"id":null}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := router.HandleRPCRequest(newTestContext(tt.body))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.Status())
+
+			body, err := resp.ResponseBytes()
+			require.NoError(t, err)
+			if tt.want == "" {
+				assert.Empty(t, body)
+				return
+			}
+			assert.JSONEq(t, tt.want, string(body))
+		})
+	}
+}
+
+func TestHandleRPCRequestBatchTooLarge(t *testing.T) {
+	router := newTestRouter(map[string]methodHandler{"test_echo": echoHandler})
+	router.maxBatchSize = 2
+
+	resp, err := router.HandleRPCRequest(newTestContext(`[{"method":"test_echo","id":1},{"method":"test_echo","id":2},{"method":"test_echo","id":3}]`))
+	require.NoError(t, err)
+
+	var responses []JSONRPCResponse
+	body, err := resp.ResponseBytes()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, &responses))
+	require.Len(t, responses, 1)
+	assert.Equal(t, ErrCodeLimitExceeded, responses[0].Error.Code)
+}
+
+func TestHandleRPCRequestBatchConcurrencyLimit(t *testing.T) {
+	var running, maxRunning int32
+	router := newTestRouter(map[string]methodHandler{
+		"test_slow": func(_ JSONRPCRequest) (interface{}, error) {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				observed := atomic.LoadInt32(&maxRunning)
+				if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return true, nil
+		},
+	})
+	router.batchConcurrency = 2
+
+	requests := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		requests = append(requests, fmt.Sprintf(`{"jsonrpc":"2.0","method":"test_slow","id":%d}`, i))
+	}
+
+	resp, err := router.HandleRPCRequest(newTestContext("[" + strings.Join(requests, ",") + "]"))
+	require.NoError(t, err)
+
+	var responses []JSONRPCResponse
+	body, err := resp.ResponseBytes()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, &responses))
+	require.Len(t, responses, 10)
+	for i, response := range responses {
+		assert.Equal(t, fmt.Sprintf("%d", i), string(response.ID))
+	}
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(2))
+}
+
+func TestHandleRPCRequestSingle(t *testing.T) {
+	router := newTestRouter(map[string]methodHandler{
+		"test_echo": echoHandler,
+		"test_params": func(_ JSONRPCRequest) (interface{}, error) {
+			return nil, newInvalidParamsError("invalid params for test_params")
+		},
+		"test_null": func(_ JSONRPCRequest) (interface{}, error) {
+			return nil, nil
+		},
+	})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "Single request",
+			body:       `{"jsonrpc":"2.0","method":"test_echo","id":7}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","result":"test_echo","id":7}`,
+		},
+		{
+			name:       "Malformed JSON",
+			body:       `{"jsonrpc":"2.0","method":`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error: invalid JSON"},"id":null}`,
+		},
+		{
+			name:       "Wrong member types",
+			body:       `{"jsonrpc":"2.0","method":1,"params":"bar"}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`,
+		},
+		{
+			name:       "Unknown method",
+			body:       `{"jsonrpc":"2.0","method":"test_unknown","id":7}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32601,"message":"the method test_unknown does not exist/is not available"},"id":7}`,
+		},
+		{
+			name:       "Unknown method notification",
+			body:       `{"jsonrpc":"2.0","method":"test_unknown"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Invalid params",
+			body:       `{"jsonrpc":"2.0","method":"test_params","id":"a"}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params for test_params"},"id":"a"}`,
+		},
+		{
+			name:       "Null result",
+			body:       `{"jsonrpc":"2.0","method":"test_null","id":8}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","result":null,"id":8}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := router.HandleRPCRequest(newTestContext(tt.body))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.Status())
+
+			body, err := resp.ResponseBytes()
+			require.NoError(t, err)
+			if tt.want == "" {
+				assert.Empty(t, body)
+				return
+			}
+			assert.JSONEq(t, tt.want, string(body))
+		})
+	}
+}
@@ -1,15 +1,22 @@
 package evm
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math/big"
+	"sort"
+	"strings"
 
 	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
 	"golang.org/x/crypto/sha3"
 )
 
-// Note: All methods in this file are placeholders and not suitable for production use.
-// They provide simplified implementations for demonstration purposes only.
+// The hashes in this file follow the ICRC-3 standard: blocks are identified by their
+// representation-independent hash, which block verification checks against the
+// certified tip, and transaction hashes are derived from it.
 
 // getBlockHash extracts the hash from an ICRC-3 block value
 //
@@ -20,7 +27,7 @@ import (
 //   - string: The block hash in EVM format (0x-prefixed hex)
 //   - error: Any error that occurred during extraction
 //
-// Note: This is a PoC implementation that assumes a specific block structure
+// Only blocks carrying a hash field have one; icrc3BlockHash hashes the others.
 func getBlockHash(block icpLogger.Value) (string, error) {
 	blockMap := block.Map
 	if blockMap == nil {
@@ -38,18 +45,127 @@ func getBlockHash(block icpLogger.Value) (string, error) {
 	return "", fmt.Errorf("block hash not found")
 }
 
-// calculateTransactionHash generates a Keccak-256 hash for a transaction
+// transactionHash computes the hash of the synthetic transaction of a block entry
 //
 // Parameters:
-//   - tx: The ICRC-3 value representing the transaction
+//   - blockHash: The hash of the block holding the entry
+//   - index: The index of the entry in the block
+//   - entry: The ICRC-3 value of the entry
 //
 // Returns:
-//   - string: The transaction hash in EVM format (0x-prefixed hex)
+//   - string: The Keccak-256 hash of the block hash, the big-endian index and the
+//     representation-independent hash of the entry, in EVM format
+//   - error: If the block hash or the entry cannot be encoded
 //
-// Note: This is a simplified implementation for PoC purposes.
-// In production, proper transaction serialization would be required.
-func calculateTransactionHash(tx icpLogger.Value) string {
+// The hash only depends on the canonical encoding of the entry and its position, so it
+// is the same however the block was fetched and identical entries get distinct hashes.
+func transactionHash(blockHash string, index int, entry icpLogger.Value) (string, error) {
+	blockHashBytes, err := hex.DecodeString(strings.TrimPrefix(blockHash, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("invalid block hash %s: %w", blockHash, err)
+	}
+
+	entryHash, err := hashValue(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash entry %d: %w", index, err)
+	}
+
+	var indexBytes [8]byte
+	binary.BigEndian.PutUint64(indexBytes[:], uint64(index))
+
 	hash := sha3.NewLegacyKeccak256()
-	hash.Write([]byte(fmt.Sprintf("%v", tx)))
-	return "0x" + hex.EncodeToString(hash.Sum(nil))
+	hash.Write(blockHashBytes)
+	hash.Write(indexBytes[:])
+	hash.Write(entryHash)
+	return "0x" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashValue computes the ICRC-3 representation-independent hash of a value
+//
+// Parameters:
+//   - value: The ICRC-3 value to hash
+//
+// Returns:
+//   - []byte: The SHA-256 hash of the value
+//   - error: If the value has no variant set
+//
+// Nats and Ints are hashed as their (S)LEB128 encoding, Texts and Blobs as their raw
+// bytes, Arrays as the concatenation of their item hashes and Maps as the sorted
+// concatenation of the hashes of their keys and values, as the ICRC-3 standard defines.
+func hashValue(value icpLogger.Value) ([]byte, error) {
+	var data []byte
+	switch {
+	case value.Blob != nil:
+		data = *value.Blob
+	case value.Text != nil:
+		data = []byte(*value.Text)
+	case value.Nat != nil:
+		data = encodeLEB128(value.Nat.BigInt())
+	case value.Int != nil:
+		data = encodeSLEB128(value.Int.BigInt())
+	case value.Array != nil:
+		for _, item := range *value.Array {
+			itemHash, err := hashValue(item)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, itemHash...)
+		}
+	case value.Map != nil:
+		pairs := make([][]byte, 0, len(*value.Map))
+		for _, entry := range *value.Map {
+			keyHash := sha256.Sum256([]byte(entry.Field0))
+			valueHash, err := hashValue(entry.Field1)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for key %s: %w", entry.Field0, err)
+			}
+			pairs = append(pairs, append(keyHash[:], valueHash...))
+		}
+
+		sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i], pairs[j]) < 0 })
+		for _, pair := range pairs {
+			data = append(data, pair...)
+		}
+	default:
+		return nil, fmt.Errorf("value has no variant set")
+	}
+
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+// encodeLEB128 encodes a non-negative integer as unsigned LEB128
+func encodeLEB128(n *big.Int) []byte {
+	if n == nil {
+		return []byte{0}
+	}
+
+	var out []byte
+	v := new(big.Int).Set(n)
+	for {
+		b := byte(v.Uint64() & 0x7f)
+		v.Rsh(v, 7)
+		if v.Sign() == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// encodeSLEB128 encodes an integer as signed LEB128
+func encodeSLEB128(n *big.Int) []byte {
+	if n == nil {
+		return []byte{0}
+	}
+
+	var out []byte
+	v := new(big.Int).Set(n)
+	for {
+		b := byte(new(big.Int).And(v, big.NewInt(0x7f)).Uint64())
+		v.Rsh(v, 7) // arithmetic shift, rounds towards negative infinity
+		if (v.Sign() == 0 && b&0x40 == 0) || (v.Cmp(big.NewInt(-1)) == 0 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
 }
@@ -1,9 +1,13 @@
 package evm
 
 import (
+	"encoding/hex"
+	"math/big"
 	"testing"
 
+	"github.com/aviate-labs/agent-go/candid/idl"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
 )
 
@@ -74,3 +78,65 @@ func TestGetBlockHash(t *testing.T) {
 		})
 	}
 }
+
+// Test vectors from the ICRC-3 standard
+func TestHashValue(t *testing.T) {
+	intValue := func(n int64) icpLogger.Value {
+		i := idl.NewBigInt(big.NewInt(n))
+		return icpLogger.Value{Int: &i}
+	}
+
+	tests := []struct {
+		name  string
+		value icpLogger.Value
+		want  string
+	}{
+		{
+			name:  "Nat",
+			value: natValue(42),
+			want:  "684888c0ebb17f374298b65ee2807526c066094c701bcc7ebbe1c1095f494fc1",
+		},
+		{
+			name:  "Int",
+			value: intValue(-42),
+			want:  "de5a6f78116eca62d7fc5ce159d23ae6b889b365a1739ad2cf36f925a140d0cc",
+		},
+		{
+			name:  "Text",
+			value: textValue("Hello, World!"),
+			want:  "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f",
+		},
+		{
+			name:  "Blob",
+			value: blobValue([]byte{1, 2, 3, 4}),
+			want:  "9f64a747e1b97f131fabb6b447296c9b6f0201e79fb3c5356e6c77e89b6a806a",
+		},
+		{
+			name:  "Array",
+			value: icpLogger.Value{Array: &[]icpLogger.Value{natValue(3), textValue("foo"), blobValue([]byte{5, 6})}},
+			want:  "514a04011caa503990d446b7dec5d79e19c221ae607fb08b2848c67734d468d6",
+		},
+		{
+			name: "Map",
+			value: mapValue(
+				testMapEntry{Field0: "from", Field1: blobValue([]byte{0x00, 0xab, 0xcd, 0xef, 0x00, 0x12, 0x34, 0x00, 0x56, 0x78, 0x9a, 0x00, 0xbc, 0xde, 0xf0, 0x00, 0x01, 0x23, 0x45, 0x67, 0x89, 0x00, 0xab, 0xcd, 0xef, 0x01})},
+				testMapEntry{Field0: "to", Field1: blobValue([]byte{0x00, 0xab, 0x0d, 0xef, 0x00, 0x12, 0x34, 0x00, 0x56, 0x78, 0x9a, 0x00, 0xbc, 0xde, 0xf0, 0x00, 0x01, 0x23, 0x45, 0x67, 0x89, 0x00, 0xab, 0xcd, 0xef, 0x01})},
+				testMapEntry{Field0: "amount", Field1: natValue(42)},
+				testMapEntry{Field0: "created_at", Field1: natValue(1699218263)},
+				testMapEntry{Field0: "memo", Field1: natValue(0)},
+			),
+			want: "c56ece650e1de4269c5bdeff7875949e3e2033f85b2d193c2ff4f7f78bdcfc75",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hashValue(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hex.EncodeToString(got))
+		})
+	}
+
+	_, err := hashValue(icpLogger.Value{})
+	assert.Error(t, err)
+}
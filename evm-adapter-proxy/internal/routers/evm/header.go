@@ -0,0 +1,135 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// HeaderFork selects the Ethereum fork whose block header shape the proxy advertises
+type HeaderFork string
+
+// Supported header forks, each adding header fields to the previous one
+const (
+	// HeaderForkLegacy is the pre-London header, without baseFeePerGas
+	HeaderForkLegacy HeaderFork = "legacy"
+	// HeaderForkLondon adds baseFeePerGas
+	HeaderForkLondon HeaderFork = "london"
+	// HeaderForkShanghai adds withdrawalsRoot and the withdrawals list
+	HeaderForkShanghai HeaderFork = "shanghai"
+	// HeaderForkCancun adds blobGasUsed, excessBlobGas and parentBeaconBlockRoot
+	HeaderForkCancun HeaderFork = "cancun"
+
+	// DefaultHeaderFork is the header shape used when none is configured
+	DefaultHeaderFork = HeaderForkCancun
+)
+
+// headerForkOrder ranks the forks so later ones include the fields of earlier ones
+var headerForkOrder = map[HeaderFork]int{
+	HeaderForkLegacy:   0,
+	HeaderForkLondon:   1,
+	HeaderForkShanghai: 2,
+	HeaderForkCancun:   3,
+}
+
+// includes reports whether the header shape of f has the fields introduced by fork
+func (f HeaderFork) includes(fork HeaderFork) bool {
+	return headerForkOrder[f] >= headerForkOrder[fork]
+}
+
+// completeHeader sets the header fields introduced up to a fork and the size of the block
+//
+// Parameters:
+//   - block: The mapped block, with its roots and bloom set
+//   - transactions: The synthetic transactions of the block, encoded into its size
+//   - fork: The header shape to advertise; empty for DefaultHeaderFork
+//
+// Returns:
+//   - error: If a header field cannot be encoded
+//
+// The proxy charges no gas and has no withdrawals, blobs or beacon chain, so the
+// fields are zero, the empty withdrawals list and its empty trie root.
+func completeHeader(block *Block, transactions []Transaction, fork HeaderFork) error {
+	if fork == "" {
+		fork = DefaultHeaderFork
+	}
+
+	if fork.includes(HeaderForkLondon) {
+		block.BaseFeePerGas = "0x0"
+	}
+	if fork.includes(HeaderForkShanghai) {
+		block.WithdrawalsRoot = types.EmptyWithdrawalsHash.Hex()
+		block.Withdrawals = &[]interface{}{}
+	}
+	if fork.includes(HeaderForkCancun) {
+		block.BlobGasUsed = "0x0"
+		block.ExcessBlobGas = "0x0"
+		block.ParentBeaconBlockRoot = common.Hash{}.Hex()
+	}
+
+	header, err := gethHeader(*block)
+	if err != nil {
+		return err
+	}
+
+	body := types.Body{Transactions: legacyTransactions(transactions)}
+	if block.Withdrawals != nil {
+		body.Withdrawals = []*types.Withdrawal{}
+	}
+	block.Size = hexutil.EncodeUint64(types.NewBlockWithHeader(header).WithBody(body).Size())
+
+	return nil
+}
+
+// gethHeader converts the header fields of a block to a go-ethereum header; gas,
+// difficulty and nonce are always zero in mapped blocks
+func gethHeader(block Block) (*types.Header, error) {
+	number, err := hexutil.DecodeBig(block.Number)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block number %s: %w", block.Number, err)
+	}
+	timestamp, err := hexutil.DecodeUint64(block.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block timestamp %s: %w", block.Timestamp, err)
+	}
+	extra, err := hexutil.Decode(block.ExtraData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block extra data %s: %w", block.ExtraData, err)
+	}
+
+	header := &types.Header{
+		ParentHash:  common.HexToHash(block.ParentHash),
+		UncleHash:   common.HexToHash(block.Sha3Uncles),
+		Coinbase:    common.HexToAddress(block.Miner),
+		Root:        common.HexToHash(block.StateRoot),
+		TxHash:      common.HexToHash(block.TransactionsRoot),
+		ReceiptHash: common.HexToHash(block.ReceiptsRoot),
+		Bloom:       types.BytesToBloom(common.FromHex(block.LogsBloom)),
+		Difficulty:  new(big.Int),
+		Number:      number,
+		Time:        timestamp,
+		Extra:       extra,
+		MixDigest:   common.HexToHash(block.MixHash),
+		Nonce:       types.EncodeNonce(0),
+	}
+
+	if block.BaseFeePerGas != "" {
+		header.BaseFee = new(big.Int)
+	}
+	if block.WithdrawalsRoot != "" {
+		withdrawalsRoot := common.HexToHash(block.WithdrawalsRoot)
+		header.WithdrawalsHash = &withdrawalsRoot
+	}
+	if block.ParentBeaconBlockRoot != "" {
+		var blobGasUsed, excessBlobGas uint64
+		beaconRoot := common.HexToHash(block.ParentBeaconBlockRoot)
+		header.BlobGasUsed = &blobGasUsed
+		header.ExcessBlobGas = &excessBlobGas
+		header.ParentBeaconRoot = &beaconRoot
+	}
+
+	return header, nil
+}
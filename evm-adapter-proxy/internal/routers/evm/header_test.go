@@ -0,0 +1,88 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderForks(t *testing.T) {
+	// Fields in the order the forks introduced them
+	postCancun := []string{"baseFeePerGas", "withdrawalsRoot", "withdrawals", "blobGasUsed", "excessBlobGas", "parentBeaconBlockRoot"}
+	postLondon, postShanghai := postCancun[:1], postCancun[:3]
+
+	tests := []struct {
+		name        string
+		fork        HeaderFork
+		wantFields  []string
+		wantMissing []string
+	}{
+		{name: "Legacy", fork: HeaderForkLegacy, wantMissing: postCancun},
+		{name: "London", fork: HeaderForkLondon, wantFields: postLondon, wantMissing: postCancun[len(postLondon):]},
+		{name: "Shanghai", fork: HeaderForkShanghai, wantFields: postShanghai, wantMissing: postCancun[len(postShanghai):]},
+		{name: "Cancun", fork: HeaderForkCancun, wantFields: postCancun},
+		{name: "Default", wantFields: postCancun},
+	}
+
+	block := ICRC3Block{ID: 3, Value: newTestBlock(3, testCallerA, testCallerB)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newDefaultMapperRegistry(nil, nil)
+			registry.SetHeaderFork(tt.fork)
+
+			evmBlock, err := registry.mapBlock(block)
+			require.NoError(t, err)
+
+			encoded, err := json.Marshal(evmBlock)
+			require.NoError(t, err)
+			var fields map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(encoded, &fields))
+
+			assert.Contains(t, fields, "mixHash")
+			for _, field := range tt.wantFields {
+				assert.Contains(t, fields, field)
+			}
+			for _, field := range tt.wantMissing {
+				assert.NotContains(t, fields, field)
+			}
+
+			size, err := hexutil.DecodeUint64(evmBlock.Size)
+			require.NoError(t, err)
+			assert.NotZero(t, size)
+		})
+	}
+}
+
+func TestHeaderDecodedByEthclient(t *testing.T) {
+	log := newTestLog(4)
+	blockStore := openTestStore(t, filepath.Join(t.TempDir(), "blocks.db"))
+	router := newStoreRouter(blockStore)
+	require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 10).Start())
+
+	client, err := ethclient.Dial(newTestRPCServer(t, router).URL)
+	require.NoError(t, err)
+	defer client.Close()
+
+	want, err := router.mappers.mapBlock(log.blocks[2])
+	require.NoError(t, err)
+
+	block, err := client.BlockByNumber(context.Background(), big.NewInt(2))
+	require.NoError(t, err)
+
+	header := block.Header()
+	require.NotNil(t, header.BaseFee)
+	assert.Zero(t, header.BaseFee.Sign())
+	require.NotNil(t, header.WithdrawalsHash)
+	assert.Equal(t, want.WithdrawalsRoot, header.WithdrawalsHash.Hex())
+	require.NotNil(t, header.BlobGasUsed)
+	require.NotNil(t, header.ParentBeaconRoot)
+	assert.Empty(t, block.Withdrawals())
+	assert.Equal(t, want.Size, hexutil.EncodeUint64(block.Size()), "the size is the RLP size of the block clients decode")
+}
@@ -0,0 +1,151 @@
+package evm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	ingesterTaskName       = "block-ingester"
+	defaultIngestBatchSize = 100
+)
+
+// blockIngester is a golem runner task that copies the ICRC-3 log into the block store
+//
+// The runner calls Start again about a second after it returns, so each call catches
+// up with the tip of the log and returns. Blocks are stored in order from the store
+// height, which makes a restarted ingester resume from the last persisted block.
+type blockIngester struct {
+	store     *store.Store
+	fetch     func(start, length uint64) (blocksPage, error)
+	index     func(block ICRC3Block) (store.Block, error)
+	batchSize uint64
+	// release, if set, is called by Stop before the store is closed
+	release func()
+}
+
+// newBlockIngester creates an ingester reading blocks with fetch and indexing them with index
+//
+// Parameters:
+//   - blockStore: The store the blocks are written to
+//   - fetch: Returns blocks from the canister, verified when block verification is enabled
+//   - index: Encodes a block and its index keys for the store
+//   - batchSize: Number of blocks fetched and stored at a time
+func newBlockIngester(blockStore *store.Store, fetch func(start, length uint64) (blocksPage, error),
+	index func(block ICRC3Block) (store.Block, error), batchSize int) *blockIngester {
+	if batchSize <= 0 {
+		batchSize = defaultIngestBatchSize
+	}
+
+	return &blockIngester{
+		store:     blockStore,
+		fetch:     fetch,
+		index:     index,
+		batchSize: uint64(batchSize),
+	}
+}
+
+// Name implements runner.Task
+func (i *blockIngester) Name() string {
+	return ingesterTaskName
+}
+
+// Start implements runner.Task, ingesting every block up to the current tip
+func (i *blockIngester) Start() error {
+	height, err := i.store.Height()
+	if err != nil {
+		return fmt.Errorf("failed to read block store height: %w", err)
+	}
+	first := height
+
+	for {
+		page, err := i.fetch(height, i.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch blocks from %d: %w", height, err)
+		}
+
+		blocks := make([]store.Block, 0, len(page.Blocks))
+		for _, block := range page.Blocks {
+			if block.ID != height+uint64(len(blocks)) {
+				break
+			}
+			indexed, err := i.index(block)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, indexed)
+		}
+
+		if len(blocks) == 0 {
+			break
+		}
+		if err := i.store.Put(blocks); err != nil {
+			return fmt.Errorf("failed to store blocks from %d: %w", height, err)
+		}
+		height += uint64(len(blocks))
+
+		if uint64(len(blocks)) < i.batchSize {
+			break
+		}
+	}
+
+	if height > first {
+		zap.S().Debugf("[%s] ingested blocks %d to %d", ingesterTaskName, first, height-1)
+	}
+
+	return nil
+}
+
+// Stop implements runner.Task, closing the block store once released
+func (i *blockIngester) Stop() error {
+	if i.release != nil {
+		i.release()
+	}
+	return i.store.Close()
+}
+
+// requestGate tracks the requests a router is serving, so that its block store is
+// closed only once they are done
+//
+// Every request holds a read lock while it runs, and close takes the write lock, which
+// waits for the requests in flight. Requests arriving after close are refused. A nil
+// gate admits every request.
+type requestGate struct {
+	mu     sync.RWMutex
+	closed bool
+}
+
+// enter admits a request, which must call leave when done
+//
+// Returns:
+//   - bool: Whether the request was admitted, false once the gate is closed
+func (g *requestGate) enter() bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.RLock()
+	if g.closed {
+		g.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+// leave releases a request admitted by enter
+func (g *requestGate) leave() {
+	if g != nil {
+		g.mu.RUnlock()
+	}
+}
+
+// close refuses new requests and waits for the ones in flight to leave
+func (g *requestGate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+}
@@ -0,0 +1,198 @@
+package evm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+)
+
+const (
+	testCallerA = "rrkah-fqaaa-aaaaa-aaaaq-cai"
+	testCallerB = "ryjl3-tyaaa-aaaaa-aaaba-cai"
+)
+
+// testLog is a log canister whose even blocks are logged by testCallerA and odd blocks by testCallerB
+type testLog struct {
+	blocks  []ICRC3Block
+	fetches []uint64
+}
+
+func newTestLog(n int) *testLog {
+	l := &testLog{}
+	l.append(n)
+	return l
+}
+
+func (l *testLog) append(n int) {
+	for i := 0; i < n; i++ {
+		id := uint64(len(l.blocks))
+		caller := testCallerA
+		if id%2 == 1 {
+			caller = testCallerB
+		}
+		l.blocks = append(l.blocks, ICRC3Block{ID: id, Value: newTestBlock(id, caller)})
+	}
+}
+
+func (l *testLog) fetch(start, length uint64) (blocksPage, error) {
+	l.fetches = append(l.fetches, start)
+	page := blocksPage{LogLength: uint64(len(l.blocks))}
+	for id := start; id < start+length && id < uint64(len(l.blocks)); id++ {
+		page.Blocks = append(page.Blocks, l.blocks[id])
+	}
+	return page, nil
+}
+
+func openTestStore(t *testing.T, path string) *store.Store {
+	blockStore, err := store.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = blockStore.Close() })
+	return blockStore
+}
+
+func newStoreRouter(blockStore *store.Store) *evmRouter {
+	return &evmRouter{store: blockStore, mappers: newDefaultMapperRegistry(nil, nil), hashIndex: newBlockHashIndex(blockStore)}
+}
+
+func TestBlockIngester(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.db")
+	log := newTestLog(25)
+
+	blockStore, err := store.Open(path)
+	require.NoError(t, err)
+	router := newStoreRouter(blockStore)
+	ingester := newBlockIngester(blockStore, log.fetch, router.indexBlock, 10)
+	released := false
+	ingester.release = func() { released = true }
+
+	require.NoError(t, ingester.Start())
+	height, err := blockStore.Height()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(25), height)
+	assert.Equal(t, []uint64{0, 10, 20}, log.fetches)
+
+	// Nothing new: a single fetch at the height
+	log.fetches = nil
+	require.NoError(t, ingester.Start())
+	assert.Equal(t, []uint64{25}, log.fetches)
+
+	// A restarted ingester resumes from the persisted height
+	require.NoError(t, ingester.Stop())
+	assert.True(t, released, "released before the store is closed")
+	log.append(3)
+	log.fetches = nil
+
+	blockStore = openTestStore(t, path)
+	router = newStoreRouter(blockStore)
+	require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 10).Start())
+	assert.Equal(t, []uint64{25}, log.fetches)
+
+	height, err = blockStore.Height()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(28), height)
+}
+
+func TestBlockIngesterDrainsRequests(t *testing.T) {
+	blockStore, err := store.Open(filepath.Join(t.TempDir(), "blocks.db"))
+	require.NoError(t, err)
+
+	started, proceed := make(chan struct{}), make(chan struct{})
+	router := newTestRouter(map[string]methodHandler{
+		"eth_blockNumber": func(JSONRPCRequest) (interface{}, error) {
+			close(started)
+			<-proceed
+			return blockStore.Height()
+		},
+	})
+	router.store, router.gate, router.principals = blockStore, &requestGate{}, newPrincipalBook(blockStore)
+	ingester := newBlockIngester(blockStore, newTestLog(0).fetch, router.indexBlock, 10)
+	ingester.release = router.release
+
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`)
+	responses := make(chan interface{})
+	go func() { responses <- router.processMessage(context.Background(), router.methodHandlers, body) }()
+	<-started
+
+	stopped := make(chan error)
+	go func() { stopped <- ingester.Stop() }()
+	select {
+	case <-stopped:
+		t.Fatal("the store was closed while a request was reading it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(proceed)
+	response := (<-responses).(JSONRPCResponse)
+	assert.Nil(t, response.Error)
+	require.NoError(t, <-stopped)
+
+	// Requests arriving after the store is closed are refused
+	response = router.processMessage(context.Background(), router.methodHandlers, body).(JSONRPCResponse)
+	require.NotNil(t, response.Error)
+	assert.Equal(t, ErrCodeResourceUnavailable, response.Error.Code)
+}
+
+func TestStoreRouter(t *testing.T) {
+	log := newTestLog(12)
+	blockStore := openTestStore(t, filepath.Join(t.TempDir(), "blocks.db"))
+	router := newStoreRouter(blockStore)
+	require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 5).Start())
+
+	t.Run("Blocks round-trip through the store", func(t *testing.T) {
+		// The router has no canister clients, so every block must come from the store
+		page, err := router.getBlocks(3, 4)
+		require.NoError(t, err)
+		require.Len(t, page.Blocks, 4)
+
+		for i, block := range page.Blocks {
+			original := log.blocks[3+i]
+
+			want, err := router.mappers.mapBlock(original)
+			require.NoError(t, err)
+			got, err := router.mappers.mapBlock(block)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			wantLogs, err := router.mappers.mapLogs(original, logFilter{})
+			require.NoError(t, err)
+			gotLogs, err := router.mappers.mapLogs(block, logFilter{})
+			require.NoError(t, err)
+			assert.Equal(t, wantLogs, gotLogs)
+		}
+	})
+
+	t.Run("Block by hash", func(t *testing.T) {
+		want, err := router.mappers.mapBlock(log.blocks[7])
+		require.NoError(t, err)
+
+		got, err := router.EthGetBlockByHash(JSONRPCRequest{Params: []interface{}{want.Hash}})
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("Logs by address", func(t *testing.T) {
+		logs, err := router.mappers.mapLogs(log.blocks[1], logFilter{})
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+
+		got, err := router.getLogsInRange(logFilter{Addresses: []string{logs[0].Address}}, 2, 9)
+		require.NoError(t, err)
+
+		var numbers []string
+		for _, log := range got {
+			numbers = append(numbers, log.BlockNumber)
+		}
+		assert.Equal(t, []string{"0x3", "0x5", "0x7", "0x9"}, numbers)
+	})
+
+	t.Run("Logs by unknown block hash", func(t *testing.T) {
+		got, err := router.getLogsInRange(logFilter{BlockHash: "0xab"}, 0, 11)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
@@ -0,0 +1,399 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp"
+	icpLedger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/ledger"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// ICRC-1 and ICRC-2 block types, as defined by the ICRC-3 standard
+const (
+	BlockTypeICRC1Transfer     = "1xfer"
+	BlockTypeICRC1Mint         = "1mint"
+	BlockTypeICRC1Burn         = "1burn"
+	BlockTypeICRC2Approve      = "2approve"
+	BlockTypeICRC2TransferFrom = "2xfer"
+)
+
+// LedgerMetadata is the ICRC-1 metadata of the configured ledger and the address
+// of the ERC-20 token that represents it
+type LedgerMetadata struct {
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// ledgerMetadataCache keeps the ledger metadata once it has been fetched; it does not
+// change during the lifetime of a ledger
+type ledgerMetadataCache struct {
+	mu       sync.Mutex
+	metadata *LedgerMetadata
+}
+
+// GetLedgerMetadata handles the eth_getLedgerMetadata RPC method
+// Returns the name, symbol and decimals of the ICRC-1 ledger and its token address
+func (r *evmRouter) GetLedgerMetadata(_ JSONRPCRequest) (interface{}, error) {
+	if r.ledger == nil {
+		return nil, newRPCError(ErrCodeMethodNotSupported, "no ICRC-1 ledger configured")
+	}
+
+	return r.getLedgerMetadata()
+}
+
+// getLedgerMetadata returns the cached ledger metadata, fetching it on first use
+func (r *evmRouter) getLedgerMetadata() (LedgerMetadata, error) {
+	r.ledgerMetadata.mu.Lock()
+	defer r.ledgerMetadata.mu.Unlock()
+
+	if r.ledgerMetadata.metadata != nil {
+		return *r.ledgerMetadata.metadata, nil
+	}
+
+	name, err := r.ledger.Icrc1Name()
+	if err != nil {
+		return LedgerMetadata{}, newCanisterError(err, "failed to get ledger name")
+	}
+
+	symbol, err := r.ledger.Icrc1Symbol()
+	if err != nil {
+		return LedgerMetadata{}, newCanisterError(err, "failed to get ledger symbol")
+	}
+
+	decimals, err := r.ledger.Icrc1Decimals()
+	if err != nil {
+		return LedgerMetadata{}, newCanisterError(err, "failed to get ledger decimals")
+	}
+
+	r.ledgerMetadata.metadata = &LedgerMetadata{
+		Address:  r.tokens.address(*symbol).Hex(),
+		Name:     *name,
+		Symbol:   *symbol,
+		Decimals: *decimals,
+	}
+
+	return *r.ledgerMetadata.metadata, nil
+}
+
+// ledgerBlock returns the block at an index, as the ledger mappers read the blocks
+// fee_col_block fields refer to
+func (r *evmRouter) ledgerBlock(index uint64) (ICRC3Block, error) {
+	page, err := r.getBlocks(index, 1)
+	if err != nil {
+		return ICRC3Block{}, err
+	}
+	for _, block := range page.Blocks {
+		if block.ID == index {
+			return block, nil
+		}
+	}
+
+	return ICRC3Block{}, newNotFoundError("block %d not found", index)
+}
+
+// ledgerTokenAddress returns the address of the token that emits the ledger logs
+func (r *evmRouter) ledgerTokenAddress() (common.Address, error) {
+	metadata, err := r.getLedgerMetadata()
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return common.HexToAddress(metadata.Address), nil
+}
+
+// ledgerLog is the ICRC-3 log of an ICRC-1 ledger
+type ledgerLog struct {
+	agent             *icpLedger.Agent
+	queries           *icp.QueryAgent
+	certificateMaxAge time.Duration
+}
+
+// fetchBlocksPage implements blockSource with icrc3_get_blocks, following the archives
+func (l ledgerLog) fetchBlocksPage(start, length uint64) (blocksPage, error) {
+	var result icpLedger.GetBlocksResult
+	source := idl.PrincipalMethod{Principal: l.agent.CanisterId, Method: icp.GetBlocksMethod}
+	archived, err := icp.GetBlocks(l.queries, source, start, length, ledgerBlocksArgs, &result)
+	if err != nil {
+		return blocksPage{}, err
+	}
+
+	page := blocksPage{LogLength: result.LogLength.BigInt().Uint64(), Blocks: ledgerBlocks(result)}
+
+	return stitchArchived(page, archived, fetchLedgerArchive(l.queries))
+}
+
+// fetchCertifiedTip implements tipSource with icrc3_get_tip_certificate
+func (l ledgerLog) fetchCertifiedTip() (certifiedTip, error) {
+	tipCert, err := l.agent.Icrc3GetTipCertificate()
+	if err != nil {
+		return certifiedTip{}, newCanisterError(err, "failed to get tip certificate")
+	}
+	if tipCert == nil || *tipCert == nil {
+		return certifiedTip{}, newNotFoundError("no tip certificate found")
+	}
+
+	return checkTipCertificate((*tipCert).Certificate, (*tipCert).HashTree, l.agent.CanisterId, l.agent.GetRootKey(), l.certificateMaxAge)
+}
+
+// fromLedgerValue converts a ledger client Value into the logger client Value the
+// mappers work with; both are the ICRC-3 Value type
+func fromLedgerValue(value icpLedger.Value) icpLogger.Value {
+	converted := icpLogger.Value{
+		Blob: value.Blob,
+		Text: value.Text,
+		Nat:  value.Nat,
+		Int:  value.Int,
+	}
+
+	if value.Array != nil {
+		items := make([]icpLogger.Value, 0, len(*value.Array))
+		for _, item := range *value.Array {
+			items = append(items, fromLedgerValue(item))
+		}
+		converted.Array = &items
+	}
+
+	if value.Map != nil {
+		entries := make([]struct {
+			Field0 string          `ic:"0" json:"0"`
+			Field1 icpLogger.Value `ic:"1" json:"1"`
+		}, 0, len(*value.Map))
+		for _, entry := range *value.Map {
+			entries = append(entries, struct {
+				Field0 string          `ic:"0" json:"0"`
+				Field1 icpLogger.Value `ic:"1" json:"1"`
+			}{Field0: entry.Field0, Field1: fromLedgerValue(entry.Field1)})
+		}
+		converted.Map = &entries
+	}
+
+	return converted
+}
+
+// registerLedgerMappers registers the ICRC-1/ICRC-2 block mappers, whose logs are
+// emitted by the token address returned by tokenAddress and whose long account owners
+// are recorded in principals; block reads the blocks fee_col_block fields refer to
+func registerLedgerMappers(registry *MapperRegistry, tokenAddress func() (common.Address, error),
+	block func(index uint64) (ICRC3Block, error), principals *principalBook) {
+	mapper := &ledgerMapper{
+		tokenAddress:  tokenAddress,
+		block:         block,
+		principals:    principals,
+		feeCollectors: make(map[uint64]common.Address),
+	}
+	for _, btype := range []string{
+		BlockTypeICRC1Transfer,
+		BlockTypeICRC1Mint,
+		BlockTypeICRC1Burn,
+		BlockTypeICRC2Approve,
+		BlockTypeICRC2TransferFrom,
+	} {
+		registry.Register(btype, mapper)
+	}
+}
+
+// ledgerMapper maps ICRC-1/ICRC-2 ledger blocks to ERC-20 logs
+//
+// Transfers, mints and burns become Transfer events, approvals become Approval
+// events. A non-zero fee becomes an extra Transfer from the payer to the fee
+// collector, or to the zero address when the fee is burned.
+type ledgerMapper struct {
+	tokenAddress func() (common.Address, error)
+	block        func(index uint64) (ICRC3Block, error)
+	principals   *principalBook
+
+	mu sync.Mutex
+	// feeCollectors are the fee_col accounts of the blocks fee_col_block fields refer to
+	feeCollectors map[uint64]common.Address
+}
+
+// MapBlock implements BlockMapper
+func (l *ledgerMapper) MapBlock(block ICRC3Block) (Block, error) {
+	return mapBlockHeader(block)
+}
+
+// MapTransactions implements BlockMapper; a ledger block is a single transaction
+// sent by its from account (the zero address for mints) to the token
+func (l *ledgerMapper) MapTransactions(block ICRC3Block) ([]Transaction, error) {
+	logs, err := l.MapLogs(block)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := l.accountField(*lookupField(block.Value, "tx"), "from")
+	if err != nil {
+		return nil, err
+	}
+
+	return []Transaction{newTransaction(logs[0], from.Hex(), logs[0].Address)}, nil
+}
+
+// MapLogs implements BlockMapper
+func (l *ledgerMapper) MapLogs(block ICRC3Block) ([]Log, error) {
+	tx := lookupField(block.Value, "tx")
+	if tx == nil {
+		return nil, fmt.Errorf("invalid ledger block: tx not found")
+	}
+
+	amount, err := natField(*tx, "amt")
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := l.accountField(*tx, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := l.accountField(*tx, "to")
+	if err != nil {
+		return nil, err
+	}
+	spender, err := l.accountField(*tx, "spender")
+	if err != nil {
+		return nil, err
+	}
+	feeCollector, err := l.feeCollector(block.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fee paid is the block fee when set, otherwise the one requested in the tx
+	fee, err := natField(block.Value, "fee")
+	if err != nil {
+		return nil, err
+	}
+	if fee.Sign() == 0 {
+		if fee, err = natField(*tx, "fee"); err != nil {
+			return nil, err
+		}
+	}
+
+	var events []Log
+	feePayer := from
+	switch btype := blockType(block.Value); btype {
+	case BlockTypeICRC1Transfer, BlockTypeICRC2TransferFrom:
+		events = append(events, erc20Event(TransferEventTopic, from, to, amount))
+	case BlockTypeICRC1Mint:
+		events = append(events, erc20Event(TransferEventTopic, common.Address{}, to, amount))
+		feePayer = common.Address{}
+	case BlockTypeICRC1Burn:
+		events = append(events, erc20Event(TransferEventTopic, from, common.Address{}, amount))
+	case BlockTypeICRC2Approve:
+		events = append(events, erc20Event(ApprovalEventTopic, from, spender, amount))
+	default:
+		return nil, fmt.Errorf("unsupported ledger block type %q", btype)
+	}
+
+	if fee.Sign() > 0 && feePayer != (common.Address{}) {
+		events = append(events, erc20Event(TransferEventTopic, feePayer, feeCollector, fee))
+	}
+
+	token, err := l.tokenAddress()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger token address: %w", err)
+	}
+
+	blockHash, err := icrc3BlockHash(block.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block hash: %w", err)
+	}
+
+	txHash, err := transactionHash(blockHash, 0, *tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		events[i].Address = token.Hex()
+		events[i].BlockNumber = fmt.Sprintf("0x%x", block.ID)
+		events[i].BlockHash = blockHash
+		events[i].TxHash = txHash
+		events[i].TxIndex = "0x0"
+		events[i].LogIndex = fmt.Sprintf("0x%x", i)
+	}
+
+	return events, nil
+}
+
+// feeCollector returns the address of the fee collector of a block: its fee_col account,
+// or the fee_col of the block its fee_col_block refers to, which ledgers write instead
+// once the collector has been set; the zero address when the fee is burned
+func (l *ledgerMapper) feeCollector(value icpLogger.Value) (common.Address, error) {
+	if lookupField(value, "fee_col") != nil || lookupField(value, "fee_col_block") == nil {
+		return l.accountField(value, "fee_col")
+	}
+
+	index, err := natField(value, "fee_col_block")
+	if err != nil {
+		return common.Address{}, err
+	}
+	if !index.IsUint64() {
+		return common.Address{}, fmt.Errorf("invalid ledger block: fee_col_block %s out of range", index)
+	}
+
+	l.mu.Lock()
+	collector, found := l.feeCollectors[index.Uint64()]
+	l.mu.Unlock()
+	if found {
+		return collector, nil
+	}
+
+	if l.block == nil {
+		return common.Address{}, fmt.Errorf("cannot read fee collector block %d", index)
+	}
+	collectorBlock, err := l.block(index.Uint64())
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to read fee collector block %d: %w", index, err)
+	}
+	if lookupField(collectorBlock.Value, "fee_col") == nil {
+		return common.Address{}, fmt.Errorf("invalid ledger block: fee_col_block %d has no fee_col", index)
+	}
+	if collector, err = l.accountField(collectorBlock.Value, "fee_col"); err != nil {
+		return common.Address{}, err
+	}
+
+	l.mu.Lock()
+	l.feeCollectors[index.Uint64()] = collector
+	l.mu.Unlock()
+
+	return collector, nil
+}
+
+// natField returns a Nat field of a Map value, zero when absent
+func natField(value icpLogger.Value, key string) (*big.Int, error) {
+	field := lookupField(value, key)
+	if field == nil {
+		return new(big.Int), nil
+	}
+	if field.Nat == nil {
+		return nil, fmt.Errorf("invalid ledger block: %s is not a Nat", key)
+	}
+
+	return field.Nat.BigInt(), nil
+}
+
+// accountField returns the EVM address of an ICRC-1 account field, encoded as an array
+// of its owner principal and optional subaccount; the zero address when absent
+//
+// The subaccount is not part of the address, so all the subaccounts of a principal
+// share its EVM address.
+func (l *ledgerMapper) accountField(value icpLogger.Value, key string) (common.Address, error) {
+	field := lookupField(value, key)
+	if field == nil {
+		return common.Address{}, nil
+	}
+	if field.Array == nil || len(*field.Array) == 0 || (*field.Array)[0].Blob == nil {
+		return common.Address{}, fmt.Errorf("invalid ledger block: %s is not an account", key)
+	}
+
+	owner := principal.Principal{Raw: *(*field.Array)[0].Blob}
+	return l.principals.address(owner), nil
+}
@@ -0,0 +1,235 @@
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpLedger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/ledger"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+var testLedgerToken = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+
+// accountValue encodes an ICRC-1 account the way ledgers store it in blocks
+func accountValue(t *testing.T, owner string) (icpLogger.Value, common.Address) {
+	p, err := principal.Decode(owner)
+	require.NoError(t, err)
+
+	address, err := convertICPToEthAddress(owner)
+	require.NoError(t, err)
+
+	return icpLogger.Value{Array: &[]icpLogger.Value{blobValue(p.Raw)}}, common.HexToAddress(address)
+}
+
+// newLedgerRegistry returns the ledger mappers of testLedgerToken, reading the blocks
+// fee_col_block fields refer to from blocks
+func newLedgerRegistry(blocks ...ICRC3Block) *MapperRegistry {
+	registry := newDefaultMapperRegistry(nil, nil)
+	registerLedgerMappers(registry, func() (common.Address, error) { return testLedgerToken, nil }, func(index uint64) (ICRC3Block, error) {
+		for _, block := range blocks {
+			if block.ID == index {
+				return block, nil
+			}
+		}
+		return ICRC3Block{}, newNotFoundError("block %d not found", index)
+	}, nil)
+	return registry
+}
+
+func TestLedgerMapperLogs(t *testing.T) {
+	alice, aliceAddress := accountValue(t, "rrkah-fqaaa-aaaaa-aaaaq-cai")
+	bob, bobAddress := accountValue(t, "ryjl3-tyaaa-aaaaa-aaaba-cai")
+	collector, collectorAddress := accountValue(t, "r7inp-6aaaa-aaaaa-aaabq-cai")
+	zero := common.Address{}
+
+	type event struct {
+		topic  common.Hash
+		from   common.Address
+		to     common.Address
+		amount int64
+	}
+
+	tests := []struct {
+		name  string
+		block icpLogger.Value
+		want  []event
+	}{
+		{
+			name: "Transfer with burned fee",
+			block: mapValue(
+				testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC1Transfer)},
+				testMapEntry{Field0: "ts", Field1: natValue(1e9)},
+				testMapEntry{Field0: "fee", Field1: natValue(10)},
+				testMapEntry{Field0: "tx", Field1: mapValue(
+					testMapEntry{Field0: "from", Field1: alice},
+					testMapEntry{Field0: "to", Field1: bob},
+					testMapEntry{Field0: "amt", Field1: natValue(500)},
+				)},
+			),
+			want: []event{
+				{topic: TransferEventTopic, from: aliceAddress, to: bobAddress, amount: 500},
+				{topic: TransferEventTopic, from: aliceAddress, to: zero, amount: 10},
+			},
+		},
+		{
+			name: "Legacy transfer_from with fee collector",
+			block: mapValue(
+				testMapEntry{Field0: "ts", Field1: natValue(1e9)},
+				testMapEntry{Field0: "fee_col", Field1: collector},
+				testMapEntry{Field0: "tx", Field1: mapValue(
+					testMapEntry{Field0: "op", Field1: textValue("xfer")},
+					testMapEntry{Field0: "from", Field1: alice},
+					testMapEntry{Field0: "to", Field1: bob},
+					testMapEntry{Field0: "spender", Field1: collector},
+					testMapEntry{Field0: "amt", Field1: natValue(7)},
+					testMapEntry{Field0: "fee", Field1: natValue(1)},
+				)},
+			),
+			want: []event{
+				{topic: TransferEventTopic, from: aliceAddress, to: bobAddress, amount: 7},
+				{topic: TransferEventTopic, from: aliceAddress, to: collectorAddress, amount: 1},
+			},
+		},
+		{
+			name: "Mint",
+			block: mapValue(
+				testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC1Mint)},
+				testMapEntry{Field0: "tx", Field1: mapValue(
+					testMapEntry{Field0: "to", Field1: bob},
+					testMapEntry{Field0: "amt", Field1: natValue(1000)},
+				)},
+			),
+			want: []event{{topic: TransferEventTopic, from: zero, to: bobAddress, amount: 1000}},
+		},
+		{
+			name: "Burn",
+			block: mapValue(
+				testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC1Burn)},
+				testMapEntry{Field0: "tx", Field1: mapValue(
+					testMapEntry{Field0: "from", Field1: alice},
+					testMapEntry{Field0: "amt", Field1: natValue(3)},
+				)},
+			),
+			want: []event{{topic: TransferEventTopic, from: aliceAddress, to: zero, amount: 3}},
+		},
+		{
+			name: "Approve",
+			block: mapValue(
+				testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC2Approve)},
+				testMapEntry{Field0: "tx", Field1: mapValue(
+					testMapEntry{Field0: "from", Field1: alice},
+					testMapEntry{Field0: "spender", Field1: bob},
+					testMapEntry{Field0: "amt", Field1: natValue(42)},
+				)},
+			),
+			want: []event{{topic: ApprovalEventTopic, from: aliceAddress, to: bobAddress, amount: 42}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := newLedgerRegistry().mapLogs(ICRC3Block{ID: 9, Value: tt.block}, logFilter{})
+			require.NoError(t, err)
+			require.Len(t, logs, len(tt.want))
+
+			for i, want := range tt.want {
+				log := logs[i]
+				assert.Equal(t, testLedgerToken.Hex(), log.Address)
+				assert.Equal(t, "0x9", log.BlockNumber)
+				assert.Equal(t, "0x0", log.TxIndex)
+				assert.Equal(t, hexIndex(i), log.LogIndex)
+				assert.Equal(t, []string{
+					want.topic.Hex(),
+					common.BytesToHash(want.from.Bytes()).Hex(),
+					common.BytesToHash(want.to.Bytes()).Hex(),
+				}, log.Topics)
+				assert.Equal(t, big.NewInt(want.amount), new(big.Int).SetBytes(common.FromHex(log.Data)))
+			}
+		})
+	}
+}
+
+func TestLedgerMapperFeeCollectorBlock(t *testing.T) {
+	alice, aliceAddress := accountValue(t, "rrkah-fqaaa-aaaaa-aaaaq-cai")
+	bob, _ := accountValue(t, "ryjl3-tyaaa-aaaaa-aaaba-cai")
+	collector, collectorAddress := accountValue(t, "r7inp-6aaaa-aaaaa-aaabq-cai")
+
+	transfer := func(feeCollector testMapEntry) icpLogger.Value {
+		return mapValue(
+			testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC1Transfer)},
+			testMapEntry{Field0: "fee", Field1: natValue(10)},
+			feeCollector,
+			testMapEntry{Field0: "tx", Field1: mapValue(
+				testMapEntry{Field0: "from", Field1: alice},
+				testMapEntry{Field0: "to", Field1: bob},
+				testMapEntry{Field0: "amt", Field1: natValue(500)},
+			)},
+		)
+	}
+
+	// The first block paying the collector names it, later ones refer to that block
+	first := ICRC3Block{ID: 3, Value: transfer(testMapEntry{Field0: "fee_col", Field1: collector})}
+	registry := newLedgerRegistry(first)
+
+	for _, id := range []uint64{9, 10} {
+		logs, err := registry.mapLogs(ICRC3Block{ID: id, Value: transfer(testMapEntry{Field0: "fee_col_block", Field1: natValue(3)})}, logFilter{})
+		require.NoError(t, err)
+		require.Len(t, logs, 2)
+		assert.Equal(t, []string{
+			TransferEventTopic.Hex(),
+			common.BytesToHash(aliceAddress.Bytes()).Hex(),
+			common.BytesToHash(collectorAddress.Bytes()).Hex(),
+		}, logs[1].Topics, "the fee goes to the collector of block 3")
+	}
+
+	t.Run("Unknown fee collector block", func(t *testing.T) {
+		_, err := registry.mapLogs(ICRC3Block{ID: 11, Value: transfer(testMapEntry{Field0: "fee_col_block", Field1: natValue(4)})}, logFilter{})
+		assert.ErrorContains(t, err, "failed to read fee collector block 4")
+	})
+
+	t.Run("Fee collector block without a collector", func(t *testing.T) {
+		registry := newLedgerRegistry(ICRC3Block{ID: 3, Value: transfer(testMapEntry{Field0: "memo", Field1: natValue(0)})})
+		_, err := registry.mapLogs(ICRC3Block{ID: 9, Value: transfer(testMapEntry{Field0: "fee_col_block", Field1: natValue(3)})}, logFilter{})
+		assert.ErrorContains(t, err, "fee_col_block 3 has no fee_col")
+	})
+}
+
+func TestLedgerMapperInvalidBlock(t *testing.T) {
+	block := mapValue(testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC1Transfer)})
+	_, err := newLedgerRegistry().mapLogs(ICRC3Block{ID: 1, Value: block}, logFilter{})
+	assert.ErrorContains(t, err, "tx not found")
+}
+
+func TestFromLedgerValue(t *testing.T) {
+	nat := idl.NewNat(uint64(5))
+	text := "op"
+	blob := []byte{1, 2}
+	ledgerValue := icpLedger.Value{Map: &[]struct {
+		Field0 string          `ic:"0" json:"0"`
+		Field1 icpLedger.Value `ic:"1" json:"1"`
+	}{
+		{Field0: "amt", Field1: icpLedger.Value{Nat: &nat}},
+		{Field0: "items", Field1: icpLedger.Value{Array: &[]icpLedger.Value{{Text: &text}, {Blob: &blob}}}},
+	}}
+
+	want := mapValue(
+		testMapEntry{Field0: "amt", Field1: natValue(5)},
+		testMapEntry{Field0: "items", Field1: icpLogger.Value{Array: &[]icpLogger.Value{textValue("op"), blobValue([]byte{1, 2})}}},
+	)
+
+	got := fromLedgerValue(ledgerValue)
+	gotHash, err := hashValue(got)
+	require.NoError(t, err)
+	wantHash, err := hashValue(want)
+	require.NoError(t, err)
+	assert.Equal(t, wantHash, gotHash)
+}
+
+func hexIndex(i int) string {
+	return "0x" + big.NewInt(int64(i)).Text(16)
+}
@@ -0,0 +1,203 @@
+package evm
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxTopics is the number of indexed topics a log can have
+const maxTopics = 4
+
+// logFilter holds the criteria of an eth_getLogs filter object
+//
+// Addresses match if the log address is any of them; an empty list matches every
+// address. Topics are positional: a nil position matches any topic and a non-nil
+// one matches if the log topic at that position is any of its values.
+type logFilter struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Addresses []string
+	Topics    [][]string
+	BlockHash string
+}
+
+// parseLogFilter extracts and validates every supported criterion of a filter object;
+// block tags of fromBlock resolve to the block number returned by latest
+func parseLogFilter(filter map[string]interface{}, latest func() (uint64, error)) (logFilter, error) {
+	blockHash, err := extractBlockHash(filter)
+	if err != nil {
+		return logFilter{}, err
+	}
+
+	if blockHash != "" && (filter["fromBlock"] != nil || filter["toBlock"] != nil) {
+		return logFilter{}, newInvalidParamsError("cannot specify both BlockHash and FromBlock/ToBlock, choose one or the other")
+	}
+
+	fromBlock, toBlock, err := extractBlockRange(filter, latest)
+	if err != nil {
+		return logFilter{}, err
+	}
+
+	addresses, err := extractAddresses(filter)
+	if err != nil {
+		return logFilter{}, err
+	}
+
+	topics, err := extractTopics(filter)
+	if err != nil {
+		return logFilter{}, err
+	}
+
+	return logFilter{
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		Addresses: addresses,
+		Topics:    topics,
+		BlockHash: blockHash,
+	}, nil
+}
+
+// matches reports whether a log satisfies the address, topic and block hash criteria
+func (f logFilter) matches(log Log) bool {
+	if f.BlockHash != "" && !strings.EqualFold(log.BlockHash, f.BlockHash) {
+		return false
+	}
+
+	if len(f.Addresses) > 0 && !containsFold(f.Addresses, log.Address) {
+		return false
+	}
+
+	if len(f.Topics) > len(log.Topics) {
+		return false
+	}
+
+	for i, alternatives := range f.Topics {
+		if len(alternatives) > 0 && !containsFold(alternatives, log.Topics[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// extractBlockHash extracts the blockHash parameter from the filter
+func extractBlockHash(filter map[string]interface{}) (string, error) {
+	blockHash, ok := filter["blockHash"]
+	if !ok || blockHash == nil {
+		return "", nil
+	}
+
+	hash, ok := blockHash.(string)
+	if !ok || !isHexHash(hash) {
+		return "", newInvalidParamsError("invalid blockHash")
+	}
+
+	return strings.ToLower(hash), nil
+}
+
+// extractAddresses extracts the address filter, a single address or an array of them
+func extractAddresses(filter map[string]interface{}) ([]string, error) {
+	switch addr := filter["address"].(type) {
+	case nil:
+		return nil, nil // No address filter
+	case string:
+		if !common.IsHexAddress(addr) {
+			return nil, newInvalidParamsError("invalid address: %s", addr)
+		}
+		return []string{strings.ToLower(addr)}, nil
+	case []interface{}:
+		addresses := make([]string, 0, len(addr))
+		for _, item := range addr {
+			addrStr, ok := item.(string)
+			if !ok || !common.IsHexAddress(addrStr) {
+				return nil, newInvalidParamsError("invalid address: %v", item)
+			}
+			addresses = append(addresses, strings.ToLower(addrStr))
+		}
+		return addresses, nil
+	default:
+		return nil, newInvalidParamsError("invalid address format")
+	}
+}
+
+// extractTopics extracts the positional topics filter
+//
+// Each position is null (any topic), a single topic or an array of topics, any of
+// which must match. Null entries inside an array make the whole position a wildcard.
+func extractTopics(filter map[string]interface{}) ([][]string, error) {
+	raw, ok := filter["topics"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	positions, ok := raw.([]interface{})
+	if !ok {
+		return nil, newInvalidParamsError("invalid topics format")
+	}
+
+	if len(positions) > maxTopics {
+		return nil, newInvalidParamsError("too many topics: %d, at most %d are allowed", len(positions), maxTopics)
+	}
+
+	topics := make([][]string, len(positions))
+	for i, position := range positions {
+		switch topic := position.(type) {
+		case nil:
+		case string:
+			if !isHexHash(topic) {
+				return nil, newInvalidParamsError("invalid topic at position %d: %s", i, topic)
+			}
+			topics[i] = []string{strings.ToLower(topic)}
+		case []interface{}:
+			alternatives := make([]string, 0, len(topic))
+			for _, item := range topic {
+				if item == nil {
+					alternatives = nil
+					break
+				}
+
+				topicStr, ok := item.(string)
+				if !ok || !isHexHash(topicStr) {
+					return nil, newInvalidParamsError("invalid topic at position %d: %v", i, item)
+				}
+				alternatives = append(alternatives, strings.ToLower(topicStr))
+			}
+			topics[i] = alternatives
+		default:
+			return nil, newInvalidParamsError("invalid topic at position %d", i)
+		}
+	}
+
+	return topics, nil
+}
+
+// isHexHash reports whether s is a 0x-prefixed 32-byte hex string
+func isHexHash(s string) bool {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return false
+	}
+
+	s = s[2:]
+	if len(s) != 2*common.HashLength {
+		return false
+	}
+
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsFold reports whether values contains s, ignoring hex case
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
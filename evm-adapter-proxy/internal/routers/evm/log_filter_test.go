@@ -0,0 +1,170 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAddressA = "0x00000000000000000000000000000000000000aa"
+	testAddressB = "0x00000000000000000000000000000000000000bb"
+	testTopicA   = "0x000000000000000000000000000000000000000000000000000000000000000a"
+	testTopicB   = "0x000000000000000000000000000000000000000000000000000000000000000b"
+	testHash     = "0x1111111111111111111111111111111111111111111111111111111111111111"
+)
+
+// testFilterTip is the latest block number filters are parsed against
+const testFilterTip = 42
+
+func TestParseLogFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  map[string]interface{}
+		want    logFilter
+		wantErr string
+	}{
+		{
+			name:   "Empty filter",
+			filter: map[string]interface{}{},
+			want:   logFilter{ToBlock: ^uint64(0)},
+		},
+		{
+			name:   "Single address is lowercased",
+			filter: map[string]interface{}{"address": "0x00000000000000000000000000000000000000AA"},
+			want:   logFilter{ToBlock: ^uint64(0), Addresses: []string{testAddressA}},
+		},
+		{
+			name:   "Address array",
+			filter: map[string]interface{}{"address": []interface{}{testAddressA, testAddressB}},
+			want:   logFilter{ToBlock: ^uint64(0), Addresses: []string{testAddressA, testAddressB}},
+		},
+		{
+			name: "Topics with wildcards and OR-sets",
+			filter: map[string]interface{}{"topics": []interface{}{
+				testTopicA,
+				nil,
+				[]interface{}{testTopicA, testTopicB},
+				[]interface{}{testTopicA, nil},
+			}},
+			want: logFilter{ToBlock: ^uint64(0), Topics: [][]string{
+				{testTopicA},
+				nil,
+				{testTopicA, testTopicB},
+				nil,
+			}},
+		},
+		{
+			name:   "Block hash",
+			filter: map[string]interface{}{"blockHash": testHash},
+			want:   logFilter{ToBlock: ^uint64(0), BlockHash: testHash},
+		},
+		{
+			name:   "Numeric block range",
+			filter: map[string]interface{}{"fromBlock": "0x10", "toBlock": "0x20"},
+			want:   logFilter{FromBlock: 16, ToBlock: 32},
+		},
+		{
+			name:   "Latest fromBlock is the tip",
+			filter: map[string]interface{}{"fromBlock": "latest", "toBlock": "latest"},
+			want:   logFilter{FromBlock: testFilterTip, ToBlock: ^uint64(0)},
+		},
+		{
+			name:   "Safe and finalized fromBlock are the tip",
+			filter: map[string]interface{}{"fromBlock": "finalized", "toBlock": "safe"},
+			want:   logFilter{FromBlock: testFilterTip, ToBlock: ^uint64(0)},
+		},
+		{
+			name:   "Pending is the tip",
+			filter: map[string]interface{}{"fromBlock": "pending", "toBlock": "pending"},
+			want:   logFilter{FromBlock: testFilterTip, ToBlock: ^uint64(0)},
+		},
+		{
+			name:   "Earliest is block 0",
+			filter: map[string]interface{}{"fromBlock": "earliest", "toBlock": "earliest"},
+			want:   logFilter{},
+		},
+		{
+			name:    "Unknown block tag",
+			filter:  map[string]interface{}{"fromBlock": "newest"},
+			wantErr: "invalid fromBlock",
+		},
+		{
+			name:    "Block hash with block range",
+			filter:  map[string]interface{}{"blockHash": testHash, "fromBlock": "0x1"},
+			wantErr: "cannot specify both BlockHash and FromBlock/ToBlock",
+		},
+		{
+			name:    "Invalid block hash",
+			filter:  map[string]interface{}{"blockHash": "0x1234"},
+			wantErr: "invalid blockHash",
+		},
+		{
+			name:    "Invalid address",
+			filter:  map[string]interface{}{"address": "0x1234"},
+			wantErr: "invalid address",
+		},
+		{
+			name:    "Invalid address in array",
+			filter:  map[string]interface{}{"address": []interface{}{testAddressA, 1.0}},
+			wantErr: "invalid address",
+		},
+		{
+			name:    "Invalid topic",
+			filter:  map[string]interface{}{"topics": []interface{}{"0x1234"}},
+			wantErr: "invalid topic at position 0",
+		},
+		{
+			name:    "Too many topics",
+			filter:  map[string]interface{}{"topics": []interface{}{nil, nil, nil, nil, nil}},
+			wantErr: "too many topics",
+		},
+	}
+
+	latest := func() (uint64, error) { return testFilterTip, nil }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLogFilter(tt.filter, latest)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Equal(t, ErrCodeInvalidParams, toJSONRPCError(err).Code)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLogFilterMatches(t *testing.T) {
+	log := Log{
+		Address:   testAddressA,
+		Topics:    []string{testTopicA, testTopicB},
+		BlockHash: testHash,
+	}
+
+	tests := []struct {
+		name   string
+		filter logFilter
+		want   bool
+	}{
+		{name: "Empty filter", filter: logFilter{}, want: true},
+		{name: "Address match", filter: logFilter{Addresses: []string{testAddressB, testAddressA}}, want: true},
+		{name: "Address mismatch", filter: logFilter{Addresses: []string{testAddressB}}, want: false},
+		{name: "Wildcard then topic", filter: logFilter{Topics: [][]string{nil, {testTopicB}}}, want: true},
+		{name: "OR-set match", filter: logFilter{Topics: [][]string{{testTopicB, testTopicA}}}, want: true},
+		{name: "Topic mismatch", filter: logFilter{Topics: [][]string{{testTopicB}}}, want: false},
+		{name: "More topics than the log", filter: logFilter{Topics: [][]string{nil, nil, nil}}, want: false},
+		{name: "Block hash match", filter: logFilter{BlockHash: testHash}, want: true},
+		{name: "Block hash mismatch", filter: logFilter{BlockHash: "0x2222222222222222222222222222222222222222222222222222222222222222"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.matches(log))
+		})
+	}
+}
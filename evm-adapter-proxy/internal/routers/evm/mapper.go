@@ -0,0 +1,393 @@
+package evm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// Block types with a built-in mapper
+const (
+	// BlockTypeLogEntry is the block type of the logger canister
+	BlockTypeLogEntry = "log_entry"
+)
+
+// legacyBlockTypes maps the tx.op of ICRC-1/ICRC-2 blocks created before ICRC-3
+// introduced btype to their ICRC-3 block type
+var legacyBlockTypes = map[string]string{
+	"xfer":    "1xfer",
+	"mint":    "1mint",
+	"burn":    "1burn",
+	"approve": "2approve",
+}
+
+// ICRC3Block is a block returned by icrc3_get_blocks together with its index in the log
+type ICRC3Block struct {
+	ID    uint64
+	Value icpLogger.Value
+}
+
+// BlockMapper turns the ICRC-3 blocks of one block type into EVM blocks, transactions and logs
+//
+// Every entry of a block is a synthetic transaction, whose hash is computed by
+// entryTransactionHashes; the logs of an entry carry its transaction hash and index.
+type BlockMapper interface {
+	// MapBlock returns the EVM header of the block; its transactions are set by the registry
+	MapBlock(block ICRC3Block) (Block, error)
+	// MapTransactions returns the synthetic transactions of the block, one per entry
+	MapTransactions(block ICRC3Block) ([]Transaction, error)
+	// MapLogs returns every log of the block, in order
+	MapLogs(block ICRC3Block) ([]Log, error)
+}
+
+// MapperRegistry selects the BlockMapper of each block by its btype
+//
+// Blocks without a registered mapper are handled by the fallback mapper.
+type MapperRegistry struct {
+	mappers    map[string]BlockMapper
+	fallback   BlockMapper
+	headerFork HeaderFork
+}
+
+// NewMapperRegistry creates an empty registry
+//
+// Parameters:
+//   - fallback: Mapper used for block types without a registered mapper
+func NewMapperRegistry(fallback BlockMapper) *MapperRegistry {
+	return &MapperRegistry{
+		mappers:  make(map[string]BlockMapper),
+		fallback: fallback,
+	}
+}
+
+// Register sets the mapper of a block type, replacing any previous one
+func (m *MapperRegistry) Register(btype string, mapper BlockMapper) {
+	m.mappers[btype] = mapper
+}
+
+// SetHeaderFork sets the fork whose header shape mapped blocks advertise
+func (m *MapperRegistry) SetHeaderFork(fork HeaderFork) {
+	m.headerFork = fork
+}
+
+// newDefaultMapperRegistry returns a registry with the built-in mappers:
+//   - log_entry: logger canister blocks, where DEX mints and burns become ERC-20 Transfer logs
+//   - any other block type: the generic JSON encoding
+//
+// The addresses of long principals are recorded in principals, which may be nil.
+func newDefaultMapperRegistry(tokens *tokenRegistry, principals *principalBook) *MapperRegistry {
+	registry := NewMapperRegistry(&entryMapper{principals: principals})
+	registry.Register(BlockTypeLogEntry, &entryMapper{
+		operations: map[string]operationMapper{
+			OperationMintTokens: dexTransferMapper(tokens, principals),
+			OperationBurnTokens: dexTransferMapper(tokens, principals),
+		},
+		principals: principals,
+	})
+
+	return registry
+}
+
+// mapperFor returns the mapper of a block
+func (m *MapperRegistry) mapperFor(value icpLogger.Value) BlockMapper {
+	if mapper, ok := m.mappers[blockType(value)]; ok {
+		return mapper
+	}
+	return m.fallback
+}
+
+// mapBlock returns the EVM block of an ICRC-3 block, including its transaction hashes,
+// the bloom of its logs, the roots of its transactions and receipts, the header fields
+// of the configured fork and its size
+func (m *MapperRegistry) mapBlock(block ICRC3Block) (Block, error) {
+	mapper := m.mapperFor(block.Value)
+
+	evmBlock, err := mapper.MapBlock(block)
+	if err != nil {
+		return Block{}, err
+	}
+
+	transactions, err := mapper.MapTransactions(block)
+	if err != nil {
+		return Block{}, fmt.Errorf("failed to map transactions: %w", err)
+	}
+
+	logs, err := mapper.MapLogs(block)
+	if err != nil {
+		return Block{}, err
+	}
+
+	evmBlock.Transactions = make([]string, 0, len(transactions))
+	for _, tx := range transactions {
+		evmBlock.Transactions = append(evmBlock.Transactions, tx.Hash)
+	}
+
+	evmBlock.LogsBloom = logsBloom(logs)
+	evmBlock.TransactionsRoot = transactionsRoot(transactions)
+	evmBlock.ReceiptsRoot, err = receiptsRoot(blockReceipts(transactions, logs))
+	if err != nil {
+		return Block{}, err
+	}
+
+	if err := completeHeader(&evmBlock, transactions, m.headerFork); err != nil {
+		return Block{}, fmt.Errorf("failed to complete block header: %w", err)
+	}
+
+	return evmBlock, nil
+}
+
+// mapTransactions returns the synthetic transactions of an ICRC-3 block
+func (m *MapperRegistry) mapTransactions(block ICRC3Block) ([]Transaction, error) {
+	transactions, err := m.mapperFor(block.Value).MapTransactions(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// mapReceipts returns the receipts of the synthetic transactions of an ICRC-3 block,
+// each with the logs of its transaction
+func (m *MapperRegistry) mapReceipts(block ICRC3Block) ([]Receipt, error) {
+	mapper := m.mapperFor(block.Value)
+
+	transactions, err := mapper.MapTransactions(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map transactions: %w", err)
+	}
+
+	logs, err := mapper.MapLogs(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockReceipts(transactions, logs), nil
+}
+
+// mapLogs returns the logs of an ICRC-3 block matching the filter
+//
+// The filter block range is ignored; callers select the blocks.
+func (m *MapperRegistry) mapLogs(block ICRC3Block, criteria logFilter) ([]Log, error) {
+	logs, err := m.mapperFor(block.Value).MapLogs(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []Log
+	for _, log := range logs {
+		if criteria.matches(log) {
+			matching = append(matching, log)
+		}
+	}
+
+	return matching, nil
+}
+
+// blockType returns the btype of a block, or the ICRC-3 type of its tx.op for legacy
+// ICRC-1/ICRC-2 blocks; empty if neither is present
+func blockType(value icpLogger.Value) string {
+	if btype := lookupField(value, "btype"); btype != nil && btype.Text != nil {
+		return *btype.Text
+	}
+
+	if tx := lookupField(value, "tx"); tx != nil {
+		if op := lookupField(*tx, "op"); op != nil && op.Text != nil {
+			return legacyBlockTypes[*op.Text]
+		}
+	}
+
+	return ""
+}
+
+// lookupField returns the value of a key of a Map value, or nil
+func lookupField(value icpLogger.Value, key string) *icpLogger.Value {
+	if value.Map == nil {
+		return nil
+	}
+
+	for i := range *value.Map {
+		if (*value.Map)[i].Field0 == key {
+			return &(*value.Map)[i].Field1
+		}
+	}
+
+	return nil
+}
+
+// icrc3BlockHash returns the hash of a block: its hash field when the canister
+// provides one, otherwise its ICRC-3 representation-independent hash
+func icrc3BlockHash(value icpLogger.Value) (string, error) {
+	if lookupField(value, "hash") != nil {
+		return getBlockHash(value)
+	}
+
+	hash, err := hashValue(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash block: %w", err)
+	}
+
+	return "0x" + hex.EncodeToString(hash), nil
+}
+
+// mapBlockHeader converts the fields every ICRC-3 block shares (phash, ts) into an EVM header
+func mapBlockHeader(block ICRC3Block) (Block, error) {
+	header, err := mapBlockToEVMBlock(block.Value)
+	if err != nil {
+		return Block{}, fmt.Errorf("failed to map ICRC3 block to EVM block: %w", err)
+	}
+
+	header.Number = fmt.Sprintf("0x%x", block.ID)
+	header.Hash, err = icrc3BlockHash(block.Value)
+	if err != nil {
+		return Block{}, err
+	}
+
+	// The first block of a log has no parent
+	if header.ParentHash == "0x" {
+		header.ParentHash = "0x0000000000000000000000000000000000000000000000000000000000000000"
+	}
+
+	return header, nil
+}
+
+// loggerEntry is an entry of a logger canister block
+type loggerEntry struct {
+	Timestamp uint64
+	Operation string
+	Details   icpLogger.Value
+	Caller    string
+	// Value is the ICRC-3 value of the whole entry, hashed into its transaction hash
+	Value icpLogger.Value
+}
+
+// operationMapper sets the address, topics and data of the log of an entry
+//
+// Returns:
+//   - Log: The log with its address, topics and data set
+//   - bool: False to fall back to the generic JSON encoding of the entry
+//   - error: Any error that occurred during mapping
+type operationMapper func(entry loggerEntry) (Log, bool, error)
+
+// entryMapper is the generic BlockMapper: each entry of the block becomes a log
+// whose data is the JSON encoding of the entry, unless a mapper is registered for
+// its operation. Blocks without entries are treated as a single entry.
+type entryMapper struct {
+	operations map[string]operationMapper
+	principals *principalBook
+}
+
+// MapBlock implements BlockMapper
+func (e *entryMapper) MapBlock(block ICRC3Block) (Block, error) {
+	return mapBlockHeader(block)
+}
+
+// MapTransactions implements BlockMapper; each entry is sent by its caller to the
+// address of its log
+func (e *entryMapper) MapTransactions(block ICRC3Block) ([]Transaction, error) {
+	entries, err := blockEntries(block.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := e.MapLogs(block)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0, len(entries))
+	for i, entry := range entries {
+		from, err := e.principals.convertICPToEthAddress(entry.Caller) //nolint
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert ICP address to ETH address: %w", err)
+		}
+
+		transactions = append(transactions, newTransaction(logs[i], from, logs[i].Address))
+	}
+
+	return transactions, nil
+}
+
+// MapLogs implements BlockMapper
+func (e *entryMapper) MapLogs(block ICRC3Block) ([]Log, error) {
+	return extractLogsFromBlock(block, e.operations, e.principals)
+}
+
+// blockEntries returns the entries of a block; a block without an entries field
+// is a single entry of its block type with its tx (or itself) as details
+func blockEntries(value icpLogger.Value) ([]loggerEntry, error) {
+	if value.Map == nil {
+		return nil, fmt.Errorf("invalid block format: Map is nil")
+	}
+
+	entriesValue := lookupField(value, "entries")
+	if entriesValue == nil {
+		details := value
+		if tx := lookupField(value, "tx"); tx != nil {
+			details = *tx
+		}
+		return []loggerEntry{{Operation: blockType(value), Details: details, Value: details}}, nil
+	}
+
+	if entriesValue.Array == nil {
+		return nil, nil
+	}
+
+	entries := make([]loggerEntry, 0, len(*entriesValue.Array))
+	for _, entryValue := range *entriesValue.Array {
+		if entryValue.Map == nil {
+			continue
+		}
+
+		entry := loggerEntry{Value: entryValue}
+		for _, field := range *entryValue.Map {
+			switch field.Field0 {
+			case "timestamp":
+				if nat := field.Field1.Nat; nat != nil {
+					entry.Timestamp = nat.BigInt().Uint64()
+				}
+			case "operation":
+				if text := field.Field1.Text; text != nil {
+					entry.Operation = *text
+				}
+			case "details":
+				entry.Details = field.Field1
+			case "caller":
+				if text := field.Field1.Text; text != nil {
+					entry.Caller = *text
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// genericEntryLog encodes an entry as a log emitted by its caller whose data is the
+// JSON encoding of the operation and its details; long callers are recorded in principals
+func genericEntryLog(entry loggerEntry, principals *principalBook) (Log, error) {
+	address, err := principals.convertICPToEthAddress(entry.Caller) //nolint
+	if err != nil {
+		return Log{}, fmt.Errorf("failed to convert ICP address to ETH address: %w", err)
+	}
+
+	logData := LogData{
+		Operation: entry.Operation,
+		Detail: LogDataDetails{
+			Map: extractDetailMap(entry.Details),
+		},
+	}
+
+	logDataJSON, err := json.Marshal(logData)
+	if err != nil {
+		return Log{}, fmt.Errorf("failed to marshal log data: %w", err)
+	}
+
+	return Log{
+		Address: address,
+		Topics:  []string{"0x0000000000000000000000000000000000000000000000000000000000000000"},
+		Data:    fmt.Sprintf("0x%x", logDataJSON),
+	}, nil
+}
@@ -0,0 +1,108 @@
+package evm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// stubMapper returns fixed logs so tests can tell which mapper handled a block
+type stubMapper struct {
+	entryMapper
+	address string
+}
+
+func (s *stubMapper) MapLogs(_ ICRC3Block) ([]Log, error) {
+	return []Log{{Address: s.address}}, nil
+}
+
+func TestBlockType(t *testing.T) {
+	tests := []struct {
+		name  string
+		value icpLogger.Value
+		want  string
+	}{
+		{
+			name:  "Explicit btype",
+			value: mapValue(testMapEntry{Field0: "btype", Field1: textValue("1xfer")}),
+			want:  "1xfer",
+		},
+		{
+			name:  "Legacy ICRC-1 operation",
+			value: mapValue(testMapEntry{Field0: "tx", Field1: mapValue(testMapEntry{Field0: "op", Field1: textValue("approve")})}),
+			want:  "2approve",
+		},
+		{
+			name:  "Unknown legacy operation",
+			value: mapValue(testMapEntry{Field0: "tx", Field1: mapValue(testMapEntry{Field0: "op", Field1: textValue("other")})}),
+			want:  "",
+		},
+		{
+			name:  "No type",
+			value: mapValue(testMapEntry{Field0: "ts", Field1: natValue(1)}),
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, blockType(tt.value))
+		})
+	}
+}
+
+func TestMapperRegistryDispatch(t *testing.T) {
+	registry := NewMapperRegistry(&stubMapper{address: "fallback"})
+	registry.Register("1xfer", &stubMapper{address: "1xfer"})
+
+	xfer := ICRC3Block{ID: 1, Value: mapValue(testMapEntry{Field0: "btype", Field1: textValue("1xfer")})}
+	logs, err := registry.mapLogs(xfer, logFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "1xfer", logs[0].Address)
+
+	other := ICRC3Block{ID: 2, Value: mapValue(testMapEntry{Field0: "btype", Field1: textValue("custom")})}
+	logs, err = registry.mapLogs(other, logFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "fallback", logs[0].Address)
+}
+
+func TestGenericMapperBlockWithoutEntries(t *testing.T) {
+	value := mapValue(
+		testMapEntry{Field0: "btype", Field1: textValue("custom")},
+		testMapEntry{Field0: "ts", Field1: natValue(3e9)},
+		testMapEntry{Field0: "tx", Field1: mapValue(testMapEntry{Field0: "amt", Field1: natValue(7)})},
+	)
+	block := ICRC3Block{ID: 5, Value: value}
+	registry := newDefaultMapperRegistry(nil, nil)
+
+	hash, err := hashValue(value)
+	require.NoError(t, err)
+
+	evmBlock, err := registry.mapBlock(block)
+	require.NoError(t, err)
+	assert.Equal(t, "0x5", evmBlock.Number)
+	assert.Equal(t, "0x"+hex.EncodeToString(hash), evmBlock.Hash, "blocks without a hash field use the representation-independent hash")
+	assert.Equal(t, common.Hash{}.Hex(), evmBlock.ParentHash)
+	assert.Equal(t, "0x3", evmBlock.Timestamp)
+
+	logs, err := registry.mapLogs(block, logFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "0x5", logs[0].BlockNumber)
+	assert.Equal(t, evmBlock.Hash, logs[0].BlockHash)
+	assert.Equal(t, []string{logs[0].TxHash}, evmBlock.Transactions, "the block is a single transaction")
+
+	var data LogData
+	require.NoError(t, json.Unmarshal(common.FromHex(logs[0].Data), &data))
+	assert.Equal(t, "custom", data.Operation)
+	require.Len(t, data.Detail.Map, 1)
+	assert.Equal(t, "amt", data.Detail.Map[0].Field0)
+	assert.Equal(t, "7", *data.Detail.Map[0].Field1.Nat)
+}
@@ -0,0 +1,479 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	"go.uber.org/zap"
+)
+
+const (
+	// dexContractNamespace seeds the derivation of the default DEX pseudo contract address
+	dexContractNamespace = "icp-icrc3-evm-adapter:dex"
+	// dexEntryScanDepth is the number of blocks below the tip searched for the logger
+	// entry written by an executed transaction
+	dexEntryScanDepth = 20
+	// dexTransactionGas is the gas limit eth_estimateGas suggests; no gas is charged
+	dexTransactionGas = 100_000
+	// receiptStatusFailure is the status of submitted transactions the DEX canister rejects
+	receiptStatusFailure = "0x0"
+)
+
+// dexABIJSON is the interface of the DEX pseudo contract signed transactions are sent to
+const dexABIJSON = `[
+	{"type":"function","name":"mintTokens","stateMutability":"nonpayable","inputs":[{"name":"currency","type":"string"},{"name":"amount","type":"uint256"},{"name":"recipient","type":"address"}],"outputs":[]},
+	{"type":"function","name":"burnTokens","stateMutability":"nonpayable","inputs":[{"name":"currency","type":"string"},{"name":"amount","type":"uint256"},{"name":"owner","type":"address"}],"outputs":[]},
+	{"type":"function","name":"addCurrencyPair","stateMutability":"nonpayable","inputs":[{"name":"baseCurrency","type":"string"},{"name":"quoteCurrency","type":"string"}],"outputs":[]}
+]`
+
+// dexABI is the parsed dexABIJSON
+var dexABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(dexABIJSON))
+	if err != nil {
+		panic(fmt.Sprintf("invalid DEX ABI: %v", err))
+	}
+	return parsed
+}()
+
+// dexCall is a DEX update call decoded from the calldata of a signed transaction;
+// exactly one of Mint, Burn and Pair is set
+type dexCall struct {
+	Mint *icpDex.MintOperation
+	Burn *icpDex.BurnOperation
+	Pair *icpDex.CurrencyPair
+}
+
+// entryLocation is where the effects of an executed transaction are found: the block
+// and logs of the logger entry it wrote
+type entryLocation struct {
+	BlockHash        string
+	BlockNumber      string
+	TransactionIndex string
+	Logs             []Log
+	// EntryHash is the synthetic transaction hash of the entry, empty when none was found
+	EntryHash string
+}
+
+// dexContractAddress returns the configured DEX pseudo contract address, or the one
+// derived from dexContractNamespace
+func dexContractAddress(configured string) common.Address {
+	if configured != "" {
+		return common.HexToAddress(configured)
+	}
+
+	return common.BytesToAddress(crypto.Keccak256([]byte(dexContractNamespace)))
+}
+
+// EthSendRawTransaction implements the eth_sendRawTransaction RPC method
+// Executes a signed call to the DEX pseudo contract as a DEX canister update call
+//
+// Parameters:
+// - data: The signed legacy (EIP-155), EIP-2930 or EIP-1559 transaction, RLP-encoded in hex
+//
+// The sender must be a configured DEX operator and the nonce must be the next one of
+// the sender. Transactions rejected by these checks are not executed and do not use
+// their nonce; transactions the DEX canister rejects use it and get a receipt with
+// status 0x0. The nonce is used before the DEX canister is called: a call that fails,
+// e.g. on a timeout, may still have been executed, so the transaction cannot be sent
+// again and has no receipt.
+//
+// Returns the transaction hash, which eth_getTransactionByHash and
+// eth_getTransactionReceipt resolve once the call returned.
+func (r *evmRouter) EthSendRawTransaction(request JSONRPCRequest) (interface{}, error) {
+	params, ok := request.Params.([]interface{})
+	if !ok || len(params) < 1 {
+		return nil, newInvalidParamsError("invalid params for eth_sendRawTransaction")
+	}
+
+	encoded, ok := params[0].(string)
+	if !ok {
+		return nil, newInvalidParamsError("invalid transaction data")
+	}
+	raw, err := hexutil.Decode(encoded)
+	if err != nil {
+		return nil, newInvalidParamsError("invalid transaction data: %w", err)
+	}
+
+	chainID, err := r.chainID()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, sender, err := decodeSignedTransaction(raw, new(big.Int).SetUint64(chainID))
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := r.dexCallOf(tx, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	r.submissions.execMu.Lock()
+	defer r.submissions.execMu.Unlock()
+
+	next, err := r.submissions.nextNonce(sender)
+	if err != nil {
+		return nil, newInternalError("failed to read nonce of %s: %w", sender.Hex(), err)
+	}
+	if tx.Nonce() < next {
+		return nil, newRPCError(ErrCodeInvalidInput, "nonce too low: next nonce %d, tx nonce %d", next, tx.Nonce())
+	}
+	if tx.Nonce() > next {
+		return nil, newRPCError(ErrCodeInvalidInput, "nonce too high: next nonce %d, tx nonce %d", next, tx.Nonce())
+	}
+
+	if err := r.submissions.use(sender, tx.Nonce()); err != nil {
+		return nil, newInternalError("failed to use nonce of %s: %w", sender.Hex(), err)
+	}
+
+	rejection, err := r.executeDexCall(call)
+	if err != nil {
+		zap.S().Warnf("transaction %s may have been executed, its nonce %d is used: %v", tx.Hash().Hex(), tx.Nonce(), err)
+		return nil, err
+	}
+	if rejection != "" {
+		zap.S().Infof("transaction %s rejected by the DEX canister: %s", tx.Hash().Hex(), rejection)
+	}
+
+	location, err := r.locateDexEntry(call, rejection == "")
+	if err != nil {
+		return nil, err
+	}
+
+	record := newSubmission(tx, sender, location, rejection == "")
+	if err := r.submissions.add(sender, tx.Nonce(), record); err != nil {
+		return nil, newInternalError("transaction %s was executed but not recorded: %w", tx.Hash().Hex(), err)
+	}
+
+	return record.Transaction.Hash, nil
+}
+
+// EthGetTransactionCount implements the eth_getTransactionCount RPC method
+// Returns the nonce of the next transaction an address can submit with eth_sendRawTransaction
+//
+// Parameters:
+// - address: The address of the sender
+// - block: Ignored, only the current nonce is known
+func (r *evmRouter) EthGetTransactionCount(request JSONRPCRequest) (interface{}, error) {
+	params, ok := request.Params.([]interface{})
+	if !ok || len(params) < 1 {
+		return nil, newInvalidParamsError("invalid params for eth_getTransactionCount")
+	}
+
+	address, ok := params[0].(string)
+	if !ok || !common.IsHexAddress(address) {
+		return nil, newInvalidParamsError("invalid address")
+	}
+
+	nonce, err := r.submissions.nextNonce(common.HexToAddress(address))
+	if err != nil {
+		return nil, newInternalError("failed to read nonce of %s: %w", address, err)
+	}
+
+	return hexutil.EncodeUint64(nonce), nil
+}
+
+// EthGasPrice implements the eth_gasPrice RPC method
+// Returns zero, canister calls are not paid for by the sender
+func (r *evmRouter) EthGasPrice(_ JSONRPCRequest) (interface{}, error) {
+	return "0x0", nil
+}
+
+// EthMaxPriorityFeePerGas implements the eth_maxPriorityFeePerGas RPC method
+// Returns zero, canister calls are not paid for by the sender
+func (r *evmRouter) EthMaxPriorityFeePerGas(_ JSONRPCRequest) (interface{}, error) {
+	return "0x0", nil
+}
+
+// EthEstimateGas implements the eth_estimateGas RPC method
+// Returns a fixed gas limit; transactions are not metered, so any limit is enough
+func (r *evmRouter) EthEstimateGas(_ JSONRPCRequest) (interface{}, error) {
+	return hexutil.EncodeUint64(dexTransactionGas), nil
+}
+
+// decodeSignedTransaction decodes a signed transaction and recovers its sender
+//
+// Parameters:
+//   - raw: The RLP-encoded legacy transaction, or the typed transaction envelope
+//   - chainID: The chain ID the transaction must be signed for
+//
+// Returns:
+//   - *types.Transaction: The decoded transaction
+//   - common.Address: The sender recovered from the secp256k1 signature
+//   - error: An invalid params error for undecodable data, or an invalid input error
+//     for unsupported types, unprotected legacy transactions, other chains and
+//     invalid signatures
+func decodeSignedTransaction(raw []byte, chainID *big.Int) (*types.Transaction, common.Address, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return nil, common.Address{}, newInvalidParamsError("invalid transaction: %w", err)
+	}
+
+	switch tx.Type() {
+	case types.LegacyTxType, types.AccessListTxType, types.DynamicFeeTxType:
+	default:
+		return nil, common.Address{}, newRPCError(ErrCodeInvalidInput, "transaction type %d not supported", tx.Type())
+	}
+
+	if !tx.Protected() {
+		return nil, common.Address{}, newRPCError(ErrCodeInvalidInput, "only replay-protected (EIP-155) transactions are allowed")
+	}
+	if tx.ChainId().Cmp(chainID) != 0 {
+		return nil, common.Address{}, newRPCError(ErrCodeInvalidInput, "invalid chain ID %s, expected %s", tx.ChainId(), chainID)
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return nil, common.Address{}, newRPCError(ErrCodeInvalidInput, "invalid sender: %w", err)
+	}
+
+	return tx, sender, nil
+}
+
+// dexCallOf checks that a transaction is a call to the DEX pseudo contract by an
+// operator and decodes it
+func (r *evmRouter) dexCallOf(tx *types.Transaction, sender common.Address) (dexCall, error) {
+	if tx.To() == nil || *tx.To() != r.dexContract {
+		return dexCall{}, newRPCError(ErrCodeInvalidInput, "transactions can only call the DEX contract %s", r.dexContract.Hex())
+	}
+	if tx.Value().Sign() != 0 {
+		return dexCall{}, newRPCError(ErrCodeInvalidInput, "DEX calls cannot transfer value")
+	}
+	if !r.dexOperators[sender] {
+		return dexCall{}, newRPCError(ErrCodeTransactionRejected, "%s is not a DEX operator", sender.Hex())
+	}
+
+	return decodeDexCall(tx.Data(), r.principals)
+}
+
+// decodeDexCall decodes the calldata of a transaction to the DEX pseudo contract, with
+// its address arguments mapped back to principals through principals
+//
+// Returns:
+//   - dexCall: The DEX update call
+//   - error: An invalid input error for unknown functions, malformed arguments and
+//     addresses that do not map back to a principal
+func decodeDexCall(data []byte, principals *principalBook) (dexCall, error) {
+	if len(data) < 4 {
+		return dexCall{}, newRPCError(ErrCodeInvalidInput, "missing function selector")
+	}
+
+	method, err := dexABI.MethodById(data[:4])
+	if err != nil {
+		return dexCall{}, newRPCError(ErrCodeInvalidInput, "unsupported function selector %s", hexutil.Encode(data[:4]))
+	}
+
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return dexCall{}, newRPCError(ErrCodeInvalidInput, "invalid arguments for %s: %w", method.Name, err)
+	}
+
+	switch method.Name {
+	case "addCurrencyPair":
+		return dexCall{Pair: &icpDex.CurrencyPair{
+			BaseCurrency:  args[0].(string),
+			QuoteCurrency: args[1].(string),
+		}}, nil
+	default:
+		account := args[2].(common.Address)
+		owner, ok, err := principals.principalFromEthAddress(account)
+		if err != nil {
+			return dexCall{}, newInternalError("failed to map address %s: %w", account.Hex(), err)
+		}
+		if !ok {
+			return dexCall{}, newRPCError(ErrCodeInvalidInput, "address %s does not map to a principal", account.Hex())
+		}
+
+		amount := idl.NewBigNat(args[1].(*big.Int))
+		if method.Name == "mintTokens" {
+			return dexCall{Mint: &icpDex.MintOperation{Currency: args[0].(string), Amount: amount, Recipient: owner}}, nil
+		}
+		return dexCall{Burn: &icpDex.BurnOperation{Currency: args[0].(string), Amount: amount, Owner: owner}}, nil
+	}
+}
+
+// executeDexCall runs a DEX update call
+//
+// Returns:
+//   - string: The reason the DEX canister rejected the call, empty when it succeeded
+//   - error: Any error reaching the canister, in which case the call may not have run
+func (r *evmRouter) executeDexCall(call dexCall) (string, error) {
+	defer r.invalidateCache()
+
+	switch {
+	case call.Mint != nil:
+		result, err := r.dex.MintTokens(*call.Mint)
+		if err != nil {
+			return "", newCanisterError(err, "failed to mint tokens")
+		}
+		if result.Err != nil {
+			return *result.Err, nil
+		}
+	case call.Burn != nil:
+		result, err := r.dex.BurnTokens(*call.Burn)
+		if err != nil {
+			return "", newCanisterError(err, "failed to burn tokens")
+		}
+		if result.Err != nil {
+			return *result.Err, nil
+		}
+	case call.Pair != nil:
+		if err := r.dex.AddCurrencyPair(*call.Pair); err != nil {
+			return "", newCanisterError(err, "failed to add currency pair")
+		}
+	}
+
+	return "", nil
+}
+
+// matches reports whether a logger entry is the one the DEX canister writes for the call
+func (c dexCall) matches(entry loggerEntry) bool {
+	switch {
+	case c.Pair != nil:
+		text, ok := detailText(entry.Details)
+		return ok && entry.Operation == OperationAddCurrencyPair &&
+			strings.Contains(text, "base_currency: "+strconv.Quote(c.Pair.BaseCurrency)) &&
+			strings.Contains(text, "quote_currency: "+strconv.Quote(c.Pair.QuoteCurrency))
+	case c.Mint != nil:
+		operation, ok := parseDexOperation(entry.Operation, entry.Details)
+		return ok && operation.Operation == OperationMintTokens && operation.Currency == c.Mint.Currency &&
+			operation.Amount.Cmp(c.Mint.Amount.BigInt()) == 0 && operation.Account == c.Mint.Recipient.Encode()
+	case c.Burn != nil:
+		operation, ok := parseDexOperation(entry.Operation, entry.Details)
+		return ok && operation.Operation == OperationBurnTokens && operation.Currency == c.Burn.Currency &&
+			operation.Amount.Cmp(c.Burn.Amount.BigInt()) == 0 && operation.Account == c.Burn.Owner.Encode()
+	}
+
+	return false
+}
+
+// locateDexEntry finds the logger entry an executed call wrote among the
+// dexEntryScanDepth blocks below the tip, newest first, skipping entries already
+// attributed to another transaction
+//
+// Rejected calls, and calls whose entry is not found (e.g. because the logger call
+// failed or the blocks come from a ledger), are placed after the entries of the tip
+// block, without logs.
+func (r *evmRouter) locateDexEntry(call dexCall, executed bool) (entryLocation, error) {
+	tip, err := r.getLatestBlockNumber()
+	if err != nil {
+		return entryLocation{}, err
+	}
+
+	length := min(uint64(dexEntryScanDepth), tip+1)
+	page, err := r.getBlocks(tip+1-length, length)
+	if err != nil {
+		return entryLocation{}, newCanisterError(err, "failed to get blocks %d to %d", tip+1-length, tip)
+	}
+	if len(page.Blocks) == 0 {
+		return entryLocation{
+			BlockHash:        common.Hash{}.Hex(),
+			BlockNumber:      hexutil.EncodeUint64(tip),
+			TransactionIndex: "0x0",
+		}, nil
+	}
+
+	for b := len(page.Blocks) - 1; executed && b >= 0; b-- {
+		block := page.Blocks[b]
+		entries, err := blockEntries(block.Value)
+		if err != nil {
+			continue
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !call.matches(entries[i]) {
+				continue
+			}
+
+			receipts, err := r.mappers.mapReceipts(block)
+			if err != nil || i >= len(receipts) {
+				break
+			}
+			if r.submissions.isClaimed(receipts[i].TransactionHash) {
+				continue
+			}
+
+			return entryLocation{
+				BlockHash:        receipts[i].BlockHash,
+				BlockNumber:      receipts[i].BlockNumber,
+				TransactionIndex: receipts[i].TransactionIndex,
+				Logs:             receipts[i].Logs,
+				EntryHash:        receipts[i].TransactionHash,
+			}, nil
+		}
+	}
+
+	tipBlock := page.Blocks[len(page.Blocks)-1]
+	header, err := r.mappers.mapBlock(tipBlock)
+	if err != nil {
+		return entryLocation{}, newInternalError("failed to map block %d: %w", tipBlock.ID, err)
+	}
+
+	return entryLocation{
+		BlockHash:        header.Hash,
+		BlockNumber:      hexutil.EncodeUint64(tipBlock.ID),
+		TransactionIndex: hexutil.EncodeUint64(uint64(len(header.Transactions))),
+	}, nil
+}
+
+// newSubmission returns the transaction object and receipt of an executed transaction
+//
+// Parameters:
+//   - tx: The signed transaction
+//   - sender: Its recovered sender
+//   - location: The block and logs of the logger entry it wrote
+//   - succeeded: False when the DEX canister rejected the call
+func newSubmission(tx *types.Transaction, sender common.Address, location entryLocation, succeeded bool) submission {
+	v, r, s := tx.RawSignatureValues()
+	hash := tx.Hash().Hex()
+	transaction := Transaction{
+		BlockHash:        location.BlockHash,
+		BlockNumber:      location.BlockNumber,
+		From:             sender.Hex(),
+		Gas:              hexutil.EncodeUint64(tx.Gas()),
+		GasPrice:         hexutil.EncodeBig(tx.EffectiveGasTipValue(common.Big0)),
+		Hash:             hash,
+		Input:            hexutil.Encode(tx.Data()),
+		Nonce:            hexutil.EncodeUint64(tx.Nonce()),
+		To:               tx.To().Hex(),
+		TransactionIndex: location.TransactionIndex,
+		Value:            hexutil.EncodeBig(tx.Value()),
+		Type:             hexutil.EncodeUint64(uint64(tx.Type())),
+		V:                hexutil.EncodeBig(v),
+		R:                hexutil.EncodeBig(r),
+		S:                hexutil.EncodeBig(s),
+	}
+	if tx.Type() != types.LegacyTxType {
+		accessList := tx.AccessList()
+		transaction.ChainID = hexutil.EncodeBig(tx.ChainId())
+		transaction.AccessList = &accessList
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		transaction.MaxFeePerGas = hexutil.EncodeBig(tx.GasFeeCap())
+		transaction.MaxPriorityFeePerGas = hexutil.EncodeBig(tx.GasTipCap())
+	}
+
+	logs := make([]Log, 0, len(location.Logs))
+	for _, log := range location.Logs {
+		log.TxHash = hash
+		logs = append(logs, log)
+	}
+
+	receipt := newReceipt(transaction, logs)
+	receipt.EffectiveGasPrice = transaction.GasPrice
+	if !succeeded {
+		receipt.Status = receiptStatusFailure
+	}
+
+	return submission{Transaction: transaction, Receipt: receipt, EntryHash: location.EntryHash}
+}
@@ -0,0 +1,279 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+)
+
+var testChainID = big.NewInt(1337)
+
+// signTestTransaction signs a transaction to the default DEX contract
+func signTestTransaction(t *testing.T, key *ecdsa.PrivateKey, signer types.Signer, txType byte, data []byte) *types.Transaction {
+	to := dexContractAddress("")
+	var inner types.TxData
+	switch txType {
+	case types.LegacyTxType:
+		inner = &types.LegacyTx{Nonce: 3, GasPrice: big.NewInt(5), Gas: dexTransactionGas, To: &to, Value: new(big.Int), Data: data}
+	case types.AccessListTxType:
+		inner = &types.AccessListTx{ChainID: testChainID, Nonce: 3, GasPrice: big.NewInt(5), Gas: dexTransactionGas, To: &to, Value: new(big.Int), Data: data}
+	case types.DynamicFeeTxType:
+		inner = &types.DynamicFeeTx{ChainID: testChainID, Nonce: 3, GasTipCap: big.NewInt(2), GasFeeCap: big.NewInt(7), Gas: dexTransactionGas, To: &to, Value: new(big.Int), Data: data}
+	}
+
+	tx, err := types.SignNewTx(key, signer, inner)
+	require.NoError(t, err)
+	return tx
+}
+
+func encodeTestTransaction(t *testing.T, tx *types.Transaction) []byte {
+	raw, err := tx.MarshalBinary()
+	require.NoError(t, err)
+	return raw
+}
+
+func requireRPCCode(t *testing.T, err error, code int) {
+	var rpcErr *RPCError
+	require.True(t, errors.As(err, &rpcErr), "%v", err)
+	assert.Equal(t, code, rpcErr.Code)
+}
+
+func TestDecodeSignedTransaction(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.LatestSignerForChainID(testChainID)
+
+	for name, txType := range map[string]byte{
+		"Legacy":   types.LegacyTxType,
+		"EIP-2930": types.AccessListTxType,
+		"EIP-1559": types.DynamicFeeTxType,
+	} {
+		t.Run(name, func(t *testing.T) {
+			signed := signTestTransaction(t, key, signer, txType, []byte{1, 2, 3})
+
+			tx, from, err := decodeSignedTransaction(encodeTestTransaction(t, signed), testChainID)
+			require.NoError(t, err)
+			assert.Equal(t, sender, from)
+			assert.Equal(t, signed.Hash(), tx.Hash())
+			assert.Equal(t, txType, tx.Type())
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		unprotected := signTestTransaction(t, key, types.HomesteadSigner{}, types.LegacyTxType, nil)
+		otherChain := signTestTransaction(t, key, types.LatestSignerForChainID(big.NewInt(1)), types.LegacyTxType, nil)
+		blob, err := types.SignNewTx(key, types.NewCancunSigner(testChainID), &types.BlobTx{})
+		require.NoError(t, err)
+
+		tests := []struct {
+			name string
+			raw  []byte
+			code int
+		}{
+			{name: "Not RLP", raw: []byte{0x01, 0xff}, code: ErrCodeInvalidParams},
+			{name: "Unprotected legacy", raw: encodeTestTransaction(t, unprotected), code: ErrCodeInvalidInput},
+			{name: "Other chain", raw: encodeTestTransaction(t, otherChain), code: ErrCodeInvalidInput},
+			{name: "Blob transaction", raw: encodeTestTransaction(t, blob), code: ErrCodeInvalidInput},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, _, err := decodeSignedTransaction(tt.raw, testChainID)
+				requireRPCCode(t, err, tt.code)
+			})
+		}
+	})
+}
+
+func TestDecodeDexCall(t *testing.T) {
+	owner, err := principal.Decode(testCallerA)
+	require.NoError(t, err)
+	ownerAddress, err := convertICPToEthAddress(testCallerA)
+	require.NoError(t, err)
+
+	pack := func(method string, args ...interface{}) []byte {
+		data, err := dexABI.Pack(method, args...)
+		require.NoError(t, err)
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    dexCall
+		wantErr bool
+	}{
+		{
+			name: "Mint",
+			data: pack("mintTokens", "USD", big.NewInt(100), common.HexToAddress(ownerAddress)),
+			want: dexCall{Mint: &icpDex.MintOperation{Currency: "USD", Amount: idl.NewNat(uint64(100)), Recipient: owner}},
+		},
+		{
+			name: "Burn",
+			data: pack("burnTokens", "EUR", big.NewInt(7), common.HexToAddress(ownerAddress)),
+			want: dexCall{Burn: &icpDex.BurnOperation{Currency: "EUR", Amount: idl.NewNat(uint64(7)), Owner: owner}},
+		},
+		{
+			name: "Add currency pair",
+			data: pack("addCurrencyPair", "USD", "EUR"),
+			want: dexCall{Pair: &icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"}},
+		},
+		{name: "Missing selector", data: []byte{0x01}, wantErr: true},
+		{name: "Unknown selector", data: crypto.Keccak256([]byte("transfer(address,uint256)"))[:4], wantErr: true},
+		{name: "Missing arguments", data: dexABI.Methods["mintTokens"].ID, wantErr: true},
+		{name: "Address without principal", data: pack("mintTokens", "USD", big.NewInt(1), common.HexToAddress("0xaa")), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeDexCall(tt.data, nil)
+			if tt.wantErr {
+				requireRPCCode(t, err, ErrCodeInvalidInput)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want.Mint != nil {
+				require.NotNil(t, got.Mint)
+				assert.Equal(t, tt.want.Mint.Amount.BigInt(), got.Mint.Amount.BigInt())
+				got.Mint.Amount, tt.want.Mint.Amount = idl.Nat{}, idl.Nat{}
+			}
+			if tt.want.Burn != nil {
+				require.NotNil(t, got.Burn)
+				assert.Equal(t, tt.want.Burn.Amount.BigInt(), got.Burn.Amount.BigInt())
+				got.Burn.Amount, tt.want.Burn.Amount = idl.Nat{}, idl.Nat{}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDexCallOf(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := types.LatestSignerForChainID(testChainID)
+	operator := crypto.PubkeyToAddress(key.PublicKey)
+	data, err := dexABI.Pack("addCurrencyPair", "USD", "EUR")
+	require.NoError(t, err)
+
+	router := &evmRouter{dexContract: dexContractAddress(""), dexOperators: map[common.Address]bool{operator: true}}
+	tx := signTestTransaction(t, key, signer, types.DynamicFeeTxType, data)
+
+	call, err := router.dexCallOf(tx, operator)
+	require.NoError(t, err)
+	assert.Equal(t, &icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"}, call.Pair)
+
+	_, err = router.dexCallOf(tx, common.HexToAddress("0xaa"))
+	requireRPCCode(t, err, ErrCodeTransactionRejected)
+
+	other := &evmRouter{dexContract: common.HexToAddress("0xdd"), dexOperators: router.dexOperators}
+	_, err = other.dexCallOf(tx, operator)
+	requireRPCCode(t, err, ErrCodeInvalidInput)
+
+	to := dexContractAddress("")
+	withValue, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{ChainID: testChainID, To: &to, Value: big.NewInt(1), Data: data})
+	require.NoError(t, err)
+	_, err = router.dexCallOf(withValue, operator)
+	requireRPCCode(t, err, ErrCodeInvalidInput)
+}
+
+func TestDexCallMatches(t *testing.T) {
+	recipient, err := principal.Decode(testRecipient)
+	require.NoError(t, err)
+	mint := dexCall{Mint: &icpDex.MintOperation{Currency: "USD", Amount: idl.NewNat(uint64(1000)), Recipient: recipient}}
+	burn := dexCall{Burn: &icpDex.BurnOperation{Currency: "USD", Amount: idl.NewNat(uint64(1000)), Owner: recipient}}
+	pair := dexCall{Pair: &icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"}}
+
+	entry := func(operation, debug string) loggerEntry {
+		entries, err := blockEntries(newDexBlock(0, operation, debug).Value)
+		require.NoError(t, err)
+		return entries[0]
+	}
+	minted := entry(OperationMintTokens, `MintOperation { currency: "USD", amount: Nat(1_000), recipient: Principal(`+testRecipient+`) }`)
+	added := entry(OperationAddCurrencyPair, `CurrencyPair { base_currency: "USD", quote_currency: "EUR" }`)
+
+	assert.True(t, mint.matches(minted))
+	assert.False(t, burn.matches(minted), "other operation")
+	assert.False(t, pair.matches(minted))
+	assert.True(t, pair.matches(added))
+	assert.False(t, dexCall{Pair: &icpDex.CurrencyPair{BaseCurrency: "EUR", QuoteCurrency: "USD"}}.matches(added), "other pair")
+
+	otherAmount := dexCall{Mint: &icpDex.MintOperation{Currency: "USD", Amount: idl.NewNat(uint64(999)), Recipient: recipient}}
+	assert.False(t, otherAmount.matches(minted))
+}
+
+func TestNewSubmission(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.LatestSignerForChainID(testChainID)
+
+	location := entryLocation{
+		BlockHash:        common.HexToHash("0x0b").Hex(),
+		BlockNumber:      "0x4",
+		TransactionIndex: "0x1",
+		Logs:             []Log{{Address: "0x00000000000000000000000000000000000000cc", Topics: []string{TransferEventTopic.Hex()}, Data: "0x", TxHash: "0x01"}},
+		EntryHash:        "0x01",
+	}
+
+	for name, txType := range map[string]byte{
+		"Legacy":   types.LegacyTxType,
+		"EIP-2930": types.AccessListTxType,
+		"EIP-1559": types.DynamicFeeTxType,
+	} {
+		t.Run(name, func(t *testing.T) {
+			signed := signTestTransaction(t, key, signer, txType, []byte{1, 2, 3})
+			record := newSubmission(signed, sender, location, true)
+
+			// Clients decode the transaction object back into the signed transaction
+			encoded, err := json.Marshal(record.Transaction)
+			require.NoError(t, err)
+			var decoded types.Transaction
+			require.NoError(t, json.Unmarshal(encoded, &decoded))
+			assert.Equal(t, signed.Hash(), decoded.Hash())
+
+			from, err := types.Sender(signer, &decoded)
+			require.NoError(t, err)
+			assert.Equal(t, sender, from)
+
+			assert.Equal(t, signed.Hash().Hex(), record.Receipt.TransactionHash)
+			assert.Equal(t, "0x4", record.Receipt.BlockNumber)
+			assert.Equal(t, receiptStatusSuccess, record.Receipt.Status)
+			require.Len(t, record.Receipt.Logs, 1)
+			assert.Equal(t, signed.Hash().Hex(), record.Receipt.Logs[0].TxHash)
+			assert.Equal(t, "0x01", record.EntryHash)
+		})
+	}
+
+	t.Run("Rejected", func(t *testing.T) {
+		signed := signTestTransaction(t, key, signer, types.DynamicFeeTxType, nil)
+		record := newSubmission(signed, sender, entryLocation{BlockHash: location.BlockHash, BlockNumber: "0x4", TransactionIndex: "0x2"}, false)
+		assert.Equal(t, receiptStatusFailure, record.Receipt.Status)
+		assert.Equal(t, "0x2", record.Receipt.EffectiveGasPrice, "the tip is paid without a base fee")
+		assert.Empty(t, record.Receipt.Logs)
+	})
+}
+
+func TestEthGetTransactionCount(t *testing.T) {
+	sender := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	router := &evmRouter{submissions: newSubmissionRegistry(nil)}
+	require.NoError(t, router.submissions.add(sender, 0, submission{Transaction: Transaction{Hash: common.Hash{1}.Hex()}}))
+
+	count, err := router.EthGetTransactionCount(JSONRPCRequest{Params: []interface{}{sender.Hex(), "latest"}})
+	require.NoError(t, err)
+	assert.Equal(t, "0x1", count)
+
+	_, err = router.EthGetTransactionCount(JSONRPCRequest{Params: []interface{}{"0x1234"}})
+	requireRPCCode(t, err, ErrCodeInvalidParams)
+}
@@ -0,0 +1,594 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+)
+
+// newFakeRouter creates a router over fake sources, the way NewEVMRouter does
+func newFakeRouter(t *testing.T, sources routerSources, cfg conf.RouterConfig) *evmRouter {
+	router, err := newEVMRouter(sources, cfg, nil, nil)
+	require.NoError(t, err)
+	return router
+}
+
+// loggerSources are the sources of a logger canister, with a DEX unless dex is nil
+func loggerSources(log *fakeLedger, dex *fakeDex) routerSources {
+	sources := routerSources{blocks: log, tips: log, chain: log}
+	if dex != nil {
+		sources.dex = dex
+	}
+	return sources
+}
+
+// ledgerSources are the sources of an ICRC-1 ledger without a DEX
+func ledgerSources(log *fakeLedger) routerSources {
+	return routerSources{blocks: log, tips: log, ledger: log}
+}
+
+// methodRecorder is the set of methods a router served through rpcCall
+type methodRecorder struct {
+	mu      sync.Mutex
+	methods map[string]bool
+}
+
+// calledMethods records the methods called by the suites, for TestRPCMethodsCovered
+var calledMethods = &methodRecorder{methods: map[string]bool{}}
+
+func (m *methodRecorder) record(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.methods[method] = true
+}
+
+func (m *methodRecorder) has(method string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.methods[method]
+}
+
+func (m *methodRecorder) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.methods = map[string]bool{}
+}
+
+// rpcCall sends a request through the JSON-RPC dispatch of a router, recording the
+// method in calledMethods when the router serves it
+//
+// Returns:
+//   - json.RawMessage: The result, null for a nil result
+//   - *JSONRPCError: The error of the response, nil on success
+func rpcCall(t *testing.T, router *evmRouter, method string, params ...any) (json.RawMessage, *JSONRPCError) {
+	t.Helper()
+
+	if params == nil {
+		params = []any{}
+	}
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(router.processMessage(context.Background(), router.methodHandlers, body))
+	require.NoError(t, err)
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &response))
+	if response.Error == nil || response.Error.Code != ErrCodeMethodNotFound {
+		calledMethods.record(method)
+	}
+
+	return response.Result, response.Error
+}
+
+// rpcResult sends a request that must succeed and decodes its result into v
+func rpcResult(t *testing.T, router *evmRouter, v any, method string, params ...any) {
+	t.Helper()
+
+	result, rpcErr := rpcCall(t, router, method, params...)
+	require.Nil(t, rpcErr, "%s: %+v", method, rpcErr)
+	require.NoError(t, json.Unmarshal(result, v))
+}
+
+// rpcError sends a request that must fail with the given code
+func rpcError(t *testing.T, router *evmRouter, code int, method string, params ...any) {
+	t.Helper()
+
+	_, rpcErr := rpcCall(t, router, method, params...)
+	require.NotNil(t, rpcErr, method)
+	assert.Equal(t, code, rpcErr.Code, rpcErr.Message)
+}
+
+// dexTestChain is a logger canister log written by a fake DEX: mints of 1000 USD and
+// 300 EUR to testRecipient around a block of two generic entries
+func dexTestChain(t *testing.T) (*fakeLedger, *fakeDex) {
+	log := newFakeLedger()
+	dex := newFakeDex(log, icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"})
+	recipient := principal.MustDecode(testRecipient)
+
+	_, err := dex.MintTokens(icpDex.MintOperation{Currency: "USD", Amount: idl.NewNat(uint64(1000)), Recipient: recipient})
+	require.NoError(t, err)
+	log.appendEntries(testEntry(testCallerA, 1e9), testEntry(testCallerB, 1e9))
+	_, err = dex.MintTokens(icpDex.MintOperation{Currency: "EUR", Amount: idl.NewNat(uint64(300)), Recipient: recipient})
+	require.NoError(t, err)
+
+	return log, dex
+}
+
+func TestRPCMethodsLogger(t *testing.T) {
+	log, dex := dexTestChain(t)
+	operatorKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	operator := crypto.PubkeyToAddress(operatorKey.PublicKey)
+	router := newFakeRouter(t, loggerSources(log, dex), conf.RouterConfig{
+		NativeCurrency:     "USD",
+		DexOperators:       []string{operator.Hex()},
+		UnsignedDexMethods: true,
+	})
+	recipient := principalToEthAddress(principal.MustDecode(testRecipient))
+	usdToken := router.tokens.address("USD")
+
+	t.Run("eth_chainId", func(t *testing.T) {
+		var chainID string
+		rpcResult(t, router, &chainID, "eth_chainId")
+		assert.Equal(t, "0x539", chainID)
+	})
+
+	t.Run("net_version", func(t *testing.T) {
+		var netVersion string
+		rpcResult(t, router, &netVersion, "net_version")
+		assert.Equal(t, "1337", netVersion)
+	})
+
+	t.Run("eth_blockNumber", func(t *testing.T) {
+		var number string
+		rpcResult(t, router, &number, "eth_blockNumber")
+		assert.Equal(t, "0x2", number)
+	})
+
+	t.Run("eth_getBlockByNumber", func(t *testing.T) {
+		var block Block
+		rpcResult(t, router, &block, "eth_getBlockByNumber", "0x1", false)
+		assert.Equal(t, "0x1", block.Number)
+		assert.Equal(t, log.hash(1), block.Hash)
+		assert.Equal(t, log.hash(0), block.ParentHash)
+		assert.Len(t, block.Transactions, 2)
+
+		rpcResult(t, router, &block, "eth_getBlockByNumber", "latest", false)
+		assert.Equal(t, "0x2", block.Number)
+
+		var full BlockWithTransactions
+		rpcResult(t, router, &full, "eth_getBlockByNumber", "0x1", true)
+		require.Len(t, full.Transactions, 2)
+		assert.Equal(t, block.Number, "0x2")
+		assert.Equal(t, "0x1", full.Transactions[1].TransactionIndex)
+
+		rpcResult(t, router, &block, "eth_getBlockByNumber", "earliest", false)
+		assert.Equal(t, "0x0", block.Number)
+		rpcResult(t, router, &block, "eth_getBlockByNumber", "pending", false)
+		assert.Equal(t, "0x2", block.Number)
+
+		result, rpcErr := rpcCall(t, router, "eth_getBlockByNumber", "0x3", false)
+		require.Nil(t, rpcErr)
+		assert.JSONEq(t, "null", string(result), "past the tip")
+		rpcError(t, router, ErrCodeInvalidParams, "eth_getBlockByNumber", "0xzz", false)
+		rpcError(t, router, ErrCodeInvalidParams, "eth_getBlockByNumber")
+	})
+
+	t.Run("eth_getBlockByHash", func(t *testing.T) {
+		var block Block
+		rpcResult(t, router, &block, "eth_getBlockByHash", log.hash(2), false)
+		assert.Equal(t, "0x2", block.Number)
+
+		result, rpcErr := rpcCall(t, router, "eth_getBlockByHash", common.Hash{1}.Hex(), false)
+		require.Nil(t, rpcErr)
+		assert.JSONEq(t, "null", string(result), "unknown hash")
+
+		rpcError(t, router, ErrCodeInvalidParams, "eth_getBlockByHash", "0xnothex", false)
+	})
+
+	var entryTx Transaction
+	t.Run("eth_getTransactionByHash", func(t *testing.T) {
+		var full BlockWithTransactions
+		rpcResult(t, router, &full, "eth_getBlockByNumber", "0x1", true)
+
+		rpcResult(t, router, &entryTx, "eth_getTransactionByHash", full.Transactions[1].Hash)
+		assert.Equal(t, full.Transactions[1], entryTx)
+
+		result, rpcErr := rpcCall(t, router, "eth_getTransactionByHash", common.Hash{2}.Hex())
+		require.Nil(t, rpcErr)
+		assert.JSONEq(t, "null", string(result))
+	})
+
+	t.Run("eth_getTransactionReceipt", func(t *testing.T) {
+		var receipt Receipt
+		rpcResult(t, router, &receipt, "eth_getTransactionReceipt", entryTx.Hash)
+		assert.Equal(t, entryTx.Hash, receipt.TransactionHash)
+		assert.Equal(t, log.hash(1), receipt.BlockHash)
+		assert.Equal(t, receiptStatusSuccess, receipt.Status)
+		assert.Len(t, receipt.Logs, 1)
+
+		result, rpcErr := rpcCall(t, router, "eth_getTransactionReceipt", common.Hash{2}.Hex())
+		require.Nil(t, rpcErr)
+		assert.JSONEq(t, "null", string(result))
+	})
+
+	t.Run("eth_getBlockReceipts", func(t *testing.T) {
+		var receipts []Receipt
+		rpcResult(t, router, &receipts, "eth_getBlockReceipts", "0x0")
+		require.Len(t, receipts, 1)
+		require.Len(t, receipts[0].Logs, 1)
+		assert.Equal(t, TransferEventTopic.Hex(), receipts[0].Logs[0].Topics[0])
+
+		rpcResult(t, router, &receipts, "eth_getBlockReceipts", log.hash(1))
+		assert.Len(t, receipts, 2)
+
+		result, rpcErr := rpcCall(t, router, "eth_getBlockReceipts", "0x7")
+		require.Nil(t, rpcErr)
+		assert.JSONEq(t, "null", string(result), "block past the tip")
+	})
+
+	t.Run("eth_getLogs", func(t *testing.T) {
+		var logs []Log
+		rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"fromBlock": "0x0", "toBlock": "latest"})
+		assert.Len(t, logs, 4)
+
+		rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"address": usdToken.Hex()})
+		require.Len(t, logs, 1)
+		assert.Equal(t, "0x0", logs[0].BlockNumber)
+
+		rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"blockHash": log.hash(1)})
+		assert.Len(t, logs, 2)
+
+		var tip string
+		rpcResult(t, router, &tip, "eth_blockNumber")
+		rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"fromBlock": "latest"})
+		require.NotEmpty(t, logs)
+		for _, l := range logs {
+			assert.Equal(t, tip, l.BlockNumber, "latest is the tip, not the whole chain")
+		}
+
+		rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"fromBlock": "earliest", "toBlock": "earliest"})
+		require.Len(t, logs, 1)
+		assert.Equal(t, "0x0", logs[0].BlockNumber)
+
+		rpcError(t, router, ErrCodeInvalidParams, "eth_getLogs", "not a filter")
+	})
+
+	t.Run("eth_getBalance", func(t *testing.T) {
+		var balance string
+		rpcResult(t, router, &balance, "eth_getBalance", recipient.Hex(), "latest")
+		assert.Equal(t, "0x3e8", balance)
+
+		rpcResult(t, router, &balance, "eth_getBalance", common.Address{9}.Hex(), "latest")
+		assert.Equal(t, "0x0", balance, "address without a principal")
+
+		rpcError(t, router, ErrCodeInvalidParams, "eth_getBalance", "0x12")
+	})
+
+	t.Run("eth_call", func(t *testing.T) {
+		data, err := erc20ABI.Pack("balanceOf", recipient)
+		require.NoError(t, err)
+
+		var result string
+		rpcResult(t, router, &result, "eth_call", map[string]any{"to": usdToken.Hex(), "data": hexutil.Encode(data)}, "latest")
+		assert.Equal(t, hexutil.Encode(common.LeftPadBytes(big.NewInt(1000).Bytes(), 32)), result)
+
+		rpcResult(t, router, &result, "eth_call", map[string]any{"to": common.Address{9}.Hex(), "data": "0x"}, "latest")
+		assert.Equal(t, "0x", result, "account without code")
+	})
+
+	t.Run("eth_getCurrencyPairs", func(t *testing.T) {
+		var pairs []icpDex.CurrencyPair
+		rpcResult(t, router, &pairs, "eth_getCurrencyPairs")
+		assert.Equal(t, []icpDex.CurrencyPair{{BaseCurrency: "USD", QuoteCurrency: "EUR"}}, pairs)
+	})
+
+	t.Run("eth_mintTokens", func(t *testing.T) {
+		var result any
+		rpcResult(t, router, &result, "eth_mintTokens", map[string]any{"currency": "USD", "amount": "0x10", "recipient": recipient.Hex()})
+
+		var balance string
+		rpcResult(t, router, &balance, "eth_getBalance", recipient.Hex(), "latest")
+		assert.Equal(t, "0x3f8", balance)
+
+		rpcError(t, router, ErrCodeInvalidParams, "eth_mintTokens", map[string]any{"currency": "USD", "amount": "0x10", "recipient": "0x12"})
+	})
+
+	t.Run("eth_burnTokens", func(t *testing.T) {
+		var result any
+		rpcResult(t, router, &result, "eth_burnTokens", map[string]any{"currency": "USD", "amount": "0x10", "owner": recipient.Hex()})
+
+		var balance string
+		rpcResult(t, router, &balance, "eth_getBalance", recipient.Hex(), "latest")
+		assert.Equal(t, "0x3e8", balance)
+
+		_, rpcErr := rpcCall(t, router, "eth_burnTokens", map[string]any{"currency": "USD", "amount": "0x100000", "owner": recipient.Hex()})
+		require.NotNil(t, rpcErr, "burning more than the balance")
+	})
+
+	t.Run("eth_sendRawTransaction", func(t *testing.T) {
+		var nonce string
+		rpcResult(t, router, &nonce, "eth_getTransactionCount", operator.Hex(), "latest")
+		require.Equal(t, "0x0", nonce)
+
+		data, err := dexABI.Pack("mintTokens", "EUR", big.NewInt(5), recipient)
+		require.NoError(t, err)
+		to := router.dexContract
+		tx, err := types.SignNewTx(operatorKey, types.LatestSignerForChainID(big.NewInt(1337)),
+			&types.DynamicFeeTx{ChainID: big.NewInt(1337), Gas: dexTransactionGas, To: &to, Data: data})
+		require.NoError(t, err)
+		raw, err := tx.MarshalBinary()
+		require.NoError(t, err)
+
+		var hash string
+		rpcResult(t, router, &hash, "eth_sendRawTransaction", hexutil.Encode(raw))
+		assert.Equal(t, tx.Hash().Hex(), hash)
+
+		var receipt Receipt
+		rpcResult(t, router, &receipt, "eth_getTransactionReceipt", hash)
+		assert.Equal(t, receiptStatusSuccess, receipt.Status)
+		require.Len(t, receipt.Logs, 1, "the logs of the entry the mint wrote")
+		assert.Equal(t, router.tokens.address("EUR").Hex(), receipt.Logs[0].Address)
+
+		var sent Transaction
+		rpcResult(t, router, &sent, "eth_getTransactionByHash", hash)
+		assert.Equal(t, operator.Hex(), sent.From)
+
+		rpcResult(t, router, &nonce, "eth_getTransactionCount", operator.Hex(), "latest")
+		assert.Equal(t, "0x1", nonce)
+
+		rpcError(t, router, ErrCodeInvalidInput, "eth_sendRawTransaction", hexutil.Encode(raw))
+
+		// A failed call may have been executed, its nonce is used all the same
+		dex.err = errors.New("request timed out")
+		tx, err = types.SignNewTx(operatorKey, types.LatestSignerForChainID(big.NewInt(1337)),
+			&types.DynamicFeeTx{ChainID: big.NewInt(1337), Nonce: 1, Gas: dexTransactionGas, To: &to, Data: data})
+		require.NoError(t, err)
+		raw, err = tx.MarshalBinary()
+		require.NoError(t, err)
+		rpcError(t, router, ErrCodeInternal, "eth_sendRawTransaction", hexutil.Encode(raw))
+
+		rpcResult(t, router, &nonce, "eth_getTransactionCount", operator.Hex(), "latest")
+		assert.Equal(t, "0x2", nonce)
+
+		dex.err = nil
+		rpcError(t, router, ErrCodeInvalidInput, "eth_sendRawTransaction", hexutil.Encode(raw))
+	})
+
+	t.Run("eth_getTransactionCount", func(t *testing.T) {
+		var nonce string
+		rpcResult(t, router, &nonce, "eth_getTransactionCount", common.Address{9}.Hex(), "latest")
+		assert.Equal(t, "0x0", nonce)
+
+		rpcError(t, router, ErrCodeInvalidParams, "eth_getTransactionCount", "0x12")
+	})
+
+	t.Run("Gas methods", func(t *testing.T) {
+		for method, want := range map[string]string{
+			"eth_gasPrice":             "0x0",
+			"eth_maxPriorityFeePerGas": "0x0",
+			"eth_estimateGas":          hexutil.EncodeUint64(dexTransactionGas),
+		} {
+			var result string
+			rpcResult(t, router, &result, method, map[string]any{})
+			assert.Equal(t, want, result, method)
+		}
+	})
+
+	t.Run("Log filters", func(t *testing.T) {
+		var logFilterID, blockFilterID string
+		rpcResult(t, router, &logFilterID, "eth_newFilter", map[string]any{"fromBlock": "0x0"})
+		rpcResult(t, router, &blockFilterID, "eth_newBlockFilter")
+
+		var logs []Log
+		rpcResult(t, router, &logs, "eth_getFilterLogs", logFilterID)
+		assert.NotEmpty(t, logs)
+
+		var hashes []string
+		rpcResult(t, router, &hashes, "eth_getFilterChanges", blockFilterID)
+		assert.Empty(t, hashes)
+
+		id := log.appendEntries(testEntry(testCallerA, 5e9))
+		rpcResult(t, router, &hashes, "eth_getFilterChanges", blockFilterID)
+		assert.Equal(t, []string{log.hash(id)}, hashes)
+
+		rpcResult(t, router, &logs, "eth_getFilterChanges", logFilterID)
+		require.Len(t, logs, 1, "the logs of the blocks added since the filter was installed")
+		assert.Equal(t, hexutil.EncodeUint64(id), logs[0].BlockNumber)
+		rpcResult(t, router, &logs, "eth_getFilterChanges", logFilterID)
+		assert.Empty(t, logs)
+
+		var uninstalled bool
+		rpcResult(t, router, &uninstalled, "eth_uninstallFilter", logFilterID)
+		assert.True(t, uninstalled)
+		rpcResult(t, router, &uninstalled, "eth_uninstallFilter", logFilterID)
+		assert.False(t, uninstalled)
+		rpcError(t, router, ErrCodeInvalidInput, "eth_getFilterChanges", logFilterID)
+	})
+
+	t.Run("eth_getLedgerMetadata", func(t *testing.T) {
+		rpcError(t, router, ErrCodeMethodNotSupported, "eth_getLedgerMetadata")
+	})
+
+	t.Run("Node methods", func(t *testing.T) {
+		var accounts []string
+		rpcResult(t, router, &accounts, "eth_accounts")
+		assert.Empty(t, accounts)
+
+		var version string
+		rpcResult(t, router, &version, "web3_clientVersion")
+		assert.NotEmpty(t, version)
+
+		var hash string
+		rpcResult(t, router, &hash, "web3_sha3", "0x68656c6c6f")
+		assert.Equal(t, crypto.Keccak256Hash([]byte("hello")).Hex(), hash)
+		rpcError(t, router, ErrCodeInvalidParams, "web3_sha3", "0xzz")
+
+		var listening bool
+		rpcResult(t, router, &listening, "net_listening")
+		assert.True(t, listening)
+
+		var peers string
+		rpcResult(t, router, &peers, "net_peerCount")
+		assert.Equal(t, "0x1", peers)
+	})
+}
+
+func TestRPCMethodsLedger(t *testing.T) {
+	const alice, bob = "rrkah-fqaaa-aaaaa-aaaaq-cai", "ryjl3-tyaaa-aaaaa-aaaba-cai"
+	log := newFakeLedger()
+	log.appendTransfer("", alice, 500)
+	log.appendTransfer(alice, bob, 200)
+	log.appendTransfer(bob, "", 50)
+	router := newFakeRouter(t, ledgerSources(log), conf.RouterConfig{ChainID: 31337, UnsignedDexMethods: true})
+
+	var chainID, netVersion string
+	rpcResult(t, router, &chainID, "eth_chainId")
+	assert.Equal(t, "0x7a69", chainID)
+	rpcResult(t, router, &netVersion, "net_version")
+	assert.Equal(t, "31337", netVersion)
+
+	var metadata LedgerMetadata
+	rpcResult(t, router, &metadata, "eth_getLedgerMetadata")
+	assert.Equal(t, LedgerMetadata{Address: router.tokens.address("TST").Hex(), Name: "Test Token", Symbol: "TST", Decimals: 8}, metadata)
+
+	var logs []Log
+	rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"address": metadata.Address})
+	require.Len(t, logs, 3)
+	aliceAddress := principalToEthAddress(principal.MustDecode(alice))
+	assert.Equal(t, common.BytesToHash(aliceAddress.Bytes()).Hex(), logs[1].Topics[1], "transfer from alice")
+
+	var block Block
+	rpcResult(t, router, &block, "eth_getBlockByNumber", "latest", false)
+	assert.Equal(t, log.hash(2), block.Hash)
+	assert.Equal(t, log.hash(1), block.ParentHash)
+
+	var balance string
+	rpcResult(t, router, &balance, "eth_getBalance", aliceAddress.Hex(), "latest")
+	assert.Equal(t, "0x0", balance, "no native currency without a DEX")
+
+	for _, method := range []string{"eth_sendRawTransaction", "eth_getCurrencyPairs", "eth_mintTokens", "eth_burnTokens"} {
+		rpcError(t, router, ErrCodeMethodNotFound, method)
+	}
+}
+
+func TestRPCMethodsEmptyChain(t *testing.T) {
+	log := newFakeLedger()
+	router := newFakeRouter(t, loggerSources(log, nil), conf.RouterConfig{})
+
+	rpcError(t, router, ErrCodeResourceNotFound, "eth_blockNumber")
+
+	// Unknown hashes are not found, as on any chain
+	for _, call := range []struct {
+		method string
+		params []any
+	}{
+		{"eth_getBlockByNumber", []any{"latest", false}},
+		{"eth_getBlockByNumber", []any{"0x0", false}},
+		{"eth_getBlockByHash", []any{common.Hash{1}.Hex(), false}},
+		{"eth_getTransactionByHash", []any{common.Hash{1}.Hex()}},
+		{"eth_getTransactionReceipt", []any{common.Hash{1}.Hex()}},
+		{"eth_getBlockReceipts", []any{common.Hash{1}.Hex()}},
+		{"eth_getBlockReceipts", []any{"0x0"}},
+		{"eth_getBlockReceipts", []any{"latest"}},
+	} {
+		result, rpcErr := rpcCall(t, router, call.method, call.params...)
+		require.Nil(t, rpcErr, "%s: %+v", call.method, rpcErr)
+		assert.JSONEq(t, "null", string(result), call.method)
+	}
+
+	var logs []Log
+	rpcResult(t, router, &logs, "eth_getLogs", map[string]any{})
+	assert.NotNil(t, logs, "an empty array, not null")
+	assert.Empty(t, logs)
+
+	var filterID string
+	rpcResult(t, router, &filterID, "eth_newBlockFilter")
+	var hashes []string
+	rpcResult(t, router, &hashes, "eth_getFilterChanges", filterID)
+	assert.Empty(t, hashes)
+
+	log.appendEntries(testEntry(testCallerA, 1e9))
+	rpcResult(t, router, &hashes, "eth_getFilterChanges", filterID)
+	assert.Equal(t, []string{log.hash(0)}, hashes, "the first block of the log")
+}
+
+func TestRPCMethodsGaps(t *testing.T) {
+	log := newFakeLedger()
+	for i := 0; i < 25; i++ {
+		log.appendEntries(testEntry(testCallerA, uint64(i)*1e9))
+	}
+	log.gaps[3], log.gaps[12] = true, true
+	router := newFakeRouter(t, loggerSources(log, nil), conf.RouterConfig{})
+
+	result, rpcErr := rpcCall(t, router, "eth_getBlockByNumber", "0x3", false)
+	require.Nil(t, rpcErr)
+	assert.JSONEq(t, "null", string(result), "a missing block")
+
+	var block Block
+	rpcResult(t, router, &block, "eth_getBlockByNumber", "0x4", false)
+	assert.Equal(t, log.hash(3), block.ParentHash, "the parent hash does not need the missing block")
+
+	var logs []Log
+	rpcResult(t, router, &logs, "eth_getLogs", map[string]any{"fromBlock": "0x0", "toBlock": "latest"})
+	assert.Len(t, logs, 23, "blocks past a gap are still read")
+
+	result, rpcErr = rpcCall(t, router, "eth_getBlockReceipts", "0xc")
+	require.Nil(t, rpcErr)
+	assert.JSONEq(t, "null", string(result))
+}
+
+func TestRPCMethodsCanisterErrors(t *testing.T) {
+	log, dex := dexTestChain(t)
+	router := newFakeRouter(t, loggerSources(log, dex), conf.RouterConfig{})
+	log.err = errors.New("canister unreachable")
+
+	for _, method := range []string{"eth_chainId", "net_version", "eth_blockNumber"} {
+		rpcError(t, router, ErrCodeInternal, method)
+	}
+	rpcError(t, router, ErrCodeInternal, "eth_getBlockByNumber", "0x0", false)
+}
+
+// TestRPCMethodsCovered runs the suites above and checks they call every method
+// registered on a logger router with a DEX and on a ledger router
+func TestRPCMethodsCovered(t *testing.T) {
+	calledMethods.reset()
+	for name, suite := range map[string]func(*testing.T){
+		"Logger":         TestRPCMethodsLogger,
+		"Ledger":         TestRPCMethodsLedger,
+		"EmptyChain":     TestRPCMethodsEmptyChain,
+		"Gaps":           TestRPCMethodsGaps,
+		"CanisterErrors": TestRPCMethodsCanisterErrors,
+	} {
+		t.Run(name, suite)
+	}
+
+	log, dex := dexTestChain(t)
+	routers := []*evmRouter{
+		newFakeRouter(t, loggerSources(log, dex), conf.RouterConfig{UnsignedDexMethods: true}),
+		newFakeRouter(t, ledgerSources(newFakeLedger()), conf.RouterConfig{ChainID: 1}),
+	}
+	for _, router := range routers {
+		for method := range router.methodHandlers {
+			assert.True(t, calledMethods.has(method), "%s is not called by the suites", method)
+		}
+	}
+}
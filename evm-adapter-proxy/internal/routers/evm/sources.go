@@ -0,0 +1,145 @@
+package evm
+
+import (
+	"time"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// blockSource reads the blocks of an ICRC-3 log
+type blockSource interface {
+	// fetchBlocksPage returns the blocks of [start, start+length) and the length of
+	// the log, with the blocks moved to archives fetched from them and in order
+	fetchBlocksPage(start, length uint64) (blocksPage, error)
+}
+
+// tipSource returns the last block of an ICRC-3 log
+type tipSource interface {
+	// fetchCertifiedTip returns the tip once its certificate is verified; a not found
+	// RPC error when the log has no blocks
+	fetchCertifiedTip() (certifiedTip, error)
+}
+
+// chainSource reports the chain of a logger canister
+type chainSource interface {
+	ChainId() (*string, error)
+	NetVersion() (*string, error)
+}
+
+// dexResult is the reply of the DEX update calls: Ok, or Err with the rejection
+type dexResult = struct {
+	Ok  *idl.Null `ic:"Ok,variant"`
+	Err *string   `ic:"Err,variant"`
+}
+
+// dexOperations are the DEX canister methods the router calls
+type dexOperations interface {
+	GetCurrencyPairs() (*[]icpDex.CurrencyPair, error)
+	GetTokenBalance(owner principal.Principal, currency string) (*idl.Nat, error)
+	MintTokens(operation icpDex.MintOperation) (*dexResult, error)
+	BurnTokens(operation icpDex.BurnOperation) (*dexResult, error)
+	AddCurrencyPair(pair icpDex.CurrencyPair) error
+}
+
+// ledgerInfo is the ICRC-1 metadata of a ledger
+type ledgerInfo interface {
+	Icrc1Name() (*string, error)
+	Icrc1Symbol() (*string, error)
+	Icrc1Decimals() (*uint8, error)
+}
+
+// routerSources are the canisters a router reads from and calls, behind the
+// interfaces the handlers use so they can run against in-memory fakes
+type routerSources struct {
+	blocks blockSource
+	tips   tipSource
+	// chain is nil for sources without a logger canister, which configure their chain ID
+	chain chainSource
+	// dex is nil for sources without a DEX canister
+	dex dexOperations
+	// ledger is nil unless blocks come from an ICRC-1 ledger
+	ledger ledgerInfo
+	// callCanister is the canister eth_call contracts query when they do not name one
+	callCanister principal.Principal
+	query        canisterQuery
+}
+
+// newRouterSources returns the sources of the ICP clients of a source: blocks and the
+// tip are read from the ledger when there is one and from the logger canister
+// otherwise, and eth_call contracts query the DEX canister, or the block source of
+// sources without one
+//
+// Tip certificates older than certificateMaxAge are rejected; 0 uses
+// defaultCertificateMaxAge.
+func newRouterSources(icpClients *icp.Clients, certificateMaxAge time.Duration) routerSources {
+	if certificateMaxAge <= 0 {
+		certificateMaxAge = defaultCertificateMaxAge
+	}
+
+	sources := routerSources{query: agentQuery(icpClients.Queries)}
+	if icpClients.Logger != nil {
+		sources.chain = icpClients.Logger
+		sources.callCanister = icpClients.Logger.CanisterId
+
+		log := loggerLog{agent: icpClients.Logger, queries: icpClients.Queries, certificateMaxAge: certificateMaxAge}
+		sources.blocks, sources.tips = log, log
+	}
+	if icpClients.Ledger != nil {
+		sources.ledger = icpClients.Ledger
+		sources.callCanister = icpClients.Ledger.CanisterId
+
+		log := ledgerLog{agent: icpClients.Ledger, queries: icpClients.Queries, certificateMaxAge: certificateMaxAge}
+		sources.blocks, sources.tips = log, log
+	}
+	if icpClients.Dex != nil {
+		sources.dex = icpClients.Dex
+		sources.callCanister = icpClients.Dex.CanisterId
+	}
+
+	return sources
+}
+
+// agentQuery returns a canisterQuery sending raw queries, whose signatures are checked
+func agentQuery(q *icp.QueryAgent) canisterQuery {
+	return func(canisterID principal.Principal, method string, argTypes []idl.Type, args []any) ([]any, error) {
+		return icp.Query(q, canisterID, method, argTypes, args)
+	}
+}
+
+// loggerLog is the ICRC-3 log of a logger canister
+type loggerLog struct {
+	agent             *icpLogger.Agent
+	queries           *icp.QueryAgent
+	certificateMaxAge time.Duration
+}
+
+// fetchBlocksPage implements blockSource with icrc3_get_blocks
+func (l loggerLog) fetchBlocksPage(start, length uint64) (blocksPage, error) {
+	var result icpLogger.GetBlocksResult
+	source := idl.PrincipalMethod{Principal: l.agent.CanisterId, Method: icp.GetBlocksMethod}
+	archived, err := icp.GetBlocks(l.queries, source, start, length, loggerBlocksArgs, &result)
+	if err != nil {
+		return blocksPage{}, err
+	}
+
+	page := blocksPage{LogLength: result.LogLength.BigInt().Uint64(), Blocks: loggerBlocks(result)}
+
+	return stitchArchived(page, archived, fetchLoggerArchive(l.queries))
+}
+
+// fetchCertifiedTip implements tipSource with icrc3_get_tip_certificate
+func (l loggerLog) fetchCertifiedTip() (certifiedTip, error) {
+	tipCert, err := l.agent.Icrc3GetTipCertificate()
+	if err != nil {
+		return certifiedTip{}, newCanisterError(err, "failed to get tip certificate")
+	}
+	if tipCert == nil || *tipCert == nil {
+		return certifiedTip{}, newNotFoundError("no tip certificate found")
+	}
+
+	return checkTipCertificate((*tipCert).Certificate, (*tipCert).HashTree, l.agent.CanisterId, l.agent.GetRootKey(), l.certificateMaxAge)
+}
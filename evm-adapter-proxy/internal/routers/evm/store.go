@@ -0,0 +1,231 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/fxamacker/cbor/v2"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+)
+
+// storedValue is the CBOR encoding of an ICRC-3 Value in the block store
+type storedValue struct {
+	Blob  *[]byte        `cbor:"1,keyasint,omitempty"`
+	Text  *string        `cbor:"2,keyasint,omitempty"`
+	Nat   *big.Int       `cbor:"3,keyasint,omitempty"`
+	Int   *big.Int       `cbor:"4,keyasint,omitempty"`
+	Array *[]storedValue `cbor:"5,keyasint,omitempty"`
+	Map   *[]storedEntry `cbor:"6,keyasint,omitempty"`
+}
+
+type storedEntry struct {
+	Key   string      `cbor:"1,keyasint"`
+	Value storedValue `cbor:"2,keyasint"`
+}
+
+func toStoredValue(value icpLogger.Value) storedValue {
+	stored := storedValue{Blob: value.Blob, Text: value.Text}
+	if value.Nat != nil {
+		stored.Nat = value.Nat.BigInt()
+	}
+	if value.Int != nil {
+		stored.Int = value.Int.BigInt()
+	}
+	if value.Array != nil {
+		items := make([]storedValue, 0, len(*value.Array))
+		for _, item := range *value.Array {
+			items = append(items, toStoredValue(item))
+		}
+		stored.Array = &items
+	}
+	if value.Map != nil {
+		entries := make([]storedEntry, 0, len(*value.Map))
+		for _, entry := range *value.Map {
+			entries = append(entries, storedEntry{Key: entry.Field0, Value: toStoredValue(entry.Field1)})
+		}
+		stored.Map = &entries
+	}
+	return stored
+}
+
+func fromStoredValue(stored storedValue) icpLogger.Value {
+	value := icpLogger.Value{Blob: stored.Blob, Text: stored.Text}
+	if stored.Nat != nil {
+		nat := idl.NewBigNat(stored.Nat)
+		value.Nat = &nat
+	}
+	if stored.Int != nil {
+		i := idl.NewBigInt(stored.Int)
+		value.Int = &i
+	}
+	if stored.Array != nil {
+		items := make([]icpLogger.Value, 0, len(*stored.Array))
+		for _, item := range *stored.Array {
+			items = append(items, fromStoredValue(item))
+		}
+		value.Array = &items
+	}
+	if stored.Map != nil {
+		entries := make([]struct {
+			Field0 string          `ic:"0" json:"0"`
+			Field1 icpLogger.Value `ic:"1" json:"1"`
+		}, 0, len(*stored.Map))
+		for _, entry := range *stored.Map {
+			entries = append(entries, struct {
+				Field0 string          `ic:"0" json:"0"`
+				Field1 icpLogger.Value `ic:"1" json:"1"`
+			}{Field0: entry.Key, Field1: fromStoredValue(entry.Value)})
+		}
+		value.Map = &entries
+	}
+	return value
+}
+
+// indexBlock encodes an ICRC-3 block for the store, with the hashes, addresses and
+// topics of its EVM block and logs
+func (r *evmRouter) indexBlock(block ICRC3Block) (store.Block, error) {
+	data, err := cbor.Marshal(toStoredValue(block.Value))
+	if err != nil {
+		return store.Block{}, fmt.Errorf("failed to encode block %d: %w", block.ID, err)
+	}
+
+	evmBlock, err := r.mappers.mapBlock(block)
+	if err != nil {
+		return store.Block{}, fmt.Errorf("failed to map block %d: %w", block.ID, err)
+	}
+
+	logs, err := r.mappers.mapLogs(block, logFilter{})
+	if err != nil {
+		return store.Block{}, fmt.Errorf("failed to map logs of block %d: %w", block.ID, err)
+	}
+
+	indexed := store.Block{
+		Number:   block.ID,
+		Hash:     evmBlock.Hash,
+		Data:     data,
+		TxHashes: append([]string{}, evmBlock.Transactions...),
+	}
+	for _, log := range logs {
+		if log.Address != "" {
+			indexed.Addresses = append(indexed.Addresses, log.Address)
+		}
+		indexed.Topics = append(indexed.Topics, log.Topics...)
+	}
+
+	return indexed, nil
+}
+
+// storedBlock reads an ingested block
+func (r *evmRouter) storedBlock(number uint64) (ICRC3Block, bool, error) {
+	data, found, err := r.store.Block(number)
+	if err != nil || !found {
+		return ICRC3Block{}, false, err
+	}
+
+	var stored storedValue
+	if err := cbor.Unmarshal(data, &stored); err != nil {
+		return ICRC3Block{}, false, fmt.Errorf("failed to decode stored block %d: %w", number, err)
+	}
+
+	return ICRC3Block{ID: number, Value: fromStoredValue(stored)}, true, nil
+}
+
+// storedBlocks reads the ingested blocks of [start, start+length), stopping at the
+// first block not ingested yet, and returns them with the store height
+func (r *evmRouter) storedBlocks(start, length uint64) ([]ICRC3Block, uint64, error) {
+	height, err := r.store.Height()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var blocks []ICRC3Block
+	for number := start; number < start+length && number < height; number++ {
+		block, found, err := r.storedBlock(number)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !found {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, height, nil
+}
+
+// indexedLogBlocks returns the ingested blocks in [fromBlock, toBlock] that can hold
+// logs matching the criteria, using the block hash, address and topic indexes
+//
+// Returns:
+//   - []ICRC3Block: The candidate blocks, in order
+//   - bool: False when the criteria have nothing to look up and every block is a candidate
+//   - error: Any error reading the store
+func (r *evmRouter) indexedLogBlocks(criteria logFilter, fromBlock, toBlock uint64) ([]ICRC3Block, bool, error) {
+	var candidates map[uint64]bool
+	restrict := func(numbers []uint64) {
+		next := make(map[uint64]bool, len(numbers))
+		for _, number := range numbers {
+			if candidates == nil || candidates[number] {
+				next[number] = true
+			}
+		}
+		candidates = next
+	}
+
+	if criteria.BlockHash != "" {
+		number, found, err := r.store.BlockNumberByHash(criteria.BlockHash)
+		if err != nil {
+			return nil, false, err
+		}
+		var numbers []uint64
+		if found && number >= fromBlock && number <= toBlock {
+			numbers = append(numbers, number)
+		}
+		restrict(numbers)
+	}
+
+	if len(criteria.Addresses) > 0 {
+		numbers, err := r.store.BlockNumbersByAddress(criteria.Addresses, fromBlock, toBlock)
+		if err != nil {
+			return nil, false, err
+		}
+		restrict(numbers)
+	}
+
+	for _, topics := range criteria.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		numbers, err := r.store.BlockNumbersByTopic(topics, fromBlock, toBlock)
+		if err != nil {
+			return nil, false, err
+		}
+		restrict(numbers)
+	}
+
+	if candidates == nil {
+		return nil, false, nil
+	}
+
+	numbers := make([]uint64, 0, len(candidates))
+	for number := range candidates {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	blocks := make([]ICRC3Block, 0, len(numbers))
+	for _, number := range numbers {
+		block, found, err := r.storedBlock(number)
+		if err != nil {
+			return nil, false, err
+		}
+		if found {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks, true, nil
+}
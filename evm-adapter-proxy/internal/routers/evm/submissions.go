@@ -0,0 +1,142 @@
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/store"
+)
+
+// submission is a signed transaction executed by eth_sendRawTransaction
+type submission struct {
+	Transaction Transaction `json:"transaction"`
+	Receipt     Receipt     `json:"receipt"`
+	// EntryHash is the synthetic transaction hash of the logger entry the transaction
+	// wrote, empty when none was found
+	EntryHash string `json:"entryHash,omitempty"`
+}
+
+// submissionRegistry keeps the transactions executed by eth_sendRawTransaction and
+// the next nonce of their senders
+//
+// Both are persisted in the block store, so nonces survive restarts and executed
+// transactions cannot be replayed: conf.Config.Validate requires a store wherever DEX
+// operators may submit transactions. Routers without one, as in tests, keep them in
+// memory only.
+type submissionRegistry struct {
+	// execMu serializes the execution of transactions, so nonces are consumed in order
+	execMu sync.Mutex
+
+	mu      sync.RWMutex
+	nonces  map[common.Address]uint64
+	records map[string]submission
+	claimed map[string]bool
+	store   *store.Store
+}
+
+func newSubmissionRegistry(blockStore *store.Store) *submissionRegistry {
+	return &submissionRegistry{
+		nonces:  make(map[common.Address]uint64),
+		records: make(map[string]submission),
+		claimed: make(map[string]bool),
+		store:   blockStore,
+	}
+}
+
+// nextNonce returns the nonce the next transaction of sender must have
+func (s *submissionRegistry) nextNonce(sender common.Address) (uint64, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	s.mu.RLock()
+	nonce, found := s.nonces[sender]
+	s.mu.RUnlock()
+	if found || s.store == nil {
+		return nonce, nil
+	}
+
+	return s.store.NextNonce(sender.Hex())
+}
+
+// use moves the nonce of sender past nonce before its transaction is executed; a
+// transaction whose execution failed may still have run, so it cannot be sent again
+func (s *submissionRegistry) use(sender common.Address, nonce uint64) error {
+	if s.store != nil {
+		if err := s.store.UseNonce(sender.Hex(), nonce); err != nil {
+			return fmt.Errorf("failed to store nonce %d of %s: %w", nonce, sender.Hex(), err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nonces[sender] = nonce + 1
+	return nil
+}
+
+// add records an executed transaction and moves the nonce of its sender past it
+//
+// Parameters:
+//   - sender: The recovered sender of the transaction
+//   - nonce: The nonce of the transaction
+//   - record: The transaction and its receipt
+func (s *submissionRegistry) add(sender common.Address, nonce uint64, record submission) error {
+	hash := strings.ToLower(record.Transaction.Hash)
+	if s.store != nil {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode transaction %s: %w", hash, err)
+		}
+		if err := s.store.PutSubmission(hash, data, sender.Hex(), nonce); err != nil {
+			return fmt.Errorf("failed to store transaction %s: %w", hash, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nonces[sender] = nonce + 1
+	s.records[hash] = record
+	if record.EntryHash != "" {
+		s.claimed[strings.ToLower(record.EntryHash)] = true
+	}
+
+	return nil
+}
+
+// lookup returns the executed transaction with the given hash
+func (s *submissionRegistry) lookup(hash string) (submission, bool, error) {
+	if s == nil {
+		return submission{}, false, nil
+	}
+
+	s.mu.RLock()
+	record, found := s.records[strings.ToLower(hash)]
+	s.mu.RUnlock()
+	if found || s.store == nil {
+		return record, found, nil
+	}
+
+	data, found, err := s.store.Submission(hash)
+	if err != nil || !found {
+		return submission{}, false, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return submission{}, false, fmt.Errorf("failed to decode stored transaction %s: %w", hash, err)
+	}
+
+	return record, true, nil
+}
+
+// isClaimed reports whether the logger entry with the given synthetic transaction
+// hash was already attributed to an executed transaction since the proxy started
+func (s *submissionRegistry) isClaimed(entryHash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.claimed[strings.ToLower(entryHash)]
+}
@@ -0,0 +1,60 @@
+package evm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmissionRegistry(t *testing.T) {
+	sender := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	record := submission{
+		Transaction: Transaction{Hash: "0x" + common.Bytes2Hex(common.LeftPadBytes([]byte{1}, 32)), Nonce: "0x0"},
+		Receipt:     Receipt{Status: receiptStatusSuccess, Logs: []Log{}},
+		EntryHash:   "0x" + common.Bytes2Hex(common.LeftPadBytes([]byte{2}, 32)),
+	}
+
+	t.Run("In memory", func(t *testing.T) {
+		registry := newSubmissionRegistry(nil)
+		nonce, err := registry.nextNonce(sender)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), nonce)
+
+		require.NoError(t, registry.add(sender, 0, record))
+
+		nonce, err = registry.nextNonce(sender)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), nonce)
+
+		got, found, err := registry.lookup(record.Transaction.Hash)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, record, got)
+		assert.True(t, registry.isClaimed(record.EntryHash))
+
+		_, found, err = (*submissionRegistry)(nil).lookup(record.Transaction.Hash)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Persisted in the block store", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocks.db")
+		blockStore := openTestStore(t, path)
+		require.NoError(t, newSubmissionRegistry(blockStore).add(sender, 6, record))
+		require.NoError(t, blockStore.Close())
+
+		// A new process keeps the nonce and the transaction of the previous one
+		registry := newSubmissionRegistry(openTestStore(t, path))
+		nonce, err := registry.nextNonce(sender)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(7), nonce)
+
+		got, found, err := registry.lookup(record.Transaction.Hash)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, record, got)
+	})
+}
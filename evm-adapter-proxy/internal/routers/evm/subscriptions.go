@@ -0,0 +1,256 @@
+package evm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zondax/golem/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Subscription types supported by eth_subscribe
+const (
+	SubscriptionNewHeads = "newHeads"
+	SubscriptionLogs     = "logs"
+)
+
+const (
+	defaultPollInterval = 2 * time.Second
+	// maxBlocksPerPoll bounds how far the poller catches up in a single tick
+	maxBlocksPerPoll = uint64(100)
+)
+
+// subscriber receives the notifications of the subscriptions it owns
+type subscriber interface {
+	notify(subscriptionID string, result interface{})
+}
+
+type subscription struct {
+	id     string
+	kind   string
+	filter logFilter
+	owner  subscriber
+}
+
+// subscriptionHub runs a single ICRC-3 tip poller and fans new blocks and logs
+// out to every eth_subscribe subscription, regardless of its connection
+//
+// The poller is started when the first subscription is added and stopped when the
+// last one is removed, so idle proxies do not query the canister. A restarted poller
+// waits for the previous one to return, so only one goroutine polls at a time.
+type subscriptionHub struct {
+	latestBlockNumber func() (uint64, error)
+	fetchBlocks       func(fromBlock, toBlock uint64) ([]ICRC3Block, error)
+	mappers           *MapperRegistry
+	interval          time.Duration
+	// gate holds back the closing of the block store while a poll reads it
+	gate *requestGate
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+	cancel        context.CancelFunc
+	// done is closed when the last started poller returns
+	done        chan struct{}
+	nextBlock   uint64
+	initialized bool
+}
+
+// newSubscriptionHub creates a hub that reads the chain through the given router
+func newSubscriptionHub(r *evmRouter, interval time.Duration) *subscriptionHub {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	return &subscriptionHub{
+		latestBlockNumber: r.getLatestBlockNumber,
+		fetchBlocks:       r.fetchBlocks,
+		mappers:           r.mappers,
+		interval:          interval,
+		gate:              r.gate,
+		subscriptions:     make(map[string]*subscription),
+	}
+}
+
+// subscribe registers a subscription and starts the poller if it was idle
+func (h *subscriptionHub) subscribe(sub *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subscriptions[sub.id] = sub
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		previous, done := h.done, make(chan struct{})
+		h.cancel, h.done = cancel, done
+		go h.run(ctx, previous, done)
+	}
+}
+
+// unsubscribe removes a subscription and stops the poller when none are left
+//
+// Returns:
+//   - bool: Whether the subscription existed
+func (h *subscriptionHub) unsubscribe(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscriptions[id]; !ok {
+		return false
+	}
+
+	delete(h.subscriptions, id)
+	if len(h.subscriptions) == 0 && h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+
+	return true
+}
+
+// run polls the tip until the context is cancelled, once the previous poller, if any,
+// has returned, and closes done when it returns
+func (h *subscriptionHub) run(ctx context.Context, previous <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	if previous != nil {
+		<-previous
+	}
+
+	// Blocks produced while no poller was running are not published
+	h.mu.Lock()
+	h.initialized = false
+	h.mu.Unlock()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.poll(); err != nil {
+			logger.GetLoggerFromContext(ctx).Errorf("subscription poller failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll checks the ICRC-3 tip and publishes every block produced since the last poll
+//
+// The first poll only records the current tip, so subscribers are notified about
+// blocks produced after they subscribed. A block that cannot be mapped is logged and
+// skipped, so it does not hold back the blocks after it. Nothing is polled once the
+// router is shutting down.
+func (h *subscriptionHub) poll() error {
+	if !h.gate.enter() {
+		return nil
+	}
+	defer h.gate.leave()
+
+	tip, err := h.latestBlockNumber()
+	if err != nil {
+		return fmt.Errorf("failed to get latest block number: %w", err)
+	}
+
+	h.mu.Lock()
+	if !h.initialized {
+		h.nextBlock = tip + 1
+		h.initialized = true
+		h.mu.Unlock()
+		return nil
+	}
+	fromBlock := h.nextBlock
+	h.mu.Unlock()
+
+	if tip < fromBlock {
+		return nil
+	}
+
+	toBlock := tip
+	if toBlock-fromBlock+1 > maxBlocksPerPoll {
+		toBlock = fromBlock + maxBlocksPerPoll - 1
+	}
+
+	blocks, err := h.fetchBlocks(fromBlock, toBlock)
+	if err != nil {
+		return fmt.Errorf("failed to fetch blocks %d-%d: %w", fromBlock, toBlock, err)
+	}
+
+	for _, block := range blocks {
+		if err := h.publishBlock(block); err != nil {
+			zap.S().Errorf("subscription poller skipped block %d: %v", block.ID, err)
+		}
+
+		h.mu.Lock()
+		h.nextBlock = block.ID + 1
+		h.mu.Unlock()
+	}
+
+	return nil
+}
+
+// publishBlock maps a block and publishes it
+func (h *subscriptionHub) publishBlock(block ICRC3Block) error {
+	head, err := h.mappers.mapBlock(block)
+	if err != nil {
+		return fmt.Errorf("failed to map ICRC3 block to EVM block: %w", err)
+	}
+
+	return h.publish(block, head)
+}
+
+// publish sends a block to the newHeads subscribers and its logs to the logs subscribers
+func (h *subscriptionHub) publish(block ICRC3Block, head Block) error {
+	h.mu.Lock()
+	subscriptions := make([]*subscription, 0, len(h.subscriptions))
+	for _, sub := range h.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	h.mu.Unlock()
+
+	needLogs := false
+	for _, sub := range subscriptions {
+		if sub.kind == SubscriptionLogs {
+			needLogs = true
+			break
+		}
+	}
+
+	var logs []Log
+	if needLogs {
+		var err error
+		logs, err = h.mappers.mapLogs(block, logFilter{})
+		if err != nil {
+			return fmt.Errorf("failed to extract logs from block: %w", err)
+		}
+	}
+
+	for _, sub := range subscriptions {
+		switch sub.kind {
+		case SubscriptionNewHeads:
+			sub.owner.notify(sub.id, head)
+		case SubscriptionLogs:
+			for _, log := range logs {
+				if !sub.filter.matches(log) {
+					continue
+				}
+				sub.owner.notify(sub.id, log)
+			}
+		}
+	}
+
+	return nil
+}
+
+// newRandomID returns a random 128-bit hex identifier for subscriptions and filters, as go-ethereum does
+func newRandomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(id), nil
+}
@@ -0,0 +1,229 @@
+package evm
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+type testMapEntry = struct {
+	Field0 string          `ic:"0" json:"0"`
+	Field1 icpLogger.Value `ic:"1" json:"1"`
+}
+
+func natValue(n uint64) icpLogger.Value {
+	nat := idl.NewNatFromString(fmt.Sprintf("%d", n))
+	return icpLogger.Value{Nat: &nat}
+}
+
+func textValue(s string) icpLogger.Value {
+	return icpLogger.Value{Text: &s}
+}
+
+func blobValue(b []byte) icpLogger.Value {
+	return icpLogger.Value{Blob: &b}
+}
+
+func mapValue(entries ...testMapEntry) icpLogger.Value {
+	return icpLogger.Value{Map: &entries}
+}
+
+// newTestBlock builds a logger canister block with one log entry per caller and a 32-byte hash
+func newTestBlock(id uint64, callers ...string) icpLogger.Value {
+	entries := make([]icpLogger.Value, 0, len(callers))
+	for _, caller := range callers {
+		entries = append(entries, mapValue(
+			testMapEntry{Field0: "timestamp", Field1: natValue(id * 1e9)},
+			testMapEntry{Field0: "operation", Field1: textValue("test")},
+			testMapEntry{Field0: "caller", Field1: textValue(caller)},
+			testMapEntry{Field0: "details", Field1: mapValue(testMapEntry{Field0: "key", Field1: textValue("value")})},
+		))
+	}
+
+	return mapValue(
+		testMapEntry{Field0: "id", Field1: natValue(id)},
+		testMapEntry{Field0: "hash", Field1: blobValue(common.LeftPadBytes([]byte{byte(id), 1}, 32))},
+		testMapEntry{Field0: "phash", Field1: blobValue(common.LeftPadBytes([]byte{byte(id - 1), 1}, 32))},
+		testMapEntry{Field0: "btype", Field1: textValue(BlockTypeLogEntry)},
+		testMapEntry{Field0: "ts", Field1: natValue(id * 1e9)},
+		testMapEntry{Field0: "entries", Field1: icpLogger.Value{Array: &entries}},
+	)
+}
+
+type fakeChain struct {
+	mu     sync.Mutex
+	blocks []ICRC3Block
+}
+
+func (f *fakeChain) append(callers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := uint64(len(f.blocks))
+	f.blocks = append(f.blocks, ICRC3Block{ID: id, Value: newTestBlock(id, callers...)})
+}
+
+func (f *fakeChain) latestBlockNumber() (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.blocks) == 0 {
+		return 0, fmt.Errorf("empty chain")
+	}
+	return uint64(len(f.blocks) - 1), nil
+}
+
+func (f *fakeChain) fetchBlocks(fromBlock, toBlock uint64) ([]ICRC3Block, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var blocks []ICRC3Block
+	for i := fromBlock; i <= toBlock && i < uint64(len(f.blocks)); i++ {
+		blocks = append(blocks, f.blocks[i])
+	}
+	return blocks, nil
+}
+
+type recordingSubscriber struct {
+	mu            sync.Mutex
+	notifications map[string][]interface{}
+}
+
+func (s *recordingSubscriber) notify(subscriptionID string, result interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications[subscriptionID] = append(s.notifications[subscriptionID], result)
+}
+
+func newTestHub(chain *fakeChain) *subscriptionHub {
+	return &subscriptionHub{
+		latestBlockNumber: chain.latestBlockNumber,
+		fetchBlocks:       chain.fetchBlocks,
+		mappers:           newDefaultMapperRegistry(nil, nil),
+		interval:          defaultPollInterval,
+		subscriptions:     make(map[string]*subscription),
+	}
+}
+
+func TestSubscriptionHubPoll(t *testing.T) {
+	chain := &fakeChain{}
+	chain.append("2vxsx-fae")
+
+	hub := newTestHub(chain)
+	owner := &recordingSubscriber{notifications: map[string][]interface{}{}}
+
+	callerAddress, err := convertICPToEthAddress("2vxsx-fae")
+	require.NoError(t, err)
+
+	hub.subscriptions["heads"] = &subscription{id: "heads", kind: SubscriptionNewHeads, owner: owner}
+	hub.subscriptions["all-logs"] = &subscription{id: "all-logs", kind: SubscriptionLogs, owner: owner}
+	hub.subscriptions["caller-logs"] = &subscription{id: "caller-logs", kind: SubscriptionLogs, filter: logFilter{Addresses: []string{callerAddress}}, owner: owner}
+	hub.subscriptions["other-logs"] = &subscription{id: "other-logs", kind: SubscriptionLogs, filter: logFilter{Addresses: []string{"0x0000000000000000000000000000000000000001"}}, owner: owner}
+
+	// The first poll only records the tip
+	require.NoError(t, hub.poll())
+	assert.Empty(t, owner.notifications)
+
+	chain.append("2vxsx-fae", "2vxsx-fae")
+	chain.append()
+	require.NoError(t, hub.poll())
+
+	require.Len(t, owner.notifications["heads"], 2)
+	assert.Equal(t, "0x1", owner.notifications["heads"][0].(Block).Number)
+	assert.Equal(t, "0x2", owner.notifications["heads"][1].(Block).Number)
+	assert.Len(t, owner.notifications["all-logs"], 2)
+	assert.Len(t, owner.notifications["caller-logs"], 2)
+	assert.Empty(t, owner.notifications["other-logs"])
+
+	// Nothing new, nothing sent
+	require.NoError(t, hub.poll())
+	assert.Len(t, owner.notifications["heads"], 2)
+}
+
+func TestSubscriptionHubSkipsBrokenBlocks(t *testing.T) {
+	chain := &fakeChain{}
+	chain.append()
+
+	hub := newTestHub(chain)
+	owner := &recordingSubscriber{notifications: map[string][]interface{}{}}
+	hub.subscriptions["heads"] = &subscription{id: "heads", kind: SubscriptionNewHeads, owner: owner}
+	require.NoError(t, hub.poll())
+
+	chain.mu.Lock()
+	chain.blocks = append(chain.blocks, ICRC3Block{ID: 1, Value: textValue("not a block")})
+	chain.mu.Unlock()
+	chain.append()
+	require.NoError(t, hub.poll())
+
+	require.Len(t, owner.notifications["heads"], 1)
+	assert.Equal(t, "0x2", owner.notifications["heads"][0].(Block).Number)
+	assert.Equal(t, uint64(3), hub.nextBlock, "the broken block is not retried")
+}
+
+func TestSubscriptionHubLifecycle(t *testing.T) {
+	chain := &fakeChain{}
+	chain.append()
+
+	hub := newTestHub(chain)
+	owner := &recordingSubscriber{notifications: map[string][]interface{}{}}
+
+	hub.subscribe(&subscription{id: "a", kind: SubscriptionNewHeads, owner: owner})
+	hub.subscribe(&subscription{id: "b", kind: SubscriptionNewHeads, owner: owner})
+	assert.NotNil(t, hub.cancel)
+
+	assert.True(t, hub.unsubscribe("a"))
+	assert.False(t, hub.unsubscribe("a"))
+	assert.NotNil(t, hub.cancel)
+
+	assert.True(t, hub.unsubscribe("b"))
+	assert.Nil(t, hub.cancel)
+}
+
+func TestSubscriptionHubRestart(t *testing.T) {
+	chain := &fakeChain{}
+	chain.append()
+
+	hub := newTestHub(chain)
+	owner := &recordingSubscriber{notifications: map[string][]interface{}{}}
+
+	// The first poller is stuck reading the tip when the hub goes idle and restarts
+	release := make(chan struct{})
+	var calls, polling, maxPolling atomic.Int32
+	hub.latestBlockNumber = func() (uint64, error) {
+		if n := polling.Add(1); n > maxPolling.Load() {
+			maxPolling.Store(n)
+		}
+		defer polling.Add(-1)
+
+		if calls.Add(1) == 1 {
+			<-release
+		}
+		return chain.latestBlockNumber()
+	}
+
+	hub.subscribe(&subscription{id: "a", kind: SubscriptionNewHeads, owner: owner})
+	hub.mu.Lock()
+	first := hub.done
+	hub.mu.Unlock()
+	require.Eventually(t, func() bool { return polling.Load() == 1 }, 5*time.Second, time.Millisecond)
+
+	assert.True(t, hub.unsubscribe("a"))
+	hub.subscribe(&subscription{id: "b", kind: SubscriptionNewHeads, owner: owner})
+	close(release)
+
+	<-first
+	require.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return hub.initialized && calls.Load() > 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), maxPolling.Load(), "a single poller at a time")
+
+	assert.True(t, hub.unsubscribe("b"))
+}
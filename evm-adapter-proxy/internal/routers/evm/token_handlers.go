@@ -0,0 +1,275 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// erc20ABIJSON is the read-only part of the ERC-20 interface answered by the pseudo
+// token contracts of the DEX currencies
+const erc20ABIJSON = `[
+	{"type":"function","name":"name","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"symbol","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+	{"type":"function","name":"totalSupply","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+// erc20ABI is the parsed erc20ABIJSON
+var erc20ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(erc20ABIJSON))
+	if err != nil {
+		panic(fmt.Sprintf("invalid ERC-20 ABI: %v", err))
+	}
+	return parsed
+}()
+
+// callRequest is the part of an eth_call transaction object used by the proxy
+type callRequest struct {
+	To   common.Address
+	Data []byte
+}
+
+// EthGetBalance implements the eth_getBalance RPC method
+// Returns the DEX balance of the configured native currency, or zero when none is configured
+//
+// Parameters:
+// - address: The address of the account, mapped back to its principal
+// - block: Ignored, the DEX only has current balances
+func (r *evmRouter) EthGetBalance(request JSONRPCRequest) (interface{}, error) {
+	params, ok := request.Params.([]interface{})
+	if !ok || len(params) < 1 {
+		return nil, newInvalidParamsError("invalid params for eth_getBalance")
+	}
+
+	address, ok := params[0].(string)
+	if !ok || !common.IsHexAddress(address) {
+		return nil, newInvalidParamsError("invalid address")
+	}
+
+	if r.nativeCurrency == "" {
+		return "0x0", nil
+	}
+
+	balance, err := r.dexBalance(common.HexToAddress(address), r.nativeCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	return hexutil.EncodeBig(balance), nil
+}
+
+// EthCall implements the eth_call RPC method
+// Answers calls to the configured pseudo contracts with canister queries, and the
+// ERC-20 read methods of the pseudo token contracts of the DEX currencies
+//
+// Parameters:
+// - transaction: Call object with the contract address in "to" and the ABI-encoded call in "data" or "input"
+// - block: Ignored, calls always run against the current canister state
+//
+// Calls to any other address return empty data, as calls to an account without code do.
+func (r *evmRouter) EthCall(request JSONRPCRequest) (interface{}, error) {
+	call, err := callParam(request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, handled, err := r.calls.call(call.To, call.Data)
+	if err != nil {
+		return nil, err
+	}
+	if handled {
+		return hexutil.Encode(result), nil
+	}
+
+	currency, found, err := r.tokenCurrency(call.To)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return "0x", nil
+	}
+
+	result, err = r.callToken(currency, call.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return hexutil.Encode(result), nil
+}
+
+// callToken runs an ERC-20 read call against the token of a DEX currency
+//
+// Parameters:
+//   - currency: The currency of the called token
+//   - data: The ABI-encoded call, a 4-byte selector followed by the arguments
+//
+// Returns:
+//   - []byte: The ABI-encoded return value
+//   - error: A revert error for unknown selectors or malformed arguments
+func (r *evmRouter) callToken(currency string, data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, newRevertError("missing function selector")
+	}
+
+	method, err := erc20ABI.MethodById(data[:4])
+	if err != nil {
+		return nil, newRevertError("unsupported function selector %s", hexutil.Encode(data[:4]))
+	}
+
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, newRevertError("invalid arguments for %s: %w", method.Name, err)
+	}
+
+	var value interface{}
+	switch method.Name {
+	case "name", "symbol":
+		value = currency
+	case "decimals":
+		value = r.tokens.tokenDecimals(currency)
+	case "totalSupply":
+		if value, err = r.tokenSupply(currency); err != nil {
+			return nil, err
+		}
+	case "balanceOf":
+		if value, err = r.dexBalance(args[0].(common.Address), currency); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := method.Outputs.Pack(value)
+	if err != nil {
+		return nil, newInternalError("failed to encode %s result: %w", method.Name, err)
+	}
+
+	return result, nil
+}
+
+// tokenCurrency returns the DEX currency whose token contract has the given address;
+// sources without a DEX canister have no tokens
+func (r *evmRouter) tokenCurrency(address common.Address) (string, bool, error) {
+	if r.dex == nil {
+		return "", false, nil
+	}
+
+	pairs, err := r.dex.GetCurrencyPairs()
+	if err != nil {
+		return "", false, newCanisterError(err, "failed to get currency pairs")
+	}
+
+	var known []string
+	for _, pair := range *pairs {
+		known = append(known, pair.BaseCurrency, pair.QuoteCurrency)
+	}
+
+	currency, found := r.tokens.currency(address, known)
+	return currency, found, nil
+}
+
+// dexBalance returns the DEX balance of an address in a currency; addresses that do
+// not map back to a principal, and every address of a source without a DEX canister,
+// hold nothing
+func (r *evmRouter) dexBalance(address common.Address, currency string) (*big.Int, error) {
+	owner, ok, err := r.principals.principalFromEthAddress(address)
+	if err != nil {
+		return nil, newInternalError("failed to map address %s: %w", address.Hex(), err)
+	}
+	if !ok || r.dex == nil {
+		return new(big.Int), nil
+	}
+
+	balance, err := r.dex.GetTokenBalance(owner, currency)
+	if err != nil {
+		return nil, newCanisterError(err, "failed to get %s balance of %s", currency, address.Hex())
+	}
+
+	return balance.BigInt(), nil
+}
+
+// tokenSupply returns the amount of a currency in circulation: everything minted
+// minus everything burned, as recorded by the Transfer logs of its token
+//
+// The DEX canister does not expose a total supply, so the logs of every block up to
+// the tip are read; with a block store they come from its address index.
+func (r *evmRouter) tokenSupply(currency string) (*big.Int, error) {
+	logs, err := r.getLogsByFilter(logFilter{
+		ToBlock:   ^uint64(0),
+		Addresses: []string{r.tokens.address(currency).Hex()},
+		Topics:    [][]string{{TransferEventTopic.Hex()}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transferSupply(logs)
+}
+
+// transferSupply sums the amounts of the Transfer logs from the zero address (mints)
+// and subtracts those to it (burns)
+func transferSupply(logs []Log) (*big.Int, error) {
+	zero := common.Hash{}
+	supply := new(big.Int)
+	for _, log := range logs {
+		if len(log.Topics) < 3 {
+			continue
+		}
+
+		amount, err := hexutil.Decode(log.Data)
+		if err != nil {
+			return nil, newInternalError("invalid Transfer amount %s: %w", log.Data, err)
+		}
+
+		if common.HexToHash(log.Topics[1]) == zero {
+			supply.Add(supply, new(big.Int).SetBytes(amount))
+		} else if common.HexToHash(log.Topics[2]) == zero {
+			supply.Sub(supply, new(big.Int).SetBytes(amount))
+		}
+	}
+
+	return supply, nil
+}
+
+// callParam returns the call object that is the first parameter of an eth_call request
+func callParam(params interface{}) (callRequest, error) {
+	paramSlice, ok := params.([]interface{})
+	if !ok || len(paramSlice) < 1 {
+		return callRequest{}, newInvalidParamsError("invalid params for eth_call")
+	}
+
+	call, ok := paramSlice[0].(map[string]interface{})
+	if !ok {
+		return callRequest{}, newInvalidParamsError("invalid call object")
+	}
+
+	to, ok := call["to"].(string)
+	if !ok || !common.IsHexAddress(to) {
+		return callRequest{}, newInvalidParamsError("invalid to address")
+	}
+
+	// input is the current name of the field, data the one most clients still send
+	input, ok := call["input"]
+	if !ok || input == nil {
+		input = call["data"]
+	}
+
+	var data []byte
+	if input != nil {
+		encoded, ok := input.(string)
+		if !ok {
+			return callRequest{}, newInvalidParamsError("invalid call data")
+		}
+
+		var err error
+		if data, err = hexutil.Decode(encoded); err != nil {
+			return callRequest{}, newInvalidParamsError("invalid call data: %w", err)
+		}
+	}
+
+	return callRequest{To: common.HexToAddress(to), Data: data}, nil
+}
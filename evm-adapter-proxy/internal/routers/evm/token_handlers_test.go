@@ -0,0 +1,141 @@
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallToken(t *testing.T) {
+	tokens, err := newTokenRegistry(nil, map[string]int{"USD": 6})
+	require.NoError(t, err)
+	router := &evmRouter{tokens: tokens}
+
+	call := func(t *testing.T, method string, args ...interface{}) []interface{} {
+		data, err := erc20ABI.Pack(method, args...)
+		require.NoError(t, err)
+
+		result, err := router.callToken("USD", data)
+		require.NoError(t, err)
+
+		values, err := erc20ABI.Unpack(method, result)
+		require.NoError(t, err)
+		return values
+	}
+
+	assert.Equal(t, []interface{}{"USD"}, call(t, "name"))
+	assert.Equal(t, []interface{}{"USD"}, call(t, "symbol"))
+	assert.Equal(t, []interface{}{uint8(6)}, call(t, "decimals"))
+	balance := call(t, "balanceOf", common.HexToAddress("0xaa"))
+	assert.Zero(t, balance[0].(*big.Int).Sign(), "addresses without a principal hold nothing")
+
+	for name, data := range map[string][]byte{
+		"No selector":       {0x70, 0xa0},
+		"Unknown selector":  {0xa9, 0x05, 0x9c, 0xbb},
+		"Missing arguments": erc20ABI.Methods["balanceOf"].ID,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := router.callToken("USD", data)
+			var rpcErr *RPCError
+			require.True(t, errors.As(err, &rpcErr))
+			assert.Equal(t, ErrCodeExecutionReverted, rpcErr.Code)
+		})
+	}
+}
+
+func TestTransferSupply(t *testing.T) {
+	registry := newDefaultMapperRegistry(nil, nil)
+	var logs []Log
+	for i, block := range []ICRC3Block{
+		newDexBlock(0, OperationMintTokens, `MintOperation { currency: "USD", amount: 1_000, recipient: Principal(`+testRecipient+`) }`),
+		newDexBlock(1, OperationMintTokens, `MintOperation { currency: "USD", amount: 500, recipient: Principal(`+testCallerB+`) }`),
+		newDexBlock(2, OperationBurnTokens, `BurnOperation { currency: "USD", amount: 300, owner: Principal(`+testRecipient+`) }`),
+	} {
+		blockLogs, err := registry.mapLogs(block, logFilter{})
+		require.NoError(t, err, i)
+		logs = append(logs, blockLogs...)
+	}
+
+	supply, err := transferSupply(logs)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1200), supply)
+
+	logs[0].Data = "0xzz"
+	_, err = transferSupply(logs)
+	assert.Error(t, err)
+}
+
+func TestCallParam(t *testing.T) {
+	token := (*tokenRegistry)(nil).address("USD")
+	selector := hexutil.Encode(erc20ABI.Methods["totalSupply"].ID)
+
+	tests := []struct {
+		name    string
+		params  interface{}
+		want    callRequest
+		wantErr bool
+	}{
+		{
+			name:   "Data field",
+			params: []interface{}{map[string]interface{}{"to": token.Hex(), "data": selector}, "latest"},
+			want:   callRequest{To: token, Data: erc20ABI.Methods["totalSupply"].ID},
+		},
+		{
+			name:   "Input field takes precedence",
+			params: []interface{}{map[string]interface{}{"to": token.Hex(), "input": selector, "data": "0x"}},
+			want:   callRequest{To: token, Data: erc20ABI.Methods["totalSupply"].ID},
+		},
+		{
+			name:   "No data",
+			params: []interface{}{map[string]interface{}{"to": token.Hex()}},
+			want:   callRequest{To: token},
+		},
+		{
+			name:    "Missing to",
+			params:  []interface{}{map[string]interface{}{"data": selector}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid data",
+			params:  []interface{}{map[string]interface{}{"to": token.Hex(), "data": "0xz"}},
+			wantErr: true,
+		},
+		{
+			name:    "No call object",
+			params:  []interface{}{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := callParam(tt.params)
+			if tt.wantErr {
+				var rpcErr *RPCError
+				require.True(t, errors.As(err, &rpcErr))
+				assert.Equal(t, ErrCodeInvalidParams, rpcErr.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEthGetBalanceWithoutNativeCurrency(t *testing.T) {
+	router := &evmRouter{}
+
+	balance, err := router.EthGetBalance(JSONRPCRequest{Params: []interface{}{testLedgerToken.Hex(), "latest"}})
+	require.NoError(t, err)
+	assert.Equal(t, "0x0", balance)
+
+	_, err = router.EthGetBalance(JSONRPCRequest{Params: []interface{}{"0x1234", "latest"}})
+	var rpcErr *RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, ErrCodeInvalidParams, rpcErr.Code)
+}
@@ -0,0 +1,181 @@
+package evm
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// blockHashLength is the length of a 0x-prefixed 32-byte hash
+const blockHashLength = 66
+
+// EthGetTransactionByHash implements the eth_getTransactionByHash RPC method
+// Retrieves a transaction submitted with eth_sendRawTransaction or the synthetic transaction
+// of a block entry, or null when no transaction has that hash
+//
+// Parameters:
+// - txHash: The hash of the transaction to retrieve
+func (r *evmRouter) EthGetTransactionByHash(request JSONRPCRequest) (interface{}, error) {
+	txHash, err := hashParam("eth_getTransactionByHash", request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	record, found, err := r.submissions.lookup(txHash)
+	if err != nil {
+		return nil, newInternalError("failed to read submitted transaction: %w", err)
+	}
+	if found {
+		return record.Transaction, nil
+	}
+
+	block, found, err := r.blockByTxHash(txHash)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	transactions, err := r.mappers.mapTransactions(block)
+	if err != nil {
+		return nil, newInternalError("failed to map transactions of block %d: %w", block.ID, err)
+	}
+
+	for _, tx := range transactions {
+		if strings.EqualFold(tx.Hash, txHash) {
+			return tx, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// EthGetTransactionReceipt implements the eth_getTransactionReceipt RPC method
+// Retrieves the receipt of a submitted or synthetic transaction, or null when no transaction
+// has that hash
+//
+// Parameters:
+// - txHash: The hash of the transaction whose receipt to retrieve
+func (r *evmRouter) EthGetTransactionReceipt(request JSONRPCRequest) (interface{}, error) {
+	txHash, err := hashParam("eth_getTransactionReceipt", request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	record, found, err := r.submissions.lookup(txHash)
+	if err != nil {
+		return nil, newInternalError("failed to read submitted transaction: %w", err)
+	}
+	if found {
+		return record.Receipt, nil
+	}
+
+	block, found, err := r.blockByTxHash(txHash)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	receipts, err := r.mappers.mapReceipts(block)
+	if err != nil {
+		return nil, newInternalError("failed to map receipts of block %d: %w", block.ID, err)
+	}
+
+	for _, receipt := range receipts {
+		if strings.EqualFold(receipt.TransactionHash, txHash) {
+			return receipt, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// EthGetBlockReceipts implements the eth_getBlockReceipts RPC method
+// Retrieves the receipts of every transaction of a block, or null when the block does not exist
+//
+// Parameters:
+// - block: Block number in hex, a block tag or a block hash
+func (r *evmRouter) EthGetBlockReceipts(request JSONRPCRequest) (interface{}, error) {
+	params, ok := request.Params.([]interface{})
+	if !ok || len(params) < 1 {
+		return nil, newInvalidParamsError("invalid params for eth_getBlockReceipts")
+	}
+
+	blockParam, ok := params[0].(string)
+	if !ok {
+		return nil, newInvalidParamsError("invalid block parameter")
+	}
+
+	if len(blockParam) == blockHashLength {
+		if _, err := hexutil.Decode(blockParam); err != nil {
+			return nil, newInvalidParamsError("invalid block hash: %w", err)
+		}
+
+		number, found, err := r.findBlockByHash(blockParam)
+		if err != nil || !found {
+			return nil, err
+		}
+		return r.blockReceipts(number)
+	}
+
+	number, err := r.resolveBlockNumber(blockParam)
+	if isNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.blockReceipts(number)
+}
+
+// blockReceipts returns the receipts of a block, or nil when the block does not exist
+func (r *evmRouter) blockReceipts(number uint64) (interface{}, error) {
+	result, err := r.getBlocks(number, 1)
+	if err != nil {
+		return nil, newCanisterError(err, "failed to get block %d", number)
+	}
+	if len(result.Blocks) == 0 {
+		return nil, nil
+	}
+
+	receipts, err := r.mappers.mapReceipts(result.Blocks[0])
+	if err != nil {
+		return nil, newInternalError("failed to map receipts of block %d: %w", number, err)
+	}
+
+	return receipts, nil
+}
+
+// blockByTxHash returns the block holding the transaction with the given hash
+func (r *evmRouter) blockByTxHash(txHash string) (ICRC3Block, bool, error) {
+	number, found, err := r.findBlockByTxHash(txHash)
+	if err != nil || !found {
+		return ICRC3Block{}, false, err
+	}
+
+	result, err := r.getBlocks(number, 1)
+	if err != nil {
+		return ICRC3Block{}, false, newCanisterError(err, "failed to get block %d", number)
+	}
+	if len(result.Blocks) == 0 {
+		return ICRC3Block{}, false, nil
+	}
+
+	return result.Blocks[0], true, nil
+}
+
+// hashParam returns the hash that is the first parameter of a request
+func hashParam(method string, params interface{}) (string, error) {
+	paramSlice, ok := params.([]interface{})
+	if !ok || len(paramSlice) < 1 {
+		return "", newInvalidParamsError("invalid params for %s", method)
+	}
+
+	hash, ok := paramSlice[0].(string)
+	if !ok {
+		return "", newInvalidParamsError("invalid hash")
+	}
+	if _, err := hexutil.Decode(hash); err != nil {
+		return "", newInvalidParamsError("invalid hash: %w", err)
+	}
+
+	return hash, nil
+}
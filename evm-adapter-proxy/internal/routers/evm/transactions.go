@@ -0,0 +1,176 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+const (
+	// receiptStatusSuccess is the status of every receipt; entries in the log never fail
+	receiptStatusSuccess = "0x1"
+	// legacyTransactionType is the type of every synthetic transaction
+	legacyTransactionType = "0x0"
+)
+
+// entryTransactionHashes returns the hashes of the synthetic transactions of a block,
+// one per entry, in order
+func entryTransactionHashes(value icpLogger.Value) ([]string, error) {
+	entries, err := blockEntries(value)
+	if err != nil {
+		return nil, err
+	}
+
+	blockHash, err := icrc3BlockHash(value)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		hash, err := transactionHash(blockHash, i, entry.Value)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
+
+// newTransaction returns the synthetic transaction that emitted a log
+//
+// Parameters:
+//   - log: A log of the transaction, which carries its block, hash and index
+//   - from: The address that sent the entry
+//   - to: The address the entry was sent to
+func newTransaction(log Log, from, to string) Transaction {
+	return Transaction{
+		BlockHash:        log.BlockHash,
+		BlockNumber:      log.BlockNumber,
+		From:             from,
+		Gas:              "0x0",
+		GasPrice:         "0x0",
+		Hash:             log.TxHash,
+		Input:            "0x",
+		Nonce:            "0x0",
+		To:               to,
+		TransactionIndex: log.TxIndex,
+		Value:            "0x0",
+		Type:             legacyTransactionType,
+		V:                "0x0",
+		R:                "0x0",
+		S:                "0x0",
+	}
+}
+
+// newReceipt returns the receipt of a synthetic transaction with its logs
+func newReceipt(tx Transaction, logs []Log) Receipt {
+	return Receipt{
+		TransactionHash:   tx.Hash,
+		TransactionIndex:  tx.TransactionIndex,
+		BlockHash:         tx.BlockHash,
+		BlockNumber:       tx.BlockNumber,
+		From:              tx.From,
+		To:                tx.To,
+		CumulativeGasUsed: "0x0",
+		GasUsed:           "0x0",
+		EffectiveGasPrice: "0x0",
+		Logs:              logs,
+		LogsBloom:         logsBloom(logs),
+		Type:              tx.Type,
+		Status:            receiptStatusSuccess,
+	}
+}
+
+// logsBloom returns the bloom filter of the addresses and topics of logs
+func logsBloom(logs []Log) string {
+	var bloom types.Bloom
+	for _, log := range logs {
+		bloom.Add(common.HexToAddress(log.Address).Bytes())
+		for _, topic := range log.Topics {
+			bloom.Add(common.HexToHash(topic).Bytes())
+		}
+	}
+
+	return hexutil.Encode(bloom[:])
+}
+
+// blockReceipts returns the receipt of each transaction, with the logs it emitted
+func blockReceipts(transactions []Transaction, logs []Log) []Receipt {
+	receipts := make([]Receipt, 0, len(transactions))
+	for _, tx := range transactions {
+		txLogs := []Log{}
+		for _, log := range logs {
+			if log.TxHash == tx.Hash {
+				txLogs = append(txLogs, log)
+			}
+		}
+		receipts = append(receipts, newReceipt(tx, txLogs))
+	}
+
+	return receipts
+}
+
+// transactionsRoot returns the root of the Ethereum transaction trie of a block
+//
+// Each transaction is encoded as the legacy transaction its JSON object decodes to,
+// so clients recomputing the root from eth_getBlockByNumber get the same value.
+func transactionsRoot(transactions []Transaction) string {
+	return types.DeriveSha(legacyTransactions(transactions), trie.NewStackTrie(nil)).Hex()
+}
+
+// legacyTransactions returns the legacy transactions the JSON objects of synthetic
+// transactions decode to
+func legacyTransactions(transactions []Transaction) types.Transactions {
+	txs := make(types.Transactions, 0, len(transactions))
+	for _, tx := range transactions {
+		to := common.HexToAddress(tx.To)
+		txs = append(txs, types.NewTx(&types.LegacyTx{
+			GasPrice: new(big.Int),
+			To:       &to,
+			Value:    new(big.Int),
+			V:        new(big.Int),
+			R:        new(big.Int),
+			S:        new(big.Int),
+		}))
+	}
+
+	return txs
+}
+
+// receiptsRoot returns the root of the Ethereum receipt trie of a block, built from
+// the consensus encoding of the receipts: status, cumulative gas, bloom and logs
+func receiptsRoot(receipts []Receipt) (string, error) {
+	consensus := make(types.Receipts, 0, len(receipts))
+	for _, receipt := range receipts {
+		logs := make([]*types.Log, 0, len(receipt.Logs))
+		for _, log := range receipt.Logs {
+			data, err := hexutil.Decode(log.Data)
+			if err != nil {
+				return "", fmt.Errorf("invalid data in log %s of transaction %s: %w", log.LogIndex, receipt.TransactionHash, err)
+			}
+
+			topics := make([]common.Hash, 0, len(log.Topics))
+			for _, topic := range log.Topics {
+				topics = append(topics, common.HexToHash(topic))
+			}
+
+			logs = append(logs, &types.Log{Address: common.HexToAddress(log.Address), Topics: topics, Data: data})
+		}
+
+		consensus = append(consensus, &types.Receipt{
+			Type:   types.LegacyTxType,
+			Status: types.ReceiptStatusSuccessful,
+			Bloom:  types.BytesToBloom(common.FromHex(receipt.LogsBloom)),
+			Logs:   logs,
+		})
+	}
+
+	return types.DeriveSha(consensus, trie.NewStackTrie(nil)).Hex(), nil
+}
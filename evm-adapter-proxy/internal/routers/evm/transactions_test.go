@@ -0,0 +1,258 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRPCServer serves the JSON-RPC methods of a router over HTTP
+func newTestRPCServer(t *testing.T, router *evmRouter) *httptest.Server {
+	router.initMethodHandlers()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(router.processMessage(req.Context(), router.methodHandlers, body)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestTransactionHash(t *testing.T) {
+	blockHash := common.Hash{1}.Hex()
+	entry := mapValue(testMapEntry{Field0: "amt", Field1: natValue(7)})
+
+	hash, err := transactionHash(blockHash, 0, entry)
+	require.NoError(t, err)
+
+	again, err := transactionHash(blockHash, 0, mapValue(testMapEntry{Field0: "amt", Field1: natValue(7)}))
+	require.NoError(t, err)
+	assert.Equal(t, hash, again, "equal entries have equal hashes")
+
+	otherIndex, err := transactionHash(blockHash, 1, entry)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, otherIndex)
+
+	otherBlock, err := transactionHash(common.Hash{2}.Hex(), 0, entry)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, otherBlock)
+
+	_, err = transactionHash("0xzz", 0, entry)
+	assert.Error(t, err)
+}
+
+func TestEntryTransactions(t *testing.T) {
+	block := ICRC3Block{ID: 3, Value: newTestBlock(3, testCallerA, testCallerB)}
+	registry := newDefaultMapperRegistry(nil, nil)
+
+	logs, err := registry.mapLogs(block, logFilter{})
+	require.NoError(t, err)
+	require.Len(t, logs, 2)
+
+	transactions, err := registry.mapTransactions(block)
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+
+	hashes, err := entryTransactionHashes(block.Value)
+	require.NoError(t, err)
+
+	for i, caller := range []string{testCallerA, testCallerB} {
+		from, err := convertICPToEthAddress(caller)
+		require.NoError(t, err)
+
+		tx := transactions[i]
+		assert.Equal(t, logs[i].TxHash, tx.Hash)
+		assert.Equal(t, hashes[i], tx.Hash)
+		assert.Equal(t, hexIndex(i), tx.TransactionIndex)
+		assert.Equal(t, "0x3", tx.BlockNumber)
+		assert.Equal(t, logs[i].BlockHash, tx.BlockHash)
+		assert.Equal(t, from, tx.From)
+		assert.Equal(t, logs[i].Address, tx.To)
+	}
+	assert.NotEqual(t, transactions[0].Hash, transactions[1].Hash)
+
+	receipts, err := registry.mapReceipts(block)
+	require.NoError(t, err)
+	require.Len(t, receipts, 2)
+	for i, receipt := range receipts {
+		assert.Equal(t, transactions[i].Hash, receipt.TransactionHash)
+		assert.Equal(t, []Log{logs[i]}, receipt.Logs)
+		assert.Equal(t, receiptStatusSuccess, receipt.Status)
+	}
+}
+
+func TestLedgerTransactions(t *testing.T) {
+	alice, aliceAddress := accountValue(t, "rrkah-fqaaa-aaaaa-aaaaq-cai")
+	bob, _ := accountValue(t, "ryjl3-tyaaa-aaaaa-aaaba-cai")
+	block := ICRC3Block{ID: 4, Value: mapValue(
+		testMapEntry{Field0: "btype", Field1: textValue(BlockTypeICRC1Transfer)},
+		testMapEntry{Field0: "ts", Field1: natValue(1e9)},
+		testMapEntry{Field0: "fee", Field1: natValue(10)},
+		testMapEntry{Field0: "tx", Field1: mapValue(
+			testMapEntry{Field0: "from", Field1: alice},
+			testMapEntry{Field0: "to", Field1: bob},
+			testMapEntry{Field0: "amt", Field1: natValue(500)},
+		)},
+	)}
+	registry := newLedgerRegistry()
+
+	transactions, err := registry.mapTransactions(block)
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, aliceAddress.Hex(), transactions[0].From)
+	assert.Equal(t, testLedgerToken.Hex(), transactions[0].To)
+
+	hashes, err := entryTransactionHashes(block.Value)
+	require.NoError(t, err)
+	assert.Equal(t, hashes, []string{transactions[0].Hash})
+
+	receipts, err := registry.mapReceipts(block)
+	require.NoError(t, err)
+	require.Len(t, receipts, 1)
+	assert.Len(t, receipts[0].Logs, 2, "the transfer and its fee are logged by the same transaction")
+
+	bloom := types.BytesToBloom(common.FromHex(receipts[0].LogsBloom))
+	assert.True(t, types.BloomLookup(bloom, testLedgerToken))
+	assert.True(t, types.BloomLookup(bloom, TransferEventTopic))
+	assert.True(t, types.BloomLookup(bloom, common.BytesToHash(aliceAddress.Bytes())))
+	assert.False(t, types.BloomLookup(bloom, ApprovalEventTopic))
+}
+
+func TestTransactionHandlers(t *testing.T) {
+	log := newTestLog(6)
+	blockStore := openTestStore(t, filepath.Join(t.TempDir(), "blocks.db"))
+	router := newStoreRouter(blockStore)
+	require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 10).Start())
+
+	transactions, err := router.mappers.mapTransactions(log.blocks[4])
+	require.NoError(t, err)
+	receipts, err := router.mappers.mapReceipts(log.blocks[4])
+	require.NoError(t, err)
+	tx := transactions[0]
+
+	t.Run("Transaction by hash", func(t *testing.T) {
+		got, err := router.EthGetTransactionByHash(JSONRPCRequest{Params: []interface{}{tx.Hash}})
+		require.NoError(t, err)
+		assert.Equal(t, tx, got)
+	})
+
+	t.Run("Transaction receipt", func(t *testing.T) {
+		got, err := router.EthGetTransactionReceipt(JSONRPCRequest{Params: []interface{}{tx.Hash}})
+		require.NoError(t, err)
+		assert.Equal(t, receipts[0], got)
+	})
+
+	t.Run("Block receipts by number and hash", func(t *testing.T) {
+		got, err := router.EthGetBlockReceipts(JSONRPCRequest{Params: []interface{}{"0x4"}})
+		require.NoError(t, err)
+		assert.Equal(t, receipts, got)
+
+		got, err = router.EthGetBlockReceipts(JSONRPCRequest{Params: []interface{}{tx.BlockHash}})
+		require.NoError(t, err)
+		assert.Equal(t, receipts, got)
+	})
+
+	t.Run("Block with full transactions", func(t *testing.T) {
+		got, err := router.EthGetBlockByHash(JSONRPCRequest{Params: []interface{}{tx.BlockHash, true}})
+		require.NoError(t, err)
+		require.IsType(t, BlockWithTransactions{}, got)
+		assert.Equal(t, transactions, got.(BlockWithTransactions).Transactions)
+
+		encoded, err := json.Marshal(got)
+		require.NoError(t, err)
+		var decoded struct {
+			Hash         string        `json:"hash"`
+			Transactions []Transaction `json:"transactions"`
+		}
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.Equal(t, tx.BlockHash, decoded.Hash)
+		assert.Equal(t, transactions, decoded.Transactions)
+
+		got, err = router.EthGetBlockByHash(JSONRPCRequest{Params: []interface{}{tx.BlockHash, false}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{tx.Hash}, got.(Block).Transactions)
+	})
+
+	t.Run("Invalid params", func(t *testing.T) {
+		for _, request := range []JSONRPCRequest{
+			{Params: []interface{}{"not a hash"}},
+			{Params: []interface{}{}},
+			{Params: []interface{}{42}},
+		} {
+			_, err := router.EthGetTransactionByHash(request)
+			var rpcErr *RPCError
+			require.True(t, errors.As(err, &rpcErr))
+			assert.Equal(t, ErrCodeInvalidParams, rpcErr.Code)
+		}
+
+		_, err := router.EthGetBlockByHash(JSONRPCRequest{Params: []interface{}{tx.BlockHash, "yes"}})
+		var rpcErr *RPCError
+		require.True(t, errors.As(err, &rpcErr))
+		assert.Equal(t, ErrCodeInvalidParams, rpcErr.Code)
+	})
+}
+
+func TestBlockRoots(t *testing.T) {
+	log := newTestLog(6)
+	blockStore := openTestStore(t, filepath.Join(t.TempDir(), "blocks.db"))
+	router := newStoreRouter(blockStore)
+	require.NoError(t, newBlockIngester(blockStore, log.fetch, router.indexBlock, 10).Start())
+	log.blocks[5] = ICRC3Block{ID: 5, Value: newTestBlock(5, testCallerA, testCallerB)}
+
+	t.Run("Header fields", func(t *testing.T) {
+		evmBlock, err := router.mappers.mapBlock(log.blocks[5])
+		require.NoError(t, err)
+
+		logs, err := router.mappers.mapLogs(log.blocks[5], logFilter{})
+		require.NoError(t, err)
+		bloom := types.BytesToBloom(common.FromHex(evmBlock.LogsBloom))
+		for _, log := range logs {
+			assert.True(t, types.BloomLookup(bloom, common.HexToAddress(log.Address)))
+		}
+		assert.False(t, types.BloomLookup(bloom, TransferEventTopic))
+
+		assert.Equal(t, types.EmptyUncleHash.Hex(), evmBlock.Sha3Uncles)
+		assert.NotEqual(t, types.EmptyTxsHash.Hex(), evmBlock.TransactionsRoot)
+		assert.NotEqual(t, types.EmptyReceiptsHash.Hex(), evmBlock.ReceiptsRoot)
+
+		other, err := router.mappers.mapBlock(log.blocks[4])
+		require.NoError(t, err)
+		assert.NotEqual(t, other.ReceiptsRoot, evmBlock.ReceiptsRoot)
+	})
+
+	t.Run("Decoded by ethclient", func(t *testing.T) {
+		client, err := ethclient.Dial(newTestRPCServer(t, router).URL)
+		require.NoError(t, err)
+		defer client.Close()
+
+		block, err := client.BlockByNumber(context.Background(), big.NewInt(3))
+		require.NoError(t, err)
+		require.Len(t, block.Transactions(), 1)
+		assert.Equal(t, uint64(3), block.NumberU64())
+		assert.Equal(t, types.EmptyUncleHash, block.UncleHash())
+		assert.Equal(t, types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)), block.TxHash())
+
+		receipts, err := client.BlockReceipts(context.Background(), rpc.BlockNumberOrHashWithNumber(3))
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		assert.Equal(t, types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil)), block.ReceiptHash())
+		assert.Equal(t, types.CreateBloom(types.Receipts(receipts)), block.Bloom())
+	})
+}
@@ -1,26 +1,52 @@
 package evm
 
 import (
-	"fmt"
+	"encoding/json"
 	"math/big"
 	"strconv"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
+// JSONRPCRequest is a single JSON-RPC 2.0 request. ID is kept raw so it can be
+// echoed back verbatim; an absent ID marks the request as a notification.
 type JSONRPCRequest struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Method  string      `json:"method"`
-	Params  interface{} `json:"params"`
-	ID      interface{} `json:"id"`
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification reports whether the request carries no ID and therefore expects no response
+func (r JSONRPCRequest) IsNotification() bool {
+	return len(r.ID) == 0
 }
 
 type JSONRPCResponse struct {
-	JSONRPC string        `json:"jsonrpc"`
-	Result  interface{}   `json:"result,omitempty"`
-	Error   *JSONRPCError `json:"error,omitempty"`
-	ID      interface{}   `json:"id"`
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// MarshalJSON implements json.Marshaler. A response carries either an error or a
+// result, and a nil result is sent as null, e.g. for an unknown block.
+func (r JSONRPCResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *JSONRPCError   `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{JSONRPC: r.JSONRPC, Error: r.Error, ID: r.ID})
+	}
+
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{JSONRPC: r.JSONRPC, Result: r.Result, ID: r.ID})
 }
 
 type JSONRPCError struct {
@@ -49,6 +75,68 @@ type Block struct {
 	Timestamp        string   `json:"timestamp"`
 	Transactions     []string `json:"transactions"`
 	Uncles           []string `json:"uncles"`
+	MixHash          string   `json:"mixHash"`
+	// Fields added by later forks, set according to the configured HeaderFork
+	BaseFeePerGas         string         `json:"baseFeePerGas,omitempty"`
+	WithdrawalsRoot       string         `json:"withdrawalsRoot,omitempty"`
+	Withdrawals           *[]interface{} `json:"withdrawals,omitempty"`
+	BlobGasUsed           string         `json:"blobGasUsed,omitempty"`
+	ExcessBlobGas         string         `json:"excessBlobGas,omitempty"`
+	ParentBeaconBlockRoot string         `json:"parentBeaconBlockRoot,omitempty"`
+}
+
+// BlockWithTransactions is a block returned with fullTransactions set: its transactions
+// field holds the transaction objects instead of their hashes
+type BlockWithTransactions struct {
+	Block
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Transaction is the synthetic EVM transaction of an ICRC-3 block entry, or a signed
+// transaction submitted with eth_sendRawTransaction
+//
+// Entries are not signed EVM transactions, so their gas, nonce and signature fields are zero.
+type Transaction struct {
+	BlockHash        string `json:"blockHash"`
+	BlockNumber      string `json:"blockNumber"`
+	From             string `json:"from"`
+	Gas              string `json:"gas"`
+	GasPrice         string `json:"gasPrice"`
+	Hash             string `json:"hash"`
+	Input            string `json:"input"`
+	Nonce            string `json:"nonce"`
+	To               string `json:"to"`
+	TransactionIndex string `json:"transactionIndex"`
+	Value            string `json:"value"`
+	Type             string `json:"type"`
+	V                string `json:"v"`
+	R                string `json:"r"`
+	S                string `json:"s"`
+	// ChainID, the fee caps and AccessList are only set on signed EIP-2930 and
+	// EIP-1559 transactions submitted with eth_sendRawTransaction
+	ChainID              string            `json:"chainId,omitempty"`
+	MaxFeePerGas         string            `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string            `json:"maxPriorityFeePerGas,omitempty"`
+	AccessList           *types.AccessList `json:"accessList,omitempty"`
+}
+
+// Receipt is the receipt of a transaction; every entry in the log succeeded, only
+// submitted transactions the DEX canister rejects have status 0x0
+type Receipt struct {
+	TransactionHash   string  `json:"transactionHash"`
+	TransactionIndex  string  `json:"transactionIndex"`
+	BlockHash         string  `json:"blockHash"`
+	BlockNumber       string  `json:"blockNumber"`
+	From              string  `json:"from"`
+	To                string  `json:"to"`
+	CumulativeGasUsed string  `json:"cumulativeGasUsed"`
+	GasUsed           string  `json:"gasUsed"`
+	EffectiveGasPrice string  `json:"effectiveGasPrice"`
+	ContractAddress   *string `json:"contractAddress"`
+	Logs              []Log   `json:"logs"`
+	LogsBloom         string  `json:"logsBloom"`
+	Type              string  `json:"type"`
+	Status            string  `json:"status"`
 }
 
 type Log struct {
@@ -63,6 +151,18 @@ type Log struct {
 	Removed     bool     `json:"removed"`
 }
 
+// SubscriptionNotification is the eth_subscription message pushed to WebSocket clients
+type SubscriptionNotification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  SubscriptionResult `json:"params"`
+}
+
+type SubscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
 type LogData struct {
 	Operation string         `json:"operation"`
 	Detail    LogDataDetails `json:"detail"`
@@ -110,13 +210,9 @@ func hexToDecimal(hex string) (string, error) {
 	return strconv.FormatUint(n, 10), nil
 }
 
-// ConvertHexAmountToBigInt converts a hex amount to a big.Int
-// Returns nil if the conversion fails
+// ConvertHexAmountToBigInt converts a hex amount to a big.Int.
+// The returned value is a copy, so callers may modify it; the error is always nil
+// because a hexutil.Big is already a parsed number, and is kept for existing callers.
 func ConvertHexAmountToBigInt(amount hexutil.Big) (*big.Int, error) {
-	amountStr := strings.TrimPrefix(amount.String(), "0x")
-	amountInt, ok := new(big.Int).SetString(amountStr, 16)
-	if !ok {
-		return nil, fmt.Errorf("failed to parse amount: %s", amount.String())
-	}
-	return amountInt, nil
+	return new(big.Int).Set(amount.ToInt()), nil
 }
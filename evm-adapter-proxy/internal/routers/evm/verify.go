@@ -0,0 +1,420 @@
+package evm
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zondax/golem/pkg/metrics"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+	"go.uber.org/zap"
+)
+
+// BlockVerificationFailuresMetric counts blocks rejected by the hash chain verification,
+// labeled by the check that failed
+const BlockVerificationFailuresMetric = "block_verification_failures"
+
+// Checks reported in the reason label of BlockVerificationFailuresMetric
+const (
+	verificationReasonHash  = "hash"
+	verificationReasonPhash = "phash"
+	verificationReasonTip   = "tip"
+)
+
+// verifyPageSize is the number of blocks fetched at a time while walking the chain
+// back from the certified tip
+const verifyPageSize = 100
+
+// maxVerifyWalk is the number of blocks a single verification may fetch while walking
+// the chain, so one request for an old block cannot hold the canister for long
+const maxVerifyWalk = 10 * verifyPageSize
+
+// maxVerifiedHashes is the number of hashes the verifier remembers inside its verified
+// range, besides the hashes of its bounds
+const maxVerifiedHashes = 100000
+
+// blockVerifier checks that the blocks served by the canister belong to the chain
+// anchored at its certified tip
+//
+// The hash of a block is its ICRC-3 representation-independent hash. The tip hash is
+// certified by the IC, and each verified block certifies the hash of its parent
+// through phash, so a block is verified once the chain from the tip down to it is.
+// The verified range [low, high] grows down to the oldest block requested and up with
+// the tip. The hashes of its bounds are always kept, and those of the last
+// maxHashes blocks verified inside it are remembered so they are not walked again;
+// the chain is walked down again from the closest remembered hash for the others.
+// Blocks and the tip are fetched outside of mu, which only guards the verified range.
+type blockVerifier struct {
+	mu        sync.Mutex
+	low       uint64
+	high      uint64
+	lowHash   []byte
+	highHash  []byte
+	hashes    map[uint64]*list.Element
+	lru       *list.List
+	maxHashes int
+	maxWalk   int
+
+	fetch   func(start, length uint64) (blocksPage, error)
+	tip     func() (certifiedTip, error)
+	source  string
+	metrics metrics.TaskMetrics
+}
+
+// newBlockVerifier creates a verifier reading blocks with fetch and the certified tip with tip
+//
+// Parameters:
+//   - fetch: Returns unverified blocks from the canister
+//   - tip: Returns the verified tip certificate of the canister
+//   - source: The source of the router, reported in the source label of the metric
+//   - metricsServer: Receives BlockVerificationFailuresMetric, may be nil
+func newBlockVerifier(fetch func(start, length uint64) (blocksPage, error), tip func() (certifiedTip, error), source string, metricsServer metrics.TaskMetrics) *blockVerifier {
+	if metricsServer != nil {
+		registerCounter(metricsServer, BlockVerificationFailuresMetric, "Blocks that failed ICRC-3 hash chain verification.", "source", "reason")
+	}
+
+	return &blockVerifier{
+		hashes:    make(map[uint64]*list.Element),
+		lru:       list.New(),
+		maxHashes: maxVerifiedHashes,
+		maxWalk:   maxVerifyWalk,
+		fetch:     fetch,
+		tip:       tip,
+		source:    source,
+		metrics:   metricsServer,
+	}
+}
+
+// verifiedHash is a remembered hash of a block inside the verified range
+type verifiedHash struct {
+	id   uint64
+	hash []byte
+}
+
+// remembered returns the hash of a verified block, if it is a bound of the verified
+// range or still remembered
+func (v *blockVerifier) remembered(id uint64) ([]byte, bool) {
+	switch id {
+	case v.high:
+		return v.highHash, true
+	case v.low:
+		return v.lowHash, true
+	}
+
+	element, ok := v.hashes[id]
+	if !ok {
+		return nil, false
+	}
+	v.lru.MoveToFront(element)
+	return element.Value.(verifiedHash).hash, true
+}
+
+// remember records the hash of a verified block, forgetting the least recently used
+// hashes beyond maxHashes
+func (v *blockVerifier) remember(id uint64, hash []byte) {
+	if element, ok := v.hashes[id]; ok {
+		element.Value = verifiedHash{id: id, hash: hash}
+		v.lru.MoveToFront(element)
+		return
+	}
+
+	v.hashes[id] = v.lru.PushFront(verifiedHash{id: id, hash: hash})
+	for v.lru.Len() > v.maxHashes {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.hashes, oldest.Value.(verifiedHash).id)
+	}
+}
+
+// hash returns the hash of a block inside the verified range, walking the chain down
+// again from the closest block above it whose hash is remembered when it was forgotten
+func (v *blockVerifier) hash(id uint64, fetched map[uint64]ICRC3Block) ([]byte, error) {
+	if hash, ok := v.remembered(id); ok {
+		return hash, nil
+	}
+
+	top := id + 1
+	hash, ok := v.remembered(top)
+	for !ok {
+		top++
+		hash, ok = v.remembered(top)
+	}
+
+	for ; top > id; top-- {
+		parent, err := v.verifyLink(top, id, hash, fetched)
+		if err != nil {
+			return nil, err
+		}
+		v.remember(top-1, parent)
+		hash = parent
+	}
+
+	return hash, nil
+}
+
+// verify checks every block of a page against the certified chain
+//
+// The checks run under the lock on the blocks fetched so far and stop at the first
+// block of the walk that is missing; it is fetched outside the lock, with the blocks
+// below it, and the checks run again. The walk is limited to maxWalk blocks per page,
+// the hashes verified before the limit is reached are kept for the next request.
+func (v *blockVerifier) verify(page blocksPage) error {
+	if len(page.Blocks) == 0 {
+		return nil
+	}
+
+	last := page.Blocks[len(page.Blocks)-1].ID
+	fetched := make(map[uint64]ICRC3Block, len(page.Blocks))
+	for _, block := range page.Blocks {
+		fetched[block.ID] = block
+	}
+
+	var tip *certifiedTip
+	if v.needsTip(last) {
+		certified, err := v.tip()
+		if err != nil {
+			return err
+		}
+		tip = &certified
+	}
+
+	walked := 0
+	for {
+		err := v.check(page, tip, fetched)
+		var needed *blocksNeeded
+		if !errors.As(err, &needed) {
+			return err
+		}
+
+		if walked >= v.maxWalk {
+			return newRPCError(ErrCodeLimitExceeded, "verifying block %d walks more than %d blocks of the chain; retry to continue the walk", needed.end, v.maxWalk)
+		}
+		length := min(needed.end-needed.start+1, uint64(v.maxWalk-walked))
+		needed.start = needed.end + 1 - length
+		walked += int(length)
+
+		fetchedPage, err := v.fetch(needed.start, length)
+		if err != nil {
+			return newCanisterError(err, "failed to get blocks to verify")
+		}
+		for _, block := range fetchedPage.Blocks {
+			fetched[block.ID] = block
+		}
+		if _, ok := fetched[needed.end]; !ok {
+			return newNotFoundError("block %d not found while verifying the chain", needed.end)
+		}
+	}
+}
+
+// needsTip reports whether verifying blocks up to last needs the certified tip: before
+// the first verification, and for blocks above the verified range
+func (v *blockVerifier) needsTip(last uint64) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.highHash == nil || last > v.high
+}
+
+// check verifies a page with the blocks fetched so far, returning a blocksNeeded error
+// for the first block of the walk that is missing
+func (v *blockVerifier) check(page blocksPage, tip *certifiedTip, fetched map[uint64]ICRC3Block) error {
+	first, last := page.Blocks[0].ID, page.Blocks[len(page.Blocks)-1].ID
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if tip != nil {
+		if err := v.extendUp(*tip, fetched); err != nil {
+			return err
+		}
+	}
+	if last > v.high {
+		return v.fail(verificationReasonTip, "block %d is above the certified tip %d", last, v.high)
+	}
+	if first < v.low {
+		if err := v.extendDown(first, fetched); err != nil {
+			return err
+		}
+	}
+
+	for _, block := range page.Blocks {
+		expected, err := v.hash(block.ID, fetched)
+		if err != nil {
+			return err
+		}
+		hash, err := computeBlockHash(block.Value)
+		if err != nil {
+			return v.fail(verificationReasonHash, "block %d: %v", block.ID, err)
+		}
+		if !bytes.Equal(hash, expected) {
+			return v.fail(verificationReasonHash, "block %d hash %x does not match the certified chain", block.ID, hash)
+		}
+	}
+
+	return nil
+}
+
+// extendUp anchors the verified range at the certified tip, walking down from it to
+// the previous tip
+func (v *blockVerifier) extendUp(tip certifiedTip, fetched map[uint64]ICRC3Block) error {
+	if v.highHash == nil {
+		v.low, v.high = tip.Index, tip.Index
+		v.lowHash, v.highHash = tip.Hash, tip.Hash
+		return nil
+	}
+
+	if tip.Index <= v.high {
+		expected, err := v.hash(tip.Index, fetched)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tip.Hash, expected) {
+			return v.fail(verificationReasonTip, "certified tip %d hash %x does not match the verified chain", tip.Index, tip.Hash)
+		}
+		return nil
+	}
+
+	// A tip too far above the verified range could never be joined to it within a
+	// walk, the range starts over from the tip, which the IC certifies
+	if tip.Index-v.high > uint64(v.maxWalk) {
+		v.low, v.high = tip.Index, tip.Index
+		v.lowHash, v.highHash = tip.Hash, tip.Hash
+		v.hashes = make(map[uint64]*list.Element)
+		v.lru.Init()
+		return nil
+	}
+
+	// Walk the new blocks into a separate chain and join it to the verified range
+	// only once its oldest block links to the previous tip
+	chain := map[uint64][]byte{tip.Index: tip.Hash}
+	for id := tip.Index; id > v.high; id-- {
+		parent, err := v.verifyLink(id, v.high+1, chain[id], fetched)
+		if err != nil {
+			return err
+		}
+		chain[id-1] = parent
+	}
+	if !bytes.Equal(chain[v.high], v.highHash) {
+		return v.fail(verificationReasonPhash, "block %d phash does not link to block %d", v.high+1, v.high)
+	}
+
+	for id := v.high; id < tip.Index; id++ {
+		v.remember(id, chain[id])
+	}
+	v.high, v.highHash = tip.Index, tip.Hash
+
+	return nil
+}
+
+// extendDown grows the verified range down to block to, keeping the blocks verified
+// before a missing one
+func (v *blockVerifier) extendDown(to uint64, fetched map[uint64]ICRC3Block) error {
+	for id := v.low; id > to; id-- {
+		parent, err := v.verifyLink(id, to, v.lowHash, fetched)
+		if err != nil {
+			return err
+		}
+		v.remember(id, v.lowHash)
+		v.low, v.lowHash = id-1, parent
+	}
+
+	return nil
+}
+
+// verifyLink checks the hash of block id and returns its phash, the hash its parent must have
+func (v *blockVerifier) verifyLink(id, bottom uint64, expected []byte, fetched map[uint64]ICRC3Block) ([]byte, error) {
+	block, err := v.block(id, bottom, fetched)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := computeBlockHash(block.Value)
+	if err != nil {
+		return nil, v.fail(verificationReasonHash, "block %d: %v", id, err)
+	}
+	if !bytes.Equal(hash, expected) {
+		return nil, v.fail(verificationReasonHash, "block %d hash %x does not match the certified chain", id, hash)
+	}
+
+	parent := lookupField(block.Value, "phash")
+	if parent == nil || parent.Blob == nil {
+		return nil, v.fail(verificationReasonPhash, "block %d has no phash", id)
+	}
+
+	return *parent.Blob, nil
+}
+
+// blocksNeeded is returned by the checks when a block of the walk was not fetched
+// yet; verify fetches the blocks in [start, end] and checks again
+type blocksNeeded struct {
+	start uint64
+	end   uint64
+}
+
+func (e *blocksNeeded) Error() string {
+	return fmt.Sprintf("blocks %d to %d are needed to verify the chain", e.start, e.end)
+}
+
+// block returns a block from the fetched ones, or a blocksNeeded error for the page of
+// at most verifyPageSize blocks in [bottom, id] that ends with it
+func (v *blockVerifier) block(id, bottom uint64, fetched map[uint64]ICRC3Block) (ICRC3Block, error) {
+	if block, ok := fetched[id]; ok {
+		return block, nil
+	}
+
+	start := bottom
+	if id+1 > verifyPageSize && id+1-verifyPageSize > start {
+		start = id + 1 - verifyPageSize
+	}
+
+	return ICRC3Block{}, &blocksNeeded{start: start, end: id}
+}
+
+// fail records a verification failure and returns its RPC error
+func (v *blockVerifier) fail(reason, format string, args ...interface{}) error {
+	if v.metrics != nil {
+		if err := v.metrics.IncrementMetric(BlockVerificationFailuresMetric, v.source, reason); err != nil {
+			zap.S().Warnf("failed to increment %s metric: %v", BlockVerificationFailuresMetric, err)
+		}
+	}
+
+	return newVerificationError(format, args...)
+}
+
+// computeBlockHash returns the representation-independent hash of a block
+//
+// Blocks that carry their own hash field are hashed without it, and the field
+// must match the computed hash.
+func computeBlockHash(value icpLogger.Value) ([]byte, error) {
+	if value.Map == nil {
+		return nil, fmt.Errorf("invalid block format: Map is nil")
+	}
+
+	var claimed []byte
+	fields := make([]struct {
+		Field0 string          `ic:"0" json:"0"`
+		Field1 icpLogger.Value `ic:"1" json:"1"`
+	}, 0, len(*value.Map))
+	for _, field := range *value.Map {
+		if field.Field0 == "hash" {
+			claimed = []byte{}
+			if field.Field1.Blob != nil {
+				claimed = *field.Field1.Blob
+			}
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	hash, err := hashValue(icpLogger.Value{Map: &fields})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash block: %w", err)
+	}
+	if claimed != nil && !bytes.Equal(claimed, hash) {
+		return nil, fmt.Errorf("hash field %x does not match the computed hash %x", claimed, hash)
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,228 @@
+package evm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/zondax/golem/pkg/metrics"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+)
+
+// hashChain is an ICRC-3 log whose blocks are linked by their representation-independent hash
+type hashChain struct {
+	blocks  []ICRC3Block
+	fetches int
+}
+
+func newHashChain(t *testing.T, n int) *hashChain {
+	c := &hashChain{}
+	c.append(t, n)
+	return c
+}
+
+// append adds n blocks linked to the current tip
+func (c *hashChain) append(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		id := uint64(len(c.blocks))
+		fields := []testMapEntry{
+			{Field0: "btype", Field1: textValue(BlockTypeICRC1Mint)},
+			{Field0: "ts", Field1: natValue(id * 1e9)},
+			{Field0: "tx", Field1: mapValue(testMapEntry{Field0: "amt", Field1: natValue(id + 1)})},
+		}
+		if id > 0 {
+			fields = append(fields, testMapEntry{Field0: "phash", Field1: blobValue(c.hash(t, id-1))})
+		}
+		c.blocks = append(c.blocks, ICRC3Block{ID: id, Value: mapValue(fields...)})
+	}
+}
+
+func (c *hashChain) hash(t *testing.T, id uint64) []byte {
+	hash, err := hashValue(c.blocks[id].Value)
+	require.NoError(t, err)
+	return hash
+}
+
+// tamper changes the content of a block without relinking the chain
+func (c *hashChain) tamper(id uint64) {
+	value := c.blocks[id].Value
+	entries := append(*value.Map, testMapEntry{Field0: "memo", Field1: textValue("tampered")})
+	c.blocks[id] = ICRC3Block{ID: id, Value: icpLogger.Value{Map: &entries}}
+}
+
+func (c *hashChain) fetch(start, length uint64) (blocksPage, error) {
+	c.fetches++
+	page := blocksPage{LogLength: uint64(len(c.blocks))}
+	for id := start; id < start+length && id < uint64(len(c.blocks)); id++ {
+		page.Blocks = append(page.Blocks, c.blocks[id])
+	}
+	return page, nil
+}
+
+func (c *hashChain) page(t *testing.T, start, length uint64) blocksPage {
+	page, err := c.fetch(start, length)
+	require.NoError(t, err)
+	return page
+}
+
+func newChainVerifier(t *testing.T, c *hashChain, tipHash func() []byte) (*blockVerifier, *metrics.MockTaskMetrics) {
+	metricsServer := &metrics.MockTaskMetrics{}
+	metricsServer.On("RegisterMetric", BlockVerificationFailuresMetric, mock.Anything, []string{"source", "reason"}, mock.Anything).Return(nil)
+	metricsServer.On("IncrementMetric", BlockVerificationFailuresMetric, mock.Anything, mock.Anything).Return(nil)
+
+	tip := func() (certifiedTip, error) {
+		index := uint64(len(c.blocks) - 1)
+		if tipHash != nil {
+			return certifiedTip{Index: index, Hash: tipHash()}, nil
+		}
+		return certifiedTip{Index: index, Hash: c.hash(t, index)}, nil
+	}
+
+	return newBlockVerifier(c.fetch, tip, "ledger", metricsServer), metricsServer
+}
+
+func assertVerificationError(t *testing.T, err error, reason string, metricsServer *metrics.MockTaskMetrics) {
+	var rpcErr *RPCError
+	require.True(t, errors.As(err, &rpcErr), "expected an RPC error, got %v", err)
+	assert.Equal(t, ErrCodeBlockVerificationFailed, rpcErr.Code)
+	metricsServer.AssertCalled(t, "IncrementMetric", BlockVerificationFailuresMetric, "ledger", reason)
+}
+
+func TestBlockVerifierValidChain(t *testing.T) {
+	chain := newHashChain(t, 10)
+	verifier, metricsServer := newChainVerifier(t, chain, nil)
+
+	require.NoError(t, verifier.verify(chain.page(t, 3, 3)))
+	assert.Equal(t, uint64(3), verifier.low)
+	assert.Equal(t, uint64(9), verifier.high)
+
+	// Verified blocks are not walked again
+	fetches := chain.fetches
+	require.NoError(t, verifier.verify(chain.page(t, 4, 2)))
+	assert.Equal(t, fetches+1, chain.fetches)
+
+	require.NoError(t, verifier.verify(chain.page(t, 0, 2)))
+	assert.Equal(t, uint64(0), verifier.low)
+
+	// New blocks are linked to the previous tip
+	chain.append(t, 3)
+	require.NoError(t, verifier.verify(chain.page(t, 11, 2)))
+	assert.Equal(t, uint64(12), verifier.high)
+
+	metricsServer.AssertNotCalled(t, "IncrementMetric", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBlockVerifierForgetsHashes(t *testing.T) {
+	chain := newHashChain(t, 30)
+	verifier, metricsServer := newChainVerifier(t, chain, nil)
+	verifier.maxHashes = 5
+
+	require.NoError(t, verifier.verify(chain.page(t, 0, 30)))
+	assert.Equal(t, uint64(0), verifier.low)
+	assert.Equal(t, uint64(29), verifier.high)
+	assert.Len(t, verifier.hashes, 5)
+	assert.Equal(t, 5, verifier.lru.Len())
+
+	// Forgotten hashes are walked again from the closest remembered one
+	fetches := chain.fetches
+	require.NoError(t, verifier.verify(chain.page(t, 10, 1)))
+	assert.Greater(t, chain.fetches, fetches+1)
+	assert.Len(t, verifier.hashes, 5)
+
+	chain.tamper(12)
+	assertVerificationError(t, verifier.verify(chain.page(t, 12, 1)), verificationReasonHash, metricsServer)
+}
+
+func TestBlockVerifierWalkLimit(t *testing.T) {
+	chain := newHashChain(t, 30)
+	verifier, _ := newChainVerifier(t, chain, nil)
+	verifier.maxWalk = 10
+
+	// The lock is not held while the canister is queried
+	fetch := verifier.fetch
+	verifier.fetch = func(start, length uint64) (blocksPage, error) {
+		require.True(t, verifier.mu.TryLock(), "blocks fetched under the lock")
+		verifier.mu.Unlock()
+		return fetch(start, length)
+	}
+
+	require.NoError(t, verifier.verify(chain.page(t, 29, 1)))
+
+	// Each request walks at most maxWalk blocks and keeps what it verified
+	for _, low := range []uint64{19, 9} {
+		err := verifier.verify(chain.page(t, 0, 1))
+		requireRPCCode(t, err, ErrCodeLimitExceeded)
+		assert.Equal(t, low, verifier.low)
+	}
+	require.NoError(t, verifier.verify(chain.page(t, 0, 1)))
+	assert.Equal(t, uint64(0), verifier.low)
+
+	// A tip more than a walk above the verified range starts it over
+	chain.append(t, 20)
+	require.NoError(t, verifier.verify(chain.page(t, 49, 1)))
+	assert.Equal(t, uint64(49), verifier.low)
+	assert.Empty(t, verifier.hashes)
+
+	chain.append(t, 5)
+	require.NoError(t, verifier.verify(chain.page(t, 52, 1)))
+	assert.Equal(t, uint64(54), verifier.high)
+}
+
+func TestBlockVerifierRejects(t *testing.T) {
+	t.Run("Tampered block", func(t *testing.T) {
+		chain := newHashChain(t, 10)
+		verifier, metricsServer := newChainVerifier(t, chain, nil)
+		require.NoError(t, verifier.verify(chain.page(t, 0, 10)))
+
+		chain.tamper(4)
+		assertVerificationError(t, verifier.verify(chain.page(t, 4, 1)), verificationReasonHash, metricsServer)
+	})
+
+	t.Run("Broken phash link below the page", func(t *testing.T) {
+		chain := newHashChain(t, 10)
+		verifier, metricsServer := newChainVerifier(t, chain, nil)
+
+		chain.tamper(7)
+		assertVerificationError(t, verifier.verify(chain.page(t, 2, 2)), verificationReasonHash, metricsServer)
+	})
+
+	t.Run("Tip not certified", func(t *testing.T) {
+		chain := newHashChain(t, 5)
+		verifier, metricsServer := newChainVerifier(t, chain, func() []byte { return make([]byte, 32) })
+
+		assertVerificationError(t, verifier.verify(chain.page(t, 4, 1)), verificationReasonHash, metricsServer)
+	})
+
+	t.Run("New blocks do not link to the previous tip", func(t *testing.T) {
+		chain := newHashChain(t, 5)
+		verifier, metricsServer := newChainVerifier(t, chain, nil)
+		require.NoError(t, verifier.verify(chain.page(t, 4, 1)))
+
+		// Rewrite the history below the new tip
+		chain.blocks = chain.blocks[:3]
+		chain.tamper(2)
+		chain.append(t, 4)
+		assertVerificationError(t, verifier.verify(chain.page(t, 6, 1)), verificationReasonPhash, metricsServer)
+	})
+}
+
+func TestComputeBlockHash(t *testing.T) {
+	value := mapValue(testMapEntry{Field0: "ts", Field1: natValue(1)})
+	hash, err := hashValue(value)
+	require.NoError(t, err)
+
+	got, err := computeBlockHash(mapValue(
+		testMapEntry{Field0: "ts", Field1: natValue(1)},
+		testMapEntry{Field0: "hash", Field1: blobValue(hash)},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, hash, got, "the hash field is not part of the hashed block")
+
+	_, err = computeBlockHash(mapValue(
+		testMapEntry{Field0: "ts", Field1: natValue(1)},
+		testMapEntry{Field0: "hash", Field1: blobValue([]byte{1})},
+	))
+	assert.ErrorContains(t, err, "does not match")
+}
@@ -2,7 +2,6 @@ package evm
 
 import (
 	"encoding/hex"
-	"fmt"
 
 	"golang.org/x/crypto/sha3"
 )
@@ -29,12 +28,12 @@ func (r *evmRouter) Web3ClientVersion(_ JSONRPCRequest) (interface{}, error) {
 func (r *evmRouter) Web3Sha3(request JSONRPCRequest) (interface{}, error) {
 	params, ok := request.Params.([]interface{})
 	if !ok || len(params) == 0 {
-		return nil, fmt.Errorf("invalid params for web3_sha3")
+		return nil, newInvalidParamsError("invalid params for web3_sha3")
 	}
 
 	input, ok := params[0].(string)
 	if !ok {
-		return nil, fmt.Errorf("invalid input for web3_sha3")
+		return nil, newInvalidParamsError("invalid input for web3_sha3")
 	}
 
 	if len(input) > 2 && input[:2] == "0x" {
@@ -43,7 +42,7 @@ func (r *evmRouter) Web3Sha3(request JSONRPCRequest) (interface{}, error) {
 
 	data, err := hex.DecodeString(input)
 	if err != nil {
-		return nil, fmt.Errorf("invalid hex string: %w", err)
+		return nil, newInvalidParamsError("invalid hex string: %w", err)
 	}
 
 	hash := sha3.NewLegacyKeccak256()
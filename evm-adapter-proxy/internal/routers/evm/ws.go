@@ -0,0 +1,267 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zondax/golem/pkg/logger"
+)
+
+const (
+	defaultWSMaxSubscriptions = 16
+	defaultWSSendQueueSize    = 256
+
+	wsReadLimit    = 1 << 20
+	wsWriteTimeout = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingPeriod   = 30 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// The proxy is a public RPC endpoint, browser dapps from any origin are allowed
+	CheckOrigin: func(_ *http.Request) bool { return true },
+}
+
+// wsConnection is a single WebSocket client
+//
+// Requests are read and processed sequentially; responses and subscription
+// notifications share one bounded send queue drained by a writer goroutine.
+// A client that does not keep up with its queue is disconnected.
+type wsConnection struct {
+	router   *evmRouter
+	conn     *websocket.Conn
+	handlers map[string]methodHandler
+	send     chan []byte
+	done     chan struct{}
+
+	mu            sync.Mutex
+	subscriptions map[string]struct{}
+	closeOnce     sync.Once
+}
+
+// ServeWebSocket upgrades the HTTP connection and serves JSON-RPC over it
+//
+// The connection exposes the same methods as the HTTP endpoint plus
+// eth_subscribe and eth_unsubscribe.
+func (r *evmRouter) ServeWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logger.GetLoggerFromContext(req.Context()).Errorf("failed to upgrade websocket connection: %v", err)
+		return
+	}
+
+	c := &wsConnection{
+		router:        r,
+		conn:          conn,
+		send:          make(chan []byte, r.wsSendQueueSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]struct{}),
+	}
+
+	c.handlers = make(map[string]methodHandler, len(r.methodHandlers)+2)
+	for method, handler := range r.methodHandlers {
+		c.handlers[method] = handler
+	}
+	c.handlers["eth_subscribe"] = c.subscribe
+	c.handlers["eth_unsubscribe"] = c.unsubscribe
+
+	go c.writeLoop()
+	c.readLoop(req.Context())
+}
+
+// readLoop processes incoming messages until the connection fails or is closed
+func (c *wsConnection) readLoop(ctx context.Context) {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsReadLimit)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.GetLoggerFromContext(ctx).Errorf("websocket read failed: %v", err)
+			}
+			return
+		}
+
+		payload := c.router.processMessage(ctx, c.handlers, message)
+		if payload == nil {
+			continue
+		}
+
+		data, err := json.Marshal(payload)
+		if err != nil {
+			logger.GetLoggerFromContext(ctx).Errorf("failed to marshal websocket response: %v", err)
+			continue
+		}
+		c.enqueue(data)
+	}
+}
+
+// writeLoop drains the send queue and keeps the connection alive with pings
+func (c *wsConnection) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// enqueue queues a message without blocking; a full queue means the client is
+// too slow and the connection is dropped
+func (c *wsConnection) enqueue(message []byte) {
+	select {
+	case <-c.done:
+	case c.send <- message:
+	default:
+		logger.GetLoggerFromContext(context.Background()).Warnf("websocket send queue full, dropping connection")
+		c.close()
+	}
+}
+
+// notify implements subscriber
+func (c *wsConnection) notify(subscriptionID string, result interface{}) {
+	data, err := json.Marshal(SubscriptionNotification{
+		JSONRPC: "2.0",
+		Method:  "eth_subscription",
+		Params: SubscriptionResult{
+			Subscription: subscriptionID,
+			Result:       result,
+		},
+	})
+	if err != nil {
+		logger.GetLoggerFromContext(context.Background()).Errorf("failed to marshal subscription notification: %v", err)
+		return
+	}
+	c.enqueue(data)
+}
+
+// close removes the connection subscriptions and closes the socket, once
+func (c *wsConnection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mu.Lock()
+		for id := range c.subscriptions {
+			c.router.subscriptions.unsubscribe(id)
+		}
+		c.subscriptions = map[string]struct{}{}
+		c.mu.Unlock()
+
+		_ = c.conn.Close()
+	})
+}
+
+// subscribe implements the eth_subscribe RPC method
+//
+// Parameters in request:
+//   - kind: "newHeads" or "logs"
+//   - filter: Optional logs filter, with the same semantics as eth_getLogs
+//
+// Returns:
+//   - string: The subscription ID
+//   - error: Any error that occurred during processing
+func (c *wsConnection) subscribe(request JSONRPCRequest) (interface{}, error) {
+	params, ok := request.Params.([]interface{})
+	if !ok || len(params) < 1 {
+		return nil, newInvalidParamsError("invalid params for eth_subscribe")
+	}
+
+	kind, ok := params[0].(string)
+	if !ok {
+		return nil, newInvalidParamsError("invalid subscription type")
+	}
+
+	sub := &subscription{kind: kind, owner: c}
+	switch kind {
+	case SubscriptionNewHeads:
+	case SubscriptionLogs:
+		if len(params) > 1 && params[1] != nil {
+			filter, ok := params[1].(map[string]interface{})
+			if !ok {
+				return nil, newInvalidParamsError("invalid filter parameters")
+			}
+
+			criteria, err := parseLogFilter(filter, c.router.latestBlockOrGenesis)
+			if err != nil {
+				return nil, err
+			}
+			sub.filter = criteria
+		}
+	default:
+		return nil, newInvalidParamsError("unsupported subscription type: %s", kind)
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return nil, newInternalError("failed to create subscription ID: %w", err)
+	}
+	sub.id = id
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.subscriptions) >= c.router.wsMaxSubscriptions {
+		return nil, newRPCError(ErrCodeLimitExceeded, "subscription limit of %d reached", c.router.wsMaxSubscriptions)
+	}
+
+	c.subscriptions[id] = struct{}{}
+	c.router.subscriptions.subscribe(sub)
+
+	return id, nil
+}
+
+// unsubscribe implements the eth_unsubscribe RPC method
+//
+// Parameters in request:
+//   - id: The subscription ID returned by eth_subscribe
+//
+// Returns:
+//   - bool: Whether the subscription existed and was cancelled
+//   - error: Any error that occurred during processing
+func (c *wsConnection) unsubscribe(request JSONRPCRequest) (interface{}, error) {
+	params, ok := request.Params.([]interface{})
+	if !ok || len(params) < 1 {
+		return nil, newInvalidParamsError("invalid params for eth_unsubscribe")
+	}
+
+	id, ok := params[0].(string)
+	if !ok {
+		return nil, newInvalidParamsError("invalid subscription ID")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.subscriptions[id]; !ok {
+		return false, nil
+	}
+
+	delete(c.subscriptions, id)
+	return c.router.subscriptions.unsubscribe(id), nil
+}
@@ -0,0 +1,126 @@
+package evm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebSocket(t *testing.T, router *evmRouter) *websocket.Conn {
+	server := httptest.NewServer(http.HandlerFunc(router.ServeWebSocket))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func wsCall(t *testing.T, conn *websocket.Conn, request string) JSONRPCResponse {
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(request)))
+
+	var response JSONRPCResponse
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, conn.ReadJSON(&response))
+	return response
+}
+
+func newTestWSRouter(chain *fakeChain) *evmRouter {
+	router := newTestRouter(map[string]methodHandler{"test_echo": echoHandler})
+	router.wsMaxSubscriptions = 2
+	router.wsSendQueueSize = defaultWSSendQueueSize
+	router.subscriptions = newTestHub(chain)
+	router.subscriptions.interval = 10 * time.Millisecond
+	return router
+}
+
+func TestWebSocketServesMethodHandlers(t *testing.T) {
+	conn := newTestWebSocket(t, newTestWSRouter(&fakeChain{}))
+
+	response := wsCall(t, conn, `{"jsonrpc":"2.0","method":"test_echo","id":1}`)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "test_echo", response.Result)
+
+	response = wsCall(t, conn, `{"jsonrpc":"2.0","method":"test_unknown","id":2}`)
+	require.NotNil(t, response.Error)
+	assert.Equal(t, ErrCodeMethodNotFound, response.Error.Code)
+}
+
+func TestWebSocketSubscribeNewHeads(t *testing.T) {
+	chain := &fakeChain{}
+	chain.append()
+	router := newTestWSRouter(chain)
+	conn := newTestWebSocket(t, router)
+
+	response := wsCall(t, conn, `{"jsonrpc":"2.0","method":"eth_subscribe","params":["newHeads"],"id":1}`)
+	require.Nil(t, response.Error)
+	subscriptionID, ok := response.Result.(string)
+	require.True(t, ok)
+
+	require.Eventually(t, func() bool {
+		router.subscriptions.mu.Lock()
+		defer router.subscriptions.mu.Unlock()
+		return router.subscriptions.initialized
+	}, 5*time.Second, 5*time.Millisecond)
+
+	chain.append()
+
+	var notification struct {
+		Method string `json:"method"`
+		Params struct {
+			Subscription string `json:"subscription"`
+			Result       Block  `json:"result"`
+		} `json:"params"`
+	}
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, conn.ReadJSON(&notification))
+	assert.Equal(t, "eth_subscription", notification.Method)
+	assert.Equal(t, subscriptionID, notification.Params.Subscription)
+	assert.Equal(t, "0x1", notification.Params.Result.Number)
+
+	response = wsCall(t, conn, `{"jsonrpc":"2.0","method":"eth_unsubscribe","params":["`+subscriptionID+`"],"id":2}`)
+	assert.Equal(t, true, response.Result)
+
+	response = wsCall(t, conn, `{"jsonrpc":"2.0","method":"eth_unsubscribe","params":["`+subscriptionID+`"],"id":3}`)
+	assert.Equal(t, false, response.Result)
+}
+
+func TestWebSocketSubscriptionLimit(t *testing.T) {
+	chain := &fakeChain{}
+	chain.append()
+	conn := newTestWebSocket(t, newTestWSRouter(chain))
+
+	for i := 0; i < 2; i++ {
+		response := wsCall(t, conn, `{"jsonrpc":"2.0","method":"eth_subscribe","params":["logs",{}],"id":1}`)
+		require.Nil(t, response.Error)
+	}
+
+	response := wsCall(t, conn, `{"jsonrpc":"2.0","method":"eth_subscribe","params":["newHeads"],"id":1}`)
+	require.NotNil(t, response.Error)
+	assert.Equal(t, ErrCodeLimitExceeded, response.Error.Code)
+
+	response = wsCall(t, conn, `{"jsonrpc":"2.0","method":"eth_subscribe","params":["pendingTransactions"],"id":1}`)
+	require.NotNil(t, response.Error)
+	assert.Equal(t, ErrCodeInvalidParams, response.Error.Code)
+}
+
+func TestWebSocketBatch(t *testing.T) {
+	conn := newTestWebSocket(t, newTestWSRouter(&fakeChain{}))
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`[{"jsonrpc":"2.0","method":"test_echo","id":1},{"jsonrpc":"2.0","method":"test_echo","id":2}]`)))
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var responses []JSONRPCResponse
+	require.NoError(t, json.Unmarshal(message, &responses))
+	assert.Len(t, responses, 2)
+}
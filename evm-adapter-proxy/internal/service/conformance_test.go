@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gm "github.com/zondax/golem/pkg/metrics"
+	"github.com/zondax/golem/pkg/runner"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	icpLogger "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/logger"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/icptest"
+)
+
+// conformanceChainID is the chain ID of the source served to the conformance clients
+const conformanceChainID = 7
+
+// testDetails are the details of the entries logged by another canister
+var testDetails = func() icpLogger.Value {
+	details := "details"
+	return icpLogger.Value{Text: &details}
+}()
+
+// conformanceABI holds the functions the clients call: the DEX pseudo contract
+// function of the signed transaction and the balanceOf function of the tokens
+var conformanceABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(`[
+		{"type":"function","name":"mintTokens","stateMutability":"nonpayable","inputs":[{"name":"currency","type":"string"},{"name":"amount","type":"uint256"},{"name":"recipient","type":"address"}],"outputs":[]},
+		{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}
+	]`))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// conformanceProxy is the proxy the conformance clients talk to, with the blocks they
+// read: a signed transaction minting USD, an unsigned mint of EUR and an entry logged
+// by another canister
+type conformanceProxy struct {
+	server    *httptest.Server
+	logger    *icptest.Logger
+	recipient common.Address
+	// tx is the signed transaction of block 0
+	tx *types.Transaction
+}
+
+// newConformanceProxy serves the canisters of a local replica as a source, which
+// gets clients of its own rather than the process-wide ones of ICPConfig
+func newConformanceProxy(t *testing.T) *conformanceProxy {
+	replica := icptest.NewReplica()
+	t.Cleanup(replica.Close)
+
+	logger := icptest.NewLogger("1337")
+	replica.Install(testLoggerCanister, logger)
+	replica.Install(testDexCanister, icptest.NewDex(logger, icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"}))
+
+	operatorKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	dexContract := common.HexToAddress("0x00000000000000000000000000000000000d3c00")
+
+	routerConfig := testRouterConfig()
+	routerConfig.WSPollInterval = "50ms"
+	routerConfig.DexContractAddress = dexContract.Hex()
+	routerConfig.DexOperators = []string{crypto.PubkeyToAddress(operatorKey.PublicKey).Hex()}
+	c := &conf.Config{
+		RouterConfig: routerConfig,
+		ICP: &conf.ICPConfig{
+			NodeURL:      replica.URL(),
+			Timeout:      "10s",
+			FetchRootKey: true,
+			Sources: []conf.SourceConfig{{
+				Name:          "conformance",
+				Type:          conf.SourceTypeLogger,
+				CanisterID:    testLoggerCanister.Encode(),
+				DexCanisterID: testDexCanister.Encode(),
+				ChainID:       conformanceChainID,
+				// Operator nonces are persisted in the block store
+				StorePath: filepath.Join(t.TempDir(), "conformance.db"),
+			}},
+		},
+	}
+	require.NoError(t, c.Validate())
+
+	zr, err := newRouter(c, gm.NewTaskMetrics("/metrics", "9090", appName), runner.NewRunner())
+	require.NoError(t, err)
+	proxy := &conformanceProxy{
+		server:    httptest.NewServer(zr.GetHandler()),
+		logger:    logger,
+		recipient: embeddedAddress(testRecipient),
+	}
+	t.Cleanup(proxy.server.Close)
+
+	client := proxy.dial(t)
+	ctx := context.Background()
+
+	data, err := conformanceABI.Pack("mintTokens", "USD", big.NewInt(100), proxy.recipient)
+	require.NoError(t, err)
+	gas, err := client.EstimateGas(ctx, ethereum.CallMsg{To: &dexContract, Data: data})
+	require.NoError(t, err)
+	proxy.tx, err = types.SignNewTx(operatorKey, types.LatestSignerForChainID(big.NewInt(conformanceChainID)),
+		&types.DynamicFeeTx{ChainID: big.NewInt(conformanceChainID), Gas: gas, To: &dexContract, Data: data})
+	require.NoError(t, err)
+	require.NoError(t, client.SendTransaction(ctx, proxy.tx))
+
+	require.NoError(t, client.Client().CallContext(ctx, nil, "eth_mintTokens",
+		map[string]any{"currency": "EUR", "amount": "0x5", "recipient": proxy.recipient.Hex()}))
+
+	logger.AddEntry("custom_operation", testDetails, testRecipient)
+
+	return proxy
+}
+
+// dial returns an ethclient of the HTTP route of the proxy
+func (p *conformanceProxy) dial(t *testing.T) *ethclient.Client {
+	client, err := ethclient.Dial(p.server.URL + "/rpc/v1/conformance")
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
+
+// blockHash returns the hash the proxy serves for a block
+func (p *conformanceProxy) blockHash(number uint64) common.Hash {
+	return common.BytesToHash(p.logger.BlockHash(number))
+}
+
+func TestConformanceEthclient(t *testing.T) {
+	proxy := newConformanceProxy(t)
+	client := proxy.dial(t)
+	ctx := context.Background()
+
+	chainID, err := client.ChainID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(conformanceChainID), chainID)
+
+	networkID, err := client.NetworkID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(conformanceChainID), networkID)
+
+	blockNumber, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), blockNumber)
+
+	t.Run("Headers", func(t *testing.T) {
+		header, err := client.HeaderByNumber(ctx, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), header.Number.Uint64())
+		assert.Equal(t, proxy.blockHash(1), header.ParentHash)
+		assert.Equal(t, types.EmptyUncleHash, header.UncleHash)
+		require.NotNil(t, header.BaseFee)
+		require.NotNil(t, header.WithdrawalsHash)
+		require.NotNil(t, header.BlobGasUsed)
+		require.NotNil(t, header.ExcessBlobGas)
+		require.NotNil(t, header.ParentBeaconRoot)
+
+		header, err = client.HeaderByHash(ctx, proxy.blockHash(1))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), header.Number.Uint64())
+		assert.Equal(t, proxy.blockHash(0), header.ParentHash)
+	})
+
+	t.Run("Blocks", func(t *testing.T) {
+		block, err := client.BlockByNumber(ctx, big.NewInt(0))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), block.NumberU64())
+		require.Len(t, block.Transactions(), 1)
+		assert.Empty(t, block.Uncles())
+
+		block, err = client.BlockByHash(ctx, proxy.blockHash(2))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), block.NumberU64())
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		tx, pending, err := client.TransactionByHash(ctx, proxy.tx.Hash())
+		require.NoError(t, err)
+		assert.False(t, pending)
+		assert.Equal(t, proxy.tx.Hash(), tx.Hash())
+
+		receipt, err := client.TransactionReceipt(ctx, proxy.tx.Hash())
+		require.NoError(t, err)
+		assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+		assert.Equal(t, proxy.tx.Hash(), receipt.TxHash)
+		assert.Equal(t, proxy.blockHash(0), receipt.BlockHash)
+		assert.NotEmpty(t, receipt.Logs)
+
+		receipts, err := client.BlockReceipts(ctx, rpc.BlockNumberOrHashWithNumber(1))
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		assert.Equal(t, proxy.blockHash(1), receipts[0].BlockHash)
+
+		nonce, err := client.NonceAt(ctx, *proxy.tx.To(), nil)
+		require.NoError(t, err)
+		assert.Zero(t, nonce)
+	})
+
+	t.Run("Logs", func(t *testing.T) {
+		logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{FromBlock: big.NewInt(0)})
+		require.NoError(t, err)
+		require.NotEmpty(t, logs)
+		for _, log := range logs {
+			assert.Equal(t, proxy.blockHash(log.BlockNumber), log.BlockHash)
+		}
+
+		blockHash := proxy.blockHash(2)
+		logs, err = client.FilterLogs(ctx, ethereum.FilterQuery{BlockHash: &blockHash})
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		assert.Equal(t, uint64(2), logs[0].BlockNumber)
+	})
+
+	t.Run("Balances and calls", func(t *testing.T) {
+		balance, err := client.BalanceAt(ctx, proxy.recipient, nil)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(100), balance)
+
+		token := proxy.tokenAddress(t, client)
+		data, err := conformanceABI.Pack("balanceOf", proxy.recipient)
+		require.NoError(t, err)
+		result, err := client.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, common.LeftPadBytes([]byte{100}, 32), result)
+
+		gasPrice, err := client.SuggestGasPrice(ctx)
+		require.NoError(t, err)
+		assert.NotNil(t, gasPrice)
+		tip, err := client.SuggestGasTipCap(ctx)
+		require.NoError(t, err)
+		assert.NotNil(t, tip)
+	})
+}
+
+func TestConformanceEthclientSubscriptions(t *testing.T) {
+	proxy := newConformanceProxy(t)
+	client, err := ethclient.Dial("ws" + strings.TrimPrefix(proxy.server.URL, "http") + "/ws/v1/conformance")
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	ctx := context.Background()
+
+	headers := make(chan *types.Header, 1)
+	headSubscription, err := client.SubscribeNewHead(ctx, headers)
+	require.NoError(t, err)
+	defer headSubscription.Unsubscribe()
+	logs := make(chan types.Log, 1)
+	logSubscription, err := client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{}, logs)
+	require.NoError(t, err)
+	defer logSubscription.Unsubscribe()
+
+	proxy.logger.AddEntry("custom_operation", testDetails, testRecipient)
+
+	select {
+	case header := <-headers:
+		assert.Equal(t, uint64(3), header.Number.Uint64())
+		assert.Equal(t, proxy.blockHash(2), header.ParentHash)
+	case err := <-headSubscription.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no new head")
+	}
+
+	select {
+	case log := <-logs:
+		assert.Equal(t, uint64(3), log.BlockNumber)
+		assert.Equal(t, proxy.blockHash(3), log.BlockHash)
+	case err := <-logSubscription.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no log")
+	}
+}
+
+func TestConformanceGethclient(t *testing.T) {
+	proxy := newConformanceProxy(t)
+	client := proxy.dial(t)
+	ctx := context.Background()
+
+	token := proxy.tokenAddress(t, client)
+	data, err := conformanceABI.Pack("balanceOf", proxy.recipient)
+	require.NoError(t, err)
+
+	// gethclient sends the calldata as input, and its overrides even when there are none
+	result, err := gethclient.New(client.Client()).CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, big.NewInt(2), nil)
+	require.NoError(t, err)
+	assert.Equal(t, common.LeftPadBytes([]byte{100}, 32), result)
+}
+
+func TestConformanceBatchCall(t *testing.T) {
+	proxy := newConformanceProxy(t)
+	client := proxy.dial(t)
+
+	var (
+		chainID  hexutil.Big
+		header   types.Header
+		block    map[string]any
+		logs     []types.Log
+		receipt  types.Receipt
+		receipts []*types.Receipt
+		tx       types.Transaction
+	)
+	batch := []rpc.BatchElem{
+		{Method: "eth_chainId", Result: &chainID},
+		{Method: "eth_getBlockByNumber", Args: []any{"latest", false}, Result: &header},
+		{Method: "eth_getBlockByHash", Args: []any{proxy.blockHash(0), true}, Result: &block},
+		{Method: "eth_getLogs", Args: []any{map[string]any{"fromBlock": "0x0", "toBlock": "latest"}}, Result: &logs},
+		{Method: "eth_getTransactionReceipt", Args: []any{proxy.tx.Hash()}, Result: &receipt},
+		{Method: "eth_getBlockReceipts", Args: []any{"0x1"}, Result: &receipts},
+		{Method: "eth_getTransactionByHash", Args: []any{proxy.tx.Hash()}, Result: &tx},
+	}
+	require.NoError(t, client.Client().BatchCall(batch))
+	for _, elem := range batch {
+		assert.NoError(t, elem.Error, elem.Method)
+	}
+
+	assert.Equal(t, big.NewInt(conformanceChainID), chainID.ToInt())
+	assert.Equal(t, uint64(2), header.Number.Uint64())
+	assert.Equal(t, proxy.blockHash(0).Hex(), block["hash"])
+	assert.NotEmpty(t, logs)
+	assert.Equal(t, proxy.tx.Hash(), receipt.TxHash)
+	assert.Len(t, receipts, 1)
+	assert.Equal(t, proxy.tx.Hash(), tx.Hash())
+}
+
+// tokenAddress returns the address of the USD token, which emits the transfer log of
+// the signed mint
+func (p *conformanceProxy) tokenAddress(t *testing.T, client *ethclient.Client) common.Address {
+	receipt, err := client.TransactionReceipt(context.Background(), p.tx.Hash())
+	require.NoError(t, err)
+	require.NotEmpty(t, receipt.Logs)
+	return receipt.Logs[0].Address
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"fmt"
+
 	"github.com/zondax/golem/pkg/logger"
 	gm "github.com/zondax/golem/pkg/metrics"
 	"github.com/zondax/golem/pkg/runner"
@@ -20,6 +22,30 @@ func Start(c *conf.Config) {
 	logger.InitLogger(logger.Config{Level: c.Logging.Level})
 	metricServer := gm.NewTaskMetrics(c.Metrics.Path, c.Metrics.Port, appName)
 
+	tr := runner.NewRunner()
+	tr.AddTask(metricServer)
+	tr.Start()
+
+	zr, err := newRouter(c, metricServer, tr)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
+	zap.S().Fatal(zr.Run(c.ServerPort))
+}
+
+// newRouter creates the base router serving the EVM router of the canisters of
+// ICPConfig, if configured, and of each of its sources
+//
+// Parameters:
+//   - c: The configuration
+//   - metricServer: Server the router metrics are registered with
+//   - tr: Runner the block ingesters are added to
+//
+// Returns:
+//   - zrouter.ZRouter: The router, not running yet
+//   - error: Any error creating the ICP clients or the EVM routers
+func newRouter(c *conf.Config, metricServer gm.TaskMetrics, tr *runner.TaskRunner) (zrouter.ZRouter, error) {
 	zrouterConfig := zrouter.Config{
 		AppRevision: version.GitRevision,
 		AppVersion:  version.GitVersion,
@@ -27,16 +53,39 @@ func Start(c *conf.Config) {
 
 	zr := zrouter.New(metricServer, &zrouterConfig)
 
-	tr := runner.NewRunner()
-	tr.AddTask(metricServer)
-	tr.Start()
+	if c.ICP.LoggerCanisterID != "" {
+		icpClients, err := icp.NewICPClient(c.ICP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize ICP clients: %w", err)
+		}
 
-	icpClients, err := icp.NewICPClient(c.ICP)
+		routerConfig := c.RouterConfig
+		routerConfig.ChainID = c.ICP.ChainID
+		if err := evm.NewEVMRouter(zr, icpClients, routerConfig, metricServer, tr); err != nil {
+			return nil, fmt.Errorf("failed to initialize EVM router: %w", err)
+		}
+	}
+
+	// Every source is served by a router of its own, sharing the router settings
+	// except for its name, chain ID and block store
+	agentConfig, err := icp.NewAgentConfig(c.ICP)
 	if err != nil {
-		zap.S().Fatalf("Error initializing ICP clients: %v", err)
+		return nil, fmt.Errorf("failed to initialize ICP agent: %w", err)
 	}
+	for _, source := range c.ICP.Sources {
+		icpClients, err := icp.NewSourceClients(agentConfig, source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize ICP clients of source %s: %w", source.Name, err)
+		}
 
-	evm.NewEVMRouter(zr, icpClients)
+		routerConfig := c.RouterConfig
+		routerConfig.Source = source.Name
+		routerConfig.ChainID = source.ChainID
+		routerConfig.StorePath = source.StorePath
+		if err := evm.NewEVMRouter(zr, icpClients, routerConfig, metricServer, tr); err != nil {
+			return nil, fmt.Errorf("failed to initialize EVM router of source %s: %w", source.Name, err)
+		}
+	}
 
-	zap.S().Fatal(zr.Run(c.ServerPort))
+	return zr, nil
 }
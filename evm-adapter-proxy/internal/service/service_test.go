@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gm "github.com/zondax/golem/pkg/metrics"
+	"github.com/zondax/golem/pkg/runner"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/conf"
+	icpDex "github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/clients/dex"
+	"github.com/zondax/poc-icp-icrc3-evm-adapter/internal/icp/icptest"
+)
+
+var (
+	testLoggerCanister = principal.MustDecode("ydpfi-uiaaa-aaaal-qjupa-cai")
+	testDexCanister    = principal.MustDecode("7eo5f-eqaaa-aaaam-adqoq-cai")
+	testSourceCanister = principal.MustDecode("be2us-64aaa-aaaaa-qaabq-cai")
+	testRecipient      = principal.MustDecode("rrkah-fqaaa-aaaaa-aaaaq-cai")
+)
+
+// TestServiceEndToEnd serves the canisters of a local replica and reads them with an
+// Ethereum client, from the configuration to the canister calls
+func TestServiceEndToEnd(t *testing.T) {
+	replica := icptest.NewReplica()
+	t.Cleanup(replica.Close)
+
+	logger := icptest.NewLogger("1337")
+	dex := icptest.NewDex(logger, icpDex.CurrencyPair{BaseCurrency: "USD", QuoteCurrency: "EUR"})
+	replica.Install(testLoggerCanister, logger)
+	replica.Install(testDexCanister, dex)
+	source := icptest.NewLogger("1")
+	replica.Install(testSourceCanister, source)
+
+	c := &conf.Config{
+		RouterConfig: testRouterConfig(),
+		ICP: &conf.ICPConfig{
+			LoggerCanisterID: testLoggerCanister.Encode(),
+			DexCanisterID:    testDexCanister.Encode(),
+			NodeURL:          replica.URL(),
+			Timeout:          "10s",
+			FetchRootKey:     true,
+			ChainID:          1337,
+			Sources: []conf.SourceConfig{
+				{Name: "events", Type: conf.SourceTypeLogger, CanisterID: testSourceCanister.Encode(), ChainID: 7},
+			},
+		},
+	}
+	require.NoError(t, c.Validate())
+
+	zr, err := newRouter(c, gm.NewTaskMetrics("/metrics", "9090", appName), runner.NewRunner())
+	require.NoError(t, err)
+	server := httptest.NewServer(zr.GetHandler())
+	t.Cleanup(server.Close)
+
+	client, err := ethclient.Dial(server.URL + "/rpc/v1")
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	ctx := context.Background()
+
+	chainID, err := client.ChainID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1337), chainID)
+
+	// Tokens minted through the adapter are logged by the DEX and served as blocks
+	recipient := embeddedAddress(testRecipient)
+	require.NoError(t, client.Client().CallContext(ctx, nil, "eth_mintTokens",
+		map[string]any{"currency": "USD", "amount": "0x64", "recipient": recipient.Hex()}))
+	assert.Equal(t, big.NewInt(100), dex.Balance(testRecipient, "USD"))
+
+	balance, err := client.BalanceAt(ctx, recipient, nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(100), balance)
+
+	blockNumber, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), blockNumber)
+
+	block, err := client.BlockByNumber(ctx, big.NewInt(0))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), block.NumberU64())
+
+	logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{FromBlock: big.NewInt(0)})
+	require.NoError(t, err)
+	require.NotEmpty(t, logs)
+	// go-ethereum hashes the header itself; the adapter serves the canister's hash
+	assert.Equal(t, common.BytesToHash(logger.BlockHash(0)), logs[0].BlockHash)
+
+	// Sources are served at routes of their own, with their own chain ID
+	sourceClient, err := ethclient.Dial(server.URL + "/rpc/v1/events")
+	require.NoError(t, err)
+	t.Cleanup(sourceClient.Close)
+
+	chainID, err = sourceClient.ChainID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(7), chainID)
+}
+
+// testRouterConfig returns the default router settings, serving balances in USD and,
+// unlike the defaults, the unsigned DEX methods the tests mint with
+func testRouterConfig() conf.RouterConfig {
+	return conf.RouterConfig{
+		MaxBatchSize:       100,
+		BatchConcurrency:   8,
+		WSPollInterval:     "2s",
+		WSMaxSubscriptions: 16,
+		WSSendQueueSize:    256,
+		FilterTimeout:      "5m",
+		IngestBatchSize:    100,
+		BlockHashScanDepth: 1000,
+		HeaderFork:         "cancun",
+		NativeCurrency:     "USD",
+		UnsignedDexMethods: true,
+		BlockCacheSize:     10000,
+		BlockCacheTTL:      "1s",
+		TipCacheTTL:        "1s",
+	}
+}
+
+// embeddedAddress returns the address a canister principal is embedded in: the CRC-32
+// checksum of the principal followed by its bytes
+func embeddedAddress(p principal.Principal) common.Address {
+	return common.BytesToAddress(append(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(p.Raw)), p.Raw...))
+}
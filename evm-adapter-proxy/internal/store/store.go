@@ -0,0 +1,408 @@
+// Package store persists ingested ICRC-3 blocks and their lookup indexes in an
+// embedded bbolt database
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	blocksBucket     = []byte("blocks")
+	hashesBucket     = []byte("hashes")
+	txsBucket        = []byte("txs")
+	addressesBucket  = []byte("addresses")
+	topicsBucket     = []byte("topics")
+	metaBucket       = []byte("meta")
+	submittedBucket  = []byte("submitted")
+	noncesBucket     = []byte("nonces")
+	principalsBucket = []byte("principals")
+
+	heightKey = []byte("height")
+)
+
+// openTimeout bounds the wait for the file lock held by another process
+const openTimeout = 5 * time.Second
+
+// Block is an ingested block and the keys it is indexed by
+type Block struct {
+	Number uint64
+	// Hash is the 0x-prefixed hex hash of the block
+	Hash string
+	// Data is the encoded block, opaque to the store
+	Data []byte
+	// TxHashes, Addresses and Topics are the 0x-prefixed hex values found in the
+	// transactions and logs of the block
+	TxHashes  []string
+	Addresses []string
+	Topics    []string
+}
+
+// Store holds blocks by number, indexed by block hash, transaction hash, log address
+// and log topic
+//
+// Blocks are stored contiguously from block 0; the height is the number of the next
+// block to ingest, so a restarted ingester resumes from it. The store also keeps the
+// signed transactions submitted through the proxy, the next nonce of their senders and
+// the principals of derived addresses.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the store at path
+//
+// Parameters:
+//   - path: The database file
+//
+// Returns:
+//   - *Store: The opened store
+//   - error: Any error opening the file or creating the buckets
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block store '%s': %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{blocksBucket, hashesBucket, txsBucket, addressesBucket, topicsBucket, metaBucket, submittedBucket, noncesBucket, principalsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize block store: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Height returns the number of the next block to ingest, which is also the number of
+// blocks stored
+func (s *Store) Height() (uint64, error) {
+	var height uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(metaBucket).Get(heightKey); raw != nil {
+			height = binary.BigEndian.Uint64(raw)
+		}
+		return nil
+	})
+	return height, err
+}
+
+// Put stores blocks that continue the stored chain and moves the height past them,
+// in a single transaction
+func (s *Store) Put(blocks []Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		var height uint64
+		if raw := meta.Get(heightKey); raw != nil {
+			height = binary.BigEndian.Uint64(raw)
+		}
+
+		for _, block := range blocks {
+			if block.Number != height {
+				return fmt.Errorf("block %d does not continue the stored chain at height %d", block.Number, height)
+			}
+			if err := putBlock(tx, block); err != nil {
+				return fmt.Errorf("failed to store block %d: %w", block.Number, err)
+			}
+			height++
+		}
+
+		return meta.Put(heightKey, encodeNumber(height))
+	})
+}
+
+func putBlock(tx *bolt.Tx, block Block) error {
+	number := encodeNumber(block.Number)
+
+	if err := tx.Bucket(blocksBucket).Put(number, block.Data); err != nil {
+		return err
+	}
+
+	hash, err := decodeHex(block.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid block hash: %w", err)
+	}
+	if err := tx.Bucket(hashesBucket).Put(hash, number); err != nil {
+		return err
+	}
+
+	for _, txHash := range block.TxHashes {
+		key, err := decodeHex(txHash)
+		if err != nil {
+			return fmt.Errorf("invalid transaction hash: %w", err)
+		}
+		if err := tx.Bucket(txsBucket).Put(key, number); err != nil {
+			return err
+		}
+	}
+
+	if err := putPostings(tx.Bucket(addressesBucket), block.Addresses, number); err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	if err := putPostings(tx.Bucket(topicsBucket), block.Topics, number); err != nil {
+		return fmt.Errorf("invalid topic: %w", err)
+	}
+
+	return nil
+}
+
+// putPostings records that the block contains each value, keyed by value then number
+func putPostings(bucket *bolt.Bucket, values []string, number []byte) error {
+	for _, value := range values {
+		key, err := decodeHex(value)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(append(key, number...), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Block returns the data of a stored block
+//
+// Returns:
+//   - []byte: The block data
+//   - bool: Whether the block is stored
+//   - error: Any error reading the database
+func (s *Store) Block(number uint64) ([]byte, bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(blocksBucket).Get(encodeNumber(number)); raw != nil {
+			data = bytes.Clone(raw)
+		}
+		return nil
+	})
+	return data, data != nil, err
+}
+
+// BlockNumberByHash returns the number of the stored block with the given hash
+func (s *Store) BlockNumberByHash(hash string) (uint64, bool, error) {
+	return s.lookup(hashesBucket, hash)
+}
+
+// BlockNumberByTxHash returns the number of the stored block holding the given transaction
+func (s *Store) BlockNumberByTxHash(txHash string) (uint64, bool, error) {
+	return s.lookup(txsBucket, txHash)
+}
+
+func (s *Store) lookup(bucket []byte, value string) (uint64, bool, error) {
+	key, err := decodeHex(value)
+	if err != nil {
+		return 0, false, nil
+	}
+
+	var number uint64
+	var found bool
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(bucket).Get(key); raw != nil {
+			number, found = binary.BigEndian.Uint64(raw), true
+		}
+		return nil
+	})
+	return number, found, err
+}
+
+// BlockNumbersByAddress returns, in order, the stored blocks in [from, to] with a log
+// emitted by any of the addresses
+func (s *Store) BlockNumbersByAddress(addresses []string, from, to uint64) ([]uint64, error) {
+	return s.postings(addressesBucket, addresses, from, to)
+}
+
+// BlockNumbersByTopic returns, in order, the stored blocks in [from, to] with a log
+// carrying any of the topics, at any position
+func (s *Store) BlockNumbersByTopic(topics []string, from, to uint64) ([]uint64, error) {
+	return s.postings(topicsBucket, topics, from, to)
+}
+
+func (s *Store) postings(bucket []byte, values []string, from, to uint64) ([]uint64, error) {
+	seen := make(map[uint64]bool)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for _, value := range values {
+			prefix, err := decodeHex(value)
+			if err != nil {
+				continue
+			}
+
+			start := append(bytes.Clone(prefix), encodeNumber(from)...)
+			for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix) && len(k) == len(prefix)+8; k, _ = c.Next() {
+				number := binary.BigEndian.Uint64(k[len(prefix):])
+				if number > to {
+					break
+				}
+				seen[number] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]uint64, 0, len(seen))
+	for number := range seen {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	return numbers, nil
+}
+
+// PutSubmission stores a submitted transaction and moves the nonce of its sender past
+// it, in a single transaction
+//
+// Parameters:
+//   - hash: The 0x-prefixed hex hash of the transaction
+//   - data: The encoded transaction and receipt, opaque to the store
+//   - sender: The 0x-prefixed hex address of the sender
+//   - nonce: The nonce of the transaction
+func (s *Store) PutSubmission(hash string, data []byte, sender string, nonce uint64) error {
+	key, err := decodeHex(hash)
+	if err != nil {
+		return fmt.Errorf("invalid transaction hash: %w", err)
+	}
+	account, err := decodeHex(sender)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(submittedBucket).Put(key, data); err != nil {
+			return err
+		}
+		return tx.Bucket(noncesBucket).Put(account, encodeNumber(nonce+1))
+	})
+}
+
+// UseNonce moves the nonce of sender past nonce, before the transaction with that nonce
+// is executed, so it cannot be submitted again whatever the outcome of its execution
+//
+// Parameters:
+//   - sender: The 0x-prefixed hex address of the sender
+//   - nonce: The nonce of the transaction
+func (s *Store) UseNonce(sender string, nonce uint64) error {
+	account, err := decodeHex(sender)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(noncesBucket).Put(account, encodeNumber(nonce+1))
+	})
+}
+
+// Submission returns the data of a submitted transaction
+//
+// Returns:
+//   - []byte: The data stored with PutSubmission
+//   - bool: Whether a transaction with that hash was submitted
+//   - error: Any error reading the database
+func (s *Store) Submission(hash string) ([]byte, bool, error) {
+	key, err := decodeHex(hash)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	var data []byte
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(submittedBucket).Get(key); raw != nil {
+			data = bytes.Clone(raw)
+		}
+		return nil
+	})
+	return data, data != nil, err
+}
+
+// NextNonce returns the nonce the next transaction submitted by sender must have,
+// zero for senders that never submitted one
+func (s *Store) NextNonce(sender string) (uint64, error) {
+	account, err := decodeHex(sender)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sender: %w", err)
+	}
+
+	var nonce uint64
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(noncesBucket).Get(account); raw != nil {
+			nonce = binary.BigEndian.Uint64(raw)
+		}
+		return nil
+	})
+	return nonce, err
+}
+
+// PutPrincipal records the principal of an address derived from it
+//
+// Parameters:
+//   - address: The 0x-prefixed hex address
+//   - raw: The bytes of the principal
+func (s *Store) PutPrincipal(address string, raw []byte) error {
+	key, err := decodeHex(address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(principalsBucket).Put(key, raw)
+	})
+}
+
+// Principal returns the bytes of the principal recorded for an address
+func (s *Store) Principal(address string) ([]byte, bool, error) {
+	key, err := decodeHex(address)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	var raw []byte
+	var found bool
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(principalsBucket).Get(key); value != nil {
+			raw, found = bytes.Clone(value), true
+		}
+		return nil
+	})
+	return raw, found, err
+}
+
+func encodeNumber(number uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, number)
+	return key
+}
+
+// decodeHex decodes a 0x-prefixed hex string, case-insensitively
+func decodeHex(value string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(value), "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return raw, nil
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAddressA = "0x00000000000000000000000000000000000000aa"
+	testAddressB = "0x00000000000000000000000000000000000000bb"
+	testTopic    = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+)
+
+func testBlock(number uint64, addresses ...string) Block {
+	return Block{
+		Number:    number,
+		Hash:      fmt.Sprintf("0x%064x", number+1000),
+		Data:      []byte(fmt.Sprintf("block %d", number)),
+		TxHashes:  []string{fmt.Sprintf("0x%064x", number+2000)},
+		Addresses: addresses,
+		Topics:    []string{testTopic},
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.db")
+	s, err := Open(path)
+	require.NoError(t, err)
+
+	height, err := s.Height()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), height)
+
+	require.NoError(t, s.Put([]Block{testBlock(0, testAddressA), testBlock(1, testAddressB), testBlock(2, testAddressA, testAddressB)}))
+	require.NoError(t, s.Put([]Block{testBlock(3, testAddressB)}))
+
+	t.Run("Blocks", func(t *testing.T) {
+		data, found, err := s.Block(2)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("block 2"), data)
+
+		_, found, err = s.Block(4)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Hash and transaction indexes", func(t *testing.T) {
+		number, found, err := s.BlockNumberByHash(testBlock(1).Hash)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, uint64(1), number)
+
+		number, found, err = s.BlockNumberByTxHash(testBlock(3).TxHashes[0])
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, uint64(3), number)
+
+		_, found, err = s.BlockNumberByHash("0x1234")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		_, found, err = s.BlockNumberByHash("not hex")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Address and topic indexes", func(t *testing.T) {
+		numbers, err := s.BlockNumbersByAddress([]string{testAddressA}, 0, 3)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{0, 2}, numbers)
+
+		numbers, err = s.BlockNumbersByAddress([]string{testAddressA, "0x00000000000000000000000000000000000000BB"}, 1, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2}, numbers)
+
+		numbers, err = s.BlockNumbersByTopic([]string{testTopic}, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2, 3}, numbers)
+	})
+
+	t.Run("Blocks must continue the chain", func(t *testing.T) {
+		assert.ErrorContains(t, s.Put([]Block{testBlock(5)}), "does not continue")
+		assert.Error(t, s.Put([]Block{{Number: 4, Hash: "bad"}}))
+
+		height, err := s.Height()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4), height, "failed batches are not stored")
+	})
+
+	t.Run("Submissions", func(t *testing.T) {
+		txHash := fmt.Sprintf("0x%064x", 3000)
+		require.NoError(t, s.PutSubmission(txHash, []byte("tx"), testAddressA, 4))
+
+		data, found, err := s.Submission(txHash)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("tx"), data)
+
+		_, found, err = s.Submission(testBlock(0).TxHashes[0])
+		require.NoError(t, err)
+		assert.False(t, found, "ingested transactions are not submissions")
+
+		nonce, err := s.NextNonce(testAddressA)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(5), nonce)
+
+		nonce, err = s.NextNonce(testAddressB)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), nonce)
+
+		assert.Error(t, s.PutSubmission("bad", nil, testAddressA, 0))
+
+		require.NoError(t, s.UseNonce(testAddressB, 2))
+		nonce, err = s.NextNonce(testAddressB)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), nonce)
+		assert.Error(t, s.UseNonce("bad", 0))
+	})
+
+	t.Run("Principals", func(t *testing.T) {
+		require.NoError(t, s.PutPrincipal(testAddressB, []byte{1, 2, 3}))
+
+		raw, found, err := s.Principal("0x00000000000000000000000000000000000000BB")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte{1, 2, 3}, raw)
+
+		_, found, err = s.Principal(testAddressA)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	require.NoError(t, s.Close())
+
+	t.Run("Height survives a restart", func(t *testing.T) {
+		s, err := Open(path)
+		require.NoError(t, err)
+		defer s.Close()
+
+		height, err := s.Height()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4), height)
+
+		nonce, err := s.NextNonce(testAddressA)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(5), nonce)
+
+		nonce, err = s.NextNonce(testAddressB)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), nonce)
+	})
+}